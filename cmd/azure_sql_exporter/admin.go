@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var adminTokenFile = kingpin.Flag("web.admin-token-file", "Path of a file holding the bearer token required by the /api/targets, /api/v1/databases and /scrape endpoints. The endpoints are disabled if empty.").String()
+
+// adminHandler returns a handler requiring the bearer token in --web.admin-token-file before calling handler. Only
+// the given methods are allowed, POST if none are given.
+func adminHandler(handler http.HandlerFunc, methods ...string) (http.Handler, error) {
+	if len(methods) == 0 {
+		methods = []string{"POST"}
+	}
+	b, err := ioutil.ReadFile(*adminTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read admin token: %s", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return nil, fmt.Errorf("admin token file %s is empty", *adminTokenFile)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !contains(methods, r.Method) {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/iamseth/azure_sql_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	benchCommand      = kingpin.Command("bench", "Scrape simulated databases over a fake SQL backend, print the scrape latency, allocations and goroutines and exit, to size deployments and catch regressions of the scrape pipeline.")
+	benchDatabases    = benchCommand.Flag("databases", "Number of simulated databases.").Default("100").Int()
+	benchServers      = benchCommand.Flag("servers", "Number of servers the simulated databases are spread over, which the per server limits apply to.").Default("1").Int()
+	benchScrapes      = benchCommand.Flag("scrapes", "Number of scrapes of all databases to measure, after one scrape to warm up.").Default("20").Int()
+	benchQueryLatency = benchCommand.Flag("query-latency", "Round trip time of every query of the fake backend.").Default("5ms").Duration()
+	benchCollectors   = benchCommand.Flag("collectors", "Optional collectors of the simulated databases. The fake backend only returns rows for wait_stats; other collectors run their queries and get no rows.").Default("wait_stats").Strings()
+)
+
+// benchResult holds the measurements of the bench command.
+type benchResult struct {
+	durations      []time.Duration
+	allocs, bytes  uint64
+	peakGoroutines int
+	goroutines     int
+	series         int
+	failures       int
+}
+
+// bench implements the bench command. It scrapes the simulated databases with the settings of the flags, such as
+// the concurrency limits, prints the measurements to stdout and returns the exit code: 1 if a scrape failed.
+func bench() int {
+	for _, name := range *benchCollectors {
+		if !contains(collector.Names(), name) {
+			fmt.Fprintf(os.Stderr, "unknown collector %q\n", name)
+			return 2
+		}
+	}
+	dbs := collector.BenchDatabases(*benchDatabases, *benchServers, *benchCollectors)
+	exporter := collector.NewBenchExporter(dbs, *benchQueryLatency)
+	defer exporter.Close()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+	// The first scrape opens the connection pools and checks the permissions.
+	if _, err := reg.Gather(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var r benchResult
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	stop := collector.SampleGoroutines()
+	for i := 0; i < *benchScrapes; i++ {
+		start := time.Now()
+		families, err := reg.Gather()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		r.durations = append(r.durations, time.Since(start))
+		r.series = 0
+		for _, mf := range families {
+			r.series += len(mf.GetMetric())
+		}
+		r.failures += len(exporter.ScrapeErrors())
+	}
+	r.peakGoroutines = stop()
+	runtime.ReadMemStats(&after)
+	r.goroutines = runtime.NumGoroutine()
+	if *benchScrapes > 0 {
+		r.allocs = (after.Mallocs - before.Mallocs) / uint64(*benchScrapes)
+		r.bytes = (after.TotalAlloc - before.TotalAlloc) / uint64(*benchScrapes)
+	}
+	r.print(os.Stdout)
+	if r.failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (r benchResult) print(w io.Writer) {
+	fmt.Fprintf(w, "databases:        %d on %d servers\n", *benchDatabases, *benchServers)
+	fmt.Fprintf(w, "scrapes:          %d\n", len(r.durations))
+	if len(r.durations) > 0 {
+		sorted := append([]time.Duration{}, r.durations...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		fmt.Fprintf(w, "scrape latency:   min %s, p50 %s, p90 %s, p99 %s, max %s\n",
+			sorted[0], percentile(sorted, 0.5), percentile(sorted, 0.9), percentile(sorted, 0.99), sorted[len(sorted)-1])
+	}
+	fmt.Fprintf(w, "allocations:      %d per scrape, %d bytes per scrape\n", r.allocs, r.bytes)
+	fmt.Fprintf(w, "goroutines:       %d at peak, %d after the scrapes\n", r.peakGoroutines, r.goroutines)
+	fmt.Fprintf(w, "series:           %d\n", r.series)
+	fmt.Fprintf(w, "failed scrapes:   %d\n", r.failures)
+}
+
+// percentile returns the p-quantile of the sorted durations by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
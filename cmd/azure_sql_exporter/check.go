@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/collector"
+	"github.com/prometheus/common/expfmt"
+)
+
+// check implements the check command. It scrapes all databases once, prints the metrics in the text exposition
+// format to stdout and returns the exit code: 1 if the scrape of a database failed, 2 if the metrics couldn't be
+// gathered.
+func check(exporter *collector.Exporter) int {
+	families, err := gatherRegistry()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(os.Stdout, mf); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	errs := exporter.ScrapeErrors()
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "Scrape failed: %s\n", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/iamseth/azure_sql_exporter/pkg/collector"
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/log"
+	"gopkg.in/yaml.v2"
+)
+
+var databasesStateFile = kingpin.Flag("web.databases-state-file", "Path of a file the databases registered through /api/v1/databases are saved to and registered from again at startup. Registered databases are lost on restart if empty.").String()
+
+// maxDatabaseBody is the maximum size of a database registered through the API.
+const maxDatabaseBody = 1 << 20
+
+// databasesState is the content of the state file, the databases registered through the API as they were sent.
+type databasesState struct {
+	Databases []config.Database `yaml:"databases"`
+}
+
+// databasesAPI registers and deregisters databases at runtime. Databases of the config can't be changed through it.
+type databasesAPI struct {
+	exporter *collector.Exporter
+	cfg      config.Config
+	// static holds the databases of the config.
+	static []config.Database
+
+	mutex sync.Mutex
+	// registered holds the databases registered through the API by key, as they were sent.
+	registered map[string]config.Database
+}
+
+func newDatabasesAPI(exporter *collector.Exporter, cfg config.Config, static []config.Database, registered []config.Database) *databasesAPI {
+	a := &databasesAPI{exporter: exporter, cfg: cfg, static: static, registered: map[string]config.Database{}}
+	for _, db := range registered {
+		a.registered[db.Key()] = db
+	}
+	return a
+}
+
+// loadDatabasesState returns the databases of the state file, validated against cfg, except for those that clash
+// with a database of the config. A missing state file holds no databases.
+func loadDatabasesState(path string, cfg config.Config) (raw, validated []config.Database, err error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var state databasesState
+	if err := yaml.UnmarshalStrict(b, &state); err != nil {
+		return nil, nil, fmt.Errorf("unable to unmarshal file %s: %s", path, err)
+	}
+	static := map[string]bool{}
+	for _, db := range cfg.Databases {
+		static[db.Key()] = true
+	}
+	for _, db := range state.Databases {
+		if static[db.Key()] {
+			log.Warnf("Ignoring registered database %s on server %s of %s, the config defines it", db.Name, db.Server, path)
+			continue
+		}
+		v, err := cfg.ValidateDatabase(db, collector.Names())
+		if err == nil {
+			err = collector.CheckQueries([]config.Database{v})
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid database %s in file %s: %s", db.Name, path, err)
+		}
+		raw, validated = append(raw, db), append(validated, v)
+	}
+	return raw, validated, nil
+}
+
+// ServeHTTP registers the database in the body, as YAML or JSON like a database of the config, on PUT, replacing
+// a database registered before with the same server, name and replica. DELETE deregisters the database given by
+// the database and optional server query parameters.
+func (a *databasesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	switch r.Method {
+	case "PUT":
+		a.put(w, r)
+	case "DELETE":
+		a.delete(w, r)
+	}
+}
+
+func (a *databasesAPI) put(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxDatabaseBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var db config.Database
+	if err := yaml.UnmarshalStrict(b, &db); err != nil {
+		http.Error(w, fmt.Sprintf("invalid database: %s", err), http.StatusBadRequest)
+		return
+	}
+	validated, err := a.cfg.ValidateDatabase(db, collector.Names())
+	if err == nil {
+		err = collector.CheckQueries([]config.Database{validated})
+	}
+	if err == nil {
+		err = a.exporter.CheckDatabase(validated)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid database: %s", err), http.StatusBadRequest)
+		return
+	}
+	key := db.Key()
+	for _, s := range a.static {
+		if s.Key() == key {
+			http.Error(w, fmt.Sprintf("database %s on server %s is defined by the config", db.Name, db.Server), http.StatusConflict)
+			return
+		}
+	}
+	previous, replaced := a.registered[key]
+	if replaced {
+		if err := a.exporter.RemoveDatabase(previous); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := a.exporter.AddDatabase(validated); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.registered[key] = db
+	if err := a.save(); err != nil {
+		http.Error(w, fmt.Sprintf("registered database %s, but failed to save it: %s", db.Name, err), http.StatusInternalServerError)
+		return
+	}
+	if replaced {
+		fmt.Fprintf(w, "Replaced database %s on %s\n", db.Name, db.Server)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "Registered database %s on %s\n", db.Name, db.Server)
+}
+
+func (a *databasesAPI) delete(w http.ResponseWriter, r *http.Request) {
+	name, server := r.URL.Query().Get("database"), r.URL.Query().Get("server")
+	var found []string
+	for key, db := range a.registered {
+		if db.Name == name && (server == "" || db.Server == server) {
+			found = append(found, key)
+		}
+	}
+	switch {
+	case len(found) > 1:
+		http.Error(w, fmt.Sprintf("database %s is registered on several servers, select one with server", name), http.StatusBadRequest)
+		return
+	case len(found) == 0:
+		for _, db := range a.static {
+			if db.Name == name && (server == "" || db.Server == server) {
+				http.Error(w, fmt.Sprintf("database %s on server %s is defined by the config", name, db.Server), http.StatusConflict)
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf("database %s is not registered", name), http.StatusNotFound)
+		return
+	}
+	db := a.registered[found[0]]
+	if err := a.exporter.RemoveDatabase(db); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	delete(a.registered, found[0])
+	if err := a.save(); err != nil {
+		http.Error(w, fmt.Sprintf("deregistered database %s, but failed to save it: %s", db.Name, err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Deregistered database %s on %s\n", db.Name, db.Server)
+}
+
+// save writes the registered databases to the state file, if any. The file is replaced atomically, so a crash
+// can't leave it truncated, and is only readable by the owner, as the databases hold credentials.
+func (a *databasesAPI) save() error {
+	if *databasesStateFile == "" {
+		return nil
+	}
+	keys := make([]string, 0, len(a.registered))
+	for key := range a.registered {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var state databasesState
+	for _, key := range keys {
+		state.Databases = append(state.Databases, a.registered[key])
+	}
+	b, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(*databasesStateFile), filepath.Base(*databasesStateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), *databasesStateFile); err != nil {
+		return err
+	}
+	log.Infof("Saved %d registered databases to %s", len(state.Databases), *databasesStateFile)
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var gzipLevel = kingpin.Flag("web.gzip-level", "Compression level of gzip encoded responses from 1 (fastest) to 9 (smallest).").Default("1").Int()
+
+// expositionBytes holds the size of the last uncompressed response of the metrics handler.
+var expositionBytes int64
+
+// gzipHandler compresses the responses of the wrapped metrics handler with gzip writers reused across requests,
+// instead of the client library allocating a new writer for every scrape, which dominates the cost of rendering
+// large responses. It also records the size of the uncompressed responses.
+type gzipHandler struct {
+	handler http.Handler
+	writers sync.Pool
+}
+
+func newGzipHandler(handler http.Handler, level int) (http.Handler, error) {
+	// Fail early on invalid levels.
+	if _, err := gzip.NewWriterLevel(ioutil.Discard, level); err != nil {
+		return nil, err
+	}
+	h := &gzipHandler{handler: handler}
+	h.writers.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(ioutil.Discard, level)
+		return w
+	}
+	return h, nil
+}
+
+func (h *gzipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The encoding depends on the request, so caches must not serve a compressed response to clients that can't
+	// decompress it, or the other way round.
+	w.Header().Add("Vary", "Accept-Encoding")
+	// The wrapped handler is asked for an uncompressed response.
+	req := *r
+	req.Header = http.Header{}
+	for name, values := range r.Header {
+		req.Header[name] = values
+	}
+	req.Header.Del("Accept-Encoding")
+	cw := &countingWriter{ResponseWriter: w}
+	if !acceptsGzip(r) {
+		h.handler.ServeHTTP(cw, &req)
+		atomic.StoreInt64(&expositionBytes, cw.n)
+		return
+	}
+	gz := h.writers.Get().(*gzip.Writer)
+	defer h.writers.Put(gz)
+	gz.Reset(w)
+	cw.gzip = gz
+	h.handler.ServeHTTP(cw, &req)
+	// The gzip stream is only written once the header announced it, also for empty bodies.
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.compressed {
+		gz.Close()
+	}
+	atomic.StoreInt64(&expositionBytes, cw.n)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "gzip" || strings.HasPrefix(part, "gzip;") {
+			return true
+		}
+	}
+	return false
+}
+
+// countingWriter counts the bytes written to it and compresses them if gzip is set and the status of the response
+// allows a body.
+type countingWriter struct {
+	http.ResponseWriter
+	gzip        *gzip.Writer
+	n           int64
+	wroteHeader bool
+	// compressed is set once the header announced the gzip encoding.
+	compressed bool
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	if w.gzip != nil && code >= http.StatusOK && code != http.StatusNoContent && code != http.StatusNotModified {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.compressed = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.n += int64(len(b))
+	if w.compressed {
+		return w.gzip.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
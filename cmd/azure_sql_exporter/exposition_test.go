@@ -0,0 +1,100 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGzipHandler(t *testing.T) {
+	body := strings.Repeat("azure_sql_cpu_percent{database=\"Sales\"} 12.5\n", 100)
+	h, err := newGzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "" {
+			t.Errorf("wrapped handler got Accept-Encoding %q, want none", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(body))
+	}), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compressed responses reuse the pooled writers.
+	for _, encoding := range []string{"gzip", "identity", "gzip;q=1.0, identity;q=0.5", "gzip"} {
+		atomic.StoreInt64(&expositionBytes, 0)
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		h.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: got Vary %q, want Accept-Encoding", encoding, got)
+		}
+		got := rec.Body.String()
+		if strings.HasPrefix(encoding, "gzip") {
+			if rec.Header().Get("Content-Encoding") != "gzip" {
+				t.Fatalf("%s: got Content-Encoding %q, want gzip", encoding, rec.Header().Get("Content-Encoding"))
+			}
+			r, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("%s: %s", encoding, err)
+			}
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s: %s", encoding, err)
+			}
+			got = string(b)
+		} else if rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: got Content-Encoding %q, want none", encoding, rec.Header().Get("Content-Encoding"))
+		}
+		if got != body {
+			t.Errorf("%s: got body of %d bytes, want %d", encoding, len(got), len(body))
+		}
+		if n := atomic.LoadInt64(&expositionBytes); n != int64(len(body)) {
+			t.Errorf("%s: counted %d bytes, want the %d uncompressed bytes", encoding, n, len(body))
+		}
+	}
+}
+
+func TestGzipHandlerEmptyBody(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusNoContent, http.StatusNotModified} {
+		h, err := newGzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+			}
+		}), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != status {
+			t.Errorf("got status %d, want %d", rec.Code, status)
+		}
+		if status != http.StatusOK {
+			// Responses without a body get neither the encoding nor a gzip stream.
+			if rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 0 {
+				t.Errorf("%d: got Content-Encoding %q and %d bytes of body, want neither", status, rec.Header().Get("Content-Encoding"), rec.Body.Len())
+			}
+			continue
+		}
+		// An empty body is an empty gzip stream announced by the header.
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("got Content-Encoding %q, want gzip", rec.Header().Get("Content-Encoding"))
+		}
+		r, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := ioutil.ReadAll(r); err != nil || len(b) != 0 {
+			t.Errorf("got %q, %v from the gzip stream, want an empty body", b, err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// groupHandler serves the metrics of the databases of the named group from a registry of their own, so scraping it
+// only queries the group's databases. The metrics are renamed and labeled like those of the default registry.
+func groupHandler(exporter *collector.Exporter, name string, extraLabels []string) (http.Handler, error) {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(exporter.Group(name)); err != nil {
+		return nil, err
+	}
+	g, err := newRelabelGatherer(reg, *metricsNamespace, *constLabels, extraLabels)
+	if err != nil {
+		return nil, err
+	}
+	handler, err := newGzipHandler(wrapRegistry(registryHandler(g)), *gzipLevel)
+	if err != nil {
+		return nil, err
+	}
+	return newSharedScrapeHandler(handler, *sharedScrapeWindow, *scrapeIDHeader), nil
+}
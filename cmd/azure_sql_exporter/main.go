@@ -0,0 +1,277 @@
+// Command azure_sql_exporter exports the metrics of Azure SQL databases to Prometheus.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/iamseth/azure_sql_exporter/pkg/collector"
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/log"
+)
+
+var (
+	// Version of azure_sql_exporter. Set at build time.
+	Version = "0.0.0.dev"
+	// Revision is the git commit azure_sql_exporter was built from. Set at build time.
+	Revision = "unknown"
+
+	listenAddress          = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry. Empty to not serve HTTP, e.g. with only the file sink.").Default(":9139").String()
+	metricsPath            = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+	configFile             = kingpin.Flag("config.file", "Specify the config file with the database credentials.").Default("./config.yaml").String()
+	configDir              = kingpin.Flag("config.dir", "Directory of config files to merge, one per team for example, instead of --config.file. All .yaml and .yml files in it are read.").String()
+	disableExporterMetrics = kingpin.Flag("web.disable-exporter-metrics", "Exclude the Go runtime and process metrics of the exporter itself. A minimal set of exporter metrics is always exported.").Bool()
+	auditLogFile           = kingpin.Flag("audit.log-file", "Path of a file to append a JSON record of every executed query to. Use - for stdout. Disabled if empty.").String()
+	scrapeLogFile          = kingpin.Flag("audit.scrape-log-file", "Path of a file to append a JSON record of every scrape attempt of a database to. Use - for stdout. Disabled if empty.").String()
+	shutdownTimeout        = kingpin.Flag("web.shutdown-timeout", "How long to wait for in-flight scrapes to finish on SIGINT or SIGTERM before cancelling them.").Default("30s").Duration()
+	syntheticTargets       = kingpin.Flag("test.synthetic-targets", "Number of fake databases to add that produce random metric values without connecting anywhere, for testing dashboards, alerts and the exporter itself.").Default("0").Int()
+
+	serveCommand         = kingpin.Command("serve", "Export the metrics of the configured databases.").Default()
+	connectivityCommand  = kingpin.Command("connectivity", "Check the connectivity to a database, print the report as JSON and exit with 1 if the check failed.")
+	connectivityDatabase = connectivityCommand.Arg("database", "Name of the database.").Required().String()
+	connectivityServer   = connectivityCommand.Arg("server", "Server of the database, if the name is configured on several servers.").String()
+	checkCommand         = kingpin.Command("check", "Scrape all databases once, print the metrics to stdout and exit with 1 if a scrape failed.")
+	validateCommand      = kingpin.Command("validate", "Check the config file strictly, including unknown fields, print the errors and exit with 1 if it is invalid.")
+)
+
+const namespace = "azure_sql"
+
+// The settings of the collector and config packages are exposed as flags.
+func init() {
+	kingpin.Flag("config.age-key-file", "Path of the age key file with the identities that decrypt a config encrypted with age or SOPS. Defaults to $SOPS_AGE_KEY_FILE.").StringVar(&config.AgeKeyFile)
+	kingpin.Flag("scrape.history-size", "Number of recent scrape attempts of all databases served by /api/v1/scrapes. 0 disables the history.").Default("1000").IntVar(&collector.ScrapeHistorySize)
+	kingpin.Flag("scrape.backoff-initial", "How long to wait before scraping a database again after a failed scrape, e.g. 30s. Doubles with every consecutive failure. 0 disables backoff.").Default("0").DurationVar(&collector.BackoffInitial)
+	kingpin.Flag("scrape.backoff-max", "Maximum time to wait before scraping a failing database again.").Default("10m").DurationVar(&collector.BackoffMax)
+	kingpin.Flag("scrape.metric-ttl", "Maximum age of values served from an earlier scrape of a database, e.g. within its min_scrape_interval. The resource and collector metrics of a database without a successful scrape within the TTL are withheld, so Prometheus marks them stale. 0 disables the TTL.").Default("0").DurationVar(&collector.MetricTTL)
+	kingpin.Flag("azure.refresh-interval", "How often the resources of the databases are looked up through the Azure Resource Manager API.").Default("1h").DurationVar(&collector.AzureRefreshInterval)
+	kingpin.Flag("azure.monitor-interval", "How often the Azure Monitor metrics in monitor_metrics of the azure section are pulled, one request per database.").Default("5m").DurationVar(&collector.AzureMonitorInterval)
+	kingpin.Flag("scrape.capability-recheck-interval", "How long to skip queries the exporter lacks the permissions for before trying them again.").Default("1h").DurationVar(&collector.CapabilityRecheckInterval)
+	kingpin.Flag("scrape.max-concurrency-per-server", "Maximum number of databases of the same server scraped concurrently. 0 scrapes all databases concurrently.").Default("0").IntVar(&collector.MaxServerConcurrency)
+	kingpin.Flag("scrape.adaptive-concurrency", "Adjust the number of databases of a server scraped concurrently between 1 and --scrape.max-concurrency-per-server, shrinking it on throttling errors and slow scrapes and growing it while scrapes are fast.").BoolVar(&collector.AdaptiveConcurrency)
+	kingpin.Flag("scrape.adaptive-concurrency.latency-target", "Scrape duration above which adaptive concurrency shrinks the limit of the server.").Default("2s").DurationVar(&collector.LatencyTarget)
+	kingpin.Flag("scrape.stagger", "Time window over which the starts of the scrapes of the databases are spread evenly, in the order of the config, to smooth the burst of connections. The scrape_timeout of Prometheus must leave room for it. 0 starts all scrapes at once.").Default("0s").DurationVar(&collector.ScrapeStagger)
+	kingpin.Flag("scrape.jitter", "Maximum random delay added to the start of the scrape of every database, on top of --scrape.stagger.").Default("0s").DurationVar(&collector.ScrapeJitter)
+	kingpin.Flag("scrape.circuit-breaker.threshold", "Number of consecutive failures to connect to or log in to a server, by any of its databases, after which no connections to the server are attempted for --scrape.circuit-breaker.cooldown. 0 disables the circuit breakers.").Default("0").IntVar(&collector.BreakerThreshold)
+	kingpin.Flag("scrape.circuit-breaker.cooldown", "How long no connections to a server are attempted once its circuit breaker opened, before a single scrape probes whether it recovered.").Default("1m").DurationVar(&collector.BreakerCooldown)
+	kingpin.Flag("scrape.missing-retry-interval", "How long to wait before scraping a database again after it was found missing, e.g. because it was dropped.").Default("1h").DurationVar(&collector.MissingRetryInterval)
+	kingpin.Flag("scrape.timeout", "Time budget of a scrape of a database. Transient errors are retried as long as the budget allows; logins and queries the server doesn't respond to within it fail.").Default("10s").DurationVar(&collector.ScrapeTimeout)
+	kingpin.Flag("scrape.lock-timeout", "How long a query of a collector waits for a lock before it fails, set with SET LOCK_TIMEOUT. 0 waits as long as the server's default.").Default("5s").DurationVar(&collector.LockTimeout)
+	kingpin.Flag("scrape.retry-initial-delay", "Delay before the first retry of a scrape that failed with a transient error. Doubles with every retry.").Default("500ms").DurationVar(&collector.RetryInitialDelay)
+	kingpin.Flag("collector.index_stats.interval", "How often the index_stats collector queries a database. Results are reused in between.").Default("1h").DurationVar(&collector.IndexStatsInterval)
+	kingpin.Flag("collector.index_stats.table-filter", "LIKE pattern of the schema.table names the index_stats collector inspects.").Default("%").StringVar(&collector.IndexStatsTableFilter)
+	kingpin.Flag("collector.long_running_queries.thresholds", "Comma separated durations the long_running_queries collector counts the queries running longer than.").Default("10s,1m,5m").SetValue(&collector.LongRunningThresholds)
+	kingpin.Flag("collector.max-series-per-metric", "Maximum number of series of a metric a collector exports per database. Further series are dropped and counted in azure_sql_dropped_series_total. 0 disables the limit.").Default("1000").IntVar(&collector.MaxSeriesPerMetric)
+	kingpin.Flag("collector.max-series", "Maximum number of series of the collectors the exporter holds across all databases, to bound its memory. Further series are dropped and counted in azure_sql_dropped_series_total. 0 disables the limit.").Default("0").IntVar(&collector.MaxSeries)
+	kingpin.Flag("collector.query-duration-buckets", "Upper bound in seconds of a bucket of the histogram of the round trips of the queries, azure_sql_query_duration_seconds. Repeat for every bucket.").Default("0.005", "0.01", "0.025", "0.05", "0.1", "0.25", "0.5", "1", "2.5", "5", "10").Float64ListVar(&collector.QueryDurationBuckets)
+	kingpin.Flag("collector.background", "Optional collector refreshed in the background, e.g. index_stats or query_store. Scrapes serve its last result right away and refresh it in the background once it is older than the interval of the collector. Repeat for every collector.").EnumsVar(&collector.BackgroundCollectors, collector.Names()...)
+	kingpin.Flag("collector.max-label-value-length", "Maximum length in characters of the label values of the collectors' metrics. Longer values are truncated. 0 disables the limit.").Default("256").IntVar(&collector.MaxLabelValueLength)
+	kingpin.Flag("startup.prewarm-all", "Connect to all databases at startup, as if every database set prewarm.").BoolVar(&collector.PrewarmAll)
+	kingpin.Flag("startup.prewarm-rate", "Number of connections per second established to prewarmed databases at startup.").Default("10").Float64Var(&collector.PrewarmRate)
+	kingpin.Flag("health.ping-interval", "How often to ping every database in the background, independent of scrapes, exporting azure_sql_db_reachable. 0 disables the pings.").Default("0s").DurationVar(&collector.PingInterval)
+	kingpin.Flag("collector.query_store.top-n", "Number of queries with the highest total CPU time exported by the query_store collector.").Default("10").IntVar(&collector.QueryStoreTopN)
+	kingpin.Flag("collector.query_store.lookback", "Only consider Query Store runtime stats of queries executed within this duration.").Default("1h").DurationVar(&collector.QueryStoreLookback)
+	kingpin.Flag("collector.query_store.interval", "How often the query_store collector queries a database. Results are reused in between. 0 queries the database on every scrape.").Default("0").DurationVar(&collector.QueryStoreInterval)
+	kingpin.Flag("collector.requests.top-wait-types", "Number of wait types with the most waiting requests exported by name by the requests collector. Others are summed up as other.").Default("10").IntVar(&collector.RequestsTopWaitTypes)
+	kingpin.Flag("collector.rollups", "Export the maximum and average CPU and data I/O utilization of the scraped databases per logical server and per elastic pool, and the number of databases of every logical server by health.").BoolVar(&collector.RollupsEnabled)
+	kingpin.Flag("collector.storage_growth.window", "Time window over which the storage_growth collector computes the growth rate of the used storage.").Default("24h").DurationVar(&collector.StorageGrowthWindow)
+	kingpin.Flag("test.synthetic-failure-rate", "Ratio of scrapes of fake databases that fail.").Default("0.05").Float64Var(&collector.SyntheticFailureRate)
+}
+
+func main() {
+	kingpin.CommandLine.Name = "azure_sql_exporter"
+	// Every flag can also be set through its AZURE_SQL_EXPORTER_* environment variable, e.g.
+	// AZURE_SQL_EXPORTER_WEB_LISTEN_ADDRESS for --web.listen-address.
+	kingpin.CommandLine.DefaultEnvars()
+	// The log package registers its level with the standard flag package.
+	kingpin.Flag("log.level", "Only log messages with the given severity or above. Valid levels: [debug, info, warn, error, fatal, panic].").Default("info").SetValue(flag.CommandLine.Lookup("log.level").Value)
+	kingpin.Version(fmt.Sprintf("azure_sql_exporter, version %s (revision: %s)\n  go version: %s", Version, Revision, runtime.Version()))
+	kingpin.CommandLine.VersionFlag.NoEnvar()
+	kingpin.HelpFlag.Short('h').NoEnvar()
+	command := kingpin.Parse()
+	config.DefaultAppName = "azure_sql_exporter/" + Version
+	if command == validateCommand.FullCommand() {
+		if *configDir != "" {
+			os.Exit(validateDir(*configDir))
+		}
+		os.Exit(validate(*configFile))
+	}
+	if command == benchCommand.FullCommand() {
+		os.Exit(bench())
+	}
+	if strings.HasPrefix(command, serviceCommand.FullCommand()+" ") {
+		if err := controlService(command); err != nil {
+			log.Fatalf("Cannot %s: %s", command, err)
+		}
+		os.Exit(0)
+	}
+	configSource := *configFile
+	if *configDir != "" {
+		configSource = *configDir
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Cannot open config file %s: %s", configSource, err)
+	}
+	loaded, static := cfg, cfg.Databases
+	var registered []config.Database
+	if *databasesStateFile != "" {
+		var restored []config.Database
+		if registered, restored, err = loadDatabasesState(*databasesStateFile, cfg); err != nil {
+			log.Fatalf("Cannot restore registered databases: %s", err)
+		}
+		cfg.Databases = append(append([]config.Database{}, static...), restored...)
+	}
+	collector.LabelRedaction = cfg.Redaction
+	if err := collector.StartTracing(); err != nil {
+		log.Fatalf("Cannot start tracing: %s", err)
+	}
+	exporter := collector.NewExporter(cfg.Databases, cfg.TierCollectors)
+	if command == connectivityCommand.FullCommand() {
+		os.Exit(exporter.CheckConnectivity(*connectivityDatabase, *connectivityServer))
+	}
+	wrapRegistry = exporter.FilterHandler
+	if err := exporter.SetInfoMetrics(cfg.InfoMetrics); err != nil {
+		log.Fatalf("Invalid info metrics in config file %s: %s", configSource, err)
+	}
+	if *auditLogFile != "" {
+		if err := exporter.SetAuditLog(*auditLogFile); err != nil {
+			log.Fatalf("Cannot open audit log: %s", err)
+		}
+	}
+	if *scrapeLogFile != "" {
+		if err := exporter.SetScrapeLog(*scrapeLogFile); err != nil {
+			log.Fatalf("Cannot open scrape log: %s", err)
+		}
+	}
+	prometheus.MustRegister(exporter)
+	prometheus.MustRegister(newSelfCollector())
+	extraLabels := config.LabelNames(cfg.Databases)
+	if gatherer, err = newRelabelGatherer(prometheus.DefaultGatherer, *metricsNamespace, *constLabels, extraLabels); err != nil {
+		log.Fatalf("Invalid --metrics.namespace or --metrics.const-label: %s", err)
+	}
+	var resources *collector.AzureResources
+	if cfg.Azure != nil {
+		resources = collector.NewAzureResources(*cfg.Azure)
+		go resources.Run()
+		prometheus.MustRegister(collector.NewAzureTagsCollector(resources, cfg.Databases, extraLabels))
+		if cfg.Azure.BackupRetention {
+			prometheus.MustRegister(collector.NewBackupRetentionCollector(resources, cfg.Databases, extraLabels))
+		}
+		if len(cfg.Azure.MonitorMetrics) > 0 {
+			monitor := collector.NewMonitorCollector(resources, cfg.Databases, extraLabels, cfg.Azure.MonitorMetrics)
+			go monitor.Run()
+			prometheus.MustRegister(monitor)
+		}
+		if cfg.Azure.EgressIPURL != "" {
+			prometheus.MustRegister(collector.NewFirewallCollector(resources, cfg.Databases, extraLabels))
+		}
+	}
+	prometheus.MustRegister(collector.NewRegionCollector(resources, cfg.Databases, extraLabels))
+	if *disableExporterMetrics {
+		prometheus.Unregister(collectors.NewGoCollector())
+		prometheus.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+	if command == checkCommand.FullCommand() {
+		code := check(exporter)
+		exporter.Close()
+		collector.StopTracing()
+		os.Exit(code)
+	}
+	stop := stopRequests()
+	go exporter.Prewarm()
+	exporter.RunHealthChecks()
+	databases := newDatabasesAPI(exporter, cfg, static, registered)
+	if *configWatchInterval > 0 {
+		go watchConfig(databases, loaded, *configWatchInterval)
+	}
+	sinks, err := newSinks(*sinkNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, sink := range sinks {
+		if err := sink.Start(gatherRegistry); err != nil {
+			log.Fatalf("Cannot start sink: %s", err)
+		}
+	}
+	for _, t := range cfg.Tenants {
+		handler, err := newGzipHandler(tenantHandler(t), *gzipLevel)
+		if err != nil {
+			log.Fatalf("Invalid --web.gzip-level: %s", err)
+		}
+		http.Handle(path.Join(*metricsPath, t.Name), newSharedScrapeHandler(handler, *sharedScrapeWindow, *scrapeIDHeader))
+	}
+	for _, name := range config.Groups(cfg.Databases) {
+		handler, err := groupHandler(exporter, name, extraLabels)
+		if err != nil {
+			log.Fatalf("Cannot serve the metrics of group %s: %s", name, err)
+		}
+		http.Handle(path.Join(*metricsPath, name), handler)
+	}
+	if *adminTokenFile != "" {
+		for path, handler := range map[string]http.HandlerFunc{"/api/targets/disable": exporter.DisableHandler, "/api/targets/enable": exporter.EnableHandler, "/scrape": exporter.ScrapeHandler} {
+			h, err := adminHandler(handler)
+			if err != nil {
+				log.Fatal(err)
+			}
+			http.Handle(path, h)
+		}
+		h, err := adminHandler(databases.ServeHTTP, "PUT", "DELETE")
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.Handle("/api/v1/databases", h)
+	}
+	http.HandleFunc("/targets", exporter.TargetsHandler)
+	http.HandleFunc("/api/v1/targets", exporter.TargetsAPIHandler)
+	http.HandleFunc("/api/v1/scrapes", exporter.ScrapesAPIHandler)
+	http.HandleFunc("/debug/connectivity", exporter.ConnectivityHandler)
+	http.HandleFunc("/", exporter.LandingPageHandler(*metricsPath))
+	server := &http.Server{Addr: *listenAddress}
+	if *listenAddress == "" {
+		// Hosts that can't open another port deliver the metrics through a push based sink or a file only.
+		for _, sink := range sinks {
+			if _, ok := sink.(prometheusSink); ok {
+				log.Fatal("The prometheus sink requires --web.listen-address")
+			}
+		}
+		log.Info("Not serving HTTP, --web.listen-address is empty")
+	} else {
+		log.Infof("Starting Server: %s", *listenAddress)
+		// Listening before serving in the background lets the service manager be told the exporter is ready only
+		// once it accepts connections.
+		listener, err := net.Listen("tcp", *listenAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			if err := server.Serve(listener); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+	sdNotify("READY=1")
+
+	log.Infof("Received %s, shutting down", <-stop)
+	sdNotify("STOPPING=1")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Warnf("Cancelling in-flight scrapes: %s", err)
+	}
+	for _, sink := range sinks {
+		sink.Stop()
+	}
+	exporter.Shutdown(ctx)
+	collector.StopTracing()
+	stopped()
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// otlpPush returns a function sending metric families to the OTLP/HTTP metrics endpoint at url, e.g.
+// http://otel-collector:4318/v1/metrics, using the JSON encoding. Counters become monotonic cumulative sums starting
+// at start, the time the sink was created, gauges and untyped metrics gauges, and histograms and summaries keep
+// their buckets and quantiles.
+func otlpPush(url string, timeout time.Duration, start time.Time) func([]*dto.MetricFamily) error {
+	client := &http.Client{Timeout: timeout}
+	return func(families []*dto.MetricFamily) error {
+		body, err := json.Marshal(toOTLP(families, start, time.Now()))
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "azure_sql_exporter/"+Version)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			return fmt.Errorf("OTLP endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+		return nil
+	}
+}
+
+// toOTLP converts metric families into an OTLP export request. Metrics without a timestamp get now as their
+// timestamp.
+func toOTLP(families []*dto.MetricFamily, start, now time.Time) otlpMetricsRequest {
+	startNano := strconv.FormatInt(start.UnixNano(), 10)
+	var metrics []otlpMetric
+	for _, mf := range families {
+		metric := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		var points []otlpDataPoint
+		for _, m := range mf.Metric {
+			ts := now.UnixNano()
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs() * int64(time.Millisecond)
+			}
+			p := otlpDataPoint{TimeUnixNano: strconv.FormatInt(ts, 10)}
+			for _, l := range m.Label {
+				p.Attributes = append(p.Attributes, otlpAttr(l.GetName(), l.GetValue()))
+			}
+			switch {
+			case m.Counter != nil:
+				p.StartTimeUnixNano = startNano
+				p.AsDouble = double(m.Counter.GetValue())
+			case m.Gauge != nil:
+				p.AsDouble = double(m.Gauge.GetValue())
+			case m.Untyped != nil:
+				p.AsDouble = double(m.Untyped.GetValue())
+			case m.Summary != nil:
+				p.StartTimeUnixNano = startNano
+				p.Count = strconv.FormatUint(m.Summary.GetSampleCount(), 10)
+				p.Sum = double(m.Summary.GetSampleSum())
+				for _, q := range m.Summary.Quantile {
+					p.QuantileValues = append(p.QuantileValues, otlpQuantile{Quantile: otlpDouble(q.GetQuantile()), Value: otlpDouble(q.GetValue())})
+				}
+			case m.Histogram != nil:
+				p.StartTimeUnixNano = startNano
+				p.Count = strconv.FormatUint(m.Histogram.GetSampleCount(), 10)
+				p.Sum = double(m.Histogram.GetSampleSum())
+				// OTLP counts the observations of every bucket on its own, with a last bucket above the highest
+				// bound.
+				var cumulative uint64
+				for _, b := range m.Histogram.Bucket {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					p.ExplicitBounds = append(p.ExplicitBounds, otlpDouble(b.GetUpperBound()))
+					p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-cumulative, 10))
+					cumulative = b.GetCumulativeCount()
+				}
+				p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(m.Histogram.GetSampleCount()-cumulative, 10))
+			default:
+				continue
+			}
+			points = append(points, p)
+		}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			metric.Sum = &otlpSum{DataPoints: points, AggregationTemporality: otlpCumulative, IsMonotonic: true}
+		case dto.MetricType_SUMMARY:
+			metric.Summary = &otlpPoints{DataPoints: points}
+		case dto.MetricType_HISTOGRAM:
+			metric.Histogram = &otlpSum{DataPoints: points, AggregationTemporality: otlpCumulative}
+		default:
+			metric.Gauge = &otlpPoints{DataPoints: points}
+		}
+		metrics = append(metrics, metric)
+	}
+	return otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			otlpAttr("service.name", "azure_sql_exporter"),
+			otlpAttr("service.version", Version),
+		}},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "azure_sql_exporter", Version: Version},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+// otlpCumulative is the aggregation temporality of values accumulated since the start time.
+const otlpCumulative = 2
+
+// otlpMetricsRequest is an OTLP metrics export request in the JSON encoding, in which 64 bit integers are strings.
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// otlpMetric is a metric with exactly one of Gauge, Sum, Histogram and Summary set.
+type otlpMetric struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Gauge       *otlpPoints `json:"gauge,omitempty"`
+	Sum         *otlpSum    `json:"sum,omitempty"`
+	Histogram   *otlpSum    `json:"histogram,omitempty"`
+	Summary     *otlpPoints `json:"summary,omitempty"`
+}
+
+type otlpPoints struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic,omitempty"`
+}
+
+// otlpDataPoint holds the fields of the number, histogram and summary data points; the fields of the other kinds
+// are left empty.
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          *otlpDouble     `json:"asDouble,omitempty"`
+	Count             string          `json:"count,omitempty"`
+	Sum               *otlpDouble     `json:"sum,omitempty"`
+	BucketCounts      []string        `json:"bucketCounts,omitempty"`
+	ExplicitBounds    []otlpDouble    `json:"explicitBounds,omitempty"`
+	QuantileValues    []otlpQuantile  `json:"quantileValues,omitempty"`
+}
+
+type otlpQuantile struct {
+	Quantile otlpDouble `json:"quantile"`
+	Value    otlpDouble `json:"value"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		String string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func otlpAttr(key, value string) otlpAttribute {
+	a := otlpAttribute{Key: key}
+	a.Value.String = value
+	return a
+}
+
+// double returns a pointer to f, for the fields that are sent even if 0.
+func double(f float64) *otlpDouble {
+	d := otlpDouble(f)
+	return &d
+}
+
+// otlpDouble is a float encoded like protobuf encodes doubles in JSON, with NaN and the infinities as strings.
+type otlpDouble float64
+
+func (d otlpDouble) MarshalJSON() ([]byte, error) {
+	switch f := float64(d); {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Infinity"`), nil
+	}
+	return json.Marshal(float64(d))
+}
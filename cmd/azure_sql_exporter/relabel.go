@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	metricsNamespace = kingpin.Flag("metrics.namespace", "Prefix of the names of the exporter's metrics in place of azure_sql, so several exporter deployments can share one Prometheus without clashing.").Default(namespace).String()
+	constLabels      = kingpin.Flag("metrics.const-label", "Label added to all metrics as name=value, e.g. environment=production. Can be repeated.").StringMap()
+)
+
+var (
+	namespaceRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// gatherer collects the metrics served by the metrics handler and the sinks.
+var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+
+// relabelGatherer renames the metrics of the exporter from the azure_sql namespace to namespace and adds the
+// constant labels to all metrics. Metrics that already have one of the labels, e.g. a static label of the database,
+// keep their own value.
+type relabelGatherer struct {
+	prometheus.Gatherer
+	namespace string
+	labels    []*dto.LabelPair
+}
+
+// newRelabelGatherer returns a gatherer renaming the metrics of g to namespace and adding the labels, or g itself if
+// there is nothing to change. Invalid names and labels reserved by the exporter are an error.
+func newRelabelGatherer(g prometheus.Gatherer, namespace string, labels map[string]string, extraLabels []string) (prometheus.Gatherer, error) {
+	if !namespaceRE.MatchString(namespace) {
+		return nil, fmt.Errorf("invalid namespace %q", namespace)
+	}
+	r := relabelGatherer{Gatherer: g, namespace: namespace}
+	for name, value := range labels {
+		if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		if name == "server" || name == "database" || contains(extraLabels, name) {
+			return nil, fmt.Errorf("label %q is already set by the exporter or the labels of the databases", name)
+		}
+		name, value := name, value
+		r.labels = append(r.labels, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	if r.namespace == namespace && len(r.labels) == 0 {
+		return g, nil
+	}
+	return r, nil
+}
+
+func (r relabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := r.Gatherer.Gather()
+	for _, mf := range families {
+		if strings.HasPrefix(mf.GetName(), namespace+"_") {
+			name := r.namespace + strings.TrimPrefix(mf.GetName(), namespace)
+			mf.Name = &name
+		}
+		for _, m := range mf.Metric {
+			m.Label = addLabels(m.Label, r.labels)
+		}
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+	return families, err
+}
+
+// addLabels adds the labels a metric doesn't have yet to its label pairs, sorted by name like the registry sorts
+// them.
+func addLabels(pairs, labels []*dto.LabelPair) []*dto.LabelPair {
+	for _, l := range labels {
+		found := false
+		for _, p := range pairs {
+			if p.GetName() == l.GetName() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			pairs = append(pairs, l)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+	return pairs
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// sample is a single value of a series to be sent with remote write.
+type sample struct {
+	labels    []*dto.LabelPair
+	value     float64
+	timestamp int64
+}
+
+// remoteWrite returns a function sending metric families to the remote write endpoint at url. Summaries and
+// histograms are split into their _sum, _count and quantile or _bucket series, like Prometheus does on scrape.
+func remoteWrite(url string, timeout time.Duration) func([]*dto.MetricFamily) error {
+	client := &http.Client{Timeout: timeout}
+	return func(families []*dto.MetricFamily) error {
+		body := snappy.Encode(nil, encodeWriteRequest(toSamples(families, time.Now())))
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("User-Agent", "azure_sql_exporter/"+Version)
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+			return fmt.Errorf("remote write endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+		return nil
+	}
+}
+
+// toSamples flattens metric families into samples. Metrics without a timestamp get now as their timestamp.
+func toSamples(families []*dto.MetricFamily, now time.Time) []sample {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	var samples []sample
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			ts := nowMs
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...*dto.LabelPair) {
+				labels := append([]*dto.LabelPair{{Name: proto.String("__name__"), Value: proto.String(mf.GetName() + suffix)}}, m.Label...)
+				labels = append(labels, extra...)
+				sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+				samples = append(samples, sample{labels: labels, value: value, timestamp: ts})
+			}
+			switch {
+			case m.Counter != nil:
+				add("", m.Counter.GetValue())
+			case m.Gauge != nil:
+				add("", m.Gauge.GetValue())
+			case m.Untyped != nil:
+				add("", m.Untyped.GetValue())
+			case m.Summary != nil:
+				for _, q := range m.Summary.Quantile {
+					add("", q.GetValue(), &dto.LabelPair{Name: proto.String("quantile"), Value: proto.String(strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64))})
+				}
+				add("_sum", m.Summary.GetSampleSum())
+				add("_count", float64(m.Summary.GetSampleCount()))
+			case m.Histogram != nil:
+				infSeen := false
+				for _, b := range m.Histogram.Bucket {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						infSeen = true
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), &dto.LabelPair{Name: proto.String("le"), Value: proto.String(strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64))})
+				}
+				if !infSeen {
+					add("_bucket", float64(m.Histogram.GetSampleCount()), &dto.LabelPair{Name: proto.String("le"), Value: proto.String("+Inf")})
+				}
+				add("_sum", m.Histogram.GetSampleSum())
+				add("_count", float64(m.Histogram.GetSampleCount()))
+			}
+		}
+	}
+	return samples
+}
+
+// encodeWriteRequest encodes samples as a prometheus.WriteRequest protobuf message with one time series per sample.
+func encodeWriteRequest(samples []sample) []byte {
+	var req []byte
+	for _, s := range samples {
+		var series []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.GetName())
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.GetValue())
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var value []byte
+		value = protowire.AppendTag(value, 1, protowire.Fixed64Type)
+		value = protowire.AppendFixed64(value, math.Float64bits(s.value))
+		value = protowire.AppendTag(value, 2, protowire.VarintType)
+		value = protowire.AppendVarint(value, uint64(s.timestamp))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, value)
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, series)
+	}
+	return req
+}
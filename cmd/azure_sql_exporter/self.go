@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// selfCollector exports a minimal set of metrics about the exporter process itself. Unlike the Go and process
+// collectors of the client library, it only exports five series, so it stays cheap on large fleets.
+type selfCollector struct {
+	buildInfo      prometheus.Metric
+	residentMemory *prometheus.Desc
+	goroutines     *prometheus.Desc
+	openFDs        *prometheus.Desc
+	exposition     *prometheus.Desc
+}
+
+func newSelfCollector() *selfCollector {
+	return &selfCollector{
+		buildInfo: prometheus.MustNewConstMetric(
+			prometheus.NewDesc(namespace+"_exporter_build_info", "Version, revision and Go version the exporter was built with. Always 1.", []string{"version", "revision", "goversion"}, nil),
+			prometheus.GaugeValue, 1, Version, Revision, runtime.Version(),
+		),
+		residentMemory: prometheus.NewDesc(namespace+"_exporter_resident_memory_bytes", "Resident memory size of the exporter process.", nil, nil),
+		goroutines:     prometheus.NewDesc(namespace+"_exporter_goroutines", "Number of goroutines of the exporter process.", nil, nil),
+		openFDs:        prometheus.NewDesc(namespace+"_exporter_open_fds", "Number of open file descriptors of the exporter process.", nil, nil),
+		exposition:     prometheus.NewDesc(namespace+"_exporter_exposition_bytes", "Size of the last uncompressed response of the metrics endpoint.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *selfCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.buildInfo.Desc()
+	ch <- c.residentMemory
+	ch <- c.goroutines
+	ch <- c.openFDs
+	ch <- c.exposition
+}
+
+// Collect implements prometheus.Collector. Memory and file descriptors are read from /proc and omitted where it
+// isn't available.
+func (c *selfCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- c.buildInfo
+	ch <- prometheus.MustNewConstMetric(c.exposition, prometheus.GaugeValue, float64(atomic.LoadInt64(&expositionBytes)))
+	ch <- prometheus.MustNewConstMetric(c.goroutines, prometheus.GaugeValue, float64(runtime.NumGoroutine()))
+	if rss, err := residentMemory(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.residentMemory, prometheus.GaugeValue, rss)
+	}
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.openFDs, prometheus.GaugeValue, float64(len(fds)))
+	}
+}
+
+// residentMemory returns the resident set size of the process in bytes.
+func residentMemory() (float64, error) {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	var size, resident uint64
+	if _, err := fmt.Sscan(string(statm), &size, &resident); err != nil {
+		return 0, err
+	}
+	return float64(resident * uint64(os.Getpagesize())), nil
+}
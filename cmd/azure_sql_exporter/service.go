@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"os"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/log"
+)
+
+var (
+	serviceName             = kingpin.Flag("service.name", "Name of the Windows service of the exporter.").Default("azure_sql_exporter").String()
+	serviceCommand          = kingpin.Command("service", "Manage the Windows service of the exporter.")
+	serviceInstallCommand   = serviceCommand.Command("install", "Install the exporter as a Windows service starting automatically, serving with the flags given to install.")
+	serviceUninstallCommand = serviceCommand.Command("uninstall", "Remove the Windows service of the exporter.")
+	serviceStartCommand     = serviceCommand.Command("start", "Start the Windows service of the exporter.")
+	serviceStopCommand      = serviceCommand.Command("stop", "Stop the Windows service of the exporter and wait until it stopped.")
+)
+
+// serviceArgs returns the arguments the installed service is started with: the flags given to the install command,
+// without the command itself.
+func serviceArgs() []string {
+	var args []string
+	for _, arg := range os.Args[1:] {
+		if arg != serviceCommand.FullCommand() && arg != "install" {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
+
+// sdNotify sends the state, e.g. READY=1, to systemd if the exporter runs as a unit of Type=notify. It does
+// nothing otherwise.
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	// Go maps a leading @ to the abstract namespace, as systemd does.
+	conn, err := net.Dial("unixgram", addr)
+	if err != nil {
+		log.Warnf("Cannot notify systemd of %s: %s", state, err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Warnf("Cannot notify systemd of %s: %s", state, err)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// controlService fails, Windows services only exist on Windows.
+func controlService(command string) error {
+	return fmt.Errorf("Windows services are only supported on Windows")
+}
+
+// stopRequests returns a channel receiving the reason to shut down, SIGINT or SIGTERM.
+func stopRequests() <-chan string {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	stop := make(chan string, 1)
+	go func() {
+		stop <- (<-signals).String()
+	}()
+	return stop
+}
+
+// stopped reports that the exporter shut down. Only Windows services report it.
+func stopped() {}
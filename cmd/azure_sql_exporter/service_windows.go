@@ -0,0 +1,144 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/prometheus/log"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceStopTimeout is how long the stop command waits for the service to stop.
+const serviceStopTimeout = time.Minute
+
+// controlService runs the service command, e.g. "service install", through the service control manager.
+func controlService(command string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("cannot connect to the service control manager: %s", err)
+	}
+	defer m.Disconnect()
+	if command == serviceInstallCommand.FullCommand() {
+		return installService(m)
+	}
+	s, err := m.OpenService(*serviceName)
+	if err != nil {
+		return fmt.Errorf("cannot open service %s: %s", *serviceName, err)
+	}
+	defer s.Close()
+	switch command {
+	case serviceUninstallCommand.FullCommand():
+		return s.Delete()
+	case serviceStartCommand.FullCommand():
+		return s.Start()
+	}
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(serviceStopTimeout); status.State != svc.Stopped; {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s didn't stop within %s", *serviceName, serviceStopTimeout)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installService installs the exporter as a service starting automatically and restarted by the service control
+// manager if it fails.
+func installService(m *mgr.Mgr) error {
+	if s, err := m.OpenService(*serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", *serviceName)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	s, err := m.CreateService(*serviceName, exe, mgr.Config{
+		DisplayName: "Azure SQL Exporter",
+		Description: "Exports the metrics of Azure SQL databases to Prometheus.",
+		StartType:   mgr.StartAutomatic,
+	}, serviceArgs()...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}, uint32((24 * time.Hour).Seconds()))
+}
+
+// windowsService runs the exporter under the service control manager.
+type windowsService struct {
+	stop chan string
+	// done is closed once the exporter shut down, exited once the service control manager was told.
+	done, exited chan struct{}
+}
+
+// Execute reports the service running and forwards the first stop or shutdown request to stop. It returns once
+// the exporter shut down, as the service control manager considers the service stopped then.
+func (s *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				changes <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				select {
+				case s.stop <- "service stop request":
+				default:
+				}
+			}
+		case <-s.done:
+			return false, 0
+		}
+	}
+}
+
+// service is the running Windows service, if the exporter runs as one.
+var service *windowsService
+
+// stopRequests returns a channel receiving the reason to shut down, a stop request of the service control manager
+// if the exporter runs as a service, or an interrupt otherwise.
+func stopRequests() <-chan string {
+	stop := make(chan string, 1)
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		log.Warnf("Cannot tell whether the exporter runs as a Windows service: %s", err)
+	}
+	if !isService {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt)
+		go func() {
+			stop <- (<-signals).String()
+		}()
+		return stop
+	}
+	service = &windowsService{stop: stop, done: make(chan struct{}), exited: make(chan struct{})}
+	go func() {
+		defer close(service.exited)
+		if err := svc.Run(*serviceName, service); err != nil {
+			log.Errorf("Cannot run as Windows service %s: %s", *serviceName, err)
+		}
+	}()
+	return stop
+}
+
+// stopped reports to the service control manager that the exporter shut down, if it runs as a service.
+func stopped() {
+	if service != nil {
+		close(service.done)
+		<-service.exited
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var (
+	sharedScrapeWindow = kingpin.Flag("web.shared-scrape-window", "Scrapes arriving within this duration of each other share one collection and receive identical responses, e.g. from HA Prometheus pairs. 0 disables sharing.").Default("0").Duration()
+	scrapeIDHeader     = kingpin.Flag("web.scrape-id-header", "Request header identifying a scrape. Only scrapes with the same value, or without the header, share a collection.").Default("X-Scrape-Id").String()
+)
+
+// sharedResponse is a recorded response of the wrapped handler that may be served to several scrapes.
+type sharedResponse struct {
+	created time.Time
+	// done is closed once the response is recorded.
+	done   chan struct{}
+	code   int
+	header http.Header
+	body   []byte
+}
+
+// sharedScrapeHandler lets scrapes arriving within window of each other share one pass of the wrapped handler,
+// so they get byte identical responses and the databases are only queried once.
+type sharedScrapeHandler struct {
+	handler  http.Handler
+	window   time.Duration
+	idHeader string
+
+	mutex     sync.Mutex
+	responses map[string]*sharedResponse
+}
+
+func newSharedScrapeHandler(handler http.Handler, window time.Duration, idHeader string) http.Handler {
+	if window <= 0 {
+		return handler
+	}
+	return &sharedScrapeHandler{
+		handler:   handler,
+		window:    window,
+		idHeader:  idHeader,
+		responses: map[string]*sharedResponse{},
+	}
+}
+
+func (h *sharedScrapeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Responses differ by the collectors asked for, the negotiated format and compression, so only requests asking
+	// for the same may share.
+	key := r.Header.Get(h.idHeader) + "\x00" + r.URL.RawQuery + "\x00" + r.Header.Get("Accept") + "\x00" + r.Header.Get("Accept-Encoding")
+
+	h.mutex.Lock()
+	now := time.Now()
+	for k, resp := range h.responses {
+		if now.Sub(resp.created) >= h.window {
+			delete(h.responses, k)
+		}
+	}
+	resp, shared := h.responses[key]
+	if !shared {
+		resp = &sharedResponse{created: now, done: make(chan struct{})}
+		h.responses[key] = resp
+	}
+	h.mutex.Unlock()
+
+	if shared {
+		<-resp.done
+	} else {
+		h.record(key, resp, r)
+	}
+	for name, values := range resp.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.code)
+	w.Write(resp.body)
+}
+
+// record serves r with the wrapped handler and records the response in resp, the response shared under key. If
+// the handler panics, the scrapes waiting on resp get a 500 and the next scrape runs the handler again.
+func (h *sharedScrapeHandler) record(key string, resp *sharedResponse, r *http.Request) {
+	recorded := false
+	defer func() {
+		if !recorded {
+			resp.code = http.StatusInternalServerError
+			h.mutex.Lock()
+			if h.responses[key] == resp {
+				delete(h.responses, key)
+			}
+			h.mutex.Unlock()
+		}
+		close(resp.done)
+	}()
+	rec := httptest.NewRecorder()
+	h.handler.ServeHTTP(rec, r)
+	resp.code, resp.header, resp.body = rec.Code, rec.Header(), rec.Body.Bytes()
+	recorded = true
+}
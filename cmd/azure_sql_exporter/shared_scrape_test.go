@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharedScrapePanic(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	var mutex sync.Mutex
+	calls := 0
+	h := newSharedScrapeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls++
+		first := calls == 1
+		mutex.Unlock()
+		if first {
+			close(entered)
+			<-release
+			panic("collect failed")
+		}
+		w.Write([]byte("ok"))
+	}), time.Minute, "X-Scrape-Id")
+
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	}()
+	<-entered
+	waiter := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		waiter <- rec
+	}()
+	// Let the second scrape start waiting on the first.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if p := <-panicked; p == nil {
+		t.Error("panic of the handler was swallowed")
+	}
+	select {
+	case rec := <-waiter:
+		if rec.Code != http.StatusInternalServerError && rec.Body.String() != "ok" {
+			t.Errorf("waiting scrape got %d %q, want a 500 or a response of its own", rec.Code, rec.Body.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scrape waiting on a panicking handler is blocked")
+	}
+
+	// The failed response isn't shared with later scrapes.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("got %d %q after the panic, want 200 \"ok\"", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/log"
+)
+
+var (
+	sinkNames           = kingpin.Flag("sinks", "Comma separated list of sinks the metrics are delivered to. Available sinks: file, otlp, prometheus, pushgateway, remote_write.").Default("prometheus").String()
+	sinkInterval        = kingpin.Flag("sink.interval", "How often push based sinks collect and deliver the metrics.").Default("1m").Duration()
+	sinkFilePath        = kingpin.Flag("sink.file.path", "Path of the file the file sink writes the metrics to in the text exposition format.").Default("azure_sql_exporter.prom").String()
+	sinkFileTextfile    = kingpin.Flag("sink.file.textfile-collector", "Write the file of the file sink for the textfile collector of node_exporter: without timestamps and without the Go runtime and process metrics, which node_exporter exports itself.").Bool()
+	sinkPushgatewayURL  = kingpin.Flag("sink.pushgateway.url", "URL of the Pushgateway the pushgateway sink pushes the metrics to. Credentials for basic auth can be given in the URL.").String()
+	sinkPushgatewayJob  = kingpin.Flag("sink.pushgateway.job", "Job label of the metrics pushed by the pushgateway sink.").Default("azure_sql_exporter").String()
+	sinkRemoteWriteURL  = kingpin.Flag("sink.remote_write.url", "URL of the remote write endpoint the remote_write sink sends the metrics to, e.g. http://prometheus:9090/api/v1/write. Credentials for basic auth can be given in the URL.").String()
+	sinkRemoteWriteTime = kingpin.Flag("sink.remote_write.timeout", "Timeout of a request of the remote_write sink.").Default("30s").Duration()
+	sinkOTLPURL         = kingpin.Flag("sink.otlp.url", "URL of the OTLP/HTTP metrics endpoint the otlp sink sends the metrics to with the JSON encoding, e.g. http://otel-collector:4318/v1/metrics. Credentials for basic auth can be given in the URL.").String()
+	sinkOTLPTimeout     = kingpin.Flag("sink.otlp.timeout", "Timeout of a request of the otlp sink.").Default("30s").Duration()
+)
+
+// wrapRegistry wraps the handlers rendering the registry, e.g. to filter the collectors by request.
+var wrapRegistry = func(handler http.Handler) http.Handler { return handler }
+
+// gatherFunc collects all metrics of the exporter.
+type gatherFunc func() ([]*dto.MetricFamily, error)
+
+// Sink delivers metrics to a monitoring backend. Collectors don't know about sinks, so new backends only need to
+// implement this interface and register a factory in the sinks map.
+type Sink interface {
+	// Start begins delivering metrics. Pull based sinks collect the metrics when asked to, push based sinks call
+	// gather on their own schedule. Start must not block.
+	Start(gather gatherFunc) error
+	// Stop stops delivering metrics and waits for a delivery in progress to finish.
+	Stop()
+}
+
+// sinks holds the factories of all available sinks by name.
+var sinks = map[string]func() Sink{
+	"prometheus": func() Sink { return prometheusSink{} },
+	"file": func() Sink {
+		return &pushSink{name: "file", interval: *sinkInterval, write: writeFile(*sinkFilePath, *sinkFileTextfile)}
+	},
+	"pushgateway": func() Sink {
+		return &pushSink{name: "pushgateway", interval: *sinkInterval, remote: true, url: *sinkPushgatewayURL, write: pushToGateway(*sinkPushgatewayURL, *sinkPushgatewayJob)}
+	},
+	"remote_write": func() Sink {
+		return &pushSink{name: "remote_write", interval: *sinkInterval, remote: true, url: *sinkRemoteWriteURL, write: remoteWrite(*sinkRemoteWriteURL, *sinkRemoteWriteTime)}
+	},
+	"otlp": func() Sink {
+		return &pushSink{name: "otlp", interval: *sinkInterval, remote: true, url: *sinkOTLPURL, write: otlpPush(*sinkOTLPURL, *sinkOTLPTimeout, time.Now())}
+	},
+}
+
+// newSinks creates the sinks in the comma separated list of names.
+func newSinks(names string) ([]Sink, error) {
+	var enabled []Sink
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		factory, ok := sinks[name]
+		if !ok {
+			available := make([]string, 0, len(sinks))
+			for n := range sinks {
+				available = append(available, n)
+			}
+			sort.Strings(available)
+			return nil, fmt.Errorf("unknown sink %q, available sinks: %s", name, strings.Join(available, ", "))
+		}
+		enabled = append(enabled, factory())
+	}
+	return enabled, nil
+}
+
+// gatherRegistry collects all metrics registered with the default registry. The metrics are encoded by the
+// metrics handler in the protobuf format and decoded again, so the collection passes the same filters as a scrape.
+func gatherRegistry() ([]*dto.MetricFamily, error) {
+	return gatherQuery("")
+}
+
+// gatherQuery collects the metrics registered with the default registry like a request to the metrics endpoint
+// with the given query, e.g. collect[] filters.
+func gatherQuery(rawQuery string) ([]*dto.MetricFamily, error) {
+	req, err := http.NewRequest("GET", "/metrics?"+rawQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeProtoDelim)))
+	rec := httptest.NewRecorder()
+	wrapRegistry(registryHandler(gatherer)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		return nil, fmt.Errorf("unable to gather metrics: %s", strings.TrimSpace(rec.Body.String()))
+	}
+	var families []*dto.MetricFamily
+	dec := expfmt.NewDecoder(rec.Body, expfmt.ResponseFormat(rec.Header()))
+	for {
+		mf := &dto.MetricFamily{}
+		if err := dec.Decode(mf); err == io.EOF {
+			return families, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to decode metrics: %s", err)
+		}
+		families = append(families, mf)
+	}
+}
+
+// registryHandler returns a handler serving the metrics of g, such as the default registry renamed and labeled by
+// the gatherer. Metrics that fail to be collected are logged and omitted rather than failing the whole scrape, and
+// OpenMetrics is served to clients asking for it.
+func registryHandler(g prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(g, promhttp.HandlerOpts{
+		ErrorLog:          errorLogger{},
+		ErrorHandling:     promhttp.ContinueOnError,
+		EnableOpenMetrics: true,
+	})
+}
+
+// errorLogger logs the errors of the metrics handler.
+type errorLogger struct{}
+
+func (errorLogger) Println(v ...interface{}) {
+	log.Errorln(v...)
+}
+
+// prometheusSink exposes the metrics for Prometheus to scrape at --web.telemetry-path. Databases are queried when
+// Prometheus scrapes the exporter.
+type prometheusSink struct{}
+
+func (prometheusSink) Start(gather gatherFunc) error {
+	handler := registryHandler(gatherer)
+	if !*disableExporterMetrics {
+		handler = promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler)
+	}
+	handler, err := newGzipHandler(wrapRegistry(handler), *gzipLevel)
+	if err != nil {
+		return fmt.Errorf("invalid --web.gzip-level: %s", err)
+	}
+	http.Handle(*metricsPath, newSharedScrapeHandler(handler, *sharedScrapeWindow, *scrapeIDHeader))
+	return nil
+}
+
+// Stop does nothing, the HTTP server serving the metrics is shut down on its own.
+func (prometheusSink) Stop() {}
+
+// pushSink gathers the metrics every interval and hands them to write. Remote sinks send the metrics over the
+// network to url, which must be set with --sink.<name>.url.
+type pushSink struct {
+	name     string
+	interval time.Duration
+	remote   bool
+	url      string
+	write    func([]*dto.MetricFamily) error
+
+	// stop is closed to stop pushing, done once the last push finished.
+	stop, done chan struct{}
+}
+
+func (s *pushSink) Start(gather gatherFunc) error {
+	if s.interval <= 0 {
+		return fmt.Errorf("interval of sink %s must be positive", s.name)
+	}
+	if s.remote && s.url == "" {
+		return fmt.Errorf("sink %s requires --sink.%s.url", s.name, s.name)
+	}
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			s.push(gather)
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *pushSink) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *pushSink) push(gather gatherFunc) {
+	families, err := gather()
+	if err != nil {
+		log.Errorf("Sink %s failed to gather metrics: %s", s.name, err)
+		return
+	}
+	if err := s.write(families); err != nil {
+		log.Errorf("Sink %s failed to write metrics: %s", s.name, err)
+	}
+}
+
+// textfileExcluded are the prefixes of the metrics of the client library, which clash with the same metrics of
+// node_exporter when read by its textfile collector.
+var textfileExcluded = []string{"go_", "process_", "promhttp_"}
+
+// writeFile returns a function writing metric families to path in the text exposition format. The file is
+// replaced atomically so readers never see a partially written file. For the textfile collector of node_exporter,
+// which rejects files with timestamps, the timestamps and the metrics of the client library are left out.
+func writeFile(path string, textfile bool) func([]*dto.MetricFamily) error {
+	return func(families []*dto.MetricFamily) error {
+		var buf bytes.Buffer
+		for _, mf := range families {
+			if textfile {
+				if hasAnyPrefix(mf.GetName(), textfileExcluded) {
+					continue
+				}
+				for _, m := range mf.Metric {
+					m.TimestampMs = nil
+				}
+			}
+			if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+				return err
+			}
+		}
+		tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(buf.Bytes()); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(tmp.Name(), 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), path)
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// pushToGateway returns a function replacing the metrics of job on the Pushgateway at url. Metrics of databases
+// that are no longer exported are removed from the Pushgateway with the next push.
+func pushToGateway(url, job string) func([]*dto.MetricFamily) error {
+	return func(families []*dto.MetricFamily) error {
+		gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil })
+		return push.New(url, job).Gatherer(gatherer).Push()
+	}
+}
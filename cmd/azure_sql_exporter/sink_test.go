@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPushSinkStop(t *testing.T) {
+	var pushes int64
+	s := &pushSink{name: "test", interval: time.Millisecond, write: func([]*dto.MetricFamily) error {
+		atomic.AddInt64(&pushes, 1)
+		return nil
+	}}
+	if err := s.Start(func() ([]*dto.MetricFamily, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+	for atomic.LoadInt64(&pushes) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	s.Stop()
+	stopped := atomic.LoadInt64(&pushes)
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt64(&pushes); n != stopped {
+		t.Errorf("sink pushed %d times after it was stopped", n-stopped)
+	}
+}
+
+func TestOTLPSink(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	cpu := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "azure_sql_cpu_percent", Help: "CPU utilization."}, []string{"database"})
+	cpu.WithLabelValues("Sales").Set(12.5)
+	cpu.WithLabelValues("Billing").Set(math.NaN())
+	scrapes := prometheus.NewCounter(prometheus.CounterOpts{Name: "azure_sql_scrapes_total", Help: "Scrapes."})
+	scrapes.Add(3)
+	duration := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "azure_sql_scrape_duration_seconds", Help: "Duration.", Buckets: []float64{1, 5}})
+	for _, d := range []float64{0.5, 2, 3, 10} {
+		duration.Observe(d)
+	}
+	reg.MustRegister(cpu, scrapes, duration)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got Content-Type %q, want application/json", r.Header.Get("Content-Type"))
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	start := time.Unix(1700000000, 0)
+	if err := otlpPush(server.URL, time.Second, start)(families); err != nil {
+		t.Fatal(err)
+	}
+
+	var req struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []map[string]json.RawMessage
+			}
+		}
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("invalid JSON %s: %s", body, err)
+	}
+	metrics := map[string]map[string]json.RawMessage{}
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		var name string
+		json.Unmarshal(m["name"], &name)
+		metrics[name] = m
+	}
+	expect := func(name, kind, want string) {
+		t.Helper()
+		var got, expected interface{}
+		json.Unmarshal(metrics[name][kind], &got)
+		if err := json.Unmarshal([]byte(want), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			gotJSON, _ := json.Marshal(got)
+			t.Errorf("%s: got %s %s, want %s", name, kind, gotJSON, want)
+		}
+	}
+	now := func(kind, name string) string {
+		// The time of the data points is when they were pushed, so it is copied from the request.
+		var points struct {
+			DataPoints []struct {
+				TimeUnixNano string
+			}
+		}
+		json.Unmarshal(metrics[name][kind], &points)
+		if len(points.DataPoints) == 0 {
+			t.Fatalf("%s has no data points", name)
+		}
+		return points.DataPoints[0].TimeUnixNano
+	}
+	ts := now("gauge", "azure_sql_cpu_percent")
+	expect("azure_sql_cpu_percent", "gauge", `{"dataPoints": [
+		{"attributes": [{"key": "database", "value": {"stringValue": "Billing"}}], "timeUnixNano": "`+ts+`", "asDouble": "NaN"},
+		{"attributes": [{"key": "database", "value": {"stringValue": "Sales"}}], "timeUnixNano": "`+ts+`", "asDouble": 12.5}]}`)
+	expect("azure_sql_scrapes_total", "sum", `{"aggregationTemporality": 2, "isMonotonic": true, "dataPoints": [
+		{"startTimeUnixNano": "1700000000000000000", "timeUnixNano": "`+ts+`", "asDouble": 3}]}`)
+	expect("azure_sql_scrape_duration_seconds", "histogram", `{"aggregationTemporality": 2, "dataPoints": [
+		{"startTimeUnixNano": "1700000000000000000", "timeUnixNano": "`+ts+`", "count": "4", "sum": 15.5,
+		 "bucketCounts": ["1", "2", "1"], "explicitBounds": [1, 5]}]}`)
+}
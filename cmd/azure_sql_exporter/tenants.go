@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// tenantMatches reports whether a series with the given label pairs is exposed to the tenant.
+func tenantMatches(t config.Tenant, labels []*dto.LabelPair) bool {
+	found := 0
+	for _, l := range labels {
+		if value, ok := t.Labels[l.GetName()]; ok {
+			if value != l.GetValue() {
+				return false
+			}
+			found++
+		}
+	}
+	return found == len(t.Labels)
+}
+
+// tenantHandler serves the metrics of the registry selected by the tenant's labels. Series without the labels,
+// such as the metrics of the exporter itself, are not exposed to tenants.
+func tenantHandler(t config.Tenant) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := gatherQuery(r.URL.RawQuery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		format := expfmt.Negotiate(r.Header)
+		w.Header().Set("Content-Type", string(format))
+		enc := expfmt.NewEncoder(w, format)
+		for _, mf := range families {
+			var metrics []*dto.Metric
+			for _, m := range mf.Metric {
+				if tenantMatches(t, m.Label) {
+					metrics = append(metrics, m)
+				}
+			}
+			if len(metrics) == 0 {
+				continue
+			}
+			mf.Metric = metrics
+			if err := enc.Encode(mf); err != nil {
+				return
+			}
+		}
+	})
+}
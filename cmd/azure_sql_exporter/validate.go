@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/collector"
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+)
+
+// validate implements the validate command. It prints the errors of the config file at path to stderr and returns
+// the exit code: 1 if the config is invalid.
+func validate(path string) int {
+	errs := config.Validate(path, collector.Names())
+	if len(errs) == 0 {
+		// The config loads, as Validate loaded it.
+		cfg, _ := config.Load(path, collector.Names())
+		if err := collector.CheckQueries(cfg.Databases); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("%s is valid\n", path)
+	return 0
+}
+
+// validateDir implements the validate command for --config.dir. Every file is validated on its own, then the merged
+// config is checked for databases and sections configured in more than one file.
+func validateDir(dir string) int {
+	paths, err := config.Files(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	code := 0
+	for _, path := range paths {
+		for _, err := range config.Validate(path, collector.Names()) {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			code = 1
+		}
+	}
+	if code == 0 {
+		cfg, err := config.LoadDir(dir, collector.Names())
+		if err == nil {
+			err = collector.CheckQueries(cfg.Databases)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", dir, err)
+			return 1
+		}
+		fmt.Printf("%s is valid\n", dir)
+	}
+	return code
+}
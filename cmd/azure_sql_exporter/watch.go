@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/iamseth/azure_sql_exporter/pkg/collector"
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/log"
+)
+
+var configWatchInterval = kingpin.Flag("config.watch-interval", "How often to check --config.file or --config.dir for changes and apply changed databases without a restart, e.g. of a mounted Kubernetes ConfigMap or Secret. 0 disables watching.").Default("0s").Duration()
+
+// loadConfig loads --config.file or --config.dir and checks its queries. The fake databases of
+// --test.synthetic-targets are added to its databases.
+func loadConfig() (config.Config, error) {
+	var cfg config.Config
+	var err error
+	if *configDir != "" {
+		cfg, err = config.LoadDir(*configDir, collector.Names())
+	} else {
+		cfg, err = config.Load(*configFile, collector.Names())
+	}
+	if err != nil {
+		return config.Config{}, err
+	}
+	if err := collector.CheckQueries(cfg.Databases); err != nil {
+		return config.Config{}, fmt.Errorf("invalid queries: %s", err)
+	}
+	cfg.Databases = append(cfg.Databases, collector.SyntheticDatabases(*syntheticTargets)...)
+	return cfg, nil
+}
+
+// watchConfig loads the config every interval and applies the changes of its databases to the exporter. current is
+// the config loaded at startup. An invalid config is logged once and ignored until it changes.
+func watchConfig(databases *databasesAPI, current config.Config, interval time.Duration) {
+	var lastErr string
+	for range time.Tick(interval) {
+		cfg, err := loadConfig()
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Errorf("Not reloading the changed config: %s", err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if reflect.DeepEqual(cfg, current) {
+			continue
+		}
+		if err := databases.reload(cfg); err != nil {
+			log.Errorf("Not reloading the changed config: %s", err)
+		} else if rest, currentRest := withoutDatabases(cfg), withoutDatabases(current); !reflect.DeepEqual(rest, currentRest) {
+			log.Warn("Only the databases of the config were reloaded, restart the exporter to apply the other changes")
+		}
+		// A config that couldn't be applied is only retried once it changes again.
+		current = cfg
+	}
+}
+
+// withoutDatabases returns the config without its databases, whose defaults and queries they already inherited.
+func withoutDatabases(cfg config.Config) config.Config {
+	cfg.Databases, cfg.Defaults, cfg.Queries = nil, config.ConnectionSettings{}, nil
+	return cfg
+}
+
+// reload applies the databases of a changed config: databases removed from the config are no longer scraped, and
+// added and changed databases are added or replaced. A database added to the config replaces a database registered
+// through the API with the same server, name and replica. Nothing is applied if a database can't be added.
+func (a *databasesAPI) reload(cfg config.Config) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	previous := map[string]config.Database{}
+	for _, db := range a.static {
+		previous[db.Key()] = db
+	}
+	current := map[string]bool{}
+	var added, changed []config.Database
+	for _, db := range cfg.Databases {
+		current[db.Key()] = true
+		old, ok := previous[db.Key()]
+		switch {
+		case !ok:
+			added = append(added, db)
+		case !reflect.DeepEqual(old, db):
+			changed = append(changed, db)
+		default:
+			continue
+		}
+		if err := a.exporter.CheckDatabase(db); err != nil {
+			return err
+		}
+	}
+	removed := 0
+	for _, db := range a.static {
+		if !current[db.Key()] {
+			a.remove(db)
+			removed++
+		}
+	}
+	for _, db := range changed {
+		a.remove(previous[db.Key()])
+		a.add(db)
+	}
+	save := false
+	for _, db := range added {
+		if registered, ok := a.registered[db.Key()]; ok {
+			log.Warnf("Database %s on server %s was added to the config, replacing the database registered through the API", db.Name, db.Server)
+			a.remove(registered)
+			delete(a.registered, db.Key())
+			save = true
+		}
+		a.add(db)
+	}
+	a.cfg, a.static = cfg, cfg.Databases
+	log.Infof("Reloaded the config: %d databases added, %d changed, %d removed", len(added), len(changed), removed)
+	if save {
+		return a.save()
+	}
+	return nil
+}
+
+// remove stops scraping a database, logging failures, as the databases of the exporter are known to the caller.
+func (a *databasesAPI) remove(db config.Database) {
+	if err := a.exporter.RemoveDatabase(db); err != nil {
+		log.Errorf("Cannot remove database %s: %s", db.Name, err)
+	}
+}
+
+// add starts scraping a database checked with CheckDatabase, logging failures.
+func (a *databasesAPI) add(db config.Database) {
+	if err := a.exporter.AddDatabase(db); err != nil {
+		log.Errorf("Cannot add database %s: %s", db.Name, err)
+	}
+}
@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/log"
+)
+
+// defaultDisableTTL is how long a database stays disabled if the request doesn't say.
+const defaultDisableTTL = time.Hour
+
+// DisableHandler stops scraping the database given by the database and optional server query parameters for the
+// duration in the ttl parameter, one hour by default.
+func (e *Exporter) DisableHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := e.findTarget(r.URL.Query().Get("database"), r.URL.Query().Get("server"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	ttl := defaultDisableTTL
+	if s := r.URL.Query().Get("ttl"); s != "" {
+		if ttl, err = time.ParseDuration(s); err != nil || ttl <= 0 {
+			http.Error(w, fmt.Sprintf("invalid ttl %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	until := time.Now().Add(ttl)
+	t.statusMutex.Lock()
+	t.disabledUntil = until
+	t.statusMutex.Unlock()
+	log.Infof("Disabled scraping %s until %s", t.Database, until)
+	fmt.Fprintf(w, "Disabled scraping %s on %s until %s\n", t.Name, t.Server, until.Format(time.RFC3339))
+}
+
+// EnableHandler resumes scraping the database given by the database and optional server query parameters.
+func (e *Exporter) EnableHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := e.findTarget(r.URL.Query().Get("database"), r.URL.Query().Get("server"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	t.statusMutex.Lock()
+	t.disabledUntil = time.Time{}
+	t.statusMutex.Unlock()
+	log.Infof("Enabled scraping %s", t.Database)
+	fmt.Fprintf(w, "Enabled scraping %s on %s\n", t.Name, t.Server)
+}
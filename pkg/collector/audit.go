@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/log"
+)
+
+// auditRecord is a single entry of the audit log, describing one query executed against a database.
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	Server   string    `json:"server"`
+	Database string    `json:"database"`
+	Query    string    `json:"query"`
+	Duration float64   `json:"duration_seconds"`
+	Rows     int       `json:"rows"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// auditLog writes one JSON object per line for every query the exporter executes. A nil *auditLog is valid and
+// discards all records, so callers don't need to check whether auditing is enabled.
+type auditLog struct {
+	mutex sync.Mutex
+	enc   *json.Encoder
+}
+
+// newAuditLog opens the audit log at path for appending. A path of "-" writes the records to stdout.
+func newAuditLog(path string) (*auditLog, error) {
+	enc, err := openJSONLog(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log %s: %s", path, err)
+	}
+	return &auditLog{enc: enc}, nil
+}
+
+// openJSONLog returns an encoder appending to the file at path, or writing to stdout if path is "-".
+func openJSONLog(path string) (*json.Encoder, error) {
+	if path == "-" {
+		return json.NewEncoder(os.Stdout), nil
+	}
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return json.NewEncoder(fh), nil
+}
+
+// SetAuditLog appends a JSON record of every query the exporter executes to the file at path, or to stdout if path
+// is "-".
+func (e *Exporter) SetAuditLog(path string) error {
+	a, err := newAuditLog(path)
+	if err != nil {
+		return err
+	}
+	e.audit = a
+	return nil
+}
+
+// Record logs the execution of query against d. rows is the number of rows read and err the error the query
+// failed with, if any.
+func (a *auditLog) Record(d config.Database, query string, start time.Time, rows int, err error) {
+	if a == nil {
+		return
+	}
+	r := auditRecord{
+		Time:     start.UTC(),
+		Server:   d.Server,
+		Database: d.Name,
+		Query:    query,
+		Duration: time.Since(start).Seconds(),
+		Rows:     rows,
+		Outcome:  "success",
+	}
+	if err != nil {
+		r.Outcome = "error"
+		r.Error = err.Error()
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.enc.Encode(r); err != nil {
+		log.Errorf("Failed to write audit record: %s", err)
+	}
+}
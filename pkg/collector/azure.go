@@ -0,0 +1,234 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/log"
+)
+
+// armAPIVersion is the version of the Microsoft.Sql resource provider API used.
+const armAPIVersion = "2021-11-01"
+
+// armClient calls the Azure Resource Manager API with the token of a service principal.
+type armClient struct {
+	config config.AzureConfig
+	client *http.Client
+
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newARMClient(cfg config.AzureConfig) *armClient {
+	return &armClient{config: cfg, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// accessToken returns a token for the Resource Manager API, requesting a new one shortly before the last one
+// expires.
+func (c *armClient) accessToken() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.config.ClientID},
+		"client_secret": {c.config.ClientSecret},
+		"scope":         {strings.TrimSuffix(c.config.ResourceManager, "/") + "/.default"},
+	}
+	resp, err := c.client.PostForm(fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(c.config.AuthorityHost, "/"), c.config.TenantID), form)
+	if err != nil {
+		return "", fmt.Errorf("unable to get Azure access token: %s", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := decodeARMResponse(resp, &token); err != nil {
+		return "", fmt.Errorf("unable to get Azure access token: %s", err)
+	}
+	c.token = token.AccessToken
+	c.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// get requests path, relative to the Resource Manager endpoint, and decodes the JSON response into out.
+func (c *armClient) get(path string, out interface{}) error {
+	token, err := c.accessToken()
+	if err != nil {
+		return err
+	}
+	u := path
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		u = strings.TrimSuffix(c.config.ResourceManager, "/") + path
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeARMResponse(resp, out)
+}
+
+// armResource is the part of an Azure resource the exporter uses.
+type armResource struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Location   string            `json:"location"`
+	Tags       map[string]string `json:"tags"`
+	Properties json.RawMessage   `json:"properties"`
+}
+
+// list returns all resources of the collection at path, following the pages of the response.
+func (c *armClient) list(path string) ([]armResource, error) {
+	var resources []armResource
+	next := fmt.Sprintf("%s?api-version=%s", path, armAPIVersion)
+	for next != "" {
+		var page struct {
+			Value    []armResource `json:"value"`
+			NextLink string        `json:"nextLink"`
+		}
+		if err := c.get(next, &page); err != nil {
+			return nil, fmt.Errorf("unable to list %s: %s", path, err)
+		}
+		resources = append(resources, page.Value...)
+		next = page.NextLink
+	}
+	return resources, nil
+}
+
+// sqlDatabaseResource is an Azure SQL database found through the Resource Manager API.
+type sqlDatabaseResource struct {
+	Subscription  string
+	ResourceGroup string
+	Server        armResource
+	Database      armResource
+	// FailoverPartner is the host name of the partner server of the failover group the database is in, if any.
+	FailoverPartner string
+	// Firewall holds the firewall rules of the server, if the egress IP address of the exporter is checked.
+	Firewall *serverFirewall
+	// BackupRetentionDays is the point-in-time restore retention of the database, if looked up.
+	BackupRetentionDays int
+}
+
+// sqlDatabases returns the databases of all servers of the configured subscriptions by their server's host name
+// and name, both in lower case.
+func (c *armClient) sqlDatabases() (map[string]sqlDatabaseResource, error) {
+	databases := map[string]sqlDatabaseResource{}
+	for _, subscription := range c.config.Subscriptions {
+		servers, err := c.list(fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Sql/servers", subscription))
+		if err != nil {
+			return nil, err
+		}
+		for _, server := range servers {
+			var props struct {
+				FQDN string `json:"fullyQualifiedDomainName"`
+			}
+			json.Unmarshal(server.Properties, &props)
+			partners, err := c.failoverPartners(server, props.FQDN)
+			if err != nil {
+				return nil, err
+			}
+			var firewall *serverFirewall
+			if c.config.EgressIPURL != "" {
+				if firewall, err = c.serverFirewall(server); err != nil {
+					return nil, err
+				}
+			}
+			dbs, err := c.list(server.ID + "/databases")
+			if err != nil {
+				return nil, err
+			}
+			for _, db := range dbs {
+				r := sqlDatabaseResource{
+					Subscription:    subscription,
+					ResourceGroup:   resourceGroup(server.ID),
+					Server:          server,
+					Database:        db,
+					FailoverPartner: partners[strings.ToLower(db.ID)],
+					Firewall:        firewall,
+				}
+				// master has no backups of its own.
+				if c.config.BackupRetention && !strings.EqualFold(db.Name, "master") {
+					if r.BackupRetentionDays, err = c.backupRetentionDays(db.ID); err != nil {
+						log.Warnf("%s", err)
+					}
+				}
+				databases[sqlDatabaseKey(props.FQDN, db.Name)] = r
+			}
+		}
+	}
+	return databases, nil
+}
+
+// failoverPartners returns the host names of the partner servers of the failover groups of server by the
+// resource ID of their databases in lower case. Partner servers are assumed to be in the same cloud, so their host
+// names have the same domain as fqdn.
+func (c *armClient) failoverPartners(server armResource, fqdn string) (map[string]string, error) {
+	groups, err := c.list(server.ID + "/failoverGroups")
+	if err != nil {
+		return nil, err
+	}
+	domain := ""
+	if i := strings.Index(fqdn, "."); i >= 0 {
+		domain = fqdn[i:]
+	}
+	partners := map[string]string{}
+	for _, group := range groups {
+		var props struct {
+			Databases      []string `json:"databases"`
+			PartnerServers []struct {
+				ID string `json:"id"`
+			} `json:"partnerServers"`
+		}
+		json.Unmarshal(group.Properties, &props)
+		if len(props.PartnerServers) == 0 {
+			continue
+		}
+		id := props.PartnerServers[0].ID
+		partner := id[strings.LastIndex(id, "/")+1:] + domain
+		for _, db := range props.Databases {
+			partners[strings.ToLower(db)] = partner
+		}
+	}
+	return partners, nil
+}
+
+func sqlDatabaseKey(server, database string) string {
+	return strings.ToLower(server) + "/" + strings.ToLower(database)
+}
+
+// resourceGroup returns the resource group of the resource with the given ID.
+func resourceGroup(id string) string {
+	parts := strings.Split(id, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+func decodeARMResponse(resp *http.Response, out interface{}) error {
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
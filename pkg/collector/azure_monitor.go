@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+var (
+	// AzureMonitorInterval is how often the Azure Monitor metrics of the databases are pulled.
+	AzureMonitorInterval = 5 * time.Minute
+)
+
+// monitorAPIVersion is the version of the Azure Monitor metrics API used.
+const monitorAPIVersion = "2018-01-01"
+
+// monitorTimespan is how far back data points of the metrics are requested. Azure Monitor ingests the metrics of a
+// minute with a delay of a few minutes, so the latest data points are often missing.
+const monitorTimespan = 10 * time.Minute
+
+// monitorMetrics returns the latest value of the metrics of the resource with the ID, by name in lower case. Metrics
+// without a data point in the last monitorTimespan are omitted.
+func (c *armClient) monitorMetrics(id string, metrics []config.MonitorMetric) (map[string]float64, error) {
+	names := make([]string, 0, len(metrics))
+	aggregations := map[string]string{}
+	var aggregationList []string
+	for _, m := range metrics {
+		names = append(names, m.Name)
+		if !contains(aggregationList, m.Aggregation) {
+			aggregationList = append(aggregationList, m.Aggregation)
+		}
+		aggregations[strings.ToLower(m.Name)] = m.Aggregation
+	}
+	end := time.Now().UTC().Truncate(time.Minute)
+	query := url.Values{
+		"api-version": {monitorAPIVersion},
+		"metricnames": {strings.Join(names, ",")},
+		"aggregation": {strings.Join(aggregationList, ",")},
+		"interval":    {"PT1M"},
+		"timespan":    {end.Add(-monitorTimespan).Format(time.RFC3339) + "/" + end.Format(time.RFC3339)},
+	}
+	var resp struct {
+		Value []struct {
+			Name struct {
+				Value string `json:"value"`
+			} `json:"name"`
+			Timeseries []struct {
+				Data []map[string]interface{} `json:"data"`
+			} `json:"timeseries"`
+		} `json:"value"`
+	}
+	if err := c.get(fmt.Sprintf("%s/providers/Microsoft.Insights/metrics?%s", id, query.Encode()), &resp); err != nil {
+		return nil, fmt.Errorf("unable to get Azure Monitor metrics of %s: %s", id, err)
+	}
+	values := map[string]float64{}
+	for _, metric := range resp.Value {
+		name := strings.ToLower(metric.Name.Value)
+		aggregation, ok := aggregations[name]
+		if !ok || len(metric.Timeseries) == 0 {
+			continue
+		}
+		// Data points are in chronological order, the latest with a value wins.
+		data := metric.Timeseries[0].Data
+		for i := len(data) - 1; i >= 0; i-- {
+			if v, ok := data[i][aggregation].(float64); ok {
+				values[name] = v
+				break
+			}
+		}
+	}
+	return values, nil
+}
+
+// MonitorCollector exports the latest values of selected Azure Monitor metrics of the configured databases as
+// azure_sql_monitor_<name>, e.g. dtu_consumption_percent, which has no equivalent in the DMVs. The metrics are pulled
+// in the background by Run, as Azure Monitor throttles the requests of a subscription.
+type MonitorCollector struct {
+	resources   *AzureResources
+	dbs         []config.Database
+	extraLabels []string
+	metrics     []config.MonitorMetric
+	descs       map[string]*prometheus.Desc
+
+	mutex sync.RWMutex
+	// values holds the values of the metrics by database key and name in lower case.
+	values map[string]map[string]float64
+}
+
+// NewMonitorCollector returns a collector exporting the Azure Monitor metrics of the databases found in resources
+// once Run is called.
+func NewMonitorCollector(resources *AzureResources, dbs []config.Database, extraLabels []string, metrics []config.MonitorMetric) *MonitorCollector {
+	c := &MonitorCollector{
+		resources:   resources,
+		dbs:         dbs,
+		extraLabels: extraLabels,
+		metrics:     metrics,
+		descs:       map[string]*prometheus.Desc{},
+	}
+	for _, m := range metrics {
+		c.descs[strings.ToLower(m.Name)] = prometheus.NewDesc(namespace+"_monitor_"+config.MonitorMetricName(m.Name), fmt.Sprintf("Azure Monitor metric %s of the database, %s over the last minute with data.", m.Name, m.Aggregation), append([]string{"server", "database"}, extraLabels...), nil)
+	}
+	return c
+}
+
+// Run pulls the metrics of the databases found in Azure every AzureMonitorInterval, one request per database. It
+// doesn't return.
+func (c *MonitorCollector) Run() {
+	for !c.resources.ready() {
+		time.Sleep(time.Second)
+	}
+	for {
+		values := map[string]map[string]float64{}
+		for _, db := range c.dbs {
+			r, ok := c.resources.lookup(db)
+			if !ok {
+				continue
+			}
+			v, err := c.resources.client.monitorMetrics(r.Database.ID, c.metrics)
+			if err != nil {
+				log.Warnf("%s", err)
+				continue
+			}
+			values[db.Key()] = v
+		}
+		c.mutex.Lock()
+		c.values = values
+		c.mutex.Unlock()
+		time.Sleep(AzureMonitorInterval)
+	}
+}
+
+func (c *MonitorCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+}
+
+// Collect exports the metrics last pulled. Metrics of databases that couldn't be pulled, or that had no data point,
+// are omitted.
+func (c *MonitorCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, db := range c.dbs {
+		for name, v := range c.values[db.Key()] {
+			ch <- prometheus.MustNewConstMetric(c.descs[name], prometheus.GaugeValue, v, db.LabelValues(c.extraLabels)...)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+var (
+	// AzureRefreshInterval is how often the resources of the databases are looked up through the Azure Resource
+	// Manager API.
+	AzureRefreshInterval = time.Hour
+)
+
+// AzureResources holds the Azure resources of the databases, as last looked up through the Azure Resource Manager
+// API.
+type AzureResources struct {
+	client *armClient
+
+	mutex     sync.RWMutex
+	resources map[string]sqlDatabaseResource
+	// egressIP is the public IP address of the exporter, if looked up.
+	egressIP net.IP
+}
+
+// NewAzureResources returns the Azure resources of the databases, looked up with the Azure Resource Manager API
+// credentials in cfg once Run is called.
+func NewAzureResources(cfg config.AzureConfig) *AzureResources {
+	return &AzureResources{client: newARMClient(cfg)}
+}
+
+// Run looks up the databases every AzureRefreshInterval. It doesn't return.
+func (a *AzureResources) Run() {
+	for {
+		resources, err := a.client.sqlDatabases()
+		if err != nil {
+			log.Errorf("Failed to look up databases in Azure: %s", err)
+		} else {
+			a.mutex.Lock()
+			a.resources = resources
+			a.mutex.Unlock()
+		}
+		if a.client.config.EgressIPURL != "" {
+			ip, err := a.client.egressIP()
+			if err != nil {
+				log.Errorf("Failed to look up egress IP address: %s", err)
+			}
+			a.mutex.Lock()
+			a.egressIP = ip
+			a.mutex.Unlock()
+		}
+		time.Sleep(AzureRefreshInterval)
+	}
+}
+
+// lookup returns the resource of the database d. It returns false if the database wasn't found, e.g. before the
+// first lookup finished.
+func (a *AzureResources) lookup(d config.Database) (sqlDatabaseResource, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	r, ok := a.resources[sqlDatabaseKey(d.Server, d.Name)]
+	return r, ok
+}
+
+// ready returns whether the databases were looked up successfully at least once.
+func (a *AzureResources) ready() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.resources != nil
+}
+
+// egress returns the public IP address of the exporter, or nil if it isn't known.
+func (a *AzureResources) egress() net.IP {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.egressIP
+}
+
+// azureTagsCollector exports the subscription, resource group and selected tags of the configured databases, as
+// found through the Azure Resource Manager API, as azure_sql_database_tags_info.
+type azureTagsCollector struct {
+	resources   *AzureResources
+	tagKeys     []string
+	dbs         []config.Database
+	extraLabels []string
+	desc        *prometheus.Desc
+}
+
+// NewAzureTagsCollector returns a collector exporting the subscription, resource group and tags of the databases
+// found in resources.
+func NewAzureTagsCollector(resources *AzureResources, dbs []config.Database, extraLabels []string) prometheus.Collector {
+	tagKeys := resources.client.config.TagKeys
+	labels := append([]string{"server", "database"}, extraLabels...)
+	labels = append(labels, "subscription", "resource_group")
+	for _, key := range tagKeys {
+		labels = append(labels, config.TagLabel(key))
+	}
+	return &azureTagsCollector{
+		resources:   resources,
+		tagKeys:     tagKeys,
+		dbs:         dbs,
+		extraLabels: extraLabels,
+		desc:        prometheus.NewDesc(namespace+"_database_tags_info", "Subscription, resource group and tags of the database in Azure. Always 1.", labels, nil),
+	}
+}
+
+func (c *azureTagsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect exports the tags of the configured databases found in Azure. Databases that weren't found are omitted.
+func (c *azureTagsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, db := range c.dbs {
+		r, ok := c.resources.lookup(db)
+		if !ok {
+			continue
+		}
+		values := append(db.LabelValues(c.extraLabels), r.Subscription, r.ResourceGroup)
+		for _, key := range c.tagKeys {
+			// Tags of the server apply to databases that don't set them.
+			value, ok := r.Database.Tags[key]
+			if !ok {
+				value = r.Server.Tags[key]
+			}
+			values = append(values, value)
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, values...)
+	}
+}
@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/log"
+)
+
+var (
+	// BackgroundCollectors are the optional collectors refreshed in the background. Scrapes serve their last result
+	// right away and start a refresh once it is older than the interval of the collector, so their queries never
+	// slow down a scrape.
+	BackgroundCollectors []string
+)
+
+// backgroundRefresh tracks the background refreshes of a collector of a target. It is guarded by the mutex of the
+// target.
+type backgroundRefresh struct {
+	// running is true while a refresh is in progress.
+	running bool
+	// err is the error of the last refresh, nil if it succeeded.
+	err error
+}
+
+// refreshInBackground stores the last result of the background collector of the target's database, even if it is
+// stale, and starts a refresh if the result is missing or older than the interval of the collector and no refresh
+// is running. Until the first refresh finishes the collector has no status. The caller must hold the mutex of the
+// target.
+func (e *Exporter) refreshInBackground(t *target, name string, s scraper) {
+	b := t.refreshes[name]
+	if b == nil {
+		b = &backgroundRefresh{}
+		t.refreshes[name] = b
+	}
+	r, ok := t.results[name]
+	var interval time.Duration
+	if is, isInterval := s.(intervalScraper); isInterval {
+		interval = is.Interval()
+	}
+	if !b.running && (!ok || time.Since(r.time) >= interval) {
+		if err := e.startRefresh(t, name, s, b); err != nil {
+			log.Errorf("Unable to refresh collector %s of database %s in the background: %s", name, t.Database, err)
+			b.err = err
+		}
+	}
+	labels := append(t.LabelValues(e.extraLabels), name)
+	e.mutex.Lock()
+	if ok {
+		e.collectorDataAge.WithLabelValues(labels...).Set(time.Since(r.time).Seconds())
+	} else {
+		e.collectorDataAge.DeleteLabelValues(labels...)
+	}
+	e.mutex.Unlock()
+	if ok {
+		e.storeMetrics(t, name, r.metrics)
+	}
+	switch {
+	case b.err != nil:
+		t.states[name] = collectorFailed
+	case ok:
+		t.states[name] = collectorOK
+	default:
+		delete(t.states, name)
+	}
+}
+
+// startRefresh runs the collector against the target's database in a goroutine of its own, over the pool of a
+// prewarmed database or a pool opened for the refresh, and stores the result in the target once it is done. The
+// refresh isn't traced, and as it may overlap with a scrape, permission errors aren't tracked as capabilities.
+func (e *Exporter) startRefresh(t *target, name string, s scraper, b *backgroundRefresh) error {
+	db, release := t.db, func() {}
+	if db == nil {
+		dsn, err := t.dsn()
+		if err != nil {
+			return err
+		}
+		if db, err = e.open(dsn); err != nil {
+			return err
+		}
+		release = func() { db.Close() }
+	}
+	b.running = true
+	size := len(t.results[name].metrics)
+	c := &connection{ctx: e.ctx, db: db, database: t.Database, audit: e.audit, queries: t.queries, collector: name, observe: func(collector string, d time.Duration) {
+		e.observeQuery(t, collector, d)
+	}}
+	go func() {
+		defer release()
+		r, err := runScraper(s, c, size)
+		if err == nil {
+			r.metrics = e.limitSeries(t, name, r.metrics)
+		}
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		b.running, b.err = false, err
+		if err != nil {
+			log.Errorf("Collector %s failed for database %s in the background: %s", name, c.database, err)
+			return
+		}
+		t.results[name] = r
+	}()
+	return nil
+}
@@ -0,0 +1,120 @@
+package collector
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerScraper("backups", newBackupsScraper)
+}
+
+// backupsQuery returns the finish time of the last automated backup of the database by backup type, D for full, I
+// for differential and L for log backups.
+const backupsQuery = `SELECT backup_type, MAX(backup_finish_date)
+FROM sys.dm_database_backups
+WHERE in_retention = 1
+GROUP BY backup_type`
+
+// managedInstanceBackupsQuery returns the same from the backup history in msdb, as sys.dm_database_backups isn't
+// available on Managed Instance and SQL Server.
+const managedInstanceBackupsQuery = `SELECT type, MAX(backup_finish_date)
+FROM msdb.dbo.backupset
+WHERE database_name = DB_NAME()
+GROUP BY type`
+
+// backupTypes maps the backup types of the backup history to the type label.
+var backupTypes = map[string]string{
+	"D": "full",
+	"I": "differential",
+	"L": "log",
+}
+
+// backupsScraper exports the time of the last backup of the database by type, to alert when automated backups
+// stop, e.g. for compliance.
+type backupsScraper struct {
+	lastBackup *prometheus.Desc
+}
+
+func newBackupsScraper(labels prometheus.Labels) scraper {
+	return backupsScraper{
+		lastBackup: newDesc("last_backup_timestamp_seconds", "Time the last backup of the database of the type finished.", labels, "type"),
+	}
+}
+
+func (s backupsScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.lastBackup
+}
+
+func (s backupsScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	query := backupsQuery
+	if c.database.Type == config.TypeManagedInstance || c.database.Type == config.TypeSQLServer {
+		query = managedInstanceBackupsQuery
+	}
+	return c.query(func(rows resultRow) error {
+		var backupType string
+		var finished sql.NullTime
+		if err := rows.Scan(&backupType, &finished); err != nil {
+			return err
+		}
+		label, ok := backupTypes[backupType]
+		if !ok || !finished.Valid {
+			return nil
+		}
+		ch <- prometheus.MustNewConstMetric(s.lastBackup, prometheus.GaugeValue, float64(finished.Time.UnixNano())/float64(time.Second), c.database.Server, c.database.Name, label)
+		return nil
+	}, query)
+}
+
+// backupRetentionDays returns the point-in-time restore retention of the database with the resource ID, in days.
+func (c *armClient) backupRetentionDays(id string) (int, error) {
+	var policy struct {
+		Properties struct {
+			RetentionDays int `json:"retentionDays"`
+		} `json:"properties"`
+	}
+	if err := c.get(fmt.Sprintf("%s/backupShortTermRetentionPolicies/default?api-version=%s", id, armAPIVersion), &policy); err != nil {
+		return 0, fmt.Errorf("unable to get backup retention of %s: %s", id, err)
+	}
+	return policy.Properties.RetentionDays, nil
+}
+
+// backupRetentionCollector exports the point-in-time restore retention of the configured databases, as found
+// through the Azure Resource Manager API, as azure_sql_backup_retention_days.
+type backupRetentionCollector struct {
+	resources   *AzureResources
+	dbs         []config.Database
+	extraLabels []string
+	desc        *prometheus.Desc
+}
+
+// NewBackupRetentionCollector returns a collector exporting the backup retention of the databases found in
+// resources.
+func NewBackupRetentionCollector(resources *AzureResources, dbs []config.Database, extraLabels []string) prometheus.Collector {
+	return &backupRetentionCollector{
+		resources:   resources,
+		dbs:         dbs,
+		extraLabels: extraLabels,
+		desc:        prometheus.NewDesc(namespace+"_backup_retention_days", "Number of days the backups of the database are retained for point-in-time restore.", append([]string{"server", "database"}, extraLabels...), nil),
+	}
+}
+
+func (c *backupRetentionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect exports the backup retention of the configured databases found in Azure. Databases without a retention
+// policy, e.g. the master database, are omitted.
+func (c *backupRetentionCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, db := range c.dbs {
+		r, ok := c.resources.lookup(db)
+		if !ok || r.BackupRetentionDays == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(r.BackupRetentionDays), db.LabelValues(c.extraLabels)...)
+	}
+}
@@ -0,0 +1,178 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+)
+
+// benchDriver is the name of the fake SQL driver simulated databases are queried through.
+const benchDriver = "azure_sql_bench"
+
+func init() {
+	sql.Register(benchDriver, fakeDriver{})
+}
+
+// BenchDatabases returns n simulated databases spread evenly over servers, with the given optional collectors.
+func BenchDatabases(n, servers int, collectors []string) []config.Database {
+	if servers < 1 {
+		servers = 1
+	}
+	dbs := make([]config.Database, n)
+	for i := range dbs {
+		dbs[i] = config.Database{
+			Name:       fmt.Sprintf("bench-%d", i),
+			Server:     fmt.Sprintf("bench-%d.database.windows.net", i%servers),
+			Collectors: collectors,
+		}
+	}
+	return dbs
+}
+
+// NewBenchExporter returns an exporter for dbs that queries a fake SQL backend instead of connecting to the
+// databases, so the scrape pipeline can be measured without any database. Every query of the backend takes
+// latency. It returns a row for the resource stats, the permission check and the wait stats, and no rows for all
+// other queries.
+func NewBenchExporter(dbs []config.Database, latency time.Duration) *Exporter {
+	e := NewExporter(dbs, nil)
+	e.open = func(dsn string) (querier, error) {
+		return sql.Open(benchDriver, latency.String())
+	}
+	return e
+}
+
+// SampleGoroutines counts the goroutines every millisecond until the returned function is called, which returns
+// the highest count seen.
+func SampleGoroutines() func() int {
+	var peak int
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			if n := runtime.NumGoroutine(); n > peak {
+				peak = n
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	var once sync.Once
+	return func() int {
+		once.Do(func() { close(done) })
+		<-stopped
+		return peak
+	}
+}
+
+// fakeDriver is a database/sql driver answering the queries of the collectors with canned rows. The data source
+// name is the latency of every query.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	latency, err := time.ParseDuration(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return fakeConn{latency: latency}, nil
+}
+
+type fakeConn struct {
+	latency time.Duration
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{query: query, latency: c.latency}, nil
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type fakeStmt struct {
+	query   string
+	latency time.Duration
+}
+
+func (fakeStmt) Close() error {
+	return nil
+}
+
+func (fakeStmt) NumInput() int {
+	return -1
+}
+
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("statements are not supported")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), nil)
+}
+
+func (s fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if s.latency > 0 {
+		timer := time.NewTimer(s.latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	switch {
+	case strings.Contains(s.query, "sys.dm_db_resource_stats"):
+		return &fakeRows{
+			columns: []string{"avg_cpu_percent", "avg_data_io_percent", "avg_log_write_percent", "avg_memory_usage_percent", "max_session_percent", "max_worker_percent", "end_time", "avg_instance_cpu_percent", "avg_instance_memory_percent", "avg_login_rate_percent"},
+			values:  [][]driver.Value{{12.5, 3.2, 1.1, 45.0, 0.4, 2.0, time.Now(), 20.0, 50.0, 0.1}},
+		}, nil
+	case strings.Contains(s.query, "HAS_PERMS_BY_NAME"):
+		return &fakeRows{columns: []string{"user", "granted"}, values: [][]driver.Value{{"bench", int64(1)}}}, nil
+	case strings.Contains(s.query, "sys.dm_db_wait_stats"):
+		rows := &fakeRows{columns: []string{"wait_type", "wait_time_ms", "waiting_tasks_count"}}
+		for i := 0; i < 20; i++ {
+			rows.values = append(rows.values, []driver.Value{fmt.Sprintf("WAIT_%d", i), float64(1000 * (i + 1)), float64(i + 1)})
+		}
+		return rows, nil
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
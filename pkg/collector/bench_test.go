@@ -0,0 +1,40 @@
+package collector
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BenchmarkScrape measures a scrape of simulated databases over the fake backend of the bench command, with and
+// without an optional collector returning rows.
+func BenchmarkScrape(b *testing.B) {
+	for _, collectors := range [][]string{nil, {"wait_stats"}} {
+		for _, n := range []int{1, 10, 100} {
+			b.Run(fmt.Sprintf("collectors=%v/databases=%d", collectors, n), func(b *testing.B) {
+				e := NewBenchExporter(BenchDatabases(n, 1, collectors), 0)
+				b.Cleanup(e.Close)
+				reg := prometheus.NewRegistry()
+				reg.MustRegister(e)
+				// The first scrape opens the connection pools and checks the permissions.
+				if _, err := reg.Gather(); err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				stop := SampleGoroutines()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := reg.Gather(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StopTimer()
+				b.ReportMetric(float64(stop()), "peak-goroutines")
+				if errs := e.ScrapeErrors(); len(errs) > 0 {
+					b.Fatal(errs)
+				}
+			})
+		}
+	}
+}
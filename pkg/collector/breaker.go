@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+var (
+	// BreakerThreshold is the number of consecutive failures to connect to or log in to a server, by any of its
+	// databases, after which the circuit of the server opens. 0 disables the circuit breakers.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long the circuit of a server stays open before a single scrape is let through to
+	// probe whether the server recovered.
+	BreakerCooldown = time.Minute
+)
+
+// States of the circuit of a server.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+var circuitStates = []string{circuitClosed, circuitOpen, circuitHalfOpen}
+
+var circuitStateDesc = prometheus.NewDesc(namespace+"_server_circuit_state", "State of the circuit breaker of the server's connection attempts. The series of the current state, closed, open or half_open, is 1.", []string{"server", "state"}, nil)
+
+// circuitOpenError is the error of a scrape that wasn't attempted because the circuit of its server is open.
+type circuitOpenError struct {
+	server string
+	until  time.Time
+}
+
+func (e circuitOpenError) Error() string {
+	return fmt.Sprintf("not connecting to server %s until %s, connections to it failed repeatedly", e.server, e.until.Format(time.RFC3339))
+}
+
+// serverBreaker stops connection attempts to a server whose databases repeatedly fail to connect or log in, so
+// a server that is down isn't hammered with logins by every database on every scrape, which trips the login
+// throttling of Azure. After BreakerCooldown, a single scrape probes the server: the circuit closes if it connects
+// and opens again if it doesn't.
+type serverBreaker struct {
+	server string
+
+	mutex    sync.Mutex
+	state    string
+	failures int
+	until    time.Time
+	// probing is true while the scrape probing a half open circuit is in progress.
+	probing bool
+}
+
+func newServerBreaker(server string) *serverBreaker {
+	return &serverBreaker{server: server, state: circuitClosed}
+}
+
+// allow reports whether a database of the server may be scraped, or returns the error to fail the scrape with.
+func (b *serverBreaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Now().Before(b.until) {
+			return circuitOpenError{server: b.server, until: b.until}
+		}
+		b.state = circuitHalfOpen
+		log.Infof("Probing server %s after its circuit was open for %s", b.server, BreakerCooldown)
+		fallthrough
+	case circuitHalfOpen:
+		if b.probing {
+			return circuitOpenError{server: b.server, until: b.until}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record updates the circuit with the outcome of an allowed scrape. Only failures to reach or log in to the
+// server count; a scrape that failed later still shows that the server is up.
+func (b *serverBreaker) record(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+	if !isConnectionFailure(err) {
+		if b.state != circuitClosed {
+			log.Infof("Closing circuit of server %s, it is reachable again", b.server)
+		}
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= BreakerThreshold {
+		if b.state != circuitOpen {
+			log.Warnf("Opening circuit of server %s for %s after %d consecutive connection failures: %s", b.server, BreakerCooldown, b.failures, err)
+		}
+		b.state = circuitOpen
+		b.until = time.Now().Add(BreakerCooldown)
+	}
+}
+
+// isConnectionFailure reports whether err is a failure to reach or log in to a server. Paused databases fail to
+// connect too, but their server is up.
+func isConnectionFailure(err error) bool {
+	if err == nil || isPausedError(err) || isMissingError(err) {
+		return false
+	}
+	switch errorType(err) {
+	case "connection", "dns", "login":
+		return true
+	}
+	return false
+}
+
+// collect sends the state of the circuit.
+func (b *serverBreaker) collect(ch chan<- prometheus.Metric) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, state := range circuitStates {
+		ch <- prometheus.MustNewConstMetric(circuitStateDesc, prometheus.GaugeValue, boolToFloat(state == b.state), b.server, state)
+	}
+}
+
+// newServerBreakers returns a circuit breaker for every server of targets, or nil if they are disabled.
+func newServerBreakers(targets []*target) map[string]*serverBreaker {
+	if BreakerThreshold <= 0 {
+		return nil
+	}
+	breakers := map[string]*serverBreaker{}
+	for _, t := range targets {
+		if breakers[t.Server] == nil {
+			breakers[t.Server] = newServerBreaker(t.Server)
+		}
+	}
+	return breakers
+}
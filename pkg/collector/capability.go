@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/log"
+)
+
+var (
+	// CapabilityRecheckInterval is how long to skip queries the exporter lacks the permissions for before trying
+	// them again.
+	CapabilityRecheckInterval = time.Hour
+)
+
+// capabilities tracks which groups of queries of a database the exporter has the permissions for. Queries
+// denied by a permission error, typically server level views on least privilege deployments, are skipped or
+// replaced by a database scoped fallback until --scrape.capability-recheck-interval has passed, instead of failing
+// every scrape.
+type capabilities struct {
+	// denied holds the time capabilities were last found denied by name. Only a single scrape of a database runs
+	// at a time, so it doesn't need locking.
+	denied map[string]time.Time
+	// seen holds the names of all capabilities used so far.
+	seen map[string]bool
+	// containedUser is true if the exporter logs in as a contained database user, which never has the
+	// serverCapabilities.
+	containedUser bool
+	// verified is true once the user was found to have VIEW DATABASE STATE.
+	verified bool
+}
+
+// serverCapabilities are the capabilities whose queries need access to master, tempdb or other databases.
+var serverCapabilities = map[string]bool{
+	"server_resource_stats": true,
+	"elastic_pool_stats":    true,
+	"connection_events":     true,
+	"instance_wait_stats":   true,
+	"instance_file_stats":   true,
+	"tempdb_file_space":     true,
+}
+
+func newCapabilities(containedUser bool) *capabilities {
+	return &capabilities{denied: map[string]time.Time{}, seen: map[string]bool{}, containedUser: containedUser}
+}
+
+// capability runs fn, the queries of the named capability, unless the capability was denied recently. If fn
+// fails with a permission error, the capability is marked as denied. While it is denied, fallback is run in its
+// place, if not nil. Other errors of fn are returned.
+func (c *connection) capability(name string, fn func() error, fallback func() error) error {
+	caps := c.caps
+	if caps == nil {
+		return fn()
+	}
+	caps.seen[name] = true
+	if caps.containedUser && serverCapabilities[name] {
+		// The queries would only fail with a permission error, so they aren't tried at all.
+		caps.denied[name] = time.Now()
+		if fallback != nil {
+			return fallback()
+		}
+		return nil
+	}
+	if denied, ok := caps.denied[name]; ok && time.Since(denied) < CapabilityRecheckInterval {
+		if fallback != nil {
+			return fallback()
+		}
+		return nil
+	}
+	err := fn()
+	if err == nil {
+		if _, ok := caps.denied[name]; ok {
+			log.Infof("Permissions for %s on %s were granted", name, c.database)
+			delete(caps.denied, name)
+		}
+		return nil
+	}
+	if !isPermissionError(err) {
+		return err
+	}
+	if _, ok := caps.denied[name]; !ok {
+		log.Warnf("Skipping %s on %s for %s, permission denied: %s", name, c.database, CapabilityRecheckInterval, err)
+	}
+	caps.denied[name] = time.Now()
+	if fallback != nil {
+		return fallback()
+	}
+	return nil
+}
+
+// setCapabilities sets the capability gauges of the target's database.
+func (e *Exporter) setCapabilities(t *target) {
+	labels := t.LabelValues(e.extraLabels)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for name := range t.caps.seen {
+		_, denied := t.caps.denied[name]
+		e.capability.WithLabelValues(append(labels, name)...).Set(boolToFloat(!denied))
+	}
+}
+
+// viewDatabaseStateQuery returns the user the exporter is connected as and whether it has VIEW DATABASE STATE, or
+// the finer grained VIEW DATABASE PERFORMANCE STATE that suffices for the DMVs, on the database.
+const viewDatabaseStateQuery = `SELECT USER_NAME(), CAST(ISNULL(HAS_PERMS_BY_NAME(DB_NAME(), 'DATABASE', 'VIEW DATABASE STATE'), 0) | ISNULL(HAS_PERMS_BY_NAME(DB_NAME(), 'DATABASE', 'VIEW DATABASE PERFORMANCE STATE'), 0) AS int)`
+
+// missingPermissionError is returned by a scrape when the user lacks VIEW DATABASE STATE, which nearly all queries
+// need.
+type missingPermissionError struct {
+	user     string
+	database string
+}
+
+func (e missingPermissionError) Error() string {
+	return fmt.Sprintf("user %s lacks the VIEW DATABASE STATE permission on database %s, grant it with GRANT VIEW DATABASE STATE TO [%s]", e.user, e.database, e.user)
+}
+
+// checkPermissions verifies that the user has VIEW DATABASE STATE on the first scrape of the database, and again
+// after a scrape failed with a permission error, so a missing grant is reported as such instead of as the failure
+// of whatever query ran first. Errors of the check itself are left for the queries of the scrape to report.
+func (c *connection) checkPermissions() error {
+	if c.caps == nil || c.caps.verified {
+		return nil
+	}
+	var user string
+	var granted int
+	err := c.query(func(rows resultRow) error {
+		return rows.Scan(&user, &granted)
+	}, viewDatabaseStateQuery)
+	if err != nil {
+		log.Debugf("Unable to check permissions on %s: %s", c.database, err)
+		return nil
+	}
+	if granted == 0 {
+		return missingPermissionError{user: user, database: c.database.Name}
+	}
+	c.caps.verified = true
+	return nil
+}
+
+// hasPermissionError reports whether err indicates that the user lacks a permission for the queries of a scrape.
+func hasPermissionError(err error) bool {
+	if _, ok := err.(missingPermissionError); ok {
+		return true
+	}
+	return isPermissionError(err)
+}
@@ -0,0 +1,183 @@
+package collector
+
+import (
+	"math"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/log"
+)
+
+var (
+	// MaxSeriesPerMetric is the maximum number of series of a metric a collector exports per database, so a
+	// collector returning unexpectedly many rows, e.g. through a query override, can't flood Prometheus. Further
+	// series are dropped and counted. 0 disables the limit.
+	MaxSeriesPerMetric = 1000
+
+	// MaxLabelValueLength is the maximum length in characters of the label values of the collectors' metrics.
+	// Longer values are truncated. 0 disables the limit.
+	MaxLabelValueLength = 256
+
+	// MaxSeries is the maximum number of series of the collectors the exporter holds across all databases, so an
+	// exporter scraping thousands of databases stays within a predictable memory budget. Series of a collector that
+	// don't fit are dropped and counted. 0 disables the limit.
+	MaxSeries = 0
+)
+
+var (
+	seriesDesc      = prometheus.NewDesc(namespace+"_exporter_series", "Number of series of the collectors the exporter holds across all databases.", nil, nil)
+	seriesLimitDesc = prometheus.NewDesc(namespace+"_exporter_series_limit", "Maximum number of series of the collectors the exporter holds across all databases, set by --collector.max-series.", nil, nil)
+)
+
+// sanitizedMetric is a metric whose label values are sanitized when it is written, except for the labels of the
+// database.
+type sanitizedMetric struct {
+	prometheus.Metric
+	databaseLabels map[string]bool
+}
+
+func (m sanitizedMetric) Write(pb *dto.Metric) error {
+	if err := m.Metric.Write(pb); err != nil {
+		return err
+	}
+	for _, l := range pb.Label {
+		if !m.databaseLabels[l.GetName()] {
+			value := sanitizeLabelValue(l.GetValue())
+			l.Value = &value
+		}
+	}
+	return nil
+}
+
+// sanitizeLabelValue replaces control characters, such as the line breaks of query texts, and invalid UTF-8 in
+// value with spaces and truncates it to MaxLabelValueLength characters.
+func sanitizeLabelValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, value)
+	if MaxLabelValueLength > 0 && utf8.RuneCountInString(value) > MaxLabelValueLength {
+		value = string([]rune(value)[:MaxLabelValueLength])
+	}
+	return value
+}
+
+// limitSeries returns the metrics of the collector with sanitized label values, other than the server, database and
+// static labels of the database, and at most MaxSeriesPerMetric series per metric. Excess series, and series whose
+// sanitized label values collide with an earlier series, are dropped and counted in azure_sql_dropped_series_total.
+// Samples whose value is NaN, because their column was NULL, are skipped.
+func (e *Exporter) limitSeries(t *target, collector string, metrics []prometheus.Metric) []prometheus.Metric {
+	databaseLabels := map[string]bool{"server": true, "database": true}
+	for _, name := range e.extraLabels {
+		databaseLabels[name] = true
+	}
+	series := map[*prometheus.Desc]map[string]bool{}
+	kept := metrics[:0]
+	dropped := 0
+	for _, m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			// The registry reports the error when the metric is collected.
+			kept = append(kept, m)
+			continue
+		}
+		if isNaNSample(&pb) {
+			continue
+		}
+		changed := false
+		values := make([]string, len(pb.Label))
+		for i, l := range pb.Label {
+			values[i] = l.GetValue()
+			if !databaseLabels[l.GetName()] {
+				values[i] = sanitizeLabelValue(values[i])
+				changed = changed || values[i] != l.GetValue()
+			}
+		}
+		seen := series[m.Desc()]
+		if seen == nil {
+			seen = map[string]bool{}
+			series[m.Desc()] = seen
+		}
+		key := strings.Join(values, "\x00")
+		if seen[key] || (MaxSeriesPerMetric > 0 && len(seen) >= MaxSeriesPerMetric) {
+			dropped++
+			continue
+		}
+		seen[key] = true
+		if changed {
+			m = sanitizedMetric{Metric: m, databaseLabels: databaseLabels}
+		}
+		kept = append(kept, m)
+	}
+	if dropped > 0 {
+		log.Warnf("Dropped %d series of collector %s for database %s exceeding --collector.max-series-per-metric or colliding after sanitizing their label values", dropped, collector, t.Database)
+		e.droppedSeries.WithLabelValues(append(t.LabelValues(e.extraLabels), collector)...).Add(float64(dropped))
+	}
+	return kept
+}
+
+// storeMetrics keeps the metrics of the collector in the target, replacing those of an earlier scrape, and returns
+// them. Metrics that would take the series the exporter holds beyond MaxSeries are dropped from the end and counted
+// in azure_sql_dropped_series_total. The caller must hold the target's mutex.
+func (e *Exporter) storeMetrics(t *target, collector string, metrics []prometheus.Metric) []prometheus.Metric {
+	previous := int64(len(t.metrics[collector]))
+	n := int64(len(metrics))
+	for {
+		held := atomic.LoadInt64(&e.series)
+		if MaxSeries > 0 && held-previous+n > int64(MaxSeries) {
+			n = int64(MaxSeries) - (held - previous)
+			if n < 0 {
+				n = 0
+			}
+		}
+		// Concurrent scrapes of other databases may have changed the count since it was loaded.
+		if atomic.CompareAndSwapInt64(&e.series, held, held-previous+n) {
+			break
+		}
+		n = int64(len(metrics))
+	}
+	if dropped := len(metrics) - int(n); dropped > 0 {
+		log.Errorf("Dropped %d series of collector %s for database %s, the exporter holds the %d series of --collector.max-series", dropped, collector, t.Database, MaxSeries)
+		e.droppedSeries.WithLabelValues(append(t.LabelValues(e.extraLabels), collector)...).Add(float64(dropped))
+	}
+	t.metrics[collector] = metrics[:n]
+	return metrics[:n]
+}
+
+// resetMetrics drops the metrics the target holds, sized for its collectors and the instance level collector.
+// The caller must hold the target's mutex.
+func (e *Exporter) resetMetrics(t *target) {
+	held := 0
+	for _, metrics := range t.metrics {
+		held += len(metrics)
+	}
+	atomic.AddInt64(&e.series, -int64(held))
+	t.metrics = make(map[string][]prometheus.Metric, len(t.scrapers)+1)
+}
+
+// collectSeries sends the number of series the exporter holds and the limit, if any.
+func (e *Exporter) collectSeries(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(seriesDesc, prometheus.GaugeValue, float64(atomic.LoadInt64(&e.series)))
+	if MaxSeries > 0 {
+		ch <- prometheus.MustNewConstMetric(seriesLimitDesc, prometheus.GaugeValue, float64(MaxSeries))
+	}
+}
+
+// isNaNSample reports whether the value of a gauge, counter or untyped sample is NaN.
+func isNaNSample(pb *dto.Metric) bool {
+	switch {
+	case pb.Gauge != nil:
+		return math.IsNaN(pb.Gauge.GetValue())
+	case pb.Counter != nil:
+		return math.IsNaN(pb.Counter.GetValue())
+	case pb.Untyped != nil:
+		return math.IsNaN(pb.Untyped.GetValue())
+	}
+	return false
+}
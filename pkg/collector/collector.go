@@ -0,0 +1,226 @@
+// Package collector implements a prometheus.Collector exporting the metrics of Azure SQL databases, for embedding
+// the exporter in other programs. The exported variables hold the settings the azure_sql_exporter command exposes
+// as flags and must be set before NewExporter is called.
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scraper is an optional collector that can be enabled per database in addition to the resource stats. A
+// scraper is created for every database it is enabled for.
+type scraper interface {
+	// Describe sends the descriptors of all metrics the scraper may produce.
+	Describe(ch chan<- *prometheus.Desc)
+	// Scrape queries the database and sends the resulting metrics.
+	Scrape(c *connection, ch chan<- prometheus.Metric) error
+}
+
+// intervalScraper is implemented by scrapers whose queries are too expensive to run on every scrape. Their
+// metrics are reused until the interval has passed.
+type intervalScraper interface {
+	scraper
+	Interval() time.Duration
+}
+
+// scraperResult holds the metrics of a single run of a scraper.
+type scraperResult struct {
+	time    time.Time
+	metrics []prometheus.Metric
+}
+
+// scraperFactory creates a scraper whose metrics carry the given constant labels.
+type scraperFactory func(labels prometheus.Labels) scraper
+
+// scrapers holds the factories of all optional collectors by the name used to enable them in the collectors list
+// of a database.
+var scrapers = map[string]scraperFactory{}
+
+func registerScraper(name string, factory scraperFactory) {
+	scrapers[name] = factory
+}
+
+// Names returns the names of all optional collectors in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(scrapers))
+	for name := range scrapers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// States of the optional collectors of a database exported by azure_sql_collector_status.
+const (
+	// collectorOK means the collector ran, so absent metrics are legitimately absent, e.g. because the
+	// database has no queries in the Query Store.
+	collectorOK = "ok"
+	// collectorFailed means the collector returned an error or couldn't run because the scrape of the database
+	// failed.
+	collectorFailed = "failed"
+	// collectorDisabled means the collector didn't run because the service tier of the database, the collect[]
+	// parameter of the scrape or the API disabled it.
+	collectorDisabled = "disabled"
+)
+
+var collectorStates = []string{collectorOK, collectorFailed, collectorDisabled}
+
+// setCollectorStates sets the collector status gauges of the target's database.
+func (e *Exporter) setCollectorStates(t *target) {
+	labels := t.LabelValues(e.extraLabels)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for name, state := range t.states {
+		for _, s := range collectorStates {
+			e.collectorStatus.WithLabelValues(append(labels, name, s)...).Set(boolToFloat(s == state))
+		}
+	}
+}
+
+// querier is the connection pool of a database as used by the scrapes. It is implemented by *sql.DB and
+// replaced by a fake in tests.
+type querier interface {
+	PingContext(ctx context.Context) error
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	Close() error
+}
+
+// openFunc opens the connection pool of the database with the given data source name.
+type openFunc func(dsn string) (querier, error)
+
+// openMSSQL opens a connection pool with the mssql driver.
+func openMSSQL(dsn string) (querier, error) {
+	return sql.Open("mssql", dsn)
+}
+
+// connection is an open connection to a single database. All queries executed through it are recorded in the
+// audit log.
+type connection struct {
+	// ctx is cancelled when the exporter shuts down.
+	ctx      context.Context
+	db       querier
+	database config.Database
+	audit    *auditLog
+	// caps tracks the queries the exporter lacks the permissions for across scrapes of the database.
+	caps *capabilities
+	// span is the span the queries are traced in, nil if the scrape isn't traced.
+	span *span
+	// queries holds the queries the config overrides by the default query they replace.
+	queries map[string]string
+	// collector is the name of the collector running the queries, empty outside of collectors.
+	collector string
+	// observe records the round trip of a successful query of the collector, if not nil.
+	observe func(collector string, d time.Duration)
+}
+
+// resultRow is the current row of a query result. Its Scan tolerates NULLs and the varying numeric types of the
+// DMVs, so a NULL column, as the DMVs of brand-new databases return, doesn't fail the collector: NULL is scanned into
+// a float64 as NaN, whose sample isn't exported, into an integer as 0, into a string as "" and into a time.Time as
+// the zero time. Decimal and integer columns can be scanned into a float64.
+type resultRow struct {
+	*sql.Rows
+}
+
+func (r resultRow) Scan(dest ...interface{}) error {
+	values := make([]interface{}, len(dest))
+	for i, d := range dest {
+		switch d.(type) {
+		case *float64:
+			values[i] = new(sql.NullFloat64)
+		case *int, *int64:
+			values[i] = new(sql.NullInt64)
+		case *string:
+			values[i] = new(sql.NullString)
+		case *time.Time:
+			values[i] = new(sql.NullTime)
+		default:
+			values[i] = d
+		}
+	}
+	if err := r.Rows.Scan(values...); err != nil {
+		return err
+	}
+	for i, d := range dest {
+		switch d := d.(type) {
+		case *float64:
+			v := values[i].(*sql.NullFloat64)
+			*d = v.Float64
+			if !v.Valid {
+				*d = math.NaN()
+			}
+		case *int:
+			*d = int(values[i].(*sql.NullInt64).Int64)
+		case *int64:
+			*d = values[i].(*sql.NullInt64).Int64
+		case *string:
+			*d = values[i].(*sql.NullString).String
+		case *time.Time:
+			*d = values[i].(*sql.NullTime).Time
+		}
+	}
+	return nil
+}
+
+// query executes query, or the query the config overrides it with, with args and calls fn for every row of the
+// result.
+func (c *connection) query(fn func(resultRow) error, query string, args ...interface{}) error {
+	if override, ok := c.queries[query]; ok {
+		query = override
+	}
+	start := time.Now()
+	n, err := c.scanRows(fn, query, args...)
+	c.audit.Record(c.database, query, start, n, err)
+	if err == nil && c.collector != "" && c.observe != nil {
+		c.observe(c.collector, time.Since(start))
+	}
+	return err
+}
+
+// serviceTier returns the service tier of the database, e.g. Basic, Standard or Premium.
+func (c *connection) serviceTier() (string, error) {
+	var tier string
+	err := c.query(func(rows resultRow) error {
+		return rows.Scan(&tier)
+	}, "SELECT CAST(DATABASEPROPERTYEX(DB_NAME(), 'Edition') AS nvarchar(128))")
+	return tier, err
+}
+
+func (c *connection) scanRows(fn func(resultRow) error, query string, args ...interface{}) (n int, err error) {
+	s := c.span.query("query", query)
+	rows, err := c.db.QueryContext(c.ctx, withLockTimeout(query), args...)
+	s.end(err)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	s = c.span.child("scan")
+	defer func() {
+		s.set(intAttr("db.response.returned_rows", n))
+		s.end(err)
+	}()
+	for rows.Next() {
+		if err := fn(resultRow{rows}); err != nil {
+			return n, fmt.Errorf("unable to scan row: %s", err)
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+func newDesc(metricsName, docString string, constLabels prometheus.Labels, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", metricsName),
+		docString,
+		append([]string{"server", "database"}, labels...),
+		constLabels,
+	)
+}
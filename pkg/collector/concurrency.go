@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+var (
+	// MaxServerConcurrency is the maximum number of databases of the same server scraped concurrently. 0 scrapes all
+	// databases concurrently.
+	MaxServerConcurrency int
+
+	// AdaptiveConcurrency adjusts the number of databases of a server scraped concurrently between 1 and
+	// MaxServerConcurrency, shrinking it on throttling errors and slow scrapes and growing it while scrapes are fast.
+	AdaptiveConcurrency bool
+
+	// LatencyTarget is the scrape duration above which adaptive concurrency shrinks the limit of the server.
+	LatencyTarget = 2 * time.Second
+
+	// ScrapeStagger is the time window over which the starts of the scrapes of the databases are spread evenly, in
+	// the order of the config, so the exporter doesn't connect to all of them at once. 0 starts them all at once.
+	ScrapeStagger time.Duration
+
+	// ScrapeJitter is the maximum random delay added to the start of the scrape of every database, on top of its
+	// place in ScrapeStagger.
+	ScrapeJitter time.Duration
+)
+
+// scrapeDelay returns how long to wait before starting the scrape of the i-th of n databases, by ScrapeStagger and
+// ScrapeJitter.
+func scrapeDelay(i, n int) time.Duration {
+	delay := ScrapeStagger * time.Duration(i) / time.Duration(n)
+	if ScrapeJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(ScrapeJitter)))
+	}
+	return delay
+}
+
+// defaultAdaptiveConcurrency is the upper bound of adaptive concurrency if --scrape.max-concurrency-per-server
+// isn't set.
+const defaultAdaptiveConcurrency = 16
+
+var concurrencyLimitDesc = prometheus.NewDesc(namespace+"_exporter_scrape_concurrency_limit", "Number of databases of the server scraped concurrently.", []string{"server"}, nil)
+
+// serverLimiter limits the number of concurrent scrapes of the databases of one server. In adaptive mode, the
+// limit follows an additive increase, multiplicative decrease scheme: it grows by one per limit successful
+// scrapes below the latency target and halves on throttling errors and slow scrapes.
+type serverLimiter struct {
+	server   string
+	adaptive bool
+	max      float64
+
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	limit  float64
+	active int
+}
+
+func newServerLimiter(server string) *serverLimiter {
+	l := &serverLimiter{server: server, adaptive: AdaptiveConcurrency, max: float64(MaxServerConcurrency)}
+	if l.adaptive && l.max <= 0 {
+		l.max = defaultAdaptiveConcurrency
+	}
+	l.limit = l.max
+	if l.adaptive {
+		// Start cautiously and grow while the server keeps up.
+		l.limit = 1
+	}
+	l.cond = sync.NewCond(&l.mutex)
+	return l
+}
+
+// acquire waits for a free slot. It returns false if ctx was cancelled while waiting.
+func (l *serverLimiter) acquire(ctx context.Context) bool {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.mutex.Lock()
+			l.cond.Broadcast()
+			l.mutex.Unlock()
+		case <-stop:
+		}
+	}()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for l.active >= l.slots() {
+		if ctx.Err() != nil {
+			return false
+		}
+		l.cond.Wait()
+	}
+	l.active++
+	return true
+}
+
+// release frees the slot of a scrape that took d and failed with err, if not nil, and adapts the limit.
+func (l *serverLimiter) release(d time.Duration, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.active--
+	defer l.cond.Broadcast()
+	if !l.adaptive {
+		return
+	}
+	n, _ := sqlErrorNumber(err)
+	throttled := throttlingErrors[n]
+	switch {
+	case throttled || d > LatencyTarget:
+		if l.limit > 1 {
+			l.limit /= 2
+			if l.limit < 1 {
+				l.limit = 1
+			}
+			if throttled {
+				log.Infof("Reducing concurrent scrapes of server %s to %d after throttling: %s", l.server, l.slots(), err)
+			} else {
+				log.Infof("Reducing concurrent scrapes of server %s to %d after a scrape took %s", l.server, l.slots(), d)
+			}
+		}
+	case err == nil && l.limit < l.max:
+		l.limit += 1 / l.limit
+		if l.limit > l.max {
+			l.limit = l.max
+		}
+	}
+}
+
+// slots returns the number of scrapes admitted concurrently, the limit rounded down. The caller must hold the mutex.
+func (l *serverLimiter) slots() int {
+	return int(l.limit)
+}
+
+// metric returns the current number of slots.
+func (l *serverLimiter) metric() prometheus.Metric {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return prometheus.MustNewConstMetric(concurrencyLimitDesc, prometheus.GaugeValue, float64(l.slots()), l.server)
+}
+
+// newServerLimiters returns a limiter for every server of targets, or nil if concurrency isn't limited.
+func newServerLimiters(targets []*target) map[string]*serverLimiter {
+	if MaxServerConcurrency <= 0 && !AdaptiveConcurrency {
+		return nil
+	}
+	limiters := map[string]*serverLimiter{}
+	for _, t := range targets {
+		if limiters[t.Server] == nil {
+			limiters[t.Server] = newServerLimiter(t.Server)
+		}
+	}
+	return limiters
+}
@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	adaptive, max := AdaptiveConcurrency, MaxServerConcurrency
+	defer func() { AdaptiveConcurrency, MaxServerConcurrency = adaptive, max }()
+	AdaptiveConcurrency, MaxServerConcurrency = true, 4
+	l := newServerLimiter("salesdb.database.windows.net")
+	scrape := func(d time.Duration, err error) {
+		t.Helper()
+		if !l.acquire(context.Background()) {
+			t.Fatal("unable to acquire a slot")
+		}
+		l.release(d, err)
+	}
+
+	if l.limit != 1 {
+		t.Fatalf("initial limit = %v, want 1", l.limit)
+	}
+	// The limit grows by one per limit successful scrapes, up to the maximum.
+	scrape(time.Millisecond, nil)
+	if l.limit != 2 {
+		t.Errorf("limit after a fast scrape = %v, want 2", l.limit)
+	}
+	scrape(time.Millisecond, nil)
+	scrape(time.Millisecond, nil)
+	if int(l.limit) != 2 {
+		t.Errorf("limit after two more fast scrapes = %v, want it below 3", l.limit)
+	}
+	// A fractional limit admits as many scrapes as it reports.
+	var m dto.Metric
+	if err := l.metric().Write(&m); err != nil || m.GetGauge().GetValue() != 2 {
+		t.Errorf("reported limit = %v, %v, want 2", m.GetGauge().GetValue(), err)
+	}
+	l.acquire(context.Background())
+	l.acquire(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	if l.acquire(ctx) {
+		t.Error("acquired a third slot at a limit below 3")
+		l.release(time.Millisecond, mssql.Error{Number: errLoginFailed})
+	}
+	cancel()
+	l.release(time.Millisecond, mssql.Error{Number: errLoginFailed})
+	l.release(time.Millisecond, mssql.Error{Number: errLoginFailed})
+	for i := 0; i < 20; i++ {
+		scrape(time.Millisecond, nil)
+	}
+	if l.limit != 4 {
+		t.Errorf("limit after many fast scrapes = %v, want the maximum 4", l.limit)
+	}
+
+	// Throttling and slow scrapes halve it, down to 1.
+	scrape(time.Millisecond, mssql.Error{Number: errServiceBusy})
+	if l.limit != 2 {
+		t.Errorf("limit after throttling = %v, want 2", l.limit)
+	}
+	scrape(LatencyTarget+time.Second, nil)
+	if l.limit != 1 {
+		t.Errorf("limit after a slow scrape = %v, want 1", l.limit)
+	}
+	scrape(time.Millisecond, mssql.Error{Number: errResourceLimit})
+	if l.limit != 1 {
+		t.Errorf("limit after throttling at 1 = %v, want 1", l.limit)
+	}
+	// Elastic pool limits count as throttling too.
+	scrape(time.Millisecond, nil)
+	scrape(time.Millisecond, mssql.Error{Number: errPoolRequests})
+	if l.limit != 1 {
+		t.Errorf("limit after throttling of the elastic pool = %v, want 1", l.limit)
+	}
+	// Other errors leave it alone.
+	scrape(time.Millisecond, mssql.Error{Number: errLoginFailed})
+	if l.limit != 1 {
+		t.Errorf("limit after a login failure = %v, want 1", l.limit)
+	}
+
+	// Scrapes beyond the limit wait.
+	if !l.acquire(context.Background()) {
+		t.Fatal("unable to acquire a slot")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if l.acquire(ctx) {
+		t.Error("acquired a slot beyond the limit")
+	}
+	// And it grows again once the server recovers.
+	l.release(time.Millisecond, nil)
+	if l.limit != 2 {
+		t.Errorf("limit after recovering = %v, want 2", l.limit)
+	}
+}
@@ -0,0 +1,182 @@
+package collector
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+)
+
+// Connection stages checked by diagnoseConnectivity, in order.
+const (
+	stageDNS        = "dns"
+	stageTCP        = "tcp"
+	stageTLS        = "tls"
+	stageLogin      = "login"
+	stagePermission = "permission"
+)
+
+// stageResult is the outcome of one stage of a connectivity check.
+type stageResult struct {
+	Name     string  `json:"name"`
+	OK       bool    `json:"ok"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// connectivityReport is the outcome of a connectivity check of a database. FailedStage is the first stage that
+// failed, if any.
+type connectivityReport struct {
+	Server      string        `json:"server"`
+	Database    string        `json:"database"`
+	OK          bool          `json:"ok"`
+	FailedStage string        `json:"failed_stage,omitempty"`
+	Stages      []stageResult `json:"stages"`
+}
+
+// run executes a stage and records its outcome. It returns false if the stage failed.
+func (r *connectivityReport) run(name string, fn func() error) bool {
+	start := time.Now()
+	err := fn()
+	result := stageResult{Name: name, OK: err == nil, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		result.Error = err.Error()
+		r.FailedStage = name
+	}
+	r.Stages = append(r.Stages, result)
+	return err == nil
+}
+
+// diagnoseConnectivity connects to the database of t step by step and reports the first stage that fails. The
+// DNS and TCP stages are skipped for databases reached through an SSH tunnel.
+func diagnoseConnectivity(t *target, timeout time.Duration) connectivityReport {
+	d := t.Database
+	r := connectivityReport{Server: d.Server, Database: d.Name}
+	if t.tunnel == nil {
+		if !r.run(stageDNS, func() error {
+			_, err := net.LookupHost(d.Host())
+			return err
+		}) {
+			return r
+		}
+		// The port of a named instance without a port is looked up by the driver when connecting.
+		address, ok := d.Address()
+		if ok && !r.run(stageTCP, func() error {
+			conn, err := net.DialTimeout("tcp", address, timeout)
+			if err == nil {
+				conn.Close()
+			}
+			return err
+		}) {
+			return r
+		}
+	}
+	dsn := d.DSN()
+	if t.tunnel != nil {
+		var err error
+		if dsn, err = t.tunnel.dsn(d); err != nil {
+			r.run(stageTCP, func() error { return err })
+			return r
+		}
+	}
+	conn, err := sql.Open("mssql", withTimeouts(dsn, timeout))
+	if err != nil {
+		r.run(stageLogin, func() error { return err })
+		return r
+	}
+	defer conn.Close()
+	// The driver performs the TLS handshake and the login in one step, so they are told apart by the error.
+	start := time.Now()
+	loginErr := conn.Ping()
+	elapsed := time.Since(start).Seconds()
+	if loginErr != nil && strings.HasPrefix(loginErr.Error(), "TLS Handshake failed") {
+		r.Stages = append(r.Stages, stageResult{Name: stageTLS, Duration: elapsed, Error: loginErr.Error()})
+		r.FailedStage = stageTLS
+		return r
+	}
+	r.Stages = append(r.Stages, stageResult{Name: stageTLS, OK: true, Duration: elapsed})
+	if !r.run(stageLogin, func() error {
+		if loginErr != nil && d.IsContainedUser() {
+			return fmt.Errorf("%s (contained users can only log in to the database they were created in, check the database name)", loginErr)
+		}
+		return loginErr
+	}) {
+		return r
+	}
+	if !r.run(stagePermission, func() error {
+		if t.instance != nil {
+			_, err := conn.Exec("SELECT 1")
+			return err
+		}
+		if t.Type == config.TypeSQLServer {
+			// The performance counters require VIEW SERVER STATE.
+			_, err := conn.Exec(sqlServerIOQuery)
+			return err
+		}
+		// The resource stats require VIEW DATABASE STATE.
+		var cpu sql.NullFloat64
+		err := conn.QueryRow("SELECT TOP 1 avg_cpu_percent FROM sys.dm_db_resource_stats ORDER BY end_time DESC").Scan(&cpu)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}) {
+		return r
+	}
+	r.OK = true
+	return r
+}
+
+// findTarget returns the target of the database with the given name, and server if it isn't empty.
+func (e *Exporter) findTarget(name, server string) (*target, error) {
+	var found *target
+	for _, t := range e.allTargets() {
+		if t.Name != name || (server != "" && t.Server != server) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("database %s is configured on several servers, select one with server", name)
+		}
+		found = t
+	}
+	if found == nil {
+		return nil, fmt.Errorf("database %s is not configured", name)
+	}
+	return found, nil
+}
+
+// ConnectivityHandler checks the connectivity of the database given by the database and optional server query
+// parameters and responds with a connectivityReport as JSON.
+func (e *Exporter) ConnectivityHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := e.findTarget(r.URL.Query().Get("database"), r.URL.Query().Get("server"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diagnoseConnectivity(t, ScrapeTimeout))
+}
+
+// CheckConnectivity implements the connectivity command. It prints the connectivityReport of the database on the
+// server, or the only database of that name if server is empty, as JSON and returns the exit code.
+func (e *Exporter) CheckConnectivity(database, server string) int {
+	t, err := e.findTarget(database, server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	report := diagnoseConnectivity(t, ScrapeTimeout)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
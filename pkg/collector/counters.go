@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"math"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// counterResets keeps cumulative DMV values monotonic. SQL Server resets them to zero when the database restarts
+// or fails over to another replica, which shows up as a value lower than the previous scrape's. The values from
+// before the reset are added to all later values of the series, so the exported counters only reset when the
+// exporter restarts and rate() doesn't lose the increase between the last scrape before and the first scrape
+// after the reset.
+//
+// Scrapers are created per database and only a single scrape of a database runs at a time, so the counters don't
+// need locking.
+type counterResets struct {
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	last   float64
+	offset float64
+}
+
+func newCounterResets() *counterResets {
+	return &counterResets{series: map[string]*counterSeries{}}
+}
+
+// value returns the monotonic value of the series of desc with the given label values for the raw DMV value.
+func (c *counterResets) value(desc *prometheus.Desc, raw float64, labels ...string) float64 {
+	if math.IsNaN(raw) {
+		// A NULL value doesn't tell whether the counter was reset.
+		return raw
+	}
+	key := desc.String() + "\x00" + strings.Join(labels, "\x00")
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{}
+		c.series[key] = s
+	}
+	if raw < s.last {
+		s.offset += s.last
+	}
+	s.last = raw
+	return s.offset + raw
+}
+
+// metric returns a counter metric of desc for the raw DMV value, corrected for resets.
+func (c *counterResets) metric(desc *prometheus.Desc, raw float64, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(desc, prometheus.CounterValue, c.value(desc, raw, labels...), labels...)
+}
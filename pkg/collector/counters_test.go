@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"testing"
+)
+
+func TestWaitStatsCounterReset(t *testing.T) {
+	db := sales
+	db.Collectors = []string{"wait_stats"}
+	e, mock := newTestExporter(t, db)
+	waitLabels := withLabel(salesLabels, "wait_type", "PAGEIOLATCH_SH")
+
+	for _, tc := range []struct {
+		name   string
+		waitMs float64
+		want   float64
+	}{
+		{"first scrape", 4000, 4},
+		{"increase", 6000, 6},
+		// The database failed over, so the DMV started from zero again.
+		{"reset", 1000, 7},
+		{"increase after reset", 3000, 9},
+	} {
+		expectResourceStats(mock, 12.5)
+		mock.ExpectQuery(`FROM sys\.dm_db_wait_stats`).WillReturnRows(mock.NewRows([]string{"wait_type", "wait_time_ms", "waiting_tasks_count"}).
+			AddRow("PAGEIOLATCH_SH", tc.waitMs, tc.waitMs/100))
+		families := gather(t, e)
+		if got, _ := value(families, "azure_sql_wait_seconds_total", waitLabels); got != tc.want {
+			t.Errorf("%s: azure_sql_wait_seconds_total = %v, want %v", tc.name, got, tc.want)
+		}
+		if got, _ := value(families, "azure_sql_waiting_tasks_total", waitLabels); got != tc.want*10 {
+			t.Errorf("%s: azure_sql_waiting_tasks_total = %v, want %v", tc.name, got, tc.want*10)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPerformanceCounters(t *testing.T) {
+	db := sales
+	db.Collectors = []string{"performance_counters"}
+	e, mock := newTestExporter(t, db)
+
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`FROM sys\.dm_os_performance_counters`).WillReturnRows(mock.NewRows([]string{"counter_name", "cntr_value"}).
+		AddRow("Number of Deadlocks/sec", 3).
+		AddRow("Log Bytes Flushed/sec", 1048576).
+		AddRow("Log Flushes/sec", 42).
+		AddRow("Transactions/sec", 1200).
+		AddRow("Write Transactions/sec", 300).
+		AddRow("Batch Requests/sec", 5000))
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_deadlocks_total", salesLabels, 3)
+	expectValue(t, families, "azure_sql_log_flushed_bytes_total", salesLabels, 1048576)
+	expectValue(t, families, "azure_sql_log_flushes_total", salesLabels, 42)
+	expectValue(t, families, "azure_sql_transactions_total", salesLabels, 1200)
+	expectValue(t, families, "azure_sql_write_transactions_total", salesLabels, 300)
+	expectValue(t, families, "azure_sql_batch_requests_total", salesLabels, 5000)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
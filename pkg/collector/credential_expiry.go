@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerScraper("credential_expiry", newCredentialExpiryScraper)
+}
+
+// credentialExpiryQuery returns the number of days until the password of the exporter's SQL login expires. It
+// returns no row if the password doesn't expire, e.g. because the expiration policy isn't checked for the login, or
+// the exporter logs in as a contained user or with Windows authentication.
+const credentialExpiryQuery = `SELECT days FROM (SELECT CAST(LOGINPROPERTY(SUSER_SNAME(), 'DaysUntilExpiration') AS int) AS days) l
+WHERE days IS NOT NULL`
+
+// credentialExpiryScraper exports when the password of the exporter's login expires, so the rotation of the
+// credentials can be alerted on before the exporter is locked out.
+type credentialExpiryScraper struct {
+	expiry *prometheus.Desc
+}
+
+func newCredentialExpiryScraper(labels prometheus.Labels) scraper {
+	return credentialExpiryScraper{
+		expiry: newDesc("credential_expiry_timestamp_seconds", "Time the password of the exporter's SQL login expires, at the start of the day. Not exported if the password doesn't expire.", labels),
+	}
+}
+
+func (s credentialExpiryScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.expiry
+}
+
+func (s credentialExpiryScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	return c.query(func(rows resultRow) error {
+		var days int
+		if err := rows.Scan(&days); err != nil {
+			return err
+		}
+		expiry := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, days)
+		ch <- prometheus.MustNewConstMetric(s.expiry, prometheus.GaugeValue, float64(expiry.Unix()), c.database.Server, c.database.Name)
+		return nil
+	}, credentialExpiryQuery)
+}
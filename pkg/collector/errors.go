@@ -0,0 +1,272 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/log"
+)
+
+var (
+	// MissingRetryInterval is how long to wait before scraping a database again after it was found missing, e.g.
+	// because it was dropped.
+	MissingRetryInterval = time.Hour
+
+	// ScrapeTimeout is the time budget of a scrape of a database. Transient errors are retried as long as the budget
+	// allows, and the timeouts of the driver are limited to it, so a login or query the server doesn't respond to
+	// within the budget fails.
+	ScrapeTimeout = 10 * time.Second
+
+	// RetryInitialDelay is the delay before the first retry of a scrape that failed with a transient error. Doubles
+	// with every retry.
+	RetryInitialDelay = 500 * time.Millisecond
+)
+
+// SQL Server error numbers the exporter handles specially.
+const (
+	// errDatabaseUnavailable is returned while a database is unavailable, e.g. because a serverless database
+	// is paused or being resumed.
+	errDatabaseUnavailable = 40613
+	// errServiceError is returned when the database is moved, e.g. during reconfiguration or failover.
+	errServiceError = 40197
+	// errServiceBusy is returned when the service is busy and throttles requests.
+	errServiceBusy = 40501
+	// errResourceLimit and errResourceLimitMinimum are returned when the database reached a resource limit
+	// such as the maximum number of workers or sessions.
+	errResourceLimit        = 10928
+	errResourceLimitMinimum = 10929
+	// errCannotOpenDatabase is returned when the database requested by the login doesn't exist, or the login has
+	// no access to it.
+	errCannotOpenDatabase = 4060
+	// errDatabaseNotFound is returned when a database doesn't exist.
+	errDatabaseNotFound = 911
+	// errLoginFailed is returned for wrong credentials.
+	errLoginFailed = 18456
+	// errLockedOut is returned when the login is locked out after too many failed logins.
+	errLockedOut = 18486
+	// errPasswordExpired and errPasswordMustChange are returned when the password of the login expired or has to be
+	// changed before the login can be used.
+	errPasswordExpired    = 18487
+	errPasswordMustChange = 18488
+	// errFirewall is returned when the client's IP address isn't allowed by the server's firewall rules.
+	errFirewall = 40615
+	// errPoolResources, errPoolOperations and errPoolRequests are returned when the elastic pool of the database
+	// lacks the resources for a request.
+	errPoolResources  = 49918
+	errPoolOperations = 49919
+	errPoolRequests   = 49920
+)
+
+// throttlingErrors are the error numbers Azure SQL returns when it rejects a request because the database, its
+// elastic pool or the service reached a resource limit.
+var throttlingErrors = map[int32]bool{
+	errServiceBusy:          true,
+	errResourceLimit:        true,
+	errResourceLimitMinimum: true,
+	errPoolResources:        true,
+	errPoolOperations:       true,
+	errPoolRequests:         true,
+}
+
+// permissionErrors are the error numbers SQL Server returns when the login lacks the permissions for a query, e.g.
+// VIEW SERVER STATE or access to master.
+var permissionErrors = map[int32]bool{
+	229: true, // The SELECT permission was denied on the object.
+	262: true, // Permission denied in database.
+	297: true, // The user does not have permission to perform this action.
+	300: true, // VIEW SERVER STATE or VIEW DATABASE STATE permission was denied.
+	916: true, // The server principal is not able to access the database under the current security context.
+}
+
+// transientErrors are the error numbers Azure SQL returns for conditions that usually resolve within seconds.
+var transientErrors = map[int32]bool{
+	errDatabaseUnavailable:  true,
+	errServiceError:         true,
+	errServiceBusy:          true,
+	errResourceLimit:        true,
+	errResourceLimitMinimum: true,
+}
+
+// sqlErrorNumber returns the SQL Server error number of err if it was returned by the server.
+func sqlErrorNumber(err error) (int32, bool) {
+	if e, ok := err.(mssql.Error); ok {
+		return e.Number, true
+	}
+	return 0, false
+}
+
+// isPausedError reports whether err indicates that the database is paused, as serverless databases are after
+// their auto-pause delay.
+func isPausedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if n, ok := sqlErrorNumber(err); ok && n == errDatabaseUnavailable {
+		return true
+	}
+	return strings.Contains(err.Error(), "is not currently available")
+}
+
+// isPermissionError reports whether err indicates that the login lacks the permissions for a query.
+func isPermissionError(err error) bool {
+	n, ok := sqlErrorNumber(err)
+	return ok && permissionErrors[n]
+}
+
+// firewallClientRE matches the IP address of the exporter in the message of errFirewall.
+var firewallClientRE = regexp.MustCompile(`Client with IP address '([^']+)' is not allowed`)
+
+// isFirewallError reports whether err indicates that the server's firewall rejected the login because the
+// exporter's IP address isn't allowed. The driver returns login errors as plain errors, so the error is also
+// recognized by its message.
+func isFirewallError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if n, ok := sqlErrorNumber(err); ok && n == errFirewall {
+		return true
+	}
+	return firewallClientRE.MatchString(err.Error())
+}
+
+// firewallClientIP returns the IP address of the exporter as seen by the server from the message of errFirewall,
+// or "" if it isn't found.
+func firewallClientIP(err error) string {
+	if m := firewallClientRE.FindStringSubmatch(err.Error()); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// isMissingError reports whether err indicates that the database doesn't exist on the server.
+func isMissingError(err error) bool {
+	n, ok := sqlErrorNumber(err)
+	return ok && (n == errCannotOpenDatabase || n == errDatabaseNotFound)
+}
+
+// Reasons logins are rejected for, as the reason label of azure_sql_auth_failures_total.
+const (
+	authInvalidCredentials = "invalid_credentials"
+	authLockedOut          = "locked_out"
+	authPasswordExpired    = "password_expired"
+	authPasswordMustChange = "password_must_change"
+	authTokenExpired       = "token_expired"
+)
+
+// authFailureReason returns the reason the server rejected the exporter's login for, or "" if err isn't an
+// authentication failure. The driver returns login errors as plain errors, so they are recognized by their
+// messages too.
+func authFailureReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	n, _ := sqlErrorNumber(err)
+	msg := err.Error()
+	switch {
+	case n == errLockedOut || strings.Contains(msg, "account is currently locked out"):
+		return authLockedOut
+	case n == errPasswordExpired || strings.Contains(msg, "password of the account has expired"):
+		return authPasswordExpired
+	case n == errPasswordMustChange || strings.Contains(msg, "password of the account must be changed"):
+		return authPasswordMustChange
+	case strings.Contains(strings.ToLower(msg), "token") && strings.Contains(strings.ToLower(msg), "expired"):
+		return authTokenExpired
+	case n == errLoginFailed || strings.Contains(msg, "Login failed for user"):
+		return authInvalidCredentials
+	}
+	return ""
+}
+
+// scrapeErrorTypes are the kinds of errors a scrape is reported to have failed with.
+var scrapeErrorTypes = []string{"connection", "dns", "login", "query", "timeout"}
+
+// errorType classifies the error a scrape failed with as one of scrapeErrorTypes. The driver only returns SQL
+// Server errors as typed errors, so network and login errors are recognized by their messages.
+func errorType(err error) string {
+	if _, ok := err.(circuitOpenError); ok {
+		return "connection"
+	}
+	msg := err.Error()
+	if n, ok := sqlErrorNumber(err); ok && (n == errLoginFailed || n == errFirewall) || strings.HasPrefix(msg, "Login error") {
+		return "login"
+	}
+	if n, ok := sqlErrorNumber(err); ok && n == errLockTimeout {
+		return "timeout"
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() || errors.Is(err, context.DeadlineExceeded) || strings.Contains(msg, "i/o timeout") {
+		return "timeout"
+	}
+	if strings.Contains(msg, "no such host") {
+		return "dns"
+	}
+	if strings.HasPrefix(msg, "Unable to open tcp connection") || strings.HasPrefix(msg, "TLS Handshake failed") || strings.Contains(msg, "connection refused") {
+		return "connection"
+	}
+	return "query"
+}
+
+// Categories of the errors scrapes fail with, as the category label of azure_sql_scrape_failures_total.
+const (
+	failureThrottled = "throttled"
+	failurePaused    = "paused"
+	failureFirewall  = "firewall"
+	failureAuth      = "auth"
+	failureTimeout   = "timeout"
+	failureUnknown   = "unknown"
+)
+
+// scrapeFailureCategories are the categories of failureCategory, a bounded set alerts can be routed by.
+var scrapeFailureCategories = []string{failureThrottled, failurePaused, failureFirewall, failureAuth, failureTimeout, failureUnknown}
+
+// failureCategory maps the error a scrape failed with to one of scrapeFailureCategories by its SQL Server error
+// number, or its message for the errors the driver doesn't return typed. Permission errors count as auth.
+func failureCategory(err error) string {
+	n, _ := sqlErrorNumber(err)
+	switch {
+	case throttlingErrors[n]:
+		return failureThrottled
+	case isPausedError(err):
+		return failurePaused
+	case isFirewallError(err):
+		return failureFirewall
+	case authFailureReason(err) != "" || hasPermissionError(err):
+		return failureAuth
+	case errorType(err) == "timeout":
+		return failureTimeout
+	}
+	return failureUnknown
+}
+
+// isTransientError reports whether err is a transient Azure SQL error that is worth retrying.
+func isTransientError(err error) bool {
+	n, ok := sqlErrorNumber(err)
+	return ok && transientErrors[n]
+}
+
+// retryTransient calls fn until it succeeds, fails with an error that isn't transient, or the next attempt would
+// start after the deadline of ctx or ctx is cancelled. The delay between attempts starts at
+// --scrape.retry-initial-delay and doubles every time.
+func retryTransient(ctx context.Context, d config.Database, fn func() error) error {
+	delay := RetryInitialDelay
+	deadline, ok := ctx.Deadline()
+	for {
+		err := fn()
+		if err == nil || !isTransientError(err) || ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		log.Warnf("Transient error scraping %s, retrying in %s: %s", d, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
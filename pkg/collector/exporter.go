@@ -0,0 +1,816 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+var (
+	// BackoffInitial is how long to wait before scraping a database again after a failed scrape. Doubles with every
+	// consecutive failure. 0, the default, disables backoff.
+	BackoffInitial time.Duration
+
+	// BackoffMax is the maximum time to wait before scraping a failing database again.
+	BackoffMax = 10 * time.Minute
+
+	// MetricTTL is the maximum age of values served from an earlier scrape of a database. The resource and collector
+	// metrics of a database without a successful scrape within the TTL are withheld. 0 disables the TTL.
+	MetricTTL time.Duration
+
+	// QueryDurationBuckets are the upper bounds in seconds of the buckets of azure_sql_query_duration_seconds. Empty
+	// uses the default buckets of the Prometheus client.
+	QueryDurationBuckets []float64
+)
+
+const namespace = "azure_sql"
+
+// Exporter implements prometheus.Collector.
+type Exporter struct {
+	targets         []*target
+	mutex           sync.RWMutex
+	up              prometheus.Gauge
+	scrapesOK       prometheus.Gauge
+	scrapesFailed   prometheus.Gauge
+	cpuPercent      *prometheus.GaugeVec
+	dataIO          *prometheus.GaugeVec
+	logIO           *prometheus.GaugeVec
+	memoryPercent   *prometheus.GaugeVec
+	workPercent     *prometheus.GaugeVec
+	sessionPercent  *prometheus.GaugeVec
+	instanceCPU     *prometheus.GaugeVec
+	instanceMemory  *prometheus.GaugeVec
+	loginRate       *prometheus.GaugeVec
+	dbUp            *prometheus.GaugeVec
+	dbPaused        *prometheus.GaugeVec
+	dbMaintenance   *prometheus.GaugeVec
+	dbMissing       *prometheus.GaugeVec
+	firewallBlocked *prometheus.GaugeVec
+	permissionError *prometheus.GaugeVec
+	scrapeError     *prometheus.GaugeVec
+	capability      *prometheus.GaugeVec
+	collectorStatus *prometheus.GaugeVec
+	// collectorDataAge is the age of the results of the background collectors served by the last scrape.
+	collectorDataAge *prometheus.GaugeVec
+	audit            *auditLog
+	history          *scrapeHistory
+	// infoDefs are the definitions of the info metrics, rendered into info for every database.
+	infoDefs []config.InfoMetric
+	info     []prometheus.Metric
+	// droppedSeries counts the series of the collectors dropped by the cardinality limits.
+	droppedSeries *prometheus.CounterVec
+	// authFailures counts the logins the servers rejected by reason.
+	authFailures *prometheus.CounterVec
+	// scrapeFailures counts the failed scrapes by the category of their error.
+	scrapeFailures *prometheus.CounterVec
+	// queryDuration observes the round trips of the queries of the collectors.
+	queryDuration *prometheus.HistogramVec
+	// series is the number of series of the collectors held by the targets, accessed atomically.
+	series int64
+	// scrapesDesc describes the counters of the scrapes of the databases by result.
+	scrapesDesc *prometheus.Desc
+	// resourceColumns maps the columns of sys.dm_db_resource_stats to the gauges they are exported as.
+	resourceColumns []resourceColumn
+	// extraLabels holds the names of the static labels configured for any database, in alphabetical order.
+	extraLabels []string
+	// filter holds the collectors enabled for the scrapes currently passing gate.
+	filter collectFilter
+	gate   filterGate
+	// pools exports the statistics of the connection pools of prewarmed databases.
+	pools poolStats
+	// health pings the databases in the background, nil if disabled.
+	health *healthChecker
+	// open opens the connection pools of the databases.
+	open openFunc
+	// limiters limits the concurrent scrapes by server, if enabled.
+	limiters map[string]*serverLimiter
+	// breakers stops connection attempts to servers that repeatedly fail to connect, if enabled.
+	breakers map[string]*serverBreaker
+	// targetsMutex guards targets, limiters and breakers, which change when databases are added or removed at
+	// runtime. The targets slice is replaced rather than modified, so a snapshot of it stays valid.
+	targetsMutex sync.RWMutex
+	// tierCollectors and tunnels are used to create the targets of databases added at runtime.
+	tierCollectors map[string][]string
+	tunnels        map[string]*tunnel
+	// groups holds the groups of the databases the exporter was created with, which are the groups served.
+	groups map[string]bool
+	// ctx is cancelled by Close to abort in-flight scrapes, which are tracked by scrapes.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	scrapes sync.WaitGroup
+}
+
+// newTarget returns the target scraping db with its collectors. Databases without a list of collectors get the
+// collectors of their service tier from tierCollectors. Databases reached through the same SSH tunnel share it in
+// tunnels.
+func newTarget(db config.Database, tierCollectors map[string][]string, extraLabels []string, tunnels map[string]*tunnel) *target {
+	t := &target{Database: db, scrapers: map[string]scraper{}, results: map[string]scraperResult{}, refreshes: map[string]*backgroundRefresh{}, caps: newCapabilities(db.IsContainedUser()), queries: queryOverrides(db), removed: make(chan struct{}), resourceQuery: resourceStatsQuery}
+	if db.SSHTunnel != nil {
+		key := tunnelKey(db)
+		if tunnels[key] == nil {
+			tunnels[key] = newTunnel(db)
+		}
+		t.tunnel = tunnels[key]
+	}
+	names := db.Collectors
+	if db.Collectors == nil && len(tierCollectors) > 0 {
+		t.tierCollectors = tierCollectors
+		names = nil
+		for _, tierNames := range tierCollectors {
+			names = append(names, tierNames...)
+		}
+	}
+	for _, name := range names {
+		if _, ok := t.scrapers[name]; !ok {
+			t.scrapers[name] = scrapers[name](db.ConstLabels(extraLabels))
+		}
+	}
+	if db.SLO != nil {
+		t.slo = newSLOTracker(*db.SLO, db.ConstLabels(extraLabels))
+	}
+	switch db.Type {
+	case config.TypeManagedInstance:
+		t.instance = newManagedInstanceScraper(db, db.ConstLabels(extraLabels))
+	case config.TypeServer:
+		t.instance = newServerScraper(db.ConstLabels(extraLabels))
+	case config.TypeSynapse:
+		t.instance = newSynapseScraper(db.ConstLabels(extraLabels))
+	case config.TypeSQLServer:
+		t.resourceQuery = sqlServerResourceStatsQuery
+		t.host = newSQLServerScraper(db.ConstLabels(extraLabels))
+	}
+	return t
+}
+
+// NewExporter returns an initialized MS SQL Exporter. Databases without a list of collectors get the
+// collectors of their service tier from tierCollectors.
+func NewExporter(dbs []config.Database, tierCollectors map[string][]string) *Exporter {
+	extraLabels := config.LabelNames(dbs)
+	targets := make([]*target, len(dbs))
+	tunnels := map[string]*tunnel{}
+	groups := map[string]bool{}
+	for i, db := range dbs {
+		targets[i] = newTarget(db, tierCollectors, extraLabels, tunnels)
+		if db.Group != "" {
+			groups[db.Group] = true
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Exporter{
+		targets:          targets,
+		tierCollectors:   tierCollectors,
+		tunnels:          tunnels,
+		groups:           groups,
+		open:             openMSSQL,
+		history:          newScrapeHistory(ScrapeHistorySize),
+		limiters:         newServerLimiters(targets),
+		breakers:         newServerBreakers(targets),
+		pools:            newPoolStats(extraLabels),
+		extraLabels:      extraLabels,
+		ctx:              ctx,
+		cancel:           cancel,
+		up:               newGuage("up", "Fraction of the scraped databases whose last scrape succeeded. Disabled and paused databases don't count."),
+		scrapesOK:        newGuage("scrapes_succeeded", "Number of databases whose last scrape succeeded."),
+		scrapesFailed:    newGuage("scrapes_failed", "Number of databases whose last scrape failed, except paused databases."),
+		cpuPercent:       newGuageVec("cpu_percent", "Average compute utilization in percentage of the limit of the service tier.", extraLabels...),
+		dataIO:           newGuageVec("data_io", "Average I/O utilization in percentage based on the limit of the service tier.", extraLabels...),
+		logIO:            newGuageVec("log_io", "Average write resource utilization in percentage of the limit of the service tier.", extraLabels...),
+		memoryPercent:    newGuageVec("memory_percent", "Average Memory Usage In Percent", extraLabels...),
+		workPercent:      newGuageVec("worker_percent", "Maximum concurrent workers (requests) in percentage based on the limit of the database’s service tier.", extraLabels...),
+		sessionPercent:   newGuageVec("session_percent", "Maximum concurrent sessions in percentage based on the limit of the database’s service tier.", extraLabels...),
+		instanceCPU:      newGuageVec("instance_cpu_percent", "Average CPU utilization of the SQL Server instance hosting the database, including user and system workloads, in percentage of the limit of the service tier.", extraLabels...),
+		instanceMemory:   newGuageVec("instance_memory_percent", "Average memory utilization of the SQL Server instance hosting the database in percentage of the limit of the service tier.", extraLabels...),
+		loginRate:        newGuageVec("login_rate_percent", "Average login rate in percentage of the limit of the service tier.", extraLabels...),
+		dbUp:             newGuageVec("db_up", "Is the database is accessible.", extraLabels...),
+		dbPaused:         newGuageVec("db_paused", "Is the database paused, as serverless databases are after their auto-pause delay.", extraLabels...),
+		dbMaintenance:    newGuageVec("db_in_maintenance", "Is the database in one of its maintenance windows, during which it isn't scraped.", extraLabels...),
+		dbMissing:        newGuageVec("database_missing", "Does the database not exist on the server, e.g. because it was dropped.", extraLabels...),
+		firewallBlocked:  newGuageVec("firewall_blocked", "Did the firewall of the server reject the last login to the database because the exporter's IP address isn't allowed (error 40615).", extraLabels...),
+		permissionError:  newGuageVec("permission_error", "Did the last scrape of the database fail because the exporter's user lacks a permission, such as VIEW DATABASE STATE.", extraLabels...),
+		scrapeError:      newGuageVec("scrape_error", "Did the last scrape of the database fail with an error of the type.", append(append([]string{}, extraLabels...), "error_type")...),
+		capability:       newGuageVec("capability", "Does the exporter have the permissions for the queries of the capability. Denied queries are skipped or replaced by database scoped fallbacks.", append(append([]string{}, extraLabels...), "capability")...),
+		collectorStatus:  newGuageVec("collector_status", "Was the optional collector in the status during the last scrape of the database. ok: the collector ran and missing metrics are legitimately absent, failed: the collector or the scrape of the database failed, disabled: the collector didn't run for the service tier, the collect[] parameter or because the database is disabled.", append(append([]string{}, extraLabels...), "collector", "status")...),
+		collectorDataAge: newGuageVec("collector_data_age_seconds", "Age of the result of the collector refreshed in the background that the last scrape of the database served.", append(append([]string{}, extraLabels...), "collector")...),
+		droppedSeries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dropped_series_total",
+			Help:      "Number of series of the collector dropped because the metric exceeded --collector.max-series-per-metric, the exporter held --collector.max-series series or their label values collided after sanitizing.",
+		}, append(append([]string{"server", "database"}, extraLabels...), "collector")),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_failures_total",
+			Help:      "Number of scrapes of the database that failed because the server rejected the exporter's login, by reason: invalid_credentials, locked_out, password_expired, password_must_change or token_expired.",
+		}, append(append([]string{"server", "database"}, extraLabels...), "reason")),
+		scrapeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "scrape_failures_total",
+			Help:      "Number of failed scrapes of the database by the category of their error: throttled, paused, firewall, auth, timeout or unknown.",
+		}, append(append([]string{"server", "database"}, extraLabels...), "category")),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Round trip time of the successful queries of the collector, from sending the query until its last row was read.",
+			Buckets:   QueryDurationBuckets,
+		}, append(append([]string{"server", "database"}, extraLabels...), "collector")),
+	}
+	if PingInterval > 0 {
+		e.health = newHealthChecker(extraLabels)
+	}
+	e.scrapesDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "database_scrapes_total"), "Number of scrapes of the database by result, success or failure. The exemplar is the trace ID of the last scrape with its duration in seconds as value.", append(append([]string{"server", "database"}, extraLabels...), "result"), nil)
+	e.resourceColumns = []resourceColumn{
+		{"avg_cpu_percent", e.cpuPercent},
+		{"avg_data_io_percent", e.dataIO},
+		{"avg_log_write_percent", e.logIO},
+		{"avg_memory_usage_percent", e.memoryPercent},
+		{"max_worker_percent", e.workPercent},
+		{"max_session_percent", e.sessionPercent},
+		{"avg_instance_cpu_percent", e.instanceCPU},
+		{"avg_instance_memory_percent", e.instanceMemory},
+		{"avg_login_rate_percent", e.loginRate},
+	}
+	return e
+}
+
+// Describe describes all the metrics exported by the MS SQL exporter.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, vec := range e.resourceGauges() {
+		vec.Describe(ch)
+	}
+	e.dbUp.Describe(ch)
+	e.dbPaused.Describe(ch)
+	e.dbMaintenance.Describe(ch)
+	e.dbMissing.Describe(ch)
+	e.firewallBlocked.Describe(ch)
+	e.permissionError.Describe(ch)
+	e.scrapeError.Describe(ch)
+	e.pools.Describe(ch)
+	if e.health != nil {
+		e.health.Describe(ch)
+	}
+	if e.limiters != nil {
+		ch <- concurrencyLimitDesc
+	}
+	if e.breakers != nil {
+		ch <- circuitStateDesc
+	}
+	e.capability.Describe(ch)
+	e.collectorStatus.Describe(ch)
+	e.collectorDataAge.Describe(ch)
+	e.droppedSeries.Describe(ch)
+	e.authFailures.Describe(ch)
+	e.scrapeFailures.Describe(ch)
+	e.queryDuration.Describe(ch)
+	ch <- seriesDesc
+	ch <- seriesLimitDesc
+	ch <- e.scrapesDesc
+	e.up.Describe(ch)
+	e.scrapesOK.Describe(ch)
+	e.scrapesFailed.Describe(ch)
+	if RollupsEnabled {
+		describeRollups(ch)
+	}
+	for _, t := range e.allTargets() {
+		if t.instance != nil {
+			t.instance.Describe(ch)
+		}
+		if t.host != nil {
+			t.host.Describe(ch)
+		}
+		for _, s := range t.scrapers {
+			s.Describe(ch)
+		}
+		if t.slo != nil {
+			t.slo.Describe(ch)
+		}
+	}
+	e.mutex.Lock()
+	for _, m := range e.info {
+		ch <- m.Desc()
+	}
+	e.mutex.Unlock()
+}
+
+// Collect fetches the stats from MS SQL and delivers them as Prometheus metrics. It implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	targets := e.allTargets()
+	e.scrapeTargets(targets)
+	times := e.sourceTimes()
+	// The mutex of a target is taken before the mutex of the exporter while scraping, so the exporter's mutex
+	// must not be held while taking a target's mutex below.
+	e.mutex.Lock()
+	e.collectDatabaseGauges(times, ch)
+	e.setUp()
+	e.up.Collect(ch)
+	e.scrapesOK.Collect(ch)
+	e.scrapesFailed.Collect(ch)
+	for _, m := range e.info {
+		ch <- m
+	}
+	e.mutex.Unlock()
+	e.targetsMutex.RLock()
+	for _, l := range e.limiters {
+		ch <- l.metric()
+	}
+	for _, b := range e.breakers {
+		b.collect(ch)
+	}
+	e.targetsMutex.RUnlock()
+	if e.health != nil {
+		e.health.Collect(ch)
+	}
+	if RollupsEnabled {
+		e.collectRollups(ch)
+	}
+	e.collectTargets(targets, ch)
+	e.collectSeries(ch)
+}
+
+// scrapeTargets scrapes the databases of the targets concurrently, with their starts spread by ScrapeStagger and
+// ScrapeJitter, and waits for the scrapes to finish.
+func (e *Exporter) scrapeTargets(targets []*target) {
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		e.scrapes.Add(1)
+		go func(t *target, delay time.Duration) {
+			defer wg.Done()
+			defer e.scrapes.Done()
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-e.ctx.Done():
+					timer.Stop()
+				}
+			}
+			e.scrapeTarget(t)
+		}(t, scrapeDelay(i, len(targets)))
+	}
+	wg.Wait()
+}
+
+// collectDatabaseGauges removes the resource gauges of databases whose values are stale and sends the gauges
+// of all databases: their resources, with the source timestamps in times, health and collector states. The caller
+// must hold the mutex.
+func (e *Exporter) collectDatabaseGauges(times map[string]time.Time, ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, t := range e.allTargets() {
+		if t.status().Stale(now, MetricTTL) {
+			e.deleteResourceGauges(t.LabelValues(e.extraLabels))
+		}
+	}
+	if e.filter.enabled(resourceStatsCollector) {
+		for _, vec := range e.resourceGauges() {
+			collectWithSourceTimes(vec, times, ch)
+		}
+	}
+	e.dbUp.Collect(ch)
+	e.dbPaused.Collect(ch)
+	e.dbMaintenance.Collect(ch)
+	e.dbMissing.Collect(ch)
+	e.firewallBlocked.Collect(ch)
+	e.permissionError.Collect(ch)
+	e.scrapeError.Collect(ch)
+	e.capability.Collect(ch)
+	e.collectorStatus.Collect(ch)
+	e.collectorDataAge.Collect(ch)
+	e.droppedSeries.Collect(ch)
+	e.authFailures.Collect(ch)
+	e.scrapeFailures.Collect(ch)
+	e.queryDuration.Collect(ch)
+}
+
+// collectTargets sends the metrics kept by the targets: their scrape counters, availability objectives,
+// connection pool statistics and the metrics of their collectors, unless they are stale.
+func (e *Exporter) collectTargets(targets []*target, ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, t := range targets {
+		e.collectScrapes(t, ch)
+		status := t.status()
+		if t.slo != nil {
+			// Paused serverless databases are unavailable by design and don't consume the error budget.
+			t.slo.record(now, status.LastError == "" || status.Paused)
+			t.slo.Collect(t.Database, ch)
+		}
+		t.mutex.Lock()
+		if db, ok := t.db.(*sql.DB); ok {
+			e.pools.collect(db, t.LabelValues(e.extraLabels), ch)
+		}
+		if status.Stale(now, MetricTTL) {
+			t.mutex.Unlock()
+			continue
+		}
+		for name, metrics := range t.metrics {
+			if e.filter.enabled(name) {
+				for _, m := range metrics {
+					ch <- m
+				}
+			}
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// setUp sets the up gauge to the fraction of the databases whose last scrape succeeded, so a total or partial
+// failure to scrape the databases shows in up. Disabled databases, paused databases and databases that weren't
+// scraped yet don't count. up is 1 if no database counts. The caller must hold the mutex.
+func (e *Exporter) setUp() {
+	var succeeded, failed float64
+	for _, s := range e.statuses() {
+		switch {
+		case s.Disabled() || s.Maintenance || s.Paused:
+		case s.Health() == "up":
+			succeeded++
+		case s.Health() == "down":
+			failed++
+		}
+	}
+	e.scrapesOK.Set(succeeded)
+	e.scrapesFailed.Set(failed)
+	if succeeded+failed == 0 {
+		e.up.Set(1)
+		return
+	}
+	e.up.Set(succeeded / (succeeded + failed))
+}
+
+// scrapeTarget scrapes the target's database unless it was already scraped within its minimum scrape interval,
+// in which case the previously collected values are served from the gauges, or the target is backing off after
+// failed scrapes.
+func (e *Exporter) scrapeTarget(t *target) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if e.ctx.Err() != nil || t.isRemoved() {
+		return
+	}
+	if t.MinScrapeInterval > 0 && time.Since(t.lastScrape) < t.MinScrapeInterval {
+		log.Debugf("Skipping %s, last scraped %s ago", t.Database, time.Since(t.lastScrape))
+		return
+	}
+	status := t.status()
+	e.mutex.Lock()
+	e.dbMaintenance.WithLabelValues(t.LabelValues(e.extraLabels)...).Set(boolToFloat(status.Maintenance))
+	e.mutex.Unlock()
+	if status.Disabled() || status.Maintenance {
+		if status.Maintenance {
+			log.Debugf("Skipping %s, in a maintenance window", t.Database)
+			e.history.recordSkip(t.Database, "maintenance")
+		} else {
+			log.Debugf("Skipping %s, disabled until %s", t.Database, status.DisabledUntil)
+			e.history.recordSkip(t.Database, "disabled")
+		}
+		// Values from before the database was disabled would look current.
+		e.resetMetrics(t)
+		t.setStates(collectorDisabled)
+		e.mutex.Lock()
+		e.deleteResourceGauges(t.LabelValues(e.extraLabels))
+		e.mutex.Unlock()
+		e.setCollectorStates(t)
+		return
+	}
+	if next := t.status().NextRetry; time.Now().Before(next) {
+		log.Debugf("Skipping %s, backing off until %s", t.Database, next)
+		e.history.recordSkip(t.Database, "backoff")
+		return
+	}
+	e.scrape(t)
+}
+
+// scrape scrapes the target's database and records the outcome. It returns the trace ID of the scrape, or an
+// empty one if the exporter was closed while waiting for the concurrency limit of the server. The caller must hold
+// the target's mutex.
+func (e *Exporter) scrape(t *target) (string, error) {
+	e.targetsMutex.RLock()
+	l, b := e.limiters[t.Server], e.breakers[t.Server]
+	e.targetsMutex.RUnlock()
+	if l != nil && !l.acquire(e.ctx) {
+		return "", e.ctx.Err()
+	}
+	traceID := newTraceID()
+	log.Debugf("Scraping %s, trace ID %s", t.Database, traceID)
+	t.span = startTrace(traceID, "scrape", attr("db.system", "mssql"), attr("db.namespace", t.Name), attr("server.address", t.Server))
+	start := time.Now()
+	var err error
+	if b != nil {
+		if err = b.allow(); err == nil {
+			err = e.scrapeDatabase(t)
+			b.record(err)
+		}
+	} else {
+		err = e.scrapeDatabase(t)
+	}
+	t.span.end(err)
+	t.span = nil
+	t.resetDatabase(err)
+	if hasPermissionError(err) {
+		// Check the permissions again, so a revoked grant is reported as such.
+		t.caps.verified = false
+	}
+	if l != nil {
+		l.release(time.Since(start), err)
+	}
+	t.recordScrape(err, traceID, time.Since(start))
+	e.history.record(t.Database, start, traceID, time.Since(start), err)
+	t.lastScrape = time.Now()
+	e.setHealth(t.Database, traceID, err)
+	e.setCapabilities(t)
+	e.setCollectorStates(t)
+	t.record(err, BackoffInitial, BackoffMax)
+	return traceID, err
+}
+
+// Close cancels in-flight scrapes, waits for them to finish and prevents further scrapes. Connections to the
+// databases are closed when their scrape finishes, the connections kept open for prewarmed databases once all
+// scrapes finished.
+func (e *Exporter) Close() {
+	e.Shutdown(context.Background())
+}
+
+// Shutdown is Close, but waits for in-flight scrapes and background pings only until ctx is done. The driver
+// doesn't notice the cancellation of a query it already sent, so a scrape may run until the timeouts of the driver
+// end it. Scrapes still running when ctx is done are logged and abandoned, and the connections of the other
+// databases closed. SSH tunnels are closed last.
+func (e *Exporter) Shutdown(ctx context.Context) {
+	e.cancel()
+	done := make(chan struct{})
+	go func() {
+		e.scrapes.Wait()
+		if e.health != nil {
+			e.health.done.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		e.closeDatabases(true)
+	case <-ctx.Done():
+		e.closeDatabases(false)
+	}
+	e.targetsMutex.RLock()
+	defer e.targetsMutex.RUnlock()
+	for _, tunnel := range e.tunnels {
+		tunnel.close()
+	}
+}
+
+// setHealth sets the gauges reporting whether the database is up, paused or missing and the type of the error from
+// the outcome of a scrape. The resource gauges of a failed database are removed, so they don't keep reporting the
+// values of the last successful scrape.
+func (e *Exporter) setHealth(d config.Database, traceID string, err error) {
+	labels := d.LabelValues(e.extraLabels)
+	paused, missing, blocked, denied := isPausedError(err), isMissingError(err), isFirewallError(err), hasPermissionError(err)
+	authFailure := authFailureReason(err)
+	switch {
+	case err == nil:
+	case authFailure == authLockedOut:
+		log.Errorf("Login of the exporter to database %s is locked out, unlock it and check its password (trace ID %s): %s", d, traceID, err)
+	case authFailure == authPasswordExpired || authFailure == authPasswordMustChange || authFailure == authTokenExpired:
+		log.Errorf("Credentials of the exporter for database %s expired, rotate them (trace ID %s): %s", d, traceID, err)
+	case paused:
+		log.Infof("Database %s is paused: %s", d, err)
+	case missing:
+		log.Warnf("Database %s doesn't exist: %s", d, err)
+	case blocked:
+		log.Errorf("Firewall of server %s doesn't allow the exporter's IP address %s, add a firewall rule for it (trace ID %s): %s", d.Server, firewallClientIP(err), traceID, err)
+	case denied:
+		log.Errorf("Exporter's user lacks a permission on database %s (trace ID %s): %s", d, traceID, err)
+	default:
+		log.Errorf("Failed to scrape database %s (trace ID %s): %s", d, traceID, err)
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.dbUp.WithLabelValues(labels...).Set(boolToFloat(err == nil))
+	e.dbPaused.WithLabelValues(labels...).Set(boolToFloat(paused))
+	e.dbMissing.WithLabelValues(labels...).Set(boolToFloat(missing))
+	e.firewallBlocked.WithLabelValues(labels...).Set(boolToFloat(blocked))
+	e.permissionError.WithLabelValues(labels...).Set(boolToFloat(denied))
+	if authFailure != "" {
+		e.authFailures.WithLabelValues(append(labels, authFailure)...).Inc()
+	}
+	// All categories are exported from the first scrape on, so increases of rarely failing ones can be alerted on.
+	for _, category := range scrapeFailureCategories {
+		e.scrapeFailures.WithLabelValues(append(labels, category)...)
+	}
+	failedType := ""
+	if err != nil {
+		failedType = errorType(err)
+		e.scrapeFailures.WithLabelValues(append(labels, failureCategory(err))...).Inc()
+		e.deleteResourceGauges(labels)
+	}
+	for _, errType := range scrapeErrorTypes {
+		e.scrapeError.WithLabelValues(append(labels, errType)...).Set(boolToFloat(errType == failedType))
+	}
+}
+
+// resourceGauges returns the gauges of the resource stats.
+func (e *Exporter) resourceGauges() []*prometheus.GaugeVec {
+	vecs := make([]*prometheus.GaugeVec, len(e.resourceColumns))
+	for i, c := range e.resourceColumns {
+		vecs[i] = c.gauge
+	}
+	return vecs
+}
+
+// deleteResourceGauges removes the series of the resource gauges with the given label values. The caller must hold
+// the mutex.
+func (e *Exporter) deleteResourceGauges(labels []string) {
+	for _, vec := range e.resourceGauges() {
+		vec.DeleteLabelValues(labels...)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (e *Exporter) scrapeDatabase(t *target) error {
+	d := t.Database
+	labels := d.LabelValues(e.extraLabels)
+	e.resetMetrics(t)
+	// Collectors that don't get to run because the scrape fails first count as failed.
+	t.setStates(collectorFailed)
+	t.rollup = nil
+	t.sourceTime = time.Time{}
+	if d.Type == config.TypeSynthetic {
+		return e.scrapeSynthetic(t)
+	}
+	conn, release, err := e.openDatabase(t)
+	if err != nil {
+		return err
+	}
+	defer release()
+	// Transient errors are only retried within the time budget of the scrape. The driver ignores the context once a
+	// query was sent; logins and queries the server stops responding to are ended by its timeouts, which dsn limits
+	// to the budget.
+	ctx, cancel := context.WithTimeout(e.ctx, ScrapeTimeout)
+	defer cancel()
+	if connect := t.span.child("connect"); connect != nil {
+		// The driver connects on the first query. A traced scrape pings first to tell the time spent connecting
+		// from the time spent in the query; a failure is left to the query to retry and report.
+		connect.end(conn.PingContext(ctx))
+	}
+	c := &connection{ctx: ctx, db: conn, database: d, audit: e.audit, caps: t.caps, span: t.span, queries: t.queries, observe: func(collector string, d time.Duration) {
+		e.observeQuery(t, collector, d)
+	}}
+	if err := c.checkPermissions(); err != nil {
+		return err
+	}
+	if t.instance != nil {
+		return e.scrapeInstance(t, c)
+	}
+	if !e.filter.enabled(resourceStatsCollector) {
+		if err := retryTransient(ctx, d, func() error { return conn.PingContext(ctx) }); err != nil {
+			return err
+		}
+		e.runScrapers(t, c)
+		return nil
+	}
+	stats, err := e.queryResourceStats(ctx, t, conn)
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	for _, c := range e.resourceColumns {
+		if v, ok := stats.values[c.name]; ok {
+			c.gauge.WithLabelValues(labels...).Set(v)
+		} else {
+			c.gauge.DeleteLabelValues(labels...)
+		}
+	}
+	e.mutex.Unlock()
+	t.sourceTime = stats.endTime
+	if RollupsEnabled {
+		sampleRollup(t, c, stats.values)
+	}
+	if t.host != nil {
+		r, err := runScraper(t.host, c, len(t.metrics[resourceStatsCollector]))
+		if err != nil {
+			return err
+		}
+		e.storeMetrics(t, resourceStatsCollector, e.limitSeries(t, resourceStatsCollector, r.metrics))
+	}
+	e.runScrapers(t, c)
+	return nil
+}
+
+// scrapeInstance collects the instance level metrics of a Managed Instance or the server level metrics of a
+// logical server in place of the resource stats of a database, followed by the optional collectors.
+func (e *Exporter) scrapeInstance(t *target, c *connection) error {
+	var metrics []prometheus.Metric
+	err := retryTransient(c.ctx, t.Database, func() error {
+		if !e.filter.enabled(resourceStatsCollector) {
+			return c.db.PingContext(c.ctx)
+		}
+		r, err := runScraper(t.instance, c, 0)
+		metrics = r.metrics
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	e.storeMetrics(t, resourceStatsCollector, e.limitSeries(t, resourceStatsCollector, metrics))
+	e.runScrapers(t, c)
+	return nil
+}
+
+// runScrapers runs the optional collectors enabled for the target's database and the current scrape and stores
+// their metrics in the target. Failing collectors are logged and skipped.
+func (e *Exporter) runScrapers(t *target, c *connection) {
+	names := t.Collectors
+	if t.tierCollectors != nil {
+		tier, err := c.serviceTier()
+		if err != nil {
+			log.Errorf("Unable to detect service tier of database %s: %s", c.database, err)
+			return
+		}
+		names = t.tierCollectors[strings.ToLower(tier)]
+		// Collectors of other tiers don't run.
+		t.setStates(collectorDisabled)
+	}
+	for _, name := range names {
+		if !e.filter.enabled(name) {
+			t.states[name] = collectorDisabled
+			continue
+		}
+		s := t.scrapers[name]
+		if contains(BackgroundCollectors, name) {
+			e.refreshInBackground(t, name, s)
+			continue
+		}
+		if is, ok := s.(intervalScraper); ok {
+			if r, ok := t.results[name]; ok && time.Since(r.time) < is.Interval() {
+				e.storeMetrics(t, name, r.metrics)
+				t.states[name] = collectorOK
+				continue
+			}
+		}
+		parent := c.span
+		c.span = parent.child("collector", attr("azure_sql.collector", name))
+		c.collector = name
+		r, err := runScraper(s, c, len(t.results[name].metrics))
+		c.span.end(err)
+		c.span = parent
+		c.collector = ""
+		if err != nil {
+			log.Errorf("Collector %s failed for database %s: %s", name, c.database, err)
+			delete(t.results, name)
+			continue
+		}
+		r.metrics = e.storeMetrics(t, name, e.limitSeries(t, name, r.metrics))
+		t.states[name] = collectorOK
+		t.results[name] = r
+	}
+}
+
+// runScraper runs the collector, sizing its metrics for the number of series of its last result.
+func runScraper(s scraper, c *connection, size int) (scraperResult, error) {
+	r := scraperResult{time: time.Now(), metrics: make([]prometheus.Metric, 0, size)}
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for m := range ch {
+			r.metrics = append(r.metrics, m)
+		}
+		close(done)
+	}()
+	err := s.Scrape(c, ch)
+	close(ch)
+	<-done
+	return r, err
+}
+
+func newGuageVec(metricsName, docString string, extraLabels ...string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      metricsName,
+			Help:      docString,
+		},
+		append([]string{"server", "database"}, extraLabels...),
+	)
+}
+
+func newGuage(metricsName, docString string) prometheus.Gauge {
+	return prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      metricsName,
+			Help:      docString,
+		},
+	)
+}
+
+// observeQuery records the round trip d of a successful query of the collector for the target's database.
+func (e *Exporter) observeQuery(t *target, collector string, d time.Duration) {
+	e.queryDuration.WithLabelValues(append(t.LabelValues(e.extraLabels), collector)...).Observe(d.Seconds())
+}
@@ -0,0 +1,1179 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alecthomas/kingpin/v2"
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var sales = config.Database{Name: "Sales", Server: "sales.database.windows.net"}
+
+var salesLabels = map[string]string{"server": "sales.database.windows.net", "database": "Sales"}
+
+// withLabel returns a copy of labels with name set to value.
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	copied := map[string]string{name: value}
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
+
+func TestScrapeResourceStats(t *testing.T) {
+	e, mock := newTestExporter(t, sales)
+	expectResourceStats(mock, 12.5)
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_db_up", salesLabels, 1)
+	expectValue(t, families, "azure_sql_up", nil, 1)
+	expectValue(t, families, "azure_sql_scrapes_succeeded", nil, 1)
+	expectValue(t, families, "azure_sql_cpu_percent", salesLabels, 12.5)
+	expectValue(t, families, "azure_sql_data_io", salesLabels, 13.5)
+	expectValue(t, families, "azure_sql_log_io", salesLabels, 14.5)
+	expectValue(t, families, "azure_sql_memory_percent", salesLabels, 15.5)
+	expectValue(t, families, "azure_sql_session_percent", salesLabels, 16.5)
+	expectValue(t, families, "azure_sql_worker_percent", salesLabels, 17.5)
+	expectValue(t, families, "azure_sql_instance_cpu_percent", salesLabels, 18.5)
+	expectValue(t, families, "azure_sql_instance_memory_percent", salesLabels, 19.5)
+	expectValue(t, families, "azure_sql_login_rate_percent", salesLabels, 20.5)
+	for _, errType := range scrapeErrorTypes {
+		expectValue(t, families, "azure_sql_scrape_error", withLabel(salesLabels, "error_type", errType), 0)
+	}
+	expectValue(t, families, "azure_sql_database_scrapes_total", withLabel(salesLabels, "result", scrapeSuccess), 1)
+	exemplar := families["azure_sql_database_scrapes_total"].Metric[0].Counter.GetExemplar()
+	if len(exemplar.GetLabel()) != 1 || exemplar.GetLabel()[0].GetName() != "trace_id" || len(exemplar.GetLabel()[0].GetValue()) != 32 {
+		t.Errorf("exemplar of azure_sql_database_scrapes_total = %v, want a trace_id", exemplar)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScrapeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		err       error
+		errorType string
+		category  string
+		missing   bool
+		paused    bool
+		firewall  bool
+	}{
+		{"login", mssql.Error{Number: errLoginFailed, Message: "Login failed for user 'prometheus'."}, "login", failureAuth, false, false, false},
+		{"firewall", errors.New("Login error: mssql: Cannot open server 'salesdb' requested by the login. Client with IP address '203.0.113.7' is not allowed to access the server."), "login", failureFirewall, false, false, true},
+		{"missing", mssql.Error{Number: errCannotOpenDatabase, Message: "Cannot open database \"Sales\" requested by the login."}, "query", failureUnknown, true, false, false},
+		{"query", errors.New("mssql: Invalid object name 'sys.dm_db_resource_stats'."), "query", failureUnknown, false, false, false},
+		{"timeout", errors.New("read tcp 10.0.0.1:1433: i/o timeout"), "timeout", failureTimeout, false, false, false},
+		{"deadline", fmt.Errorf("query resource stats: %w", context.DeadlineExceeded), "timeout", failureTimeout, false, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e, mock := newTestExporter(t, sales)
+			mock.ExpectQuery(resourceStatsPattern).WillReturnError(tc.err)
+
+			families := gather(t, e)
+			expectValue(t, families, "azure_sql_db_up", salesLabels, 0)
+			expectValue(t, families, "azure_sql_up", nil, 0)
+			expectValue(t, families, "azure_sql_scrapes_failed", nil, 1)
+			expectValue(t, families, "azure_sql_database_missing", salesLabels, boolToFloat(tc.missing))
+			expectValue(t, families, "azure_sql_db_paused", salesLabels, boolToFloat(tc.paused))
+			expectValue(t, families, "azure_sql_firewall_blocked", salesLabels, boolToFloat(tc.firewall))
+			for _, errType := range scrapeErrorTypes {
+				expectValue(t, families, "azure_sql_scrape_error", withLabel(salesLabels, "error_type", errType), boolToFloat(errType == tc.errorType))
+			}
+			for _, category := range scrapeFailureCategories {
+				expectValue(t, families, "azure_sql_scrape_failures_total", withLabel(salesLabels, "category", category), boolToFloat(category == tc.category))
+			}
+			expectAbsent(t, families, "azure_sql_cpu_percent", salesLabels)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	timeout := ScrapeTimeout
+	ScrapeTimeout = time.Second
+	defer func() { ScrapeTimeout = timeout }()
+	// The server accepts connections but never responds, and the mssql driver ignores the context once it sent
+	// the login.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	db := config.Database{Name: "Sales", Server: "127.0.0.1", ConnectionSettings: config.ConnectionSettings{User: "exporter", Password: "s3cr3t", Port: uint(p)}}
+	e, _ := newTestExporter(t, db)
+	e.open = openMSSQL
+
+	start := time.Now()
+	families := gather(t, e)
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("scrape of an unresponsive server took %s, want it to fail within the budget of %s", d, ScrapeTimeout)
+	}
+	labels := map[string]string{"database": "Sales"}
+	expectValue(t, families, "azure_sql_db_up", labels, 0)
+	expectValue(t, families, "azure_sql_scrape_error", withLabel(labels, "error_type", "timeout"), 1)
+
+	for _, tc := range []struct {
+		dsn, want string
+	}{
+		{"server=sales", "server=sales;connection timeout=1;dial timeout=1"},
+		{"server=sales;Connection Timeout=60;dial timeout=1", "server=sales;Connection Timeout=60;dial timeout=1;connection timeout=1"},
+		{"server=sales;connection timeout=1;dial timeout=1", "server=sales;connection timeout=1;dial timeout=1"},
+	} {
+		if got := withTimeouts(tc.dsn, 500*time.Millisecond); got != tc.want {
+			t.Errorf("withTimeouts(%q) = %q, want %q", tc.dsn, got, tc.want)
+		}
+	}
+	if got := withTimeouts("server=sales", 10*time.Second); got != "server=sales;connection timeout=10" {
+		t.Errorf("withTimeouts with a budget longer than the default dial timeout = %q, want only the connection timeout", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	e, mock := newTestExporter(t, sales)
+	max := BackoffMax
+	defer func() { BackoffMax = max }()
+	BackoffInitial, BackoffMax = time.Minute, 3*time.Minute
+	target := e.targets[0]
+	fail := func(want time.Duration) {
+		t.Helper()
+		// Pretend the previous backoff has passed.
+		target.statusMutex.Lock()
+		target.nextRetry = time.Time{}
+		target.statusMutex.Unlock()
+		mock.ExpectQuery(resourceStatsPattern).WillReturnError(errors.New("mssql: Invalid object name 'sys.dm_db_resource_stats'."))
+		gather(t, e)
+		if got := time.Until(target.status().NextRetry); got <= want-time.Second || got > want {
+			t.Errorf("backing off for %s, want %s", got, want)
+		}
+	}
+
+	// The backoff doubles with every consecutive failure up to the maximum.
+	fail(time.Minute)
+	fail(2 * time.Minute)
+	fail(3 * time.Minute)
+	fail(3 * time.Minute)
+	// A database backing off isn't queried.
+	expectValue(t, gather(t, e), "azure_sql_db_up", salesLabels, 0)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// A successful scrape resets the backoff.
+	target.statusMutex.Lock()
+	target.nextRetry = time.Time{}
+	target.statusMutex.Unlock()
+	expectResourceStats(mock, 12.5)
+	expectValue(t, gather(t, e), "azure_sql_db_up", salesLabels, 1)
+	if s := target.status(); s.Failures != 0 || !s.NextRetry.IsZero() {
+		t.Errorf("got %d failures and next retry %s after a successful scrape, want none", s.Failures, s.NextRetry)
+	}
+	fail(time.Minute)
+}
+
+func TestFailureCategories(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		category string
+	}{
+		{mssql.Error{Number: errServiceBusy}, failureThrottled},
+		{mssql.Error{Number: errPoolRequests}, failureThrottled},
+		{mssql.Error{Number: errDatabaseUnavailable}, failurePaused},
+		{mssql.Error{Number: errFirewall}, failureFirewall},
+		{mssql.Error{Number: errLockedOut}, failureAuth},
+		{mssql.Error{Number: 300, Message: "VIEW DATABASE STATE permission denied in database 'Sales'."}, failureAuth},
+		{mssql.Error{Number: errLockTimeout}, failureTimeout},
+		{errors.New("read tcp 10.0.0.1:1433: i/o timeout"), failureTimeout},
+		{errors.New("unexpected EOF"), failureUnknown},
+	} {
+		if got := failureCategory(tc.err); got != tc.category {
+			t.Errorf("got category %s for %v, want %s", got, tc.err, tc.category)
+		}
+	}
+}
+
+func TestPermissionCheck(t *testing.T) {
+	e, mock := newTestExporter(t, sales)
+	e.targets[0].caps.verified = false
+	permissions := regexp.QuoteMeta("SELECT USER_NAME(), CAST(ISNULL(HAS_PERMS_BY_NAME(")
+	mock.ExpectQuery(permissions).WillReturnRows(sqlmock.NewRows([]string{"user", "granted"}).AddRow("prometheus", 0))
+	mock.ExpectQuery(permissions).WillReturnRows(sqlmock.NewRows([]string{"user", "granted"}).AddRow("prometheus", 1))
+	expectResourceStats(mock, 12.5)
+	// Once granted, the permission isn't checked again.
+	expectResourceStats(mock, 12.5)
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_db_up", salesLabels, 0)
+	expectValue(t, families, "azure_sql_permission_error", salesLabels, 1)
+	expectAbsent(t, families, "azure_sql_cpu_percent", salesLabels)
+	for i := 0; i < 2; i++ {
+		families = gather(t, e)
+		expectValue(t, families, "azure_sql_permission_error", salesLabels, 0)
+		expectValue(t, families, "azure_sql_cpu_percent", salesLabels, 12.5)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResourceStatsColumns(t *testing.T) {
+	e, mock := newTestExporter(t, sales)
+	// Older service levels lack the instance and login rate columns, and other deployment options add columns the
+	// exporter doesn't know.
+	columns := append(append([]string{}, resourceStatsColumns[:7]...), "dtu_limit")
+	mock.ExpectQuery(resourceStatsPattern).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(12.5, 13.5, 14.5, 15.5, 16.5, 17.5, time.Now(), 100))
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_db_up", salesLabels, 1)
+	expectValue(t, families, "azure_sql_cpu_percent", salesLabels, 12.5)
+	expectValue(t, families, "azure_sql_worker_percent", salesLabels, 17.5)
+	expectAbsent(t, families, "azure_sql_instance_cpu_percent", salesLabels)
+	expectAbsent(t, families, "azure_sql_login_rate_percent", salesLabels)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNullColumns(t *testing.T) {
+	db := sales
+	db.Collectors = []string{"memory"}
+	e, mock := newTestExporter(t, db)
+	// Brand-new databases have no resource stats yet and return NULL from other DMVs.
+	mock.ExpectQuery(resourceStatsPattern).WillReturnRows(sqlmock.NewRows(resourceStatsColumns))
+	mock.ExpectQuery(`FROM sys\.dm_exec_query_memory_grants`).WillReturnRows(sqlmock.NewRows([]string{"granted", "pending", "granted_bytes", "pending_bytes", "max_wait", "buffer_pool", "buffer_pool_dirty"}).
+		AddRow(0, 0, 0, 0, nil, "1048576.0", nil))
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_db_up", salesLabels, 1)
+	expectAbsent(t, families, "azure_sql_cpu_percent", salesLabels)
+	expectValue(t, families, "azure_sql_buffer_pool_bytes", salesLabels, 1048576)
+	expectAbsent(t, families, "azure_sql_memory_grant_max_wait_seconds", salesLabels)
+	expectAbsent(t, families, "azure_sql_buffer_pool_dirty_bytes", salesLabels)
+	expectValue(t, families, "azure_sql_collector_status", withLabel(withLabel(salesLabels, "collector", "memory"), "status", collectorOK), 1)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFailedScrapeRemovesResourceStats(t *testing.T) {
+	e, mock := newTestExporter(t, sales)
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(resourceStatsPattern).WillReturnError(errors.New("mssql: Invalid object name 'sys.dm_db_resource_stats'."))
+
+	expectValue(t, gather(t, e), "azure_sql_cpu_percent", salesLabels, 12.5)
+	expectAbsent(t, gather(t, e), "azure_sql_cpu_percent", salesLabels)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorStatus(t *testing.T) {
+	db := sales
+	db.Collectors = []string{"query_store"}
+	e, mock := newTestExporter(t, db)
+	queryStoreLabels := withLabel(salesLabels, "collector", "query_store")
+
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`FROM sys\.query_store_query`).WillReturnRows(mock.NewRows([]string{"query_id", "query_hash", "executions", "avg_duration", "avg_cpu"}).
+		AddRow(42, "0x1234", 10, 2e6, 1e6))
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_collector_status", withLabel(queryStoreLabels, "status", collectorOK), 1)
+	expectValue(t, families, "azure_sql_collector_status", withLabel(queryStoreLabels, "status", collectorFailed), 0)
+	expectValue(t, families, "azure_sql_query_store_avg_duration_seconds", withLabel(salesLabels, "query_id", "42"), 2)
+
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`FROM sys\.query_store_query`).WillReturnError(errors.New("mssql: Invalid object name 'sys.query_store_query'."))
+	families = gather(t, e)
+	expectValue(t, families, "azure_sql_collector_status", withLabel(queryStoreLabels, "status", collectorOK), 0)
+	expectValue(t, families, "azure_sql_collector_status", withLabel(queryStoreLabels, "status", collectorFailed), 1)
+	expectAbsent(t, families, "azure_sql_query_store_avg_duration_seconds", salesLabels)
+
+	mock.ExpectQuery(resourceStatsPattern).WillReturnError(errors.New("read tcp 10.0.0.1:1433: i/o timeout"))
+	families = gather(t, e)
+	expectValue(t, families, "azure_sql_collector_status", withLabel(queryStoreLabels, "status", collectorFailed), 1)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackgroundCollectors(t *testing.T) {
+	background := BackgroundCollectors
+	BackgroundCollectors = []string{"query_store"}
+	defer func() { BackgroundCollectors = background }()
+	db := sales
+	db.Collectors = []string{"query_store"}
+	e, mock := newTestExporter(t, db)
+	mock.MatchExpectationsInOrder(false)
+	queryStoreLabels := withLabel(salesLabels, "collector", "query_store")
+	queryStoreRows := func(avgDuration float64) *sqlmock.Rows {
+		return mock.NewRows([]string{"query_id", "query_hash", "executions", "avg_duration", "avg_cpu"}).AddRow(42, "0x1234", 10, avgDuration, 1e6)
+	}
+	waitForRefresh := func() {
+		t.Helper()
+		target := e.targets[0]
+		for i := 0; i < 100; i++ {
+			target.mutex.Lock()
+			running := target.refreshes["query_store"].running
+			target.mutex.Unlock()
+			if !running {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("background refresh didn't finish")
+	}
+
+	// The first scrape doesn't wait for the collector.
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`FROM sys\.query_store_query`).WillReturnRows(queryStoreRows(2e6))
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_cpu_percent", salesLabels, 12.5)
+	expectAbsent(t, families, "azure_sql_query_store_avg_duration_seconds", salesLabels)
+	expectAbsent(t, families, "azure_sql_collector_data_age_seconds", queryStoreLabels)
+	waitForRefresh()
+
+	// Later scrapes serve the last result while the next refresh runs.
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`FROM sys\.query_store_query`).WillReturnError(errors.New("mssql: Invalid object name 'sys.query_store_query'."))
+	families = gather(t, e)
+	expectValue(t, families, "azure_sql_query_store_avg_duration_seconds", withLabel(salesLabels, "query_id", "42"), 2)
+	expectValue(t, families, "azure_sql_collector_status", withLabel(queryStoreLabels, "status", collectorOK), 1)
+	if _, ok := value(families, "azure_sql_collector_data_age_seconds", queryStoreLabels); !ok {
+		t.Error("azure_sql_collector_data_age_seconds not found")
+	}
+	waitForRefresh()
+
+	// A failed refresh keeps the stale result.
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`FROM sys\.query_store_query`).WillReturnRows(queryStoreRows(3e6))
+	families = gather(t, e)
+	expectValue(t, families, "azure_sql_query_store_avg_duration_seconds", withLabel(salesLabels, "query_id", "42"), 2)
+	expectValue(t, families, "azure_sql_collector_status", withLabel(queryStoreLabels, "status", collectorFailed), 1)
+	waitForRefresh()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectFilter(t *testing.T) {
+	db := sales
+	db.Collectors = []string{"query_store"}
+	e, mock := newTestExporter(t, db)
+	e.filter = collectFilter{resourceStatsCollector: true}
+
+	expectResourceStats(mock, 12.5)
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_cpu_percent", salesLabels, 12.5)
+	expectValue(t, families, "azure_sql_collector_status", withLabel(withLabel(salesLabels, "collector", "query_store"), "status", collectorDisabled), 1)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSynapse(t *testing.T) {
+	db := sales
+	db.Type = config.TypeSynapse
+	e, mock := newTestExporter(t, db)
+	mock.ExpectQuery(`'ServiceObjective'`).WillReturnRows(sqlmock.NewRows([]string{"service_objective"}).AddRow("DW100c"))
+	mock.ExpectQuery(`FROM sys\.dm_pdw_exec_requests`).WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).
+		AddRow("Running", 3).
+		AddRow("Suspended", 2))
+	mock.ExpectQuery(`FROM sys\.dm_pdw_nodes_resource_governor_resource_pools`).WillReturnRows(sqlmock.NewRows([]string{"name", "cpu", "used", "max", "grants"}).
+		AddRow("SloDWPool", 1500, 1024, 2048, 4))
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_db_up", salesLabels, 1)
+	expectAbsent(t, families, "azure_sql_cpu_percent", salesLabels)
+	expectValue(t, families, "azure_sql_synapse_info", withLabel(salesLabels, "service_objective", "DW100c"), 1)
+	expectValue(t, families, "azure_sql_synapse_requests", withLabel(salesLabels, "status", "running"), 3)
+	expectValue(t, families, "azure_sql_synapse_requests", withLabel(salesLabels, "status", "queued"), 2)
+	poolLabels := withLabel(salesLabels, "pool", "SloDWPool")
+	expectValue(t, families, "azure_sql_synapse_resource_pool_cpu_seconds_total", poolLabels, 1.5)
+	expectValue(t, families, "azure_sql_synapse_resource_pool_memory_used_bytes", poolLabels, 1024*1024)
+	expectValue(t, families, "azure_sql_synapse_resource_pool_memory_grants", poolLabels, 4)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLServer(t *testing.T) {
+	db := sales
+	db.Type = config.TypeSQLServer
+	db.Collectors = []string{"wait_stats"}
+	e, mock := newTestExporter(t, db)
+	mock.ExpectQuery(`AS avg_cpu_percent,.*FROM sys\.dm_os_performance_counters`).WillReturnRows(sqlmock.NewRows([]string{"avg_cpu_percent", "avg_memory_usage_percent", "end_time"}).
+		AddRow(37.5, 92, time.Now()))
+	mock.ExpectQuery(`'Disk Read Bytes/sec'`).WillReturnRows(sqlmock.NewRows([]string{"counter_name", "cntr_value"}).
+		AddRow("Disk Read Bytes/sec", 4096).
+		AddRow("Disk Write IO/sec", 12))
+	mock.ExpectQuery(`FROM sys\.dm_os_wait_stats`).WillReturnRows(sqlmock.NewRows([]string{"wait_type", "wait_time_ms", "waiting_tasks_count"}).
+		AddRow("PAGEIOLATCH_SH", 1500, 3))
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_db_up", salesLabels, 1)
+	expectValue(t, families, "azure_sql_cpu_percent", salesLabels, 37.5)
+	expectValue(t, families, "azure_sql_memory_percent", salesLabels, 92)
+	expectAbsent(t, families, "azure_sql_data_io", salesLabels)
+	expectValue(t, families, "azure_sql_io_read_bytes_total", salesLabels, 4096)
+	expectValue(t, families, "azure_sql_io_writes_total", salesLabels, 12)
+	expectValue(t, families, "azure_sql_wait_seconds_total", withLabel(salesLabels, "wait_type", "PAGEIOLATCH_SH"), 1.5)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHyperscale(t *testing.T) {
+	db := sales
+	db.Collectors = []string{"hyperscale"}
+	e, mock := newTestExporter(t, db)
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`'RBPEX cache hit ratio base'`).WillReturnRows(sqlmock.NewRows([]string{"ratio"}).AddRow(0.75))
+	mock.ExpectQuery(`FROM sys\.dm_io_virtual_file_stats`).WillReturnRows(sqlmock.NewRows([]string{"reads", "bytes", "stall"}).AddRow(40, 327680, 2500))
+	mock.ExpectQuery(`RBIO\[_\]RG`).WillReturnRows(sqlmock.NewRows([]string{"wait_type", "wait_time_ms", "waiting_tasks_count"}).
+		AddRow("RBIO_RG_STORAGE", 1200, 6))
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_hyperscale_rbpex_hit_ratio", salesLabels, 0.75)
+	expectValue(t, families, "azure_sql_hyperscale_page_server_reads_total", salesLabels, 40)
+	expectValue(t, families, "azure_sql_hyperscale_page_server_read_bytes_total", salesLabels, 327680)
+	expectValue(t, families, "azure_sql_hyperscale_page_server_read_stall_seconds_total", salesLabels, 2.5)
+	expectValue(t, families, "azure_sql_hyperscale_log_governor_wait_seconds_total", withLabel(salesLabels, "wait_type", "RBIO_RG_STORAGE"), 1.2)
+	expectValue(t, families, "azure_sql_hyperscale_log_governor_waits_total", withLabel(salesLabels, "wait_type", "RBIO_RG_STORAGE"), 6)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	db := sales
+	db.MaintenanceWindows = []config.MaintenanceWindow{{Schedule: "* * * * *", Duration: time.Hour}}
+	e, mock := newTestExporter(t, db)
+
+	// No query runs during the window.
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_db_in_maintenance", salesLabels, 1)
+	expectAbsent(t, families, "azure_sql_cpu_percent", salesLabels)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// pingServer is a database/sql connector to a server that can drop its connections, after which their queries
+// fail like those of the mssql driver. Like the mssql driver, its connections don't implement driver.Pinger.
+type pingServer struct {
+	mutex sync.Mutex
+	// generation is incremented whenever the server drops its connections.
+	generation int
+	queries    int
+	// release, if set, blocks queries until it is closed, ignoring their context like the mssql driver does once it
+	// sent a query.
+	release chan struct{}
+}
+
+func (s *pingServer) Connect(context.Context) (driver.Conn, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return &pingConn{server: s, generation: s.generation}, nil
+}
+
+func (s *pingServer) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+// drop drops the connections of the server.
+func (s *pingServer) drop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.generation++
+}
+
+// queried returns the number of queries the server received on live connections.
+func (s *pingServer) queried() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.queries
+}
+
+type pingConn struct {
+	server     *pingServer
+	generation int
+}
+
+func (c *pingConn) Prepare(query string) (driver.Stmt, error) {
+	return pingStmt{conn: c}, nil
+}
+
+func (c *pingConn) Close() error {
+	return nil
+}
+
+func (c *pingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type pingStmt struct {
+	conn *pingConn
+}
+
+func (pingStmt) Close() error {
+	return nil
+}
+
+func (pingStmt) NumInput() int {
+	return -1
+}
+
+func (pingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("exec is not supported")
+}
+
+func (s pingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	server := s.conn.server
+	server.mutex.Lock()
+	if s.conn.generation < server.generation {
+		server.mutex.Unlock()
+		return nil, errors.New("read tcp 127.0.0.1:1433: connection reset by peer")
+	}
+	server.queries++
+	release := server.release
+	server.mutex.Unlock()
+	if release != nil {
+		<-release
+	}
+	return &fakeRows{columns: []string{""}, values: [][]driver.Value{{int64(1)}}}, nil
+}
+
+func TestHealthChecks(t *testing.T) {
+	interval := PingInterval
+	PingInterval = 10 * time.Millisecond
+	t.Cleanup(func() { PingInterval = interval })
+	server := &pingServer{}
+	e, _ := newTestExporter(t, sales)
+	e.open = func(dsn string) (querier, error) {
+		return sql.OpenDB(server), nil
+	}
+	waitFor := func(condition func(map[string]*dto.MetricFamily) bool) map[string]*dto.MetricFamily {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if families := gather(t, e.health); condition(families) {
+				return families
+			}
+			time.Sleep(PingInterval)
+		}
+		t.Fatal("condition not met in time")
+		return nil
+	}
+	reachable := func(families map[string]*dto.MetricFamily) bool {
+		v, _ := value(families, "azure_sql_db_reachable", salesLabels)
+		return v == 1
+	}
+
+	e.RunHealthChecks()
+	families := waitFor(reachable)
+	expectValue(t, families, "azure_sql_db_reconnects_total", salesLabels, 0)
+	if _, ok := value(families, "azure_sql_db_ping_duration_seconds", salesLabels); !ok {
+		t.Error("azure_sql_db_ping_duration_seconds not exported")
+	}
+
+	// The pool keeps the connection of the first ping, which the next ping must notice is gone.
+	server.drop()
+	waitFor(func(families map[string]*dto.MetricFamily) bool {
+		v, _ := value(families, "azure_sql_db_reconnects_total", salesLabels)
+		return v == 1 && reachable(families)
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	server := &pingServer{release: make(chan struct{})}
+	e, _ := newTestExporter(t, sales)
+	e.open = func(dsn string) (querier, error) {
+		return sql.OpenDB(server), nil
+	}
+	scraped := make(chan struct{})
+	go func() {
+		defer close(scraped)
+		gather(t, e)
+	}()
+	for i := 0; server.queried() == 0; i++ {
+		if i == 100 {
+			t.Fatal("scrape didn't query the database")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The query ignores the cancellation of the scrape, which is abandoned at the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	e.Shutdown(ctx)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("shutdown took %s, want it to end at the deadline", d)
+	}
+	close(server.release)
+	<-scraped
+}
+
+func TestRequests(t *testing.T) {
+	top := RequestsTopWaitTypes
+	RequestsTopWaitTypes = 2
+	t.Cleanup(func() { RequestsTopWaitTypes = top })
+	db := sales
+	db.Collectors = []string{"requests"}
+	e, mock := newTestExporter(t, db)
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`FROM sys\.dm_exec_requests`).WillReturnRows(sqlmock.NewRows([]string{"status", "wait_type", "count"}).
+		AddRow("running", "", 2).
+		AddRow("suspended", "LCK_M_X", 5).
+		AddRow("suspended", "PAGEIOLATCH_SH", 3).
+		AddRow("suspended", "WRITELOG", 1).
+		AddRow("runnable", "SOS_SCHEDULER_YIELD", 1))
+
+	families := gather(t, e)
+	requests := func(status, waitType string) map[string]string {
+		return withLabel(withLabel(salesLabels, "status", status), "wait_type", waitType)
+	}
+	expectValue(t, families, "azure_sql_requests", requests("running", waitTypeNone), 2)
+	expectValue(t, families, "azure_sql_requests", requests("suspended", "LCK_M_X"), 5)
+	expectValue(t, families, "azure_sql_requests", requests("suspended", "PAGEIOLATCH_SH"), 3)
+	expectValue(t, families, "azure_sql_requests", requests("suspended", waitTypeOther), 1)
+	expectValue(t, families, "azure_sql_requests", requests("runnable", waitTypeOther), 1)
+	expectAbsent(t, families, "azure_sql_requests", requests("suspended", "WRITELOG"))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackups(t *testing.T) {
+	db := sales
+	db.Collectors = []string{"backups"}
+	e, mock := newTestExporter(t, db)
+	full := time.Date(2016, 6, 1, 2, 0, 0, 0, time.UTC)
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`FROM sys\.dm_database_backups`).WillReturnRows(sqlmock.NewRows([]string{"backup_type", "finished"}).
+		AddRow("D", full).
+		AddRow("L", full.Add(10*time.Minute)))
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_last_backup_timestamp_seconds", withLabel(salesLabels, "type", "full"), float64(full.Unix()))
+	expectValue(t, families, "azure_sql_last_backup_timestamp_seconds", withLabel(salesLabels, "type", "log"), float64(full.Unix()+600))
+	expectAbsent(t, families, "azure_sql_last_backup_timestamp_seconds", withLabel(salesLabels, "type", "differential"))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	db := sales
+	db.Collectors = []string{"memory"}
+	e, mock := newTestExporter(t, db)
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`FROM sys\.dm_exec_query_memory_grants`).WillReturnRows(sqlmock.NewRows([]string{"granted", "pending", "granted_bytes", "pending_bytes", "max_wait", "buffer_pool", "dirty"}).
+		AddRow(3, 2, 1<<30, 1<<29, 12.5, 4<<30, 1<<20))
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_memory_grants", withLabel(salesLabels, "state", "granted"), 3)
+	expectValue(t, families, "azure_sql_memory_grants", withLabel(salesLabels, "state", "pending"), 2)
+	expectValue(t, families, "azure_sql_memory_grant_bytes", withLabel(salesLabels, "state", "pending"), 1<<29)
+	expectValue(t, families, "azure_sql_memory_grant_max_wait_seconds", salesLabels, 12.5)
+	expectValue(t, families, "azure_sql_buffer_pool_bytes", salesLabels, 4<<30)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueryOverrides(t *testing.T) {
+	db := sales
+	db.Collectors = []string{"memory"}
+	db.Queries = map[string]string{
+		"resource_stats": "SELECT TOP 1 * FROM sys.dm_db_resource_stats WHERE end_time < DATEADD(minute, -1, GETUTCDATE()) ORDER BY end_time DESC",
+		"memory":         "SELECT 1, 0, 1024, 0, 0, 0, 0 FROM custom.memory_grants",
+	}
+	if err := CheckQueries([]config.Database{db}); err != nil {
+		t.Fatal(err)
+	}
+	e, mock := newTestExporter(t, db)
+	mock.ExpectQuery(`WHERE end_time < DATEADD`).WillReturnRows(sqlmock.NewRows(resourceStatsColumns).
+		AddRow(12.5, 0, 0, 0, 0, 0, time.Now(), 0, 0, 0))
+	mock.ExpectQuery(`FROM custom\.memory_grants`).WillReturnRows(sqlmock.NewRows([]string{"granted", "pending", "granted_bytes", "pending_bytes", "max_wait", "buffer_pool", "dirty"}).
+		AddRow(1, 0, 1024, 0, 0, 0, 0))
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_cpu_percent", salesLabels, 12.5)
+	expectValue(t, families, "azure_sql_memory_grant_bytes", withLabel(salesLabels, "state", "granted"), 1024)
+	expectValue(t, families, "azure_sql_query_duration_seconds", withLabel(salesLabels, "collector", "resource_stats"), 1)
+	expectValue(t, families, "azure_sql_query_duration_seconds", withLabel(salesLabels, "collector", "memory"), 1)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	db.Queries = map[string]string{"resource_statistics": "SELECT 1"}
+	if err := CheckQueries([]config.Database{db}); err == nil || !strings.Contains(err.Error(), "unknown query") {
+		t.Errorf("got error %v for an unknown query, want unknown query", err)
+	}
+}
+
+func TestQueryDurationBuckets(t *testing.T) {
+	buckets := QueryDurationBuckets
+	t.Cleanup(func() { QueryDurationBuckets = buckets })
+	// The flag is declared like --collector.query-duration-buckets, whose defaults kingpin appends to the variable.
+	defaults := make([]string, len(prometheus.DefBuckets))
+	for i, b := range prometheus.DefBuckets {
+		defaults[i] = strconv.FormatFloat(b, 'g', -1, 64)
+	}
+	app := kingpin.New("test", "")
+	app.Flag("collector.query-duration-buckets", "").Default(defaults...).Float64ListVar(&QueryDurationBuckets)
+	if _, err := app.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(QueryDurationBuckets, prometheus.DefBuckets) {
+		t.Fatalf("got buckets %v, want %v", QueryDurationBuckets, prometheus.DefBuckets)
+	}
+
+	e, mock := newTestExporter(t, sales)
+	expectResourceStats(mock, 12.5)
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_query_duration_seconds", withLabel(salesLabels, "collector", "resource_stats"), 1)
+	for _, m := range families["azure_sql_query_duration_seconds"].GetMetric() {
+		if got := len(m.GetHistogram().GetBucket()); got != len(prometheus.DefBuckets) {
+			t.Errorf("got %d buckets, want %d", got, len(prometheus.DefBuckets))
+		}
+	}
+}
+
+func TestCardinalityLimits(t *testing.T) {
+	maxSeries, maxLength := MaxSeriesPerMetric, MaxLabelValueLength
+	MaxSeriesPerMetric, MaxLabelValueLength = 2, 8
+	t.Cleanup(func() { MaxSeriesPerMetric, MaxLabelValueLength = maxSeries, maxLength })
+	db := sales
+	db.Collectors = []string{"wait_stats"}
+	e, mock := newTestExporter(t, db)
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`FROM sys\.dm_db_wait_stats`).WillReturnRows(sqlmock.NewRows([]string{"wait_type", "wait_time_ms", "waiting_tasks_count"}).
+		AddRow("PAGEIOLATCH_SH", 3000, 30).
+		AddRow("PAGEIOLATCH_EX", 2000, 20).
+		AddRow("LCK_M\nX", 1000, 10).
+		AddRow("SOS_SCHEDULER_YIELD", 500, 5))
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_wait_seconds_total", withLabel(salesLabels, "wait_type", "PAGEIOLA"), 3)
+	expectValue(t, families, "azure_sql_wait_seconds_total", withLabel(salesLabels, "wait_type", "LCK_M X"), 1)
+	if n := len(families["azure_sql_wait_seconds_total"].Metric); n != 2 {
+		t.Errorf("got %d wait type series, want 2", n)
+	}
+	// The second PAGEIOLATCH row collides with the first after truncation, the last exceeds the limit.
+	expectValue(t, families, "azure_sql_dropped_series_total", withLabel(salesLabels, "collector", "wait_stats"), 4)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMaxSeries(t *testing.T) {
+	maxSeries := MaxSeries
+	MaxSeries = 4
+	t.Cleanup(func() { MaxSeries = maxSeries })
+	db := sales
+	db.Collectors = []string{"wait_stats"}
+	e, mock := newTestExporter(t, db)
+	waitStats := func(waitTypes ...string) {
+		rows := sqlmock.NewRows([]string{"wait_type", "wait_time_ms", "waiting_tasks_count"})
+		for _, w := range waitTypes {
+			rows.AddRow(w, 1000, 10)
+		}
+		mock.ExpectQuery(`FROM sys\.dm_db_wait_stats`).WillReturnRows(rows)
+	}
+	expectResourceStats(mock, 12.5)
+	waitStats("PAGEIOLATCH_SH", "LCK_M_X", "SOS_SCHEDULER_YIELD")
+
+	// Each wait type has two series, the last one doesn't fit.
+	families := gather(t, e)
+	if n := len(families["azure_sql_wait_seconds_total"].Metric); n != 2 {
+		t.Errorf("got %d wait type series, want 2", n)
+	}
+	expectValue(t, families, "azure_sql_dropped_series_total", withLabel(salesLabels, "collector", "wait_stats"), 2)
+	expectValue(t, families, "azure_sql_exporter_series", nil, 4)
+	expectValue(t, families, "azure_sql_exporter_series_limit", nil, 4)
+
+	// The series of the next scrape replace those of the last one.
+	expectResourceStats(mock, 12.5)
+	waitStats("PAGEIOLATCH_SH")
+	families = gather(t, e)
+	expectValue(t, families, "azure_sql_exporter_series", nil, 2)
+	expectValue(t, families, "azure_sql_dropped_series_total", withLabel(salesLabels, "collector", "wait_stats"), 2)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	db := sales
+	db.Group = "sales"
+	billing := config.Database{Name: "Billing", Server: "billing.database.windows.net", Group: "billing"}
+	e, mock := newTestExporter(t, db, billing)
+	// Only the database of the group is scraped.
+	expectResourceStats(mock, 12.5)
+
+	families := gather(t, e.Group("sales"))
+	expectValue(t, families, "azure_sql_cpu_percent", salesLabels, 12.5)
+	expectValue(t, families, "azure_sql_db_up", salesLabels, 1)
+	expectAbsent(t, families, "azure_sql_db_up", map[string]string{"database": "Billing"})
+	expectAbsent(t, families, "azure_sql_up", nil)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInfoMetrics(t *testing.T) {
+	db := sales
+	db.Password = "s3cret"
+	db.Metadata = map[string]string{"owner": "sales-team"}
+	e, mock := newTestExporter(t, db)
+	if err := e.SetInfoMetrics([]config.InfoMetric{{Name: "password_info", Labels: map[string]string{"password": "{{.Password}}"}}}); err == nil {
+		t.Error("got no error for a template exposing the password")
+	}
+	if err := e.SetInfoMetrics([]config.InfoMetric{{Name: "owner_info", Labels: map[string]string{"owner": "{{.Metadata.owner}}", "type": "{{.Type}}"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Databases added later get the info metrics, and removed ones lose them.
+	billing := config.Database{Name: "Billing", Server: "billing.database.windows.net", Metadata: map[string]string{"owner": "finance"}}
+	billingLabels := map[string]string{"server": billing.Server, "database": "Billing"}
+	if err := e.AddDatabase(billing); err != nil {
+		t.Fatal(err)
+	}
+	expectResourceStats(mock, 12.5)
+	expectResourceStats(mock, 12.5)
+	mock.MatchExpectationsInOrder(false)
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_owner_info", withLabel(withLabel(salesLabels, "owner", "sales-team"), "type", ""), 1)
+	expectValue(t, families, "azure_sql_owner_info", withLabel(withLabel(billingLabels, "owner", "finance"), "type", ""), 1)
+	if err := e.RemoveDatabase(billing); err != nil {
+		t.Fatal(err)
+	}
+	expectResourceStats(mock, 12.5)
+	families = gather(t, e)
+	expectAbsent(t, families, "azure_sql_owner_info", withLabel(withLabel(billingLabels, "owner", "finance"), "type", ""))
+}
+
+func TestAddRemoveDatabase(t *testing.T) {
+	e, mock := newTestExporter(t, sales)
+	billing := config.Database{Name: "Billing", Server: "billing.database.windows.net"}
+	billingLabels := map[string]string{"server": billing.Server, "database": "Billing"}
+	if err := e.AddDatabase(billing); err != nil {
+		t.Fatal(err)
+	}
+	e.allTargets()[1].caps.verified = true
+	if err := e.AddDatabase(billing); err == nil {
+		t.Error("added Billing twice")
+	}
+	labelled := config.Database{Name: "Orders", Server: billing.Server, Labels: map[string]string{"team": "orders"}}
+	if err := e.AddDatabase(labelled); err == nil {
+		t.Error("added a database with a label no database of the exporter sets")
+	}
+	expectResourceStats(mock, 12.5)
+	expectResourceStats(mock, 12.5)
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_db_up", billingLabels, 1)
+
+	if err := e.RemoveDatabase(billing); err != nil {
+		t.Fatal(err)
+	}
+	expectResourceStats(mock, 12.5)
+	families = gather(t, e)
+	expectValue(t, families, "azure_sql_db_up", salesLabels, 1)
+	expectAbsent(t, families, "azure_sql_db_up", billingLabels)
+	expectAbsent(t, families, "azure_sql_cpu_percent", billingLabels)
+	if err := e.RemoveDatabase(billing); err == nil {
+		t.Error("removed Billing twice")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoveDatabaseClosesTunnel(t *testing.T) {
+	bastion := &config.SSHTunnel{Host: "bastion.example.com:22", User: "exporter", KeyFile: "id_ecdsa", HostKey: "ssh-ed25519 AAAA"}
+	tunneled := sales
+	tunneled.SSHTunnel = bastion
+	billing := config.Database{Name: "Billing", Server: sales.Server, SSHTunnel: bastion}
+	e, _ := newTestExporter(t, tunneled, billing)
+	tun := e.allTargets()[0].tunnel
+	if tun == nil || e.allTargets()[1].tunnel != tun {
+		t.Fatal("databases on the same server don't share their tunnel")
+	}
+
+	if err := e.RemoveDatabase(billing); err != nil {
+		t.Fatal(err)
+	}
+	if tun.closed {
+		t.Error("closed the tunnel Sales still uses")
+	}
+	if err := e.RemoveDatabase(tunneled); err != nil {
+		t.Fatal(err)
+	}
+	if !tun.closed || len(e.tunnels) != 0 {
+		t.Error("kept the tunnel no database uses")
+	}
+}
+
+func TestTargetsAPI(t *testing.T) {
+	e, mock := newTestExporter(t, sales)
+	mock.ExpectQuery(resourceStatsPattern).WillReturnError(errors.New("mssql: Invalid object name 'sys.dm_db_resource_stats'."))
+	gather(t, e)
+
+	rec := httptest.NewRecorder()
+	e.TargetsAPIHandler(rec, httptest.NewRequest("GET", "/api/v1/targets", nil))
+	var resp struct {
+		Status string
+		Data   apiTargets
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "success" || len(resp.Data.Targets) != 1 {
+		t.Fatalf("got %+v, want one target", resp)
+	}
+	got := resp.Data.Targets[0]
+	if got.Database != "Sales" || got.Health != "down" || got.LastScrape == nil || got.LastError == "" {
+		t.Errorf("got target %+v, want Sales down with the last scrape and error", got)
+	}
+}
+
+func TestScrapesAPI(t *testing.T) {
+	e, mock := newTestExporter(t, sales)
+	BackoffInitial = time.Hour
+	mock.ExpectQuery(resourceStatsPattern).WillReturnError(errors.New("mssql: Invalid object name 'sys.dm_db_resource_stats'."))
+	gather(t, e)
+	// The failed database backs off.
+	gather(t, e)
+
+	rec := httptest.NewRecorder()
+	e.ScrapesAPIHandler(rec, httptest.NewRequest("GET", "/api/v1/scrapes?database=Sales", nil))
+	var resp struct {
+		Status string
+		Data   apiScrapes
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "success" || len(resp.Data.Scrapes) != 2 {
+		t.Fatalf("got %+v, want two scrapes", resp)
+	}
+	if got := resp.Data.Scrapes[0]; got.Outcome != scrapeFailure || got.Error == "" || got.TraceID == "" {
+		t.Errorf("got scrape %+v, want a failure with its error and trace ID", got)
+	}
+	if got := resp.Data.Scrapes[1]; got.Outcome != scrapeSkipped || got.Reason != "backoff" {
+		t.Errorf("got scrape %+v, want it skipped for backoff", got)
+	}
+
+	// The ring buffer keeps the most recent attempts.
+	h := newScrapeHistory(2)
+	for _, name := range []string{"Sales", "Billing", "Orders"} {
+		h.recordSkip(config.Database{Name: name}, "disabled")
+	}
+	all := func(scrapeAttempt) bool { return true }
+	if got := h.matching(all, 0); len(got) != 2 || got[0].Database != "Billing" || got[1].Database != "Orders" {
+		t.Errorf("got %+v, want Billing and Orders", got)
+	}
+	if got := h.matching(all, 1); len(got) != 1 || got[0].Database != "Orders" {
+		t.Errorf("got %+v, want Orders", got)
+	}
+}
+
+func TestScrapeHandler(t *testing.T) {
+	e, mock := newTestExporter(t, sales)
+	expectResourceStats(mock, 12.5)
+
+	rec := httptest.NewRecorder()
+	e.ScrapeHandler(rec, httptest.NewRequest("POST", "/scrape?database=Sales", nil))
+	var result scrapeResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Error != "" || result.TraceID == "" {
+		t.Errorf("got error %q and trace ID %q, want a successful traced scrape", result.Error, result.TraceID)
+	}
+	found := false
+	for _, m := range result.Metrics {
+		if m.Name == "azure_sql_cpu_percent" && m.Value == 12.5 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("got metrics %v, want azure_sql_cpu_percent 12.5", result.Metrics)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	threshold, cooldown := BreakerThreshold, BreakerCooldown
+	BreakerThreshold, BreakerCooldown = 2, time.Hour
+	t.Cleanup(func() { BreakerThreshold, BreakerCooldown = threshold, cooldown })
+	e, mock := newTestExporter(t, sales)
+	loginErr := mssql.Error{Number: errLoginFailed, Message: "Login failed for user 'prometheus'."}
+	mock.ExpectQuery(resourceStatsPattern).WillReturnError(loginErr)
+	mock.ExpectQuery(resourceStatsPattern).WillReturnError(loginErr)
+	serverLabels := map[string]string{"server": sales.Server}
+
+	gather(t, e)
+	expectValue(t, gather(t, e), "azure_sql_server_circuit_state", withLabel(serverLabels, "state", circuitOpen), 1)
+	// The open circuit fails the scrape without connecting.
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_scrape_error", withLabel(salesLabels, "error_type", "connection"), 1)
+	expectValue(t, families, "azure_sql_server_circuit_state", withLabel(serverLabels, "state", circuitOpen), 1)
+
+	// After the cooldown, a successful probe closes the circuit.
+	e.breakers[sales.Server].until = time.Now()
+	expectResourceStats(mock, 12.5)
+	families = gather(t, e)
+	expectValue(t, families, "azure_sql_db_up", salesLabels, 1)
+	expectValue(t, families, "azure_sql_server_circuit_state", withLabel(serverLabels, "state", circuitClosed), 1)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScrapeStagger(t *testing.T) {
+	stagger := ScrapeStagger
+	ScrapeStagger = 200 * time.Millisecond
+	t.Cleanup(func() { ScrapeStagger = stagger })
+	billing := config.Database{Name: "Billing", Server: "billing.database.windows.net"}
+	e, mock := newTestExporter(t, sales, billing)
+	expectResourceStats(mock, 12.5)
+	expectResourceStats(mock, 12.5)
+
+	start := time.Now()
+	families := gather(t, e)
+	// The second of the two databases starts halfway through the window.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("scrape took %s, want the second database to start after 100ms", elapsed)
+	}
+	expectValue(t, families, "azure_sql_db_up", map[string]string{"database": "Billing"}, 1)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	db := sales
+	db.Collectors = []string{"credential_expiry"}
+	e, mock := newTestExporter(t, db)
+	lockedOut := errors.New("Login error: mssql: Login failed for user 'prometheus' because the account is currently locked out. The system administrator can unlock it.")
+	mock.ExpectQuery(resourceStatsPattern).WillReturnError(lockedOut)
+	mock.ExpectQuery(resourceStatsPattern).WillReturnError(lockedOut)
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(`LOGINPROPERTY\(SUSER_SNAME\(\), 'DaysUntilExpiration'\)`).WillReturnRows(sqlmock.NewRows([]string{"days"}).AddRow(10))
+
+	gather(t, e)
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_auth_failures_total", withLabel(salesLabels, "reason", authLockedOut), 2)
+	expectValue(t, families, "azure_sql_scrape_error", withLabel(salesLabels, "error_type", "login"), 1)
+	families = gather(t, e)
+	expiry := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 10)
+	expectValue(t, families, "azure_sql_credential_expiry_timestamp_seconds", salesLabels, float64(expiry.Unix()))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRollups(t *testing.T) {
+	enabled := RollupsEnabled
+	RollupsEnabled = true
+	t.Cleanup(func() { RollupsEnabled = enabled })
+	orders := config.Database{Name: "Orders", Server: sales.Server}
+	e, mock := newTestExporter(t, sales, orders)
+	mock.MatchExpectationsInOrder(false)
+	expectResourceStats(mock, 12.5)
+	mock.ExpectQuery(regexp.QuoteMeta(elasticPoolQuery)).WillReturnRows(sqlmock.NewRows([]string{"elastic_pool_name"}).AddRow("pool1"))
+	mock.ExpectQuery(resourceStatsPattern).WillReturnError(errors.New("mssql: Invalid object name 'sys.dm_db_resource_stats'."))
+	serverLabels := map[string]string{"server": sales.Server}
+
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_rollup_server_cpu_percent_max", serverLabels, 12.5)
+	expectValue(t, families, "azure_sql_rollup_server_data_io_percent_avg", serverLabels, 13.5)
+	expectValue(t, families, "azure_sql_rollup_elastic_pool_cpu_percent_avg", withLabel(serverLabels, "elastic_pool", "pool1"), 12.5)
+	expectValue(t, families, "azure_sql_rollup_server_databases", withLabel(serverLabels, "health", "up"), 1)
+	expectValue(t, families, "azure_sql_rollup_server_databases", withLabel(serverLabels, "health", "down"), 1)
+	expectValue(t, families, "azure_sql_rollup_server_databases", withLabel(serverLabels, "health", "unknown"), 0)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadOnlyQueries(t *testing.T) {
+	for name, query := range queries {
+		if err := checkReadOnly(query); err != nil {
+			t.Errorf("built-in query %s: %s", name, err)
+		}
+	}
+	for query, want := range map[string]string{
+		"SELECT [update], 'DELETE' /* DROP */ FROM custom.memory -- EXEC\n": "",
+		"WITH w AS (SELECT 1 AS x) SELECT x FROM w;":                        "",
+		"SELECT * INTO #copy FROM sys.tables":                               "contains INTO",
+		"SELECT 1; DELETE FROM sales.orders":                                "more than one statement",
+		"SELECT 1 UPDATE sales.orders SET total = 0":                        "contains UPDATE",
+		"EXEC sp_who": "starts with EXEC",
+		"SELECT * FROM sales.orders WITH (UPDLOCK)": "contains UPDLOCK",
+		"/* SELECT */ TRUNCATE TABLE sales.orders":  "starts with TRUNCATE",
+	} {
+		err := checkReadOnly(query)
+		if want == "" && err != nil || want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+			t.Errorf("%q: got error %v, want %q", query, err, want)
+		}
+	}
+
+	db := sales
+	db.Queries = map[string]string{"memory": "DELETE FROM sales.orders"}
+	if err := CheckQueries([]config.Database{db}); err == nil || !strings.Contains(err.Error(), "not a read-only SELECT") {
+		t.Errorf("got error %v for a DELETE, want not read-only", err)
+	}
+
+	db.Queries = nil
+	e, mock := newTestExporter(t, db)
+	mock.ExpectQuery(`^SET LOCK_TIMEOUT 5000;\s+SELECT TOP 1 \* ` + resourceStatsPattern).WillReturnRows(sqlmock.NewRows(resourceStatsColumns).
+		AddRow(12.5, 0, 0, 0, 0, 0, time.Now(), 0, 0, 0))
+	families := gather(t, e)
+	expectValue(t, families, "azure_sql_cpu_percent", salesLabels, 12.5)
+}
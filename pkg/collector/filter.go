@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// resourceStatsCollector is the name of the always enabled collector of the resource stats of a database, or the
+// instance or server level metrics of Managed Instances and logical servers, in collect[] filters.
+const resourceStatsCollector = "resource_stats"
+
+// collectFilter holds the names of the collectors a scrape asked for. A nil filter enables all collectors.
+type collectFilter map[string]bool
+
+func (f collectFilter) enabled(name string) bool {
+	return f == nil || f[name]
+}
+
+// filterGate lets scrapes with the same collect[] filter run concurrently while scrapes with a different filter
+// wait, as the client library collects all scrapes through the same registry.
+type filterGate struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	key    string
+	active int
+}
+
+// parseCollectFilter returns the filter given by the collect[] query parameters of r.
+func parseCollectFilter(r *http.Request) (collectFilter, string, error) {
+	names := r.URL.Query()["collect[]"]
+	if len(names) == 0 {
+		return nil, "", nil
+	}
+	known := map[string]bool{resourceStatsCollector: true}
+	for _, name := range Names() {
+		known[name] = true
+	}
+	f := collectFilter{}
+	for _, name := range names {
+		if !known[name] {
+			return nil, "", fmt.Errorf("unknown collector %q, available collectors: %s, %s", name, resourceStatsCollector, strings.Join(Names(), ", "))
+		}
+		f[name] = true
+	}
+	keys := make([]string, 0, len(f))
+	for name := range f {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	return f, strings.Join(keys, ","), nil
+}
+
+// FilterHandler returns a handler applying the collect[] filter of the request to the exporter's collection
+// before calling handler, which renders the registry.
+func (e *Exporter) FilterHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, key, err := parseCollectFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		g := &e.gate
+		g.mutex.Lock()
+		if g.cond == nil {
+			g.cond = sync.NewCond(&g.mutex)
+		}
+		for g.active > 0 && g.key != key {
+			g.cond.Wait()
+		}
+		if g.active == 0 {
+			g.key = key
+			e.filter = f
+		}
+		g.active++
+		g.mutex.Unlock()
+
+		handler.ServeHTTP(w, r)
+
+		g.mutex.Lock()
+		g.active--
+		if g.active == 0 {
+			g.cond.Broadcast()
+		}
+		g.mutex.Unlock()
+	})
+}
@@ -0,0 +1,135 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serverFirewall is the firewall of a logical server.
+type serverFirewall struct {
+	// PublicAccess is false if the server only accepts connections through private endpoints, to which the
+	// firewall rules don't apply.
+	PublicAccess bool
+	Rules        []firewallRule
+}
+
+// firewallRule allows the IPv4 addresses from Start to End.
+type firewallRule struct {
+	Name       string
+	Start, End net.IP
+}
+
+// allows reports whether a firewall rule allows ip. The rule 0.0.0.0 allows all Azure services, which can't be
+// told from the address, so it isn't counted.
+func (f *serverFirewall) allows(ip net.IP) bool {
+	ip = ip.To4()
+	if ip == nil {
+		return false
+	}
+	for _, r := range f.Rules {
+		if r.End.Equal(net.IPv4zero) {
+			continue
+		}
+		if bytes.Compare(ip, r.Start) >= 0 && bytes.Compare(ip, r.End) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// serverFirewall returns the firewall rules of server.
+func (c *armClient) serverFirewall(server armResource) (*serverFirewall, error) {
+	var props struct {
+		PublicNetworkAccess string `json:"publicNetworkAccess"`
+	}
+	json.Unmarshal(server.Properties, &props)
+	rules, err := c.list(server.ID + "/firewallRules")
+	if err != nil {
+		return nil, err
+	}
+	f := &serverFirewall{PublicAccess: !strings.EqualFold(props.PublicNetworkAccess, "Disabled")}
+	for _, rule := range rules {
+		var props struct {
+			Start string `json:"startIpAddress"`
+			End   string `json:"endIpAddress"`
+		}
+		json.Unmarshal(rule.Properties, &props)
+		start, end := net.ParseIP(props.Start).To4(), net.ParseIP(props.End).To4()
+		if start == nil || end == nil {
+			continue
+		}
+		f.Rules = append(f.Rules, firewallRule{Name: rule.Name, Start: start, End: end})
+	}
+	return f, nil
+}
+
+// egressIP returns the public IP address of the exporter as returned by the configured echo service.
+func (c *armClient) egressIP() (net.IP, error) {
+	resp, err := c.client.Get(c.config.EgressIPURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up egress IP address: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up egress IP address: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to look up egress IP address: %s", resp.Status)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("unable to look up egress IP address: invalid address %q", strings.TrimSpace(string(body)))
+	}
+	return ip, nil
+}
+
+// firewallCollector exports whether the firewall rules of the servers of the configured databases allow the egress
+// IP address of the exporter as azure_sql_database_firewall_allowed.
+type firewallCollector struct {
+	resources   *AzureResources
+	dbs         []config.Database
+	extraLabels []string
+	desc        *prometheus.Desc
+}
+
+// NewFirewallCollector returns a collector exporting whether the firewall of the servers of the databases found in
+// resources allows the exporter's IP address.
+func NewFirewallCollector(resources *AzureResources, dbs []config.Database, extraLabels []string) prometheus.Collector {
+	labels := append([]string{"server", "database"}, extraLabels...)
+	return &firewallCollector{
+		resources:   resources,
+		dbs:         dbs,
+		extraLabels: extraLabels,
+		desc:        prometheus.NewDesc(namespace+"_database_firewall_allowed", "Does a firewall rule of the server allow the exporter's egress IP address.", append(labels, "ip"), nil),
+	}
+}
+
+func (c *firewallCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect exports the firewall check of the configured databases found in Azure. Databases on servers that only
+// accept private endpoints are omitted, as is everything while the egress IP address is unknown.
+func (c *firewallCollector) Collect(ch chan<- prometheus.Metric) {
+	ip := c.resources.egress()
+	if ip == nil {
+		return
+	}
+	for _, db := range c.dbs {
+		r, ok := c.resources.lookup(db)
+		if !ok || r.Firewall == nil || !r.Firewall.PublicAccess {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, boolToFloat(r.Firewall.allows(ip)), append(db.LabelValues(c.extraLabels), ip.String())...)
+	}
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// geoReplicationQuery returns the geo replication links of the database, which back failover groups and active
+// geo-replication.
+const geoReplicationQuery = `SELECT partner_server, partner_database, role_desc, replication_state_desc, ISNULL(replication_lag_sec, 0), last_replication
+FROM sys.dm_geo_replication_link_status`
+
+// Roles of a database in a geo replication link, as reported by role_desc.
+var geoReplicationRoles = []string{"primary", "secondary"}
+
+// geoReplicationScraper exports the role and replication state of every geo replication link of the database. The
+// DMV doesn't report failovers, so the time of the last role change is the time the exporter observed it.
+type geoReplicationScraper struct {
+	role            *prometheus.Desc
+	state           *prometheus.Desc
+	lag             *prometheus.Desc
+	lastReplication *prometheus.Desc
+	lastRoleChange  *prometheus.Desc
+
+	// Scrapers are created per database and only a single scrape of a database runs at a time, so the roles
+	// don't need locking.
+	roles       map[string]string
+	roleChanges map[string]time.Time
+}
+
+func init() {
+	registerScraper("geo_replication", newGeoReplicationScraper)
+}
+
+func newGeoReplicationScraper(labels prometheus.Labels) scraper {
+	return &geoReplicationScraper{
+		role:            newDesc("failover_group_role", "Is the database in the role in its geo replication link with the partner database.", labels, "partner_server", "partner_database", "role"),
+		state:           newDesc("geo_replication_state", "State of the geo replication link from replication_state_desc, e.g. CATCH_UP. Always 1.", labels, "partner_server", "partner_database", "state"),
+		lag:             newDesc("geo_replication_lag_seconds", "Time the secondary lags behind the primary.", labels, "partner_server", "partner_database"),
+		lastReplication: newDesc("geo_replication_last_replication_timestamp_seconds", "Time the last transaction was hardened on the secondary.", labels, "partner_server", "partner_database"),
+		lastRoleChange:  newDesc("failover_group_role_changed_timestamp_seconds", "Time the exporter observed the role of the database change, e.g. after a failover. Not exported before the first change.", labels, "partner_server", "partner_database"),
+		roles:           map[string]string{},
+		roleChanges:     map[string]time.Time{},
+	}
+}
+
+func (g *geoReplicationScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.role
+	ch <- g.state
+	ch <- g.lag
+	ch <- g.lastReplication
+	ch <- g.lastRoleChange
+}
+
+func (g *geoReplicationScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	server, name := c.database.Server, c.database.Name
+	return c.query(func(rows resultRow) error {
+		var partnerServer, partnerDatabase, role, state string
+		var lag float64
+		var lastReplication sql.NullString
+		if err := rows.Scan(&partnerServer, &partnerDatabase, &role, &state, &lag, &lastReplication); err != nil {
+			return err
+		}
+		role = strings.ToLower(role)
+		link := partnerServer + "\x00" + partnerDatabase
+		if previous, ok := g.roles[link]; ok && previous != role {
+			g.roleChanges[link] = time.Now()
+		}
+		g.roles[link] = role
+		for _, r := range geoReplicationRoles {
+			ch <- prometheus.MustNewConstMetric(g.role, prometheus.GaugeValue, boolToFloat(r == role), server, name, partnerServer, partnerDatabase, r)
+		}
+		ch <- prometheus.MustNewConstMetric(g.state, prometheus.GaugeValue, 1, server, name, partnerServer, partnerDatabase, state)
+		ch <- prometheus.MustNewConstMetric(g.lag, prometheus.GaugeValue, lag, server, name, partnerServer, partnerDatabase)
+		if t, err := time.Parse(time.RFC3339Nano, lastReplication.String); err == nil {
+			ch <- prometheus.MustNewConstMetric(g.lastReplication, prometheus.GaugeValue, float64(t.UnixNano())/1e9, server, name, partnerServer, partnerDatabase)
+		}
+		if changed, ok := g.roleChanges[link]; ok {
+			ch <- prometheus.MustNewConstMetric(g.lastRoleChange, prometheus.GaugeValue, float64(changed.UnixNano())/1e9, server, name, partnerServer, partnerDatabase)
+		}
+		return nil
+	}, geoReplicationQuery)
+}
@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// groupCollector collects the databases of a group of the config. Scraping it only queries the group's databases,
+// so it can be registered with a registry of its own and exposed to the group's Prometheus.
+type groupCollector struct {
+	e    *Exporter
+	name string
+}
+
+// Group returns a collector of the databases of the named group, including databases added to it at runtime. The
+// metrics of the exporter itself, such as up and the rollups, are only collected by the exporter.
+func (e *Exporter) Group(name string) prometheus.Collector {
+	return &groupCollector{e: e, name: name}
+}
+
+// Describe sends the descriptors of all metrics of the exporter, of which the group collects a subset.
+func (g *groupCollector) Describe(ch chan<- *prometheus.Desc) {
+	g.e.Describe(ch)
+}
+
+func (g *groupCollector) Collect(ch chan<- prometheus.Metric) {
+	e := g.e
+	var targets []*target
+	// databases holds the server and database of the group's databases, separated by a zero byte.
+	databases := map[string]bool{}
+	for _, t := range e.allTargets() {
+		if t.Group == g.name {
+			targets = append(targets, t)
+			databases[t.Server+"\x00"+t.Name] = true
+		}
+	}
+	e.scrapeTargets(targets)
+	times := e.sourceTimes()
+	gauges := make(chan prometheus.Metric)
+	go func() {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		e.collectDatabaseGauges(times, gauges)
+		close(gauges)
+	}()
+	for m := range gauges {
+		if owns(databases, m) {
+			ch <- m
+		}
+	}
+	e.collectTargets(targets, ch)
+}
+
+// owns reports whether a gauge of the exporter is one of the databases, given by server and database separated by a
+// zero byte.
+func owns(databases map[string]bool, m prometheus.Metric) bool {
+	var pb dto.Metric
+	if m.Write(&pb) != nil {
+		return false
+	}
+	var server, database string
+	for _, l := range pb.Label {
+		switch l.GetName() {
+		case "server":
+			server = l.GetValue()
+		case "database":
+			database = l.GetValue()
+		}
+	}
+	return databases[server+"\x00"+database]
+}
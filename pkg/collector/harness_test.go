@@ -0,0 +1,131 @@
+package collector
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// resourceStatsPattern matches the queries of the resource stats of a database.
+const resourceStatsPattern = `FROM sys\.dm_db_resource_stats`
+
+// resourceStatsColumns are the columns of the resource stats query.
+var resourceStatsColumns = []string{"avg_cpu_percent", "avg_data_io_percent", "avg_log_write_percent", "avg_memory_usage_percent", "max_session_percent", "max_worker_percent", "end_time", "avg_instance_cpu_percent", "avg_instance_memory_percent", "avg_login_rate_percent"}
+
+// fakeDB is a connection pool backed by sqlmock. Closing it is a no-op, so the same mock serves every scrape
+// without expecting the pool to be closed after each of them.
+type fakeDB struct {
+	*sql.DB
+}
+
+func (fakeDB) Close() error {
+	return nil
+}
+
+// newTestExporter returns an exporter for dbs whose scrapes query the returned mock instead of connecting to the
+// databases. Backoff is disabled so consecutive scrapes of a failing database query the mock again.
+func newTestExporter(t *testing.T, dbs ...config.Database) (*Exporter, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to create mock: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	backoff := BackoffInitial
+	BackoffInitial = 0
+	t.Cleanup(func() { BackoffInitial = backoff })
+	e := NewExporter(dbs, nil)
+	e.open = func(dsn string) (querier, error) {
+		return fakeDB{db}, nil
+	}
+	// The permission check on the first scrape has a test of its own.
+	for _, t := range e.targets {
+		t.caps.verified = true
+	}
+	t.Cleanup(e.Close)
+	return e, mock
+}
+
+// expectResourceStats expects the resource stats query and returns a row with cpu as the CPU utilization and
+// the other percentages derived from it.
+func expectResourceStats(mock sqlmock.Sqlmock, cpu float64) {
+	mock.ExpectQuery(resourceStatsPattern).WillReturnRows(sqlmock.NewRows(resourceStatsColumns).
+		AddRow(cpu, cpu+1, cpu+2, cpu+3, cpu+4, cpu+5, time.Now(), cpu+6, cpu+7, cpu+8))
+}
+
+// gather scrapes the exporter, or a collector of it, through a pedantic registry, which also checks that the
+// collected metrics match their descriptions, and returns the metric families by name.
+func gather(t *testing.T, e prometheus.Collector) map[string]*dto.MetricFamily {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(e); err != nil {
+		t.Fatalf("unable to register exporter: %s", err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unable to gather metrics: %s", err)
+	}
+	byName := map[string]*dto.MetricFamily{}
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+	return byName
+}
+
+// value returns the value of the series of the metric family name with the given labels, which may be a subset
+// of the series' labels. It returns false if there is no such series.
+func value(families map[string]*dto.MetricFamily, name string, labels map[string]string) (float64, bool) {
+	mf, ok := families[name]
+	if !ok {
+		return 0, false
+	}
+	for _, m := range mf.Metric {
+		found := 0
+		for _, l := range m.Label {
+			if v, ok := labels[l.GetName()]; ok && v == l.GetValue() {
+				found++
+			}
+		}
+		if found != len(labels) {
+			continue
+		}
+		switch {
+		case m.Gauge != nil:
+			return m.Gauge.GetValue(), true
+		case m.Counter != nil:
+			return m.Counter.GetValue(), true
+		case m.Untyped != nil:
+			return m.Untyped.GetValue(), true
+		case m.Histogram != nil:
+			// Histograms are compared by their number of observations.
+			return float64(m.Histogram.GetSampleCount()), true
+		}
+	}
+	return 0, false
+}
+
+// expectValue fails the test unless the series of name with labels has the value want.
+func expectValue(t *testing.T, families map[string]*dto.MetricFamily, name string, labels map[string]string, want float64) {
+	t.Helper()
+	got, ok := value(families, name, labels)
+	if !ok {
+		t.Errorf("%s%v not found", name, labels)
+		return
+	}
+	if got != want {
+		t.Errorf("%s%v = %v, want %v", name, labels, got, want)
+	}
+}
+
+// expectAbsent fails the test if the series of name with labels exists.
+func expectAbsent(t *testing.T, families map[string]*dto.MetricFamily, name string, labels map[string]string) {
+	t.Helper()
+	if got, ok := value(families, name, labels); ok {
+		t.Errorf("%s%v = %v, want no series", name, labels, got)
+	}
+}
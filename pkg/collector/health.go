@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+var (
+	// PingInterval is how often every database is pinged in the background, independent of scrapes, to detect
+	// lost connectivity within seconds. 0 disables the pings.
+	PingInterval time.Duration
+)
+
+// healthChecker pings the databases in the background over a connection pool of its own, so the pings neither
+// wait for nor delay scrapes.
+type healthChecker struct {
+	reachable    *prometheus.GaugeVec
+	pingDuration *prometheus.GaugeVec
+	reconnects   *prometheus.CounterVec
+	// done tracks the goroutines pinging the databases.
+	done sync.WaitGroup
+	// started is true once the pings started, after which databases added at runtime are pinged right away. It is
+	// guarded by the targetsMutex of the exporter.
+	started bool
+}
+
+func newHealthChecker(extraLabels []string) *healthChecker {
+	return &healthChecker{
+		reachable:    newGuageVec("db_reachable", "Did the last background ping of the database succeed.", extraLabels...),
+		pingDuration: newGuageVec("db_ping_duration_seconds", "Duration of the last successful background ping of the database.", extraLabels...),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_reconnects_total",
+			Help:      "Number of times the background pings reconnected to the database after a failed ping.",
+		}, append([]string{"server", "database"}, extraLabels...)),
+	}
+}
+
+// delete removes the series of the database with the labels.
+func (h *healthChecker) delete(labels prometheus.Labels) {
+	h.reachable.DeletePartialMatch(labels)
+	h.pingDuration.DeletePartialMatch(labels)
+	h.reconnects.DeletePartialMatch(labels)
+}
+
+func (h *healthChecker) Describe(ch chan<- *prometheus.Desc) {
+	h.reachable.Describe(ch)
+	h.pingDuration.Describe(ch)
+	h.reconnects.Describe(ch)
+}
+
+func (h *healthChecker) Collect(ch chan<- prometheus.Metric) {
+	h.reachable.Collect(ch)
+	h.pingDuration.Collect(ch)
+	h.reconnects.Collect(ch)
+}
+
+// RunHealthChecks pings every database every --health.ping-interval until the exporter is closed. The first pings
+// are spread over the interval. It returns immediately; the pings are disabled if the interval is 0.
+func (e *Exporter) RunHealthChecks() {
+	if e.health == nil {
+		return
+	}
+	e.targetsMutex.Lock()
+	defer e.targetsMutex.Unlock()
+	e.health.started = true
+	for _, t := range e.targets {
+		e.startPings(t)
+	}
+}
+
+// startPings starts pinging the target's database after a random delay within the ping interval.
+func (e *Exporter) startPings(t *target) {
+	if !t.pingable() {
+		return
+	}
+	e.health.done.Add(1)
+	go func(t *target, delay time.Duration) {
+		defer e.health.done.Done()
+		e.pingTarget(t, delay)
+	}(t, time.Duration(rand.Int63n(int64(PingInterval))))
+}
+
+// pingable reports whether the target's database is pinged in the background. Synthetic databases have nothing to
+// ping.
+func (t *target) pingable() bool {
+	return t.Type != config.TypeSynthetic
+}
+
+// pingTarget pings the target's database every PingInterval, starting after delay. A failed ping closes the
+// connection pool, so the next ping reconnects. The driver doesn't implement driver.Pinger, so PingContext of a
+// pool with an idle connection returns without talking to the server: the ping queries SELECT 1 instead, with the
+// timeouts of the driver limited to PingInterval.
+func (e *Exporter) pingTarget(t *target, delay time.Duration) {
+	labels := t.LabelValues(e.extraLabels)
+	// Export the counter from the start, so the first reconnect shows up as an increase.
+	e.health.reconnects.WithLabelValues(labels...)
+	var db querier
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+	connected := false
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-e.ctx.Done():
+			return
+		case <-t.removed:
+			// A ping may have been in progress when the database was removed.
+			e.health.delete(e.databaseLabels(t.Database))
+			return
+		}
+		timer.Reset(PingInterval)
+		// Connecting resumes paused serverless databases and would keep them from pausing again.
+		if status := t.status(); status.Disabled() || status.Maintenance || status.Paused || status.Missing {
+			continue
+		}
+		if db == nil {
+			if connected {
+				e.health.reconnects.WithLabelValues(labels...).Inc()
+			}
+			dsn, err := t.dsn()
+			if err == nil {
+				db, err = e.open(withTimeouts(dsn, PingInterval))
+			}
+			if err == nil {
+				limitLifetime(db, t.Database)
+			}
+			if err != nil {
+				log.Debugf("Background ping of %s failed: %s", t.Database, err)
+				e.health.reachable.WithLabelValues(labels...).Set(0)
+				continue
+			}
+		}
+		ctx, cancel := context.WithTimeout(e.ctx, PingInterval)
+		start := time.Now()
+		var one int
+		err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+		cancel()
+		connected = true
+		if err != nil {
+			if e.ctx.Err() != nil {
+				return
+			}
+			log.Debugf("Background ping of %s failed: %s", t.Database, err)
+			e.health.reachable.WithLabelValues(labels...).Set(0)
+			db.Close()
+			db = nil
+			continue
+		}
+		e.health.reachable.WithLabelValues(labels...).Set(1)
+		e.health.pingDuration.WithLabelValues(labels...).Set(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hyperscaleRBPEXQuery returns the ratio of the page reads of a Hyperscale compute replica served by its local SSD
+// cache, the Resilient Buffer Pool Extension (RBPEX), rather than by the page servers. It is NULL on other tiers.
+const hyperscaleRBPEXQuery = `SELECT CAST(MAX(CASE WHEN counter_name = 'RBPEX cache hit ratio' THEN cntr_value END) AS float)
+	/ NULLIF(MAX(CASE WHEN counter_name = 'RBPEX cache hit ratio base' THEN cntr_value END), 0)
+FROM sys.dm_os_performance_counters
+WHERE counter_name IN ('RBPEX cache hit ratio', 'RBPEX cache hit ratio base')`
+
+// hyperscalePageServerReadsQuery returns the cumulative reads of the data files of the database. On Hyperscale the
+// data files live on the page servers, so these are the reads that missed the buffer pool and the RBPEX.
+const hyperscalePageServerReadsQuery = `SELECT ISNULL(SUM(s.num_of_reads), 0), ISNULL(SUM(s.num_of_bytes_read), 0), ISNULL(SUM(s.io_stall_read_ms), 0)
+FROM sys.dm_io_virtual_file_stats(DB_ID(), NULL) s
+JOIN sys.database_files f ON f.file_id = s.file_id
+WHERE f.type = 0`
+
+// hyperscaleLogGovernorQuery returns the cumulative waits of the database on the log rate governor of Hyperscale,
+// which throttles log generation while the page servers, the log service or the replicas fall behind.
+const hyperscaleLogGovernorQuery = `SELECT wait_type, wait_time_ms, waiting_tasks_count
+FROM sys.dm_db_wait_stats
+WHERE wait_type LIKE 'RBIO[_]RG[_]%'`
+
+// hyperscaleScraper exports the performance of the storage of Hyperscale databases, where the IO percentages of the
+// resource stats only cover the local replica and hide the reads of the page servers and log throttling.
+type hyperscaleScraper struct {
+	rbpexHitRatio     *prometheus.Desc
+	pageServerReads   *prometheus.Desc
+	pageServerBytes   *prometheus.Desc
+	pageServerStall   *prometheus.Desc
+	logGovernorWaits  *prometheus.Desc
+	logGovernorWaited *prometheus.Desc
+	counters          *counterResets
+}
+
+func init() {
+	registerScraper("hyperscale", newHyperscaleScraper)
+}
+
+func newHyperscaleScraper(labels prometheus.Labels) scraper {
+	return hyperscaleScraper{
+		rbpexHitRatio:     newDesc("hyperscale_rbpex_hit_ratio", "Ratio of the page reads served by the local SSD cache (RBPEX) of the compute replica.", labels),
+		pageServerReads:   newDesc("hyperscale_page_server_reads_total", "Number of reads of the data files from the page servers.", labels),
+		pageServerBytes:   newDesc("hyperscale_page_server_read_bytes_total", "Bytes read from the data files on the page servers.", labels),
+		pageServerStall:   newDesc("hyperscale_page_server_read_stall_seconds_total", "Time spent waiting for reads of the data files from the page servers.", labels),
+		logGovernorWaits:  newDesc("hyperscale_log_governor_waits_total", "Number of waits on the log rate governor by wait type.", labels, "wait_type"),
+		logGovernorWaited: newDesc("hyperscale_log_governor_wait_seconds_total", "Time spent waiting on the log rate governor by wait type.", labels, "wait_type"),
+		counters:          newCounterResets(),
+	}
+}
+
+func (h hyperscaleScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.rbpexHitRatio
+	ch <- h.pageServerReads
+	ch <- h.pageServerBytes
+	ch <- h.pageServerStall
+	ch <- h.logGovernorWaits
+	ch <- h.logGovernorWaited
+}
+
+func (h hyperscaleScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	err := c.query(func(rows resultRow) error {
+		var ratio sql.NullFloat64
+		if err := rows.Scan(&ratio); err != nil {
+			return err
+		}
+		if ratio.Valid {
+			ch <- prometheus.MustNewConstMetric(h.rbpexHitRatio, prometheus.GaugeValue, ratio.Float64, c.database.Server, c.database.Name)
+		}
+		return nil
+	}, hyperscaleRBPEXQuery)
+	if err != nil {
+		return err
+	}
+
+	err = c.query(func(rows resultRow) error {
+		var reads, bytes, stallMs float64
+		if err := rows.Scan(&reads, &bytes, &stallMs); err != nil {
+			return err
+		}
+		ch <- h.counters.metric(h.pageServerReads, reads, c.database.Server, c.database.Name)
+		ch <- h.counters.metric(h.pageServerBytes, bytes, c.database.Server, c.database.Name)
+		ch <- h.counters.metric(h.pageServerStall, stallMs/1000, c.database.Server, c.database.Name)
+		return nil
+	}, hyperscalePageServerReadsQuery)
+	if err != nil {
+		return err
+	}
+
+	return c.query(func(rows resultRow) error {
+		var waitType string
+		var waitMs, tasks float64
+		if err := rows.Scan(&waitType, &waitMs, &tasks); err != nil {
+			return err
+		}
+		ch <- h.counters.metric(h.logGovernorWaited, waitMs/1000, c.database.Server, c.database.Name, waitType)
+		ch <- h.counters.metric(h.logGovernorWaits, tasks, c.database.Server, c.database.Name, waitType)
+		return nil
+	}, hyperscaleLogGovernorQuery)
+}
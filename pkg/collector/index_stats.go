@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// IndexStatsInterval is how often the index_stats collector queries a database. Results are reused in between.
+	IndexStatsInterval = time.Hour
+
+	// IndexStatsTableFilter is the LIKE pattern of the schema.table names the index_stats collector inspects.
+	IndexStatsTableFilter = "%"
+)
+
+const indexFragmentationQuery = `SELECT s.name, t.name, i.name, MAX(ps.avg_fragmentation_in_percent), SUM(ps.page_count)
+FROM sys.indexes i
+JOIN sys.tables t ON t.object_id = i.object_id
+JOIN sys.schemas s ON s.schema_id = t.schema_id
+CROSS APPLY sys.dm_db_index_physical_stats(DB_ID(), i.object_id, i.index_id, NULL, 'LIMITED') ps
+WHERE i.index_id > 0 AND ps.alloc_unit_type_desc = 'IN_ROW_DATA' AND s.name + '.' + t.name LIKE ?
+GROUP BY s.name, t.name, i.name`
+
+const statisticsAgeQuery = `SELECT s.name, t.name, st.name, DATEDIFF(second, sp.last_updated, GETUTCDATE()), sp.modification_counter
+FROM sys.stats st
+JOIN sys.tables t ON t.object_id = st.object_id
+JOIN sys.schemas s ON s.schema_id = t.schema_id
+CROSS APPLY sys.dm_db_stats_properties(st.object_id, st.stats_id) sp
+WHERE sp.last_updated IS NOT NULL AND s.name + '.' + t.name LIKE ?`
+
+// indexStatsScraper exports index fragmentation and the age of statistics. The queries are expensive, so the
+// scraper only runs every IndexStatsInterval.
+type indexStatsScraper struct {
+	fragmentation *prometheus.Desc
+	pages         *prometheus.Desc
+	statsAge      *prometheus.Desc
+	modifications *prometheus.Desc
+}
+
+func init() {
+	registerScraper("index_stats", newIndexStatsScraper)
+}
+
+func newIndexStatsScraper(labels prometheus.Labels) scraper {
+	return indexStatsScraper{
+		fragmentation: newDesc("index_fragmentation_percent", "Logical fragmentation of the leaf level of the index.", labels, "schema", "table", "index"),
+		pages:         newDesc("index_pages", "Number of leaf level pages of the index.", labels, "schema", "table", "index"),
+		statsAge:      newDesc("statistics_age_seconds", "Time since the statistics were last updated.", labels, "schema", "table", "statistics"),
+		modifications: newDesc("statistics_modifications", "Number of modifications of the leading column since the statistics were last updated.", labels, "schema", "table", "statistics"),
+	}
+}
+
+func (indexStatsScraper) Interval() time.Duration {
+	return IndexStatsInterval
+}
+
+func (i indexStatsScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- i.fragmentation
+	ch <- i.pages
+	ch <- i.statsAge
+	ch <- i.modifications
+}
+
+func (i indexStatsScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	err := c.query(func(rows resultRow) error {
+		var schema, table, index string
+		var fragmentation, pages float64
+		if err := rows.Scan(&schema, &table, &index, &fragmentation, &pages); err != nil {
+			return err
+		}
+		labels := []string{c.database.Server, c.database.Name, schema, table, index}
+		ch <- prometheus.MustNewConstMetric(i.fragmentation, prometheus.GaugeValue, fragmentation, labels...)
+		ch <- prometheus.MustNewConstMetric(i.pages, prometheus.GaugeValue, pages, labels...)
+		return nil
+	}, indexFragmentationQuery, IndexStatsTableFilter)
+	if err != nil {
+		return err
+	}
+	return c.query(func(rows resultRow) error {
+		var schema, table, stats string
+		var age, modifications float64
+		if err := rows.Scan(&schema, &table, &stats, &age, &modifications); err != nil {
+			return err
+		}
+		labels := []string{c.database.Server, c.database.Name, schema, table, stats}
+		ch <- prometheus.MustNewConstMetric(i.statsAge, prometheus.GaugeValue, age, labels...)
+		ch <- prometheus.MustNewConstMetric(i.modifications, prometheus.GaugeValue, modifications, labels...)
+		return nil
+	}, statisticsAgeQuery, IndexStatsTableFilter)
+}
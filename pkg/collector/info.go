@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/template"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+// SetInfoMetrics exports the info metrics defined in the config file for every scraped database. The metrics of
+// databases added or removed later are updated by AddDatabase and RemoveDatabase.
+func (e *Exporter) SetInfoMetrics(defs []config.InfoMetric) error {
+	metrics, err := newInfoMetrics(defs, e.databases(), e.extraLabels)
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.infoDefs, e.info = defs, metrics
+	return nil
+}
+
+// updateInfoMetrics renders the info metrics again for the currently scraped databases. A database whose labels
+// fail to render keeps the exporter's previous info metrics.
+func (e *Exporter) updateInfoMetrics() {
+	e.mutex.Lock()
+	defs := e.infoDefs
+	e.mutex.Unlock()
+	if len(defs) == 0 {
+		return
+	}
+	metrics, err := newInfoMetrics(defs, e.databases(), e.extraLabels)
+	if err != nil {
+		log.Errorf("Unable to update info metrics: %s", err)
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.info = metrics
+}
+
+// databases returns the databases currently scraped.
+func (e *Exporter) databases() []config.Database {
+	targets := e.allTargets()
+	dbs := make([]config.Database, len(targets))
+	for i, t := range targets {
+		dbs[i] = t.Database
+	}
+	return dbs
+}
+
+// infoData is what the label templates of info metrics are rendered against. It only holds fields that are safe
+// to publish, so a template can't expose the credentials of a database.
+type infoData struct {
+	Name     string
+	Server   string
+	Type     string
+	Metadata map[string]string
+	Labels   map[string]string
+}
+
+// newInfoMetrics renders the info metric definitions for every database.
+func newInfoMetrics(defs []config.InfoMetric, dbs []config.Database, extraLabels []string) ([]prometheus.Metric, error) {
+	var metrics []prometheus.Metric
+	for _, def := range defs {
+		if def.Name == "" {
+			return nil, fmt.Errorf("info metric without a name")
+		}
+		help := def.Help
+		if help == "" {
+			help = "Static information about the database from the exporter configuration."
+		}
+		names := make([]string, 0, len(def.Labels))
+		for name := range def.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		templates := make([]*template.Template, len(names))
+		for i, name := range names {
+			tmpl, err := template.New(name).Option("missingkey=zero").Parse(def.Labels[name])
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for label %s of info metric %s: %s", name, def.Name, err)
+			}
+			templates[i] = tmpl
+		}
+		for _, db := range dbs {
+			desc := newDesc(def.Name, help, db.ConstLabels(extraLabels), names...)
+			values := []string{db.Server, db.Name}
+			for i, tmpl := range templates {
+				var buf bytes.Buffer
+				data := infoData{Name: db.Name, Server: db.Server, Type: db.Type, Metadata: db.Metadata, Labels: db.Labels}
+				if err := tmpl.Execute(&buf, data); err != nil {
+					return nil, fmt.Errorf("unable to render label %s of info metric %s for database %s: %s", names[i], def.Name, db.Name, err)
+				}
+				values = append(values, buf.String())
+			}
+			m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, 1, values...)
+			if err != nil {
+				return nil, fmt.Errorf("invalid info metric %s: %s", def.Name, err)
+			}
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics, nil
+}
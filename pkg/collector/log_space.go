@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// logSpaceQuery returns the size and usage of the transaction log, its maximum size and what the log is waiting on
+// before its space can be reused. The maximum size is NULL if the log may grow without limit.
+const logSpaceQuery = `SELECT total_log_size_in_bytes, used_log_space_in_bytes, used_log_space_in_percent,
+	log_space_in_bytes_since_last_backup,
+	(SELECT SUM(CAST(NULLIF(max_size, -1) AS bigint)) * 8192 FROM sys.database_files WHERE type_desc = 'LOG'),
+	(SELECT log_reuse_wait_desc FROM sys.databases WHERE database_id = DB_ID())
+FROM sys.dm_db_log_space_usage`
+
+// logSpaceScraper exports the usage of the transaction log, so a full log, which fails all writes with error 9002,
+// can be predicted.
+type logSpaceScraper struct {
+	size            *prometheus.Desc
+	used            *prometheus.Desc
+	usedPercent     *prometheus.Desc
+	sinceLastBackup *prometheus.Desc
+	max             *prometheus.Desc
+	reuseWait       *prometheus.Desc
+}
+
+func init() {
+	registerScraper("log_space", newLogSpaceScraper)
+}
+
+func newLogSpaceScraper(labels prometheus.Labels) scraper {
+	return logSpaceScraper{
+		size:            newDesc("log_size_bytes", "Current size of the transaction log.", labels),
+		used:            newDesc("log_used_bytes", "Space used in the transaction log.", labels),
+		usedPercent:     newDesc("log_used_percent", "Space used in the transaction log in percentage of its current size.", labels),
+		sinceLastBackup: newDesc("log_since_last_backup_bytes", "Space used in the transaction log since the last log backup.", labels),
+		max:             newDesc("log_max_bytes", "Maximum size the transaction log can grow to. Not exported if the log may grow without limit.", labels),
+		reuseWait:       newDesc("log_reuse_wait_info", "What the space of the transaction log is waiting on before it can be reused, e.g. ACTIVE_TRANSACTION.", labels, "reason"),
+	}
+}
+
+func (l logSpaceScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.size
+	ch <- l.used
+	ch <- l.usedPercent
+	ch <- l.sinceLastBackup
+	ch <- l.max
+	ch <- l.reuseWait
+}
+
+func (l logSpaceScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	return c.query(func(rows resultRow) error {
+		var size, used, usedPercent, sinceLastBackup float64
+		var max sql.NullFloat64
+		var reuseWait sql.NullString
+		if err := rows.Scan(&size, &used, &usedPercent, &sinceLastBackup, &max, &reuseWait); err != nil {
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(l.size, prometheus.GaugeValue, size, c.database.Server, c.database.Name)
+		ch <- prometheus.MustNewConstMetric(l.used, prometheus.GaugeValue, used, c.database.Server, c.database.Name)
+		ch <- prometheus.MustNewConstMetric(l.usedPercent, prometheus.GaugeValue, usedPercent, c.database.Server, c.database.Name)
+		ch <- prometheus.MustNewConstMetric(l.sinceLastBackup, prometheus.GaugeValue, sinceLastBackup, c.database.Server, c.database.Name)
+		if max.Valid {
+			ch <- prometheus.MustNewConstMetric(l.max, prometheus.GaugeValue, max.Float64, c.database.Server, c.database.Name)
+		}
+		if reuseWait.Valid {
+			ch <- prometheus.MustNewConstMetric(l.reuseWait, prometheus.GaugeValue, 1, c.database.Server, c.database.Name, reuseWait.String)
+		}
+		return nil
+	}, logSpaceQuery)
+}
@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Durations is a comma separated list of durations, sorted ascending. It implements kingpin.Value and flag.Value.
+type Durations []time.Duration
+
+func (f *Durations) String() string {
+	s := make([]string, len(*f))
+	for i, d := range *f {
+		s[i] = d.String()
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *Durations) Set(value string) error {
+	var durations Durations
+	for _, s := range strings.Split(value, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		if d <= 0 {
+			return fmt.Errorf("duration %s must be positive", d)
+		}
+		durations = append(durations, d)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	*f = durations
+	return nil
+}
+
+// LongRunningThresholds are the durations the long_running_queries collector counts the queries running longer
+// than.
+var LongRunningThresholds = Durations{10 * time.Second, time.Minute, 5 * time.Minute}
+
+func init() {
+	registerScraper("long_running_queries", newLongRunningQueriesScraper)
+}
+
+// runningRequestsQuery returns how long every request of a user session in the database has been running, except
+// the exporter's own.
+const runningRequestsQuery = `SELECT r.total_elapsed_time
+FROM sys.dm_exec_requests r
+JOIN sys.dm_exec_sessions s ON s.session_id = r.session_id
+WHERE s.is_user_process = 1 AND r.database_id = DB_ID() AND r.session_id <> @@SPID`
+
+// longRunningQueriesScraper counts the requests running longer than each of --collector.long_running_queries.thresholds
+// and reports the longest running request, to alert on runaway queries.
+type longRunningQueriesScraper struct {
+	longRunning *prometheus.Desc
+	maxDuration *prometheus.Desc
+}
+
+func newLongRunningQueriesScraper(labels prometheus.Labels) scraper {
+	return longRunningQueriesScraper{
+		longRunning: newDesc("long_running_queries", "Number of requests running for longer than the threshold.", labels, "threshold"),
+		maxDuration: newDesc("running_query_max_duration_seconds", "Time the longest running request has been running. 0 if no request is running.", labels),
+	}
+}
+
+func (l longRunningQueriesScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.longRunning
+	ch <- l.maxDuration
+}
+
+func (l longRunningQueriesScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	counts := make([]float64, len(LongRunningThresholds))
+	var max time.Duration
+	err := c.query(func(rows resultRow) error {
+		var elapsedMs int64
+		if err := rows.Scan(&elapsedMs); err != nil {
+			return err
+		}
+		elapsed := time.Duration(elapsedMs) * time.Millisecond
+		if elapsed > max {
+			max = elapsed
+		}
+		for i, threshold := range LongRunningThresholds {
+			if elapsed > threshold {
+				counts[i]++
+			}
+		}
+		return nil
+	}, runningRequestsQuery)
+	if err != nil {
+		return err
+	}
+	for i, threshold := range LongRunningThresholds {
+		ch <- prometheus.MustNewConstMetric(l.longRunning, prometheus.GaugeValue, counts[i], c.database.Server, c.database.Name, threshold.String())
+	}
+	ch <- prometheus.MustNewConstMetric(l.maxDuration, prometheus.GaugeValue, max.Seconds(), c.database.Server, c.database.Name)
+	return nil
+}
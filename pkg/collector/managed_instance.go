@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const instanceResourceStatsQuery = `SELECT TOP 1 avg_cpu_percent, reserved_storage_mb, storage_space_used_mb, io_requests, io_bytes_read, io_bytes_written
+FROM master.sys.server_resource_stats
+ORDER BY end_time DESC`
+
+const instanceWaitStatsQuery = `SELECT TOP 20 wait_type, wait_time_ms, waiting_tasks_count
+FROM sys.dm_os_wait_stats
+WHERE wait_time_ms > 0
+ORDER BY wait_time_ms DESC`
+
+const instanceFileStatsQuery = `SELECT d.name, f.type_desc, SUM(vfs.num_of_bytes_read), SUM(vfs.num_of_bytes_written), SUM(vfs.io_stall_read_ms), SUM(vfs.io_stall_write_ms)
+FROM sys.dm_io_virtual_file_stats(NULL, NULL) vfs
+JOIN sys.databases d ON d.database_id = vfs.database_id
+JOIN sys.master_files f ON f.database_id = vfs.database_id AND f.file_id = vfs.file_id
+GROUP BY d.name, f.type_desc`
+
+// managedInstanceScraper replaces the resource stats of Azure SQL Database for Managed Instances, where
+// sys.dm_db_resource_stats has different semantics. It exports instance level resource usage from
+// sys.server_resource_stats, the top waits of the instance and the file IO of every database on the instance.
+type managedInstanceScraper struct {
+	cpuPercent      *prometheus.Desc
+	storageReserved *prometheus.Desc
+	storageUsed     *prometheus.Desc
+	ioRequests      *prometheus.Desc
+	ioBytesRead     *prometheus.Desc
+	ioBytesWritten  *prometheus.Desc
+	waitSeconds     *prometheus.Desc
+	waitingTasks    *prometheus.Desc
+	fileReadBytes   *prometheus.Desc
+	fileWriteBytes  *prometheus.Desc
+	fileReadStall   *prometheus.Desc
+	fileWriteStall  *prometheus.Desc
+	counters        *counterResets
+}
+
+// newManagedInstanceScraper creates the scraper for the Managed Instance d belongs to. Its metrics carry the
+// instance name, the first label of the server's host name, as managed_instance label.
+func newManagedInstanceScraper(d config.Database, labels prometheus.Labels) scraper {
+	instanceLabels := prometheus.Labels{"managed_instance": strings.SplitN(d.Server, ".", 2)[0]}
+	for name, value := range labels {
+		instanceLabels[name] = value
+	}
+	return managedInstanceScraper{
+		cpuPercent:      newDesc("mi_cpu_percent", "Average CPU utilization of the Managed Instance in percent.", instanceLabels),
+		storageReserved: newDesc("mi_storage_reserved_bytes", "Storage reserved for the Managed Instance.", instanceLabels),
+		storageUsed:     newDesc("mi_storage_used_bytes", "Storage used by all database files of the Managed Instance.", instanceLabels),
+		ioRequests:      newDesc("mi_io_requests", "Number of IO operations of the Managed Instance in the last interval of sys.server_resource_stats.", instanceLabels),
+		ioBytesRead:     newDesc("mi_io_read_bytes", "Bytes read by the Managed Instance in the last interval of sys.server_resource_stats.", instanceLabels),
+		ioBytesWritten:  newDesc("mi_io_written_bytes", "Bytes written by the Managed Instance in the last interval of sys.server_resource_stats.", instanceLabels),
+		waitSeconds:     newDesc("mi_wait_seconds_total", "Time spent waiting by wait type since the instance started, for the top 20 wait types.", instanceLabels, "wait_type"),
+		waitingTasks:    newDesc("mi_waiting_tasks_total", "Number of waits by wait type since the instance started, for the top 20 wait types.", instanceLabels, "wait_type"),
+		fileReadBytes:   newDesc("mi_file_read_bytes_total", "Bytes read from the files of a database on the instance.", instanceLabels, "db_name", "file_type"),
+		fileWriteBytes:  newDesc("mi_file_written_bytes_total", "Bytes written to the files of a database on the instance.", instanceLabels, "db_name", "file_type"),
+		fileReadStall:   newDesc("mi_file_read_stall_seconds_total", "Time spent waiting for reads from the files of a database on the instance.", instanceLabels, "db_name", "file_type"),
+		fileWriteStall:  newDesc("mi_file_write_stall_seconds_total", "Time spent waiting for writes to the files of a database on the instance.", instanceLabels, "db_name", "file_type"),
+		counters:        newCounterResets(),
+	}
+}
+
+func (m managedInstanceScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.cpuPercent
+	ch <- m.storageReserved
+	ch <- m.storageUsed
+	ch <- m.ioRequests
+	ch <- m.ioBytesRead
+	ch <- m.ioBytesWritten
+	ch <- m.waitSeconds
+	ch <- m.waitingTasks
+	ch <- m.fileReadBytes
+	ch <- m.fileWriteBytes
+	ch <- m.fileReadStall
+	ch <- m.fileWriteStall
+}
+
+func (m managedInstanceScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	server, name := c.database.Server, c.database.Name
+	rows := 0
+	err := c.query(func(r resultRow) error {
+		var cpu, reserved, used, requests, read, written float64
+		if err := r.Scan(&cpu, &reserved, &used, &requests, &read, &written); err != nil {
+			return err
+		}
+		rows++
+		ch <- prometheus.MustNewConstMetric(m.cpuPercent, prometheus.GaugeValue, cpu, server, name)
+		ch <- prometheus.MustNewConstMetric(m.storageReserved, prometheus.GaugeValue, reserved*1024*1024, server, name)
+		ch <- prometheus.MustNewConstMetric(m.storageUsed, prometheus.GaugeValue, used*1024*1024, server, name)
+		ch <- prometheus.MustNewConstMetric(m.ioRequests, prometheus.GaugeValue, requests, server, name)
+		ch <- prometheus.MustNewConstMetric(m.ioBytesRead, prometheus.GaugeValue, read, server, name)
+		ch <- prometheus.MustNewConstMetric(m.ioBytesWritten, prometheus.GaugeValue, written, server, name)
+		return nil
+	}, instanceResourceStatsQuery)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	err = c.capability("instance_wait_stats", func() error {
+		return c.query(func(r resultRow) error {
+			var waitType string
+			var waitMs, tasks float64
+			if err := r.Scan(&waitType, &waitMs, &tasks); err != nil {
+				return err
+			}
+			ch <- m.counters.metric(m.waitSeconds, waitMs/1000, server, name, waitType)
+			ch <- m.counters.metric(m.waitingTasks, tasks, server, name, waitType)
+			return nil
+		}, instanceWaitStatsQuery)
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return c.capability("instance_file_stats", func() error {
+		return c.query(func(r resultRow) error {
+			var dbName, fileType string
+			var read, written, readStallMs, writeStallMs float64
+			if err := r.Scan(&dbName, &fileType, &read, &written, &readStallMs, &writeStallMs); err != nil {
+				return err
+			}
+			ch <- m.counters.metric(m.fileReadBytes, read, server, name, dbName, fileType)
+			ch <- m.counters.metric(m.fileWriteBytes, written, server, name, dbName, fileType)
+			ch <- m.counters.metric(m.fileReadStall, readStallMs/1000, server, name, dbName, fileType)
+			ch <- m.counters.metric(m.fileWriteStall, writeStallMs/1000, server, name, dbName, fileType)
+			return nil
+		}, instanceFileStatsQuery)
+	}, nil)
+}
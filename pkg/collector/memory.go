@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerScraper("memory", newMemoryScraper)
+}
+
+// memoryQuery returns the number and memory of the granted and pending query memory grants of the database's
+// sessions, the longest time a pending grant has waited, and the size of the database's pages in the buffer pool
+// and how much of it is modified. The memory of pending grants is the memory they requested.
+const memoryQuery = `SELECT COUNT(g.grant_time), COUNT(*) - COUNT(g.grant_time),
+	ISNULL(SUM(CAST(g.granted_memory_kb AS bigint)), 0) * 1024,
+	ISNULL(SUM(CASE WHEN g.grant_time IS NULL THEN CAST(g.requested_memory_kb AS bigint) END), 0) * 1024,
+	ISNULL(MAX(CASE WHEN g.grant_time IS NULL THEN g.wait_time_ms END), 0) / 1000.0,
+	(SELECT COUNT_BIG(*) * 8192 FROM sys.dm_os_buffer_descriptors WHERE database_id = DB_ID()),
+	(SELECT COUNT_BIG(*) * 8192 FROM sys.dm_os_buffer_descriptors WHERE database_id = DB_ID() AND is_modified = 1)
+FROM sys.dm_exec_query_memory_grants g
+JOIN sys.dm_exec_sessions s ON s.session_id = g.session_id
+WHERE s.database_id = DB_ID()`
+
+// memoryScraper exports the query memory grants and the buffer pool usage of the database. Queries waiting on
+// RESOURCE_SEMAPHORE wait for a memory grant, which the memory percentage of the resource stats doesn't show.
+type memoryScraper struct {
+	grants          *prometheus.Desc
+	grantBytes      *prometheus.Desc
+	grantMaxWait    *prometheus.Desc
+	bufferPool      *prometheus.Desc
+	bufferPoolDirty *prometheus.Desc
+}
+
+func newMemoryScraper(labels prometheus.Labels) scraper {
+	return memoryScraper{
+		grants:          newDesc("memory_grants", "Number of query memory grants of the database's sessions by state, granted or pending.", labels, "state"),
+		grantBytes:      newDesc("memory_grant_bytes", "Memory of the query memory grants of the database's sessions by state. Pending grants count the memory they requested.", labels, "state"),
+		grantMaxWait:    newDesc("memory_grant_max_wait_seconds", "Longest time a pending query memory grant of the database's sessions has been waiting.", labels),
+		bufferPool:      newDesc("buffer_pool_bytes", "Size of the database's pages in the buffer pool.", labels),
+		bufferPoolDirty: newDesc("buffer_pool_dirty_bytes", "Size of the database's pages in the buffer pool that were modified since they were read from disk.", labels),
+	}
+}
+
+func (m memoryScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.grants
+	ch <- m.grantBytes
+	ch <- m.grantMaxWait
+	ch <- m.bufferPool
+	ch <- m.bufferPoolDirty
+}
+
+func (m memoryScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	return c.query(func(rows resultRow) error {
+		var granted, pending, grantedBytes, pendingBytes, maxWait, bufferPool, bufferPoolDirty float64
+		if err := rows.Scan(&granted, &pending, &grantedBytes, &pendingBytes, &maxWait, &bufferPool, &bufferPoolDirty); err != nil {
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(m.grants, prometheus.GaugeValue, granted, c.database.Server, c.database.Name, "granted")
+		ch <- prometheus.MustNewConstMetric(m.grants, prometheus.GaugeValue, pending, c.database.Server, c.database.Name, "pending")
+		ch <- prometheus.MustNewConstMetric(m.grantBytes, prometheus.GaugeValue, grantedBytes, c.database.Server, c.database.Name, "granted")
+		ch <- prometheus.MustNewConstMetric(m.grantBytes, prometheus.GaugeValue, pendingBytes, c.database.Server, c.database.Name, "pending")
+		ch <- prometheus.MustNewConstMetric(m.grantMaxWait, prometheus.GaugeValue, maxWait, c.database.Server, c.database.Name)
+		ch <- prometheus.MustNewConstMetric(m.bufferPool, prometheus.GaugeValue, bufferPool, c.database.Server, c.database.Name)
+		ch <- prometheus.MustNewConstMetric(m.bufferPoolDirty, prometheus.GaugeValue, bufferPoolDirty, c.database.Server, c.database.Name)
+		return nil
+	}, memoryQuery)
+}
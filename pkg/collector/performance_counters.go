@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// performanceCountersQuery returns the cumulative performance counters of the database. Despite their names, the
+// /sec counters are totals since the database last started or failed over. Database counters are reported by the
+// physical name of the database. Batch requests are counted by the SQL Server instance hosting the database, which
+// all databases of an elastic pool share.
+const performanceCountersQuery = `SELECT RTRIM(counter_name), cntr_value
+FROM sys.dm_os_performance_counters
+WHERE (object_name LIKE '%:Locks' AND counter_name = 'Number of Deadlocks/sec' AND instance_name = '_Total')
+OR (object_name LIKE '%:SQL Statistics' AND counter_name = 'Batch Requests/sec')
+OR (object_name LIKE '%:Databases' AND counter_name IN ('Log Bytes Flushed/sec', 'Log Flushes/sec', 'Transactions/sec', 'Write Transactions/sec')
+AND instance_name = (SELECT physical_database_name FROM sys.databases WHERE database_id = DB_ID()))`
+
+// performanceCountersScraper exports cumulative values of sys.dm_os_performance_counters as counters.
+type performanceCountersScraper struct {
+	descs    map[string]*prometheus.Desc
+	counters *counterResets
+}
+
+func init() {
+	registerScraper("performance_counters", newPerformanceCountersScraper)
+}
+
+func newPerformanceCountersScraper(labels prometheus.Labels) scraper {
+	return performanceCountersScraper{
+		descs: map[string]*prometheus.Desc{
+			"number of deadlocks/sec": newDesc("deadlocks_total", "Number of lock requests that resulted in a deadlock.", labels),
+			"log bytes flushed/sec":   newDesc("log_flushed_bytes_total", "Bytes of transaction log flushed to disk.", labels),
+			"log flushes/sec":         newDesc("log_flushes_total", "Number of transaction log flushes.", labels),
+			"transactions/sec":        newDesc("transactions_total", "Number of transactions started in the database.", labels),
+			"write transactions/sec":  newDesc("write_transactions_total", "Number of transactions that wrote to the database and committed.", labels),
+			"batch requests/sec":      newDesc("batch_requests_total", "Number of T-SQL batches received by the SQL Server instance hosting the database.", labels),
+		},
+		counters: newCounterResets(),
+	}
+}
+
+func (p performanceCountersScraper) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range p.descs {
+		ch <- desc
+	}
+}
+
+func (p performanceCountersScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	return c.query(func(rows resultRow) error {
+		var name string
+		var value float64
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		if desc, ok := p.descs[strings.ToLower(name)]; ok {
+			ch <- p.counters.metric(desc, value, c.database.Server, c.database.Name)
+		}
+		return nil
+	}, performanceCountersQuery)
+}
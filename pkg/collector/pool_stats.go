@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolStats exports the statistics of the connection pools kept open for prewarmed databases.
+type poolStats struct {
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxIdleTimeClosed *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+func newPoolStats(extraLabels []string) poolStats {
+	labels := append([]string{"server", "database"}, extraLabels...)
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+	return poolStats{
+		open:              desc("pool_open_connections", "Number of open connections to the database, in use or idle."),
+		inUse:             desc("pool_in_use_connections", "Number of connections to the database currently in use."),
+		idle:              desc("pool_idle_connections", "Number of idle connections to the database."),
+		waitCount:         desc("pool_wait_count_total", "Number of times a query waited for a connection to the database."),
+		waitDuration:      desc("pool_wait_duration_seconds_total", "Time queries waited for a connection to the database."),
+		maxIdleClosed:     desc("pool_max_idle_closed_total", "Number of connections to the database closed due to the maximum number of idle connections."),
+		maxIdleTimeClosed: desc("pool_max_idle_time_closed_total", "Number of connections to the database closed due to the maximum idle time."),
+		maxLifetimeClosed: desc("pool_max_lifetime_closed_total", "Number of connections to the database closed due to the maximum connection lifetime."),
+	}
+}
+
+func (p poolStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.open
+	ch <- p.inUse
+	ch <- p.idle
+	ch <- p.waitCount
+	ch <- p.waitDuration
+	ch <- p.maxIdleClosed
+	ch <- p.maxIdleTimeClosed
+	ch <- p.maxLifetimeClosed
+}
+
+// collect exports the statistics of db with the given label values.
+func (p poolStats) collect(db *sql.DB, labels []string, ch chan<- prometheus.Metric) {
+	s := db.Stats()
+	ch <- prometheus.MustNewConstMetric(p.open, prometheus.GaugeValue, float64(s.OpenConnections), labels...)
+	ch <- prometheus.MustNewConstMetric(p.inUse, prometheus.GaugeValue, float64(s.InUse), labels...)
+	ch <- prometheus.MustNewConstMetric(p.idle, prometheus.GaugeValue, float64(s.Idle), labels...)
+	ch <- prometheus.MustNewConstMetric(p.waitCount, prometheus.CounterValue, float64(s.WaitCount), labels...)
+	ch <- prometheus.MustNewConstMetric(p.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds(), labels...)
+	ch <- prometheus.MustNewConstMetric(p.maxIdleClosed, prometheus.CounterValue, float64(s.MaxIdleClosed), labels...)
+	ch <- prometheus.MustNewConstMetric(p.maxIdleTimeClosed, prometheus.CounterValue, float64(s.MaxIdleTimeClosed), labels...)
+	ch <- prometheus.MustNewConstMetric(p.maxLifetimeClosed, prometheus.CounterValue, float64(s.MaxLifetimeClosed), labels...)
+}
@@ -0,0 +1,168 @@
+package collector
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/log"
+)
+
+var (
+	// PrewarmAll connects to all databases at startup, as if every database set prewarm.
+	PrewarmAll bool
+
+	// PrewarmRate is the number of connections per second established to prewarmed databases at startup.
+	PrewarmRate = 10.0
+)
+
+// openDatabase returns the connection pool of the target and a function to call once the scrape is done. Pools
+// of prewarmed databases are kept open between scrapes; other databases are connected to for every scrape.
+func (e *Exporter) openDatabase(t *target) (querier, func(), error) {
+	if t.db != nil {
+		return t.db, func() {}, nil
+	}
+	dsn, err := t.dsn()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := e.open(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.prewarm() {
+		limitLifetime(conn, t.Database)
+		t.db = conn
+		return conn, func() {}, nil
+	}
+	return conn, func() { conn.Close() }, nil
+}
+
+// dsn returns the data source name of the target's database, connecting through its SSH tunnel if configured, with
+// the timeouts of the driver limited to ScrapeTimeout.
+func (t *target) dsn() (string, error) {
+	if t.tunnel != nil {
+		dsn, err := t.tunnel.dsn(t.Database)
+		return withTimeouts(dsn, ScrapeTimeout), err
+	}
+	return withTimeouts(t.DSN(), ScrapeTimeout), nil
+}
+
+// driverDialTimeout is the dial timeout of the driver if the data source name doesn't set one.
+const driverDialTimeout = 5 * time.Second
+
+// withTimeouts returns dsn with its connection and dial timeouts limited to budget. The driver only checks the
+// context of a query before sending it, so a login or query the server stops responding to is only ended by the
+// connection timeout, which limits every read from and write to the server, 30s by default. Later parameters
+// override earlier ones.
+func withTimeouts(dsn string, budget time.Duration) string {
+	if budget <= 0 {
+		return dsn
+	}
+	limit := int(math.Ceil(budget.Seconds()))
+	params := map[string]string{}
+	for _, param := range strings.Split(dsn, ";") {
+		if kv := strings.SplitN(param, "=", 2); len(kv) == 2 {
+			params[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+		}
+	}
+	if timeout, err := strconv.Atoi(params["connection timeout"]); err != nil || timeout <= 0 || timeout > limit {
+		dsn += fmt.Sprintf(";connection timeout=%d", limit)
+	}
+	dial, err := strconv.Atoi(params["dial timeout"])
+	if err != nil || dial <= 0 {
+		dial = int(driverDialTimeout.Seconds())
+	}
+	if dial > limit {
+		dsn += fmt.Sprintf(";dial timeout=%d", limit)
+	}
+	return dsn
+}
+
+// prewarm reports whether the connections to the target's database are established at startup and kept open.
+func (t *target) prewarm() bool {
+	return t.Type != config.TypeSynthetic && (t.Prewarm || PrewarmAll)
+}
+
+// Prewarm connects to the prewarmed databases at --startup.prewarm-rate, so the first scrape after a deploy
+// doesn't have to wait for hundreds of TLS and login handshakes. It returns once all connections were attempted.
+func (e *Exporter) Prewarm() {
+	if PrewarmRate <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / PrewarmRate))
+	defer ticker.Stop()
+	done := make(chan struct{})
+	n := 0
+	for _, t := range e.allTargets() {
+		if !t.prewarm() {
+			continue
+		}
+		n++
+		go func(t *target) {
+			defer func() { done <- struct{}{} }()
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			conn, release, err := e.openDatabase(t)
+			if err == nil {
+				defer release()
+				err = conn.PingContext(e.ctx)
+			}
+			if err != nil {
+				log.Warnf("Failed to prewarm connection to %s: %s", t.Database, err)
+				return
+			}
+			log.Debugf("Prewarmed connection to %s", t.Database)
+		}(t)
+		select {
+		case <-ticker.C:
+		case <-e.ctx.Done():
+		}
+	}
+	for ; n > 0; n-- {
+		<-done
+	}
+}
+
+// limitLifetime closes the connections of a pool kept open between scrapes after the connection_max_lifetime of the
+// database, so the server name is resolved again when they are replaced.
+func limitLifetime(conn querier, d config.Database) {
+	if db, ok := conn.(*sql.DB); ok && d.ConnectionMaxLifetime > 0 {
+		db.SetConnMaxLifetime(d.ConnectionMaxLifetime)
+	}
+}
+
+// resetDatabase closes the pool kept open for the target after a scrape failed to reach the server or failed
+// with a transient error, as during a failover, so the next scrape reconnects and resolves the server name again
+// instead of reusing connections to the former primary. The caller must hold the target's mutex.
+func (t *target) resetDatabase(err error) {
+	if t.db == nil || !(isConnectionFailure(err) || isTransientError(err)) {
+		return
+	}
+	log.Debugf("Closing connections to %s to reconnect on the next scrape: %s", t.Database, err)
+	t.db.Close()
+	t.db = nil
+}
+
+// closeDatabases closes the connection pools kept open between scrapes. Unless the scrapes finished, the pools of
+// databases still being scraped are left to their scrapes, which are logged as abandoned.
+func (e *Exporter) closeDatabases(finished bool) {
+	for _, t := range e.allTargets() {
+		if !finished && !t.mutex.TryLock() {
+			log.Warnf("Abandoning scrape of %s still running at shutdown", t.Database)
+			continue
+		}
+		if finished {
+			t.mutex.Lock()
+		}
+		if t.db != nil {
+			t.db.Close()
+			t.db = nil
+		}
+		t.mutex.Unlock()
+	}
+}
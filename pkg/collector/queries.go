@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+)
+
+// queries holds the queries of the collectors that the config can override, by the name they are overridden by.
+// An override must return the same columns in the same order, and take the same parameters, as the query it
+// replaces.
+var queries = map[string]string{
+	"backups":                   backupsQuery,
+	"backups_managed_instance":  managedInstanceBackupsQuery,
+	"connection_events":         connectionEventsQuery,
+	"credential_expiry":         credentialExpiryQuery,
+	"database_states":           databaseStatesQuery,
+	"elastic_pool":              elasticPoolQuery,
+	"elastic_pool_stats":        elasticPoolStatsQuery,
+	"geo_replication":           geoReplicationQuery,
+	"hyperscale_log_governor":   hyperscaleLogGovernorQuery,
+	"hyperscale_page_reads":     hyperscalePageServerReadsQuery,
+	"hyperscale_rbpex":          hyperscaleRBPEXQuery,
+	"index_fragmentation":       indexFragmentationQuery,
+	"instance_file_stats":       instanceFileStatsQuery,
+	"instance_resource_stats":   instanceResourceStatsQuery,
+	"instance_wait_stats":       instanceWaitStatsQuery,
+	"log_space":                 logSpaceQuery,
+	"memory":                    memoryQuery,
+	"performance_counters":      performanceCountersQuery,
+	"query_store":               queryStoreQuery,
+	"requests":                  requestsQuery,
+	"resource_limit_stats":      resourceLimitStatsQuery,
+	"resource_stats":            resourceStatsQuery,
+	"resource_window":           resourceWindowQuery,
+	"resource_window_latest":    resourceWindowLatestQuery,
+	"running_requests":          runningRequestsQuery,
+	"server_resource_stats":     serverResourceStatsQuery,
+	"session_origins":           sessionOriginsQuery,
+	"sqlserver_io":              sqlServerIOQuery,
+	"sqlserver_resource_stats":  sqlServerResourceStatsQuery,
+	"statistics_age":            statisticsAgeQuery,
+	"storage_used":              storageUsedQuery,
+	"synapse_requests":          synapseRequestsQuery,
+	"synapse_resource_pools":    synapseResourcePoolsQuery,
+	"synapse_service_objective": synapseServiceObjectiveQuery,
+	"tempdb":                    tempdbQuery,
+	"tempdb_sessions":           tempdbSessionQuery,
+	"throttled_requests":        throttledRequestsQuery,
+	"wait_stats":                waitStatsQuery,
+	"xtp":                       xtpQuery,
+}
+
+// QueryNames returns the names of the queries the config can override in alphabetical order.
+func QueryNames() []string {
+	names := make([]string, 0, len(queries))
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckQueries returns an error if a database overrides a query that doesn't exist, or with a query that isn't a
+// single read-only SELECT statement, so a mistake in the config can't modify or block a production database.
+func CheckQueries(dbs []config.Database) error {
+	for _, db := range dbs {
+		for name, query := range db.Queries {
+			if _, ok := queries[name]; !ok {
+				return fmt.Errorf("unknown query %q for database %s, available queries: %s", name, db.Name, strings.Join(QueryNames(), ", "))
+			}
+			if err := checkReadOnly(query); err != nil {
+				return fmt.Errorf("query %s of database %s is not a read-only SELECT: %s", name, db.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// queryOverrides returns the queries the database overrides, by the default query they replace, or nil if it
+// overrides none.
+func queryOverrides(d config.Database) map[string]string {
+	if len(d.Queries) == 0 {
+		return nil
+	}
+	overrides := map[string]string{}
+	for name, query := range d.Queries {
+		if defaultQuery, ok := queries[name]; ok {
+			overrides[defaultQuery] = query
+		}
+	}
+	return overrides
+}
@@ -0,0 +1,80 @@
+package collector
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// QueryStoreTopN is the number of queries with the highest total CPU time exported by the query_store collector.
+	QueryStoreTopN = 10
+
+	// QueryStoreLookback limits the query_store collector to Query Store runtime stats of queries executed within
+	// this duration.
+	QueryStoreLookback = time.Hour
+
+	// QueryStoreInterval is how often the query_store collector queries a database. Results are reused in between. 0
+	// queries the database on every scrape.
+	QueryStoreInterval time.Duration
+)
+
+const queryStoreQuery = `SELECT TOP (?)
+	q.query_id,
+	CONVERT(varchar(32), q.query_hash, 2),
+	SUM(rs.count_executions),
+	SUM(rs.avg_duration * rs.count_executions) / SUM(rs.count_executions),
+	SUM(rs.avg_cpu_time * rs.count_executions) / SUM(rs.count_executions)
+FROM sys.query_store_query q
+JOIN sys.query_store_plan p ON p.query_id = q.query_id
+JOIN sys.query_store_runtime_stats rs ON rs.plan_id = p.plan_id
+WHERE rs.last_execution_time > DATEADD(second, -?, SYSUTCDATETIME())
+GROUP BY q.query_id, q.query_hash
+ORDER BY SUM(rs.avg_cpu_time * rs.count_executions) DESC`
+
+// queryStoreScraper exports execution statistics of the most expensive queries recorded by Query Store.
+type queryStoreScraper struct {
+	executions  *prometheus.Desc
+	avgDuration *prometheus.Desc
+	avgCPU      *prometheus.Desc
+}
+
+func init() {
+	registerScraper("query_store", newQueryStoreScraper)
+}
+
+func newQueryStoreScraper(labels prometheus.Labels) scraper {
+	return queryStoreScraper{
+		executions:  newDesc("query_store_executions", "Number of executions of the query within the lookback window.", labels, "query_id", "query_hash"),
+		avgDuration: newDesc("query_store_avg_duration_seconds", "Average duration of the query within the lookback window.", labels, "query_id", "query_hash"),
+		avgCPU:      newDesc("query_store_avg_cpu_seconds", "Average CPU time of the query within the lookback window.", labels, "query_id", "query_hash"),
+	}
+}
+
+func (queryStoreScraper) Interval() time.Duration {
+	return QueryStoreInterval
+}
+
+func (q queryStoreScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- q.executions
+	ch <- q.avgDuration
+	ch <- q.avgCPU
+}
+
+func (q queryStoreScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	return c.query(func(rows resultRow) error {
+		var id int64
+		var hash string
+		var executions, duration, cpu float64
+		if err := rows.Scan(&id, &hash, &executions, &duration, &cpu); err != nil {
+			return err
+		}
+		labels := []string{c.database.Server, c.database.Name, strconv.FormatInt(id, 10), hash}
+		// Query Store records durations in microseconds.
+		ch <- prometheus.MustNewConstMetric(q.executions, prometheus.GaugeValue, executions, labels...)
+		ch <- prometheus.MustNewConstMetric(q.avgDuration, prometheus.GaugeValue, duration/1e6, labels...)
+		ch <- prometheus.MustNewConstMetric(q.avgCPU, prometheus.GaugeValue, cpu/1e6, labels...)
+		return nil
+	}, queryStoreQuery, QueryStoreTopN, int64(QueryStoreLookback.Seconds()))
+}
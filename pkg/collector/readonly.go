@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	// LockTimeout is how long a query of a collector waits for a lock before it fails with error 1222, so a query
+	// blocked by the workload of the database doesn't hold up the scrape, or the workload queued behind it. 0 waits
+	// as long as the server's default.
+	LockTimeout = 5 * time.Second
+)
+
+// errLockTimeout is returned when a query waited longer than its LOCK_TIMEOUT for a lock.
+const errLockTimeout = 1222
+
+// withLockTimeout returns query prefixed with the SET LOCK_TIMEOUT of LockTimeout. The setting outlives the batch on
+// the session, which the pool reuses for the next query, so it is sent with every query on purpose rather than once
+// per connection: whichever query of the exporter a session ran last, the next one sets the lock timeout again.
+func withLockTimeout(query string) string {
+	if LockTimeout <= 0 {
+		return query
+	}
+	ms := LockTimeout.Milliseconds()
+	if ms == 0 {
+		ms = 1
+	}
+	return fmt.Sprintf("SET LOCK_TIMEOUT %d;\n%s", ms, query)
+}
+
+// deniedKeywords are the keywords a read-only query must not contain: statements that modify data, schema,
+// permissions or the session, run other code, or read from outside the database, and table hints taking locks
+// that block writers.
+var deniedKeywords = map[string]bool{
+	"ALTER": true, "BACKUP": true, "BEGIN": true, "BULK": true, "COMMIT": true, "CREATE": true, "DBCC": true,
+	"DECLARE": true, "DELETE": true, "DENY": true, "DROP": true, "EXEC": true, "EXECUTE": true, "GRANT": true,
+	"HOLDLOCK": true, "INSERT": true, "INTO": true, "KILL": true, "MERGE": true, "OPENDATASOURCE": true,
+	"OPENQUERY": true, "OPENROWSET": true, "RECONFIGURE": true, "RESTORE": true, "REVOKE": true, "ROLLBACK": true,
+	"SET": true, "SHUTDOWN": true, "TABLOCKX": true, "TRUNCATE": true, "UPDATE": true, "UPDLOCK": true, "USE": true,
+	"WAITFOR": true, "XLOCK": true,
+}
+
+// checkReadOnly returns an error if query isn't a single SELECT statement, optionally with common table
+// expressions, that only reads. Comments, string literals and quoted identifiers are ignored, so a column named
+// [update] is allowed.
+func checkReadOnly(query string) error {
+	code := stripLiterals(query)
+	if i := strings.IndexByte(code, ';'); i >= 0 && strings.TrimSpace(code[i+1:]) != "" {
+		return fmt.Errorf("it contains more than one statement")
+	}
+	words := strings.FieldsFunc(code, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '@' && r != '#' && r != '$'
+	})
+	if len(words) == 0 {
+		return fmt.Errorf("it contains no statement")
+	}
+	if first := strings.ToUpper(words[0]); first != "SELECT" && first != "WITH" {
+		return fmt.Errorf("it starts with %s rather than SELECT or WITH", first)
+	}
+	for _, w := range words {
+		if deniedKeywords[strings.ToUpper(w)] {
+			return fmt.Errorf("it contains %s", strings.ToUpper(w))
+		}
+	}
+	return nil
+}
+
+// stripLiterals returns query with its comments, string literals and quoted identifiers replaced by spaces. Block
+// comments nest, as they do in T-SQL.
+func stripLiterals(query string) string {
+	var b strings.Builder
+	for i := 0; i < len(query); {
+		switch {
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return b.String()
+			}
+			i += end
+		case strings.HasPrefix(query[i:], "/*"):
+			depth := 0
+			for i < len(query) {
+				if strings.HasPrefix(query[i:], "/*") {
+					depth++
+					i += 2
+				} else if strings.HasPrefix(query[i:], "*/") {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+			b.WriteByte(' ')
+		case query[i] == '\'' || query[i] == '"' || query[i] == '[':
+			closing := query[i]
+			if closing == '[' {
+				closing = ']'
+			}
+			// A doubled closing character escapes it.
+			for i++; i < len(query); i++ {
+				if query[i] == closing {
+					if i+1 < len(query) && query[i+1] == closing {
+						i++
+						continue
+					}
+					i++
+					break
+				}
+			}
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(query[i])
+			i++
+		}
+	}
+	return b.String()
+}
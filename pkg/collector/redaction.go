@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+)
+
+// LabelRedaction configures how the sensitive label values of the collectors are exposed.
+var LabelRedaction config.Redaction
+
+// redactedCounts sums counts by their redacted label values, as redaction can map several label values to the same
+// series. The label values of each count are in the order of labels.
+type redactedCounts struct {
+	labels []string
+	counts map[string]float64
+}
+
+func newRedactedCounts(labels ...string) *redactedCounts {
+	return &redactedCounts{labels: labels, counts: map[string]float64{}}
+}
+
+func (c *redactedCounts) add(count float64, values ...string) {
+	redacted := make([]string, len(values))
+	for i, v := range values {
+		redacted[i] = LabelRedaction.Redact(c.labels[i], v)
+	}
+	c.counts[strings.Join(redacted, "\x00")] += count
+}
+
+// each calls fn with every distinct set of redacted label values and its count, in a stable order.
+func (c *redactedCounts) each(fn func(count float64, values []string)) {
+	keys := make([]string, 0, len(c.counts))
+	for key := range c.counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fn(c.counts[key], strings.Split(key, "\x00"))
+	}
+}
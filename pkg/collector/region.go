@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// regionCollector exports the Azure region and failover group partner server of the databases as
+// azure_sql_database_region_info. The values set in the config file take precedence over the values looked up
+// through the Azure Resource Manager API, if enabled.
+type regionCollector struct {
+	// resources is nil if the Azure Resource Manager API isn't used.
+	resources   *AzureResources
+	dbs         []config.Database
+	extraLabels []string
+	desc        *prometheus.Desc
+}
+
+// NewRegionCollector returns a collector exporting the region and failover partner of the databases. resources
+// may be nil.
+func NewRegionCollector(resources *AzureResources, dbs []config.Database, extraLabels []string) prometheus.Collector {
+	labels := append([]string{"server", "database"}, extraLabels...)
+	return &regionCollector{
+		resources:   resources,
+		dbs:         dbs,
+		extraLabels: extraLabels,
+		desc:        prometheus.NewDesc(namespace+"_database_region_info", "Azure region of the database and the partner server of its failover group, if any. Always 1.", append(labels, "region", "failover_partner"), nil),
+	}
+}
+
+func (c *regionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect exports the region info of every database whose region or failover partner is known.
+func (c *regionCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, db := range c.dbs {
+		region, partner := db.Region, db.FailoverPartner
+		if c.resources != nil {
+			if r, ok := c.resources.lookup(db); ok {
+				if region == "" {
+					region = r.Database.Location
+				}
+				if partner == "" {
+					partner = r.FailoverPartner
+				}
+			}
+		}
+		if region == "" && partner == "" {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, append(db.LabelValues(c.extraLabels), region, partner)...)
+	}
+}
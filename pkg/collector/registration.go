@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"fmt"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+// allTargets returns the targets of the databases currently scraped. The returned slice is never modified.
+func (e *Exporter) allTargets() []*target {
+	e.targetsMutex.RLock()
+	defer e.targetsMutex.RUnlock()
+	return e.targets
+}
+
+// isRemoved reports whether the database of the target was removed at runtime.
+func (t *target) isRemoved() bool {
+	select {
+	case <-t.removed:
+		return true
+	default:
+		return false
+	}
+}
+
+// databaseLabels returns the server, database and static labels of the database.
+func (e *Exporter) databaseLabels(d config.Database) prometheus.Labels {
+	labels := prometheus.Labels(d.ConstLabels(e.extraLabels))
+	labels["server"], labels["database"] = d.Server, d.Name
+	return labels
+}
+
+// CheckDatabase returns an error if the validated database can't be added at runtime. It may only set the static
+// labels of the databases the exporter was created with, so all series of a metric keep the same labels, and only
+// their groups, as the paths of the groups are served from the start.
+func (e *Exporter) CheckDatabase(db config.Database) error {
+	for name := range db.Labels {
+		if !contains(e.extraLabels, name) {
+			return fmt.Errorf("label %q of database %s is not set by any database the exporter was started with, only %v can be set", name, db.Name, e.extraLabels)
+		}
+	}
+	if db.Group != "" && !e.groups[db.Group] {
+		return fmt.Errorf("group %s of database %s has no databases in the config, groups can only be added by restarting the exporter", db.Group, db.Name)
+	}
+	return nil
+}
+
+// AddDatabase starts scraping a database at runtime, e.g. one registered through the API. The database must have
+// been validated, pass CheckDatabase and must not be scraped already.
+func (e *Exporter) AddDatabase(db config.Database) error {
+	if err := e.CheckDatabase(db); err != nil {
+		return err
+	}
+	e.targetsMutex.Lock()
+	for _, t := range e.targets {
+		if t.Key() == db.Key() {
+			e.targetsMutex.Unlock()
+			return fmt.Errorf("database %s on server %s is already scraped", db.Name, db.Server)
+		}
+	}
+	t := newTarget(db, e.tierCollectors, e.extraLabels, e.tunnels)
+	// The slice is replaced, as readers may hold the current one.
+	e.targets = append(append([]*target{}, e.targets...), t)
+	if e.limiters != nil && e.limiters[db.Server] == nil {
+		e.limiters[db.Server] = newServerLimiter(db.Server)
+	}
+	if e.breakers != nil && e.breakers[db.Server] == nil {
+		e.breakers[db.Server] = newServerBreaker(db.Server)
+	}
+	if e.health != nil && e.health.started {
+		e.startPings(t)
+	}
+	e.targetsMutex.Unlock()
+	e.updateInfoMetrics()
+	log.Infof("Added database %s", db)
+	return nil
+}
+
+// RemoveDatabase stops scraping the database with the key of db, waiting for a scrape in progress, closes its
+// connections, and its SSH tunnel if no other database uses it, and removes its series.
+func (e *Exporter) RemoveDatabase(db config.Database) error {
+	e.targetsMutex.Lock()
+	var removed *target
+	targets := make([]*target, 0, len(e.targets))
+	for _, t := range e.targets {
+		if t.Key() == db.Key() {
+			removed = t
+			continue
+		}
+		targets = append(targets, t)
+	}
+	e.targets = targets
+	// The tunnel of the database is closed unless other databases are reached through it.
+	unused := removed != nil && removed.tunnel != nil
+	for _, t := range targets {
+		if unused && t.tunnel == removed.tunnel {
+			unused = false
+		}
+	}
+	if unused {
+		delete(e.tunnels, tunnelKey(removed.Database))
+	}
+	e.targetsMutex.Unlock()
+	if removed == nil {
+		return fmt.Errorf("database %s on server %s is not scraped", db.Name, db.Server)
+	}
+	removed.mutex.Lock()
+	close(removed.removed)
+	if removed.db != nil {
+		removed.db.Close()
+		removed.db = nil
+	}
+	if unused {
+		removed.tunnel.close()
+	}
+	e.resetMetrics(removed)
+	removed.mutex.Unlock()
+	labels := e.databaseLabels(removed.Database)
+	e.mutex.Lock()
+	for _, vec := range append(e.resourceGauges(), e.dbUp, e.dbPaused, e.dbMaintenance, e.dbMissing, e.firewallBlocked, e.permissionError, e.scrapeError, e.capability, e.collectorStatus, e.collectorDataAge) {
+		vec.DeletePartialMatch(labels)
+	}
+	e.droppedSeries.DeletePartialMatch(labels)
+	e.authFailures.DeletePartialMatch(labels)
+	e.scrapeFailures.DeletePartialMatch(labels)
+	e.queryDuration.DeletePartialMatch(labels)
+	e.mutex.Unlock()
+	if e.health != nil {
+		e.health.delete(labels)
+	}
+	e.updateInfoMetrics()
+	log.Infof("Removed database %s", removed.Database)
+	return nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
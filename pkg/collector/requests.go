@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// RequestsTopWaitTypes is the number of wait types with the most waiting requests exported by the requests
+	// collector. Requests waiting on other wait types are exported with the wait type "other".
+	RequestsTopWaitTypes = 10
+)
+
+func init() {
+	registerScraper("requests", newRequestsScraper)
+}
+
+// requestsQuery counts the current requests of user sessions in the database by status and wait type, except the
+// exporter's own.
+const requestsQuery = `SELECT r.status, ISNULL(r.wait_type, ''), COUNT(*)
+FROM sys.dm_exec_requests r
+JOIN sys.dm_exec_sessions s ON s.session_id = r.session_id
+WHERE s.is_user_process = 1 AND r.database_id = DB_ID() AND r.session_id <> @@SPID
+GROUP BY r.status, r.wait_type`
+
+// Wait types of requests that aren't exported by name.
+const (
+	// waitTypeNone is the wait type of requests that aren't waiting.
+	waitTypeNone = "none"
+	// waitTypeOther is the wait type of requests waiting on a wait type outside the top --collector.requests.top-wait-types.
+	waitTypeOther = "other"
+)
+
+// requestsScraper exports the current requests by status and wait type, to see what a stalled database is waiting
+// on right now. Only the wait types with the most waiting requests are exported by name to bound the cardinality.
+type requestsScraper struct {
+	requests *prometheus.Desc
+}
+
+func newRequestsScraper(labels prometheus.Labels) scraper {
+	return requestsScraper{
+		requests: newDesc("requests", "Number of current requests of user sessions by status and wait type. Wait types outside the top ones are summed up as other, requests that aren't waiting have the wait type none.", labels, "status", "wait_type"),
+	}
+}
+
+func (s requestsScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.requests
+}
+
+func (s requestsScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	type group struct {
+		status, waitType string
+	}
+	counts := map[group]float64{}
+	waiting := map[string]float64{}
+	err := c.query(func(rows resultRow) error {
+		var g group
+		var count float64
+		if err := rows.Scan(&g.status, &g.waitType, &count); err != nil {
+			return err
+		}
+		if g.waitType == "" {
+			g.waitType = waitTypeNone
+		} else {
+			waiting[g.waitType] += count
+		}
+		counts[g] += count
+		return nil
+	}, requestsQuery)
+	if err != nil {
+		return err
+	}
+	top := make([]string, 0, len(waiting))
+	for waitType := range waiting {
+		top = append(top, waitType)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if waiting[top[i]] != waiting[top[j]] {
+			return waiting[top[i]] > waiting[top[j]]
+		}
+		return top[i] < top[j]
+	})
+	exported := map[string]bool{waitTypeNone: true}
+	for i, waitType := range top {
+		exported[waitType] = i < RequestsTopWaitTypes
+	}
+	folded := map[group]float64{}
+	for g, count := range counts {
+		if !exported[g.waitType] {
+			g.waitType = waitTypeOther
+		}
+		folded[g] += count
+	}
+	for g, count := range folded {
+		ch <- prometheus.MustNewConstMetric(s.requests, prometheus.GaugeValue, count, c.database.Server, c.database.Name, g.status, g.waitType)
+	}
+	return nil
+}
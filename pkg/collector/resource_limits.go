@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const resourceLimitStatsQuery = `SELECT end_time, avg_cpu_percent, avg_data_io_percent, avg_log_write_percent, avg_memory_usage_percent, max_worker_percent, max_session_percent
+FROM sys.dm_db_resource_stats
+ORDER BY end_time`
+
+// throttledRequestsQuery counts the requests waiting on wait types caused by resource governance: CPU caps,
+// log rate governance and Hyperscale storage governance.
+const throttledRequestsQuery = `SELECT wait_type, COUNT(*)
+FROM sys.dm_exec_requests
+WHERE wait_type IN ('RESOURCE_GOVERNOR_IDLE', 'LOG_RATE_GOVERNOR', 'POOL_LOG_RATE_GOVERNOR', 'INSTANCE_LOG_RATE_GOVERNOR', 'HADR_THROTTLE_LOG_RATE_GOVERNOR', 'RBIO_RG_STORAGE', 'RBIO_RG_LOCALDESTAGE')
+GROUP BY wait_type`
+
+// resourceLimitHitThreshold is the utilization in percent at which a resource is considered to be at its limit.
+const resourceLimitHitThreshold = 100
+
+var resourceLimitResources = []string{"cpu", "data_io", "log_write", "memory", "worker", "session"}
+
+// resourceLimitsScraper counts the 15 second intervals of sys.dm_db_resource_stats in which a resource was at the
+// limit of the service tier and reports requests currently delayed by resource governance.
+type resourceLimitsScraper struct {
+	hits      *prometheus.Desc
+	throttled *prometheus.Desc
+
+	// Scrapers are created per database and only a single scrape of a database runs at a time, so the counts
+	// don't need locking.
+	lastEndTime time.Time
+	hitCounts   map[string]float64
+}
+
+func init() {
+	registerScraper("resource_limits", newResourceLimitsScraper)
+}
+
+func newResourceLimitsScraper(labels prometheus.Labels) scraper {
+	return &resourceLimitsScraper{
+		hits:      newDesc("resource_limit_hits_total", "Number of 15 second intervals in which the resource was at the limit of the service tier.", labels, "resource"),
+		throttled: newDesc("throttled_requests", "Number of requests currently waiting on a resource governance wait type.", labels, "wait_type"),
+		hitCounts: map[string]float64{},
+	}
+}
+
+func (r *resourceLimitsScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.hits
+	ch <- r.throttled
+}
+
+func (r *resourceLimitsScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	lastEndTime := r.lastEndTime
+	err := c.query(func(rows resultRow) error {
+		var endTime time.Time
+		values := make([]float64, len(resourceLimitResources))
+		dest := []interface{}{&endTime}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if !endTime.After(lastEndTime) {
+			return nil
+		}
+		for i, resource := range resourceLimitResources {
+			if values[i] >= resourceLimitHitThreshold {
+				r.hitCounts[resource]++
+			}
+		}
+		r.lastEndTime = endTime
+		return nil
+	}, resourceLimitStatsQuery)
+	if err != nil {
+		return err
+	}
+	for _, resource := range resourceLimitResources {
+		ch <- prometheus.MustNewConstMetric(r.hits, prometheus.CounterValue, r.hitCounts[resource], c.database.Server, c.database.Name, resource)
+	}
+
+	return c.query(func(rows resultRow) error {
+		var waitType string
+		var count float64
+		if err := rows.Scan(&waitType, &count); err != nil {
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(r.throttled, prometheus.GaugeValue, count, c.database.Server, c.database.Name, waitType)
+		return nil
+	}, throttledRequestsQuery)
+}
@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resourceStatsQuery returns the latest row of the resource stats of a database. The columns of
+// sys.dm_db_resource_stats differ between service levels and deployment options, so all of them are selected and
+// the known ones are picked by name. A column that doesn't exist doesn't fail the scrape, its gauge is just not
+// exported.
+const resourceStatsQuery = "SELECT TOP 1 * FROM sys.dm_db_resource_stats ORDER BY end_time DESC"
+
+// resourceColumn is a column of sys.dm_db_resource_stats and the gauge it is exported as.
+type resourceColumn struct {
+	name  string
+	gauge *prometheus.GaugeVec
+}
+
+// resourceStats is the latest row of the resource stats of a database.
+type resourceStats struct {
+	// values holds the values of the known columns by name. Columns the database lacks or that are NULL are
+	// missing.
+	values  map[string]float64
+	endTime time.Time
+}
+
+// queryResourceStats queries the resource stats of the target's database within ctx, retrying transient errors.
+func (e *Exporter) queryResourceStats(ctx context.Context, t *target, conn querier) (resourceStats, error) {
+	query := t.resourceQuery
+	if override, ok := t.queries[query]; ok {
+		query = override
+	}
+	var stats resourceStats
+	err := retryTransient(ctx, t.Database, func() error {
+		start := time.Now()
+		var err error
+		stats, err = e.scanResourceStats(ctx, conn, query, t.span)
+		if err != nil {
+			e.audit.Record(t.Database, query, start, 0, err)
+			return err
+		}
+		e.audit.Record(t.Database, query, start, 1, nil)
+		e.observeQuery(t, resourceStatsCollector, time.Since(start))
+		return nil
+	})
+	return stats, err
+}
+
+// scanResourceStats runs query, the resource stats query of the target or its override, and scans the known columns of the first row,
+// traced in parent. Brand-new databases have no row yet, which returns no values rather than an error.
+func (e *Exporter) scanResourceStats(ctx context.Context, conn querier, query string, parent *span) (_ resourceStats, err error) {
+	s := parent.query("query", query)
+	rows, err := conn.QueryContext(ctx, withLockTimeout(query))
+	s.end(err)
+	if err != nil {
+		return resourceStats{}, err
+	}
+	defer rows.Close()
+	s = parent.child("scan")
+	defer func() { s.end(err) }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return resourceStats{}, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return resourceStats{}, err
+		}
+		return resourceStats{values: map[string]float64{}}, nil
+	}
+	known := map[string]bool{}
+	for _, c := range e.resourceColumns {
+		known[c.name] = true
+	}
+	stats := resourceStats{values: map[string]float64{}}
+	var endTime sql.NullTime
+	numbers := make([]sql.NullFloat64, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i, name := range columns {
+		switch {
+		case name == "end_time":
+			dest[i] = &endTime
+		case known[name]:
+			dest[i] = &numbers[i]
+		default:
+			dest[i] = new(interface{})
+		}
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return resourceStats{}, err
+	}
+	stats.endTime = endTime.Time
+	for i, name := range columns {
+		if known[name] && numbers[i].Valid {
+			stats.values[name] = numbers[i].Float64
+		}
+	}
+	return stats, rows.Err()
+}
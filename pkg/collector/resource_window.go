@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const resourceWindowQuery = `SELECT end_time, avg_cpu_percent, avg_data_io_percent, avg_log_write_percent, avg_memory_usage_percent, max_worker_percent, max_session_percent
+FROM sys.dm_db_resource_stats
+WHERE end_time > ?
+ORDER BY end_time`
+
+const resourceWindowLatestQuery = `SELECT TOP 1 end_time, avg_cpu_percent, avg_data_io_percent, avg_log_write_percent, avg_memory_usage_percent, max_worker_percent, max_session_percent
+FROM sys.dm_db_resource_stats
+ORDER BY end_time DESC`
+
+// resourceWindowStats aggregates the utilization of one resource over the rows of a scrape window.
+type resourceWindowStats struct {
+	sum, min, max float64
+}
+
+// resourceWindowScraper aggregates all rows of sys.dm_db_resource_stats written since the previous scrape, so
+// spikes lasting a single 15 second interval between two scrapes aren't missed. The first scrape only aggregates
+// the latest row.
+type resourceWindowScraper struct {
+	avg     *prometheus.Desc
+	min     *prometheus.Desc
+	max     *prometheus.Desc
+	samples *prometheus.Desc
+
+	// Scrapers are created per database and only a single scrape of a database runs at a time, so the window
+	// doesn't need locking.
+	lastEndTime time.Time
+	count       int
+	stats       []resourceWindowStats
+}
+
+func init() {
+	registerScraper("resource_window", newResourceWindowScraper)
+}
+
+func newResourceWindowScraper(labels prometheus.Labels) scraper {
+	return &resourceWindowScraper{
+		avg:     newDesc("resource_window_avg_percent", "Average utilization of the resource over the rows of sys.dm_db_resource_stats since the previous scrape.", labels, "resource"),
+		min:     newDesc("resource_window_min_percent", "Minimum utilization of the resource over the rows of sys.dm_db_resource_stats since the previous scrape.", labels, "resource"),
+		max:     newDesc("resource_window_max_percent", "Maximum utilization of the resource over the rows of sys.dm_db_resource_stats since the previous scrape.", labels, "resource"),
+		samples: newDesc("resource_window_samples", "Number of rows of sys.dm_db_resource_stats aggregated by the last scrape.", labels),
+	}
+}
+
+func (r *resourceWindowScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.avg
+	ch <- r.min
+	ch <- r.max
+	ch <- r.samples
+}
+
+func (r *resourceWindowScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	query, args := resourceWindowQuery, []interface{}{r.lastEndTime}
+	if r.lastEndTime.IsZero() {
+		query, args = resourceWindowLatestQuery, nil
+	}
+	count, lastEndTime := 0, r.lastEndTime
+	stats := make([]resourceWindowStats, len(resourceLimitResources))
+	err := c.query(func(rows resultRow) error {
+		var endTime time.Time
+		values := make([]float64, len(resourceLimitResources))
+		dest := []interface{}{&endTime}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			if count == 0 {
+				stats[i] = resourceWindowStats{sum: v, min: v, max: v}
+				continue
+			}
+			stats[i].sum += v
+			if v < stats[i].min {
+				stats[i].min = v
+			}
+			if v > stats[i].max {
+				stats[i].max = v
+			}
+		}
+		count++
+		lastEndTime = endTime
+		return nil
+	}, query, args...)
+	if err != nil {
+		return err
+	}
+	// sys.dm_db_resource_stats is only written every 15 seconds. Without new rows the previous window is
+	// exported again.
+	if count > 0 {
+		r.lastEndTime, r.count, r.stats = lastEndTime, count, stats
+	}
+	if r.count == 0 {
+		return nil
+	}
+	server, name := c.database.Server, c.database.Name
+	for i, resource := range resourceLimitResources {
+		s := r.stats[i]
+		ch <- prometheus.MustNewConstMetric(r.avg, prometheus.GaugeValue, s.sum/float64(r.count), server, name, resource)
+		ch <- prometheus.MustNewConstMetric(r.min, prometheus.GaugeValue, s.min, server, name, resource)
+		ch <- prometheus.MustNewConstMetric(r.max, prometheus.GaugeValue, s.max, server, name, resource)
+	}
+	ch <- prometheus.MustNewConstMetric(r.samples, prometheus.GaugeValue, float64(r.count), server, name)
+	return nil
+}
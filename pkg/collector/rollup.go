@@ -0,0 +1,163 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+var (
+	// RollupsEnabled exports the maximum and average CPU and data I/O utilization of the scraped databases per
+	// logical server and per elastic pool, and the number of databases of every logical server by health.
+	RollupsEnabled bool
+)
+
+const elasticPoolQuery = `SELECT ISNULL(elastic_pool_name, '') FROM sys.database_service_objectives WHERE database_id = DB_ID()`
+
+// rollupResource is a column of the resource stats that is rolled up.
+type rollupResource struct {
+	column string
+	// serverMax, serverAvg, poolMax and poolAvg describe the rollups by server and by elastic pool.
+	serverMax, serverAvg, poolMax, poolAvg *prometheus.Desc
+}
+
+func newRollupResource(column, name, help string) rollupResource {
+	return rollupResource{
+		column:    column,
+		serverMax: prometheus.NewDesc(namespace+"_rollup_server_"+name+"_max", "Maximum "+help+" of the scraped databases of the logical server.", []string{"server"}, nil),
+		serverAvg: prometheus.NewDesc(namespace+"_rollup_server_"+name+"_avg", "Average "+help+" of the scraped databases of the logical server.", []string{"server"}, nil),
+		poolMax:   prometheus.NewDesc(namespace+"_rollup_elastic_pool_"+name+"_max", "Maximum "+help+" of the scraped databases of the elastic pool.", []string{"server", "elastic_pool"}, nil),
+		poolAvg:   prometheus.NewDesc(namespace+"_rollup_elastic_pool_"+name+"_avg", "Average "+help+" of the scraped databases of the elastic pool.", []string{"server", "elastic_pool"}, nil),
+	}
+}
+
+var rollupResources = []rollupResource{
+	newRollupResource("avg_cpu_percent", "cpu_percent", "compute utilization"),
+	newRollupResource("avg_data_io_percent", "data_io_percent", "data I/O utilization"),
+}
+
+var rollupServerDatabases = prometheus.NewDesc(namespace+"_rollup_server_databases", "Number of databases of the logical server by the health of their last scrape: up, down or unknown if they weren't scraped yet.", []string{"server", "health"}, nil)
+
+// rollupHealths are the healths of targetStatus the databases of a server are counted by.
+var rollupHealths = []string{"up", "down", "unknown"}
+
+// rollupSample holds the values of the last successful scrape of a database that are rolled up.
+type rollupSample struct {
+	// values holds the values of the rolled up columns of the resource stats by column. Columns the database
+	// lacks are missing.
+	values      map[string]float64
+	elasticPool string
+}
+
+// rollup aggregates the samples of a group of databases.
+type rollup struct {
+	max, sum float64
+	count    int
+}
+
+func (r *rollup) add(v float64) {
+	if r.count == 0 || v > r.max {
+		r.max = v
+	}
+	r.sum += v
+	r.count++
+}
+
+// elasticPool returns the name of the elastic pool the database belongs to, or an empty string.
+func (c *connection) elasticPool() (string, error) {
+	var pool string
+	err := c.query(func(rows resultRow) error {
+		return rows.Scan(&pool)
+	}, elasticPoolQuery)
+	return pool, err
+}
+
+// sampleRollup records the resource stats of a successful scrape of t for the rollups.
+func sampleRollup(t *target, c *connection, stats map[string]float64) {
+	t.rollup = nil
+	values := map[string]float64{}
+	for _, r := range rollupResources {
+		if v, ok := stats[r.column]; ok {
+			values[r.column] = v
+		}
+	}
+	if len(values) == 0 {
+		return
+	}
+	pool, err := c.elasticPool()
+	if err != nil {
+		log.Errorf("Unable to detect elastic pool of database %s: %s", c.database, err)
+	}
+	t.rollup = &rollupSample{values: values, elasticPool: pool}
+}
+
+func describeRollups(ch chan<- *prometheus.Desc) {
+	for _, r := range rollupResources {
+		ch <- r.serverMax
+		ch <- r.serverAvg
+		ch <- r.poolMax
+		ch <- r.poolAvg
+	}
+	ch <- rollupServerDatabases
+}
+
+// collectRollups aggregates the last samples of all databases that were scraped successfully by server and by
+// elastic pool, and counts the databases of every server by health.
+func (e *Exporter) collectRollups(ch chan<- prometheus.Metric) {
+	type poolKey struct{ server, pool string }
+	servers := map[string]map[string]*rollup{}
+	pools := map[poolKey]map[string]*rollup{}
+	healths := map[string]map[string]int{}
+	for _, t := range e.allTargets() {
+		if healths[t.Server] == nil {
+			healths[t.Server] = map[string]int{}
+		}
+		healths[t.Server][t.status().Health()]++
+		t.mutex.Lock()
+		sample := t.rollup
+		t.mutex.Unlock()
+		if sample == nil {
+			continue
+		}
+		if servers[t.Server] == nil {
+			servers[t.Server] = map[string]*rollup{}
+		}
+		addRollupSample(servers[t.Server], sample)
+		if sample.elasticPool == "" {
+			continue
+		}
+		key := poolKey{t.Server, sample.elasticPool}
+		if pools[key] == nil {
+			pools[key] = map[string]*rollup{}
+		}
+		addRollupSample(pools[key], sample)
+	}
+	for _, r := range rollupResources {
+		for server, rollups := range servers {
+			if v := rollups[r.column]; v != nil {
+				ch <- prometheus.MustNewConstMetric(r.serverMax, prometheus.GaugeValue, v.max, server)
+				ch <- prometheus.MustNewConstMetric(r.serverAvg, prometheus.GaugeValue, v.sum/float64(v.count), server)
+			}
+		}
+		for key, rollups := range pools {
+			if v := rollups[r.column]; v != nil {
+				ch <- prometheus.MustNewConstMetric(r.poolMax, prometheus.GaugeValue, v.max, key.server, key.pool)
+				ch <- prometheus.MustNewConstMetric(r.poolAvg, prometheus.GaugeValue, v.sum/float64(v.count), key.server, key.pool)
+			}
+		}
+	}
+	for server, counts := range healths {
+		for _, health := range rollupHealths {
+			ch <- prometheus.MustNewConstMetric(rollupServerDatabases, prometheus.GaugeValue, float64(counts[health]), server, health)
+		}
+	}
+}
+
+// addRollupSample adds the values of sample to the rollups of a group of databases by column.
+func addRollupSample(rollups map[string]*rollup, sample *rollupSample) {
+	for column, v := range sample.values {
+		if rollups[column] == nil {
+			rollups[column] = &rollup{}
+		}
+		rollups[column].add(v)
+	}
+}
@@ -0,0 +1,152 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/log"
+)
+
+// scrapeResult is the outcome of an on-demand scrape of a database as served by ScrapeHandler.
+type scrapeResult struct {
+	Server     string            `json:"server"`
+	Database   string            `json:"database"`
+	TraceID    string            `json:"trace_id"`
+	Duration   float64           `json:"duration_seconds"`
+	Error      string            `json:"error,omitempty"`
+	Collectors map[string]string `json:"collectors,omitempty"`
+	Metrics    []scrapedMetric   `json:"metrics"`
+}
+
+// scrapedMetric is a sample of a metric of the scraped database.
+type scrapedMetric struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// metricList is a collector of fixed metrics. It describes nothing, so registries accept it unchecked.
+type metricList []prometheus.Metric
+
+func (metricList) Describe(chan<- *prometheus.Desc) {}
+
+func (l metricList) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range l {
+		ch <- m
+	}
+}
+
+// ScrapeHandler scrapes the database given by the database and optional server query parameters right away,
+// regardless of its minimum scrape interval and backoff, and responds with its metrics and errors as JSON. Scrapes
+// of disabled databases are refused.
+func (e *Exporter) ScrapeHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := e.findTarget(r.URL.Query().Get("database"), r.URL.Query().Get("server"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if status := t.status(); status.Disabled() {
+		http.Error(w, fmt.Sprintf("scraping %s on %s is disabled until %s", t.Name, t.Server, status.DisabledUntil.Format(time.RFC3339)), http.StatusConflict)
+		return
+	}
+	e.scrapes.Add(1)
+	defer e.scrapes.Done()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if e.ctx.Err() != nil {
+		http.Error(w, "exporter is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	log.Infof("Scraping %s on demand", t.Database)
+	start := time.Now()
+	traceID, err := e.scrape(t)
+	result := scrapeResult{
+		Server:     t.Server,
+		Database:   t.Name,
+		TraceID:    traceID,
+		Duration:   time.Since(start).Seconds(),
+		Collectors: t.states,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if result.Metrics, err = e.targetMetrics(t); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// targetMetrics returns the current metrics of the target's database: its resource and health gauges and the
+// metrics of its collectors. The caller must hold the target's mutex.
+func (e *Exporter) targetMetrics(t *target) ([]scrapedMetric, error) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		for _, vec := range append(e.resourceGauges(), e.dbUp, e.dbPaused, e.dbMaintenance, e.dbMissing, e.firewallBlocked, e.permissionError, e.scrapeError) {
+			vec.Collect(ch)
+		}
+		close(ch)
+	}()
+	labels := t.ConstLabels(e.extraLabels)
+	labels["server"], labels["database"] = t.Server, t.Name
+	var metrics metricList
+	for m := range ch {
+		var pb dto.Metric
+		// Gauges of a vector always write successfully.
+		m.Write(&pb)
+		if hasLabels(pb.Label, labels) {
+			metrics = append(metrics, m)
+		}
+	}
+	for _, ms := range t.metrics {
+		metrics = append(metrics, ms...)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(metrics)
+	families, err := reg.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("unable to gather metrics of %s: %s", t.Database, err)
+	}
+	var samples []scrapedMetric
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			s := scrapedMetric{Name: mf.GetName(), Labels: map[string]string{}}
+			for _, l := range m.Label {
+				s.Labels[l.GetName()] = l.GetValue()
+			}
+			switch {
+			case m.Gauge != nil:
+				s.Value = m.Gauge.GetValue()
+			case m.Counter != nil:
+				s.Value = m.Counter.GetValue()
+			case m.Untyped != nil:
+				s.Value = m.Untyped.GetValue()
+			default:
+				continue
+			}
+			samples = append(samples, s)
+		}
+	}
+	return samples, nil
+}
+
+// hasLabels reports whether the label pairs contain all labels with their values.
+func hasLabels(pairs []*dto.LabelPair, labels map[string]string) bool {
+	found := 0
+	for _, p := range pairs {
+		if value, ok := labels[p.GetName()]; ok {
+			if value != p.GetValue() {
+				return false
+			}
+			found++
+		}
+	}
+	return found == len(labels)
+}
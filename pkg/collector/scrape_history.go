@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/log"
+)
+
+var (
+	// ScrapeHistorySize is the number of recent scrape attempts kept for /api/v1/scrapes. 0 disables the history.
+	ScrapeHistorySize = 1000
+)
+
+// Outcome of a scrape attempt that didn't query the database.
+const scrapeSkipped = "skipped"
+
+// scrapeAttempt is an entry of the scrape history, describing one scrape of a database or why it was skipped.
+type scrapeAttempt struct {
+	Time     time.Time `json:"time"`
+	Server   string    `json:"server"`
+	Database string    `json:"database"`
+	TraceID  string    `json:"trace_id,omitempty"`
+	Duration float64   `json:"duration_seconds"`
+	Outcome  string    `json:"outcome"`
+	// Reason is why a skipped scrape was skipped: disabled, maintenance or backoff.
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// scrapeHistory keeps the last scrape attempts of all databases in a ring buffer, and optionally appends them to a
+// log as JSON, so what the exporter did during an incident can be reconstructed after the fact.
+type scrapeHistory struct {
+	mutex    sync.Mutex
+	attempts []scrapeAttempt
+	// next is the index of the ring buffer the next attempt is stored at.
+	next int
+	// full is true once the ring buffer wrapped around.
+	full bool
+	enc  *json.Encoder
+}
+
+func newScrapeHistory(size int) *scrapeHistory {
+	if size < 0 {
+		size = 0
+	}
+	return &scrapeHistory{attempts: make([]scrapeAttempt, size)}
+}
+
+// SetScrapeLog appends a JSON record of every scrape attempt to the file at path, or to stdout if path is "-".
+func (e *Exporter) SetScrapeLog(path string) error {
+	enc, err := openJSONLog(path)
+	if err != nil {
+		return fmt.Errorf("unable to open scrape log %s: %s", path, err)
+	}
+	e.history.mutex.Lock()
+	defer e.history.mutex.Unlock()
+	e.history.enc = enc
+	return nil
+}
+
+// record adds an attempt to scrape d that started at start, took duration and failed with err, if not nil.
+func (h *scrapeHistory) record(d config.Database, start time.Time, traceID string, duration time.Duration, err error) {
+	a := scrapeAttempt{
+		Time:     start.UTC(),
+		Server:   d.Server,
+		Database: d.Name,
+		TraceID:  traceID,
+		Duration: duration.Seconds(),
+		Outcome:  scrapeSuccess,
+	}
+	if err != nil {
+		a.Outcome, a.Error = scrapeFailure, err.Error()
+	}
+	h.add(a)
+}
+
+// recordSkip adds a scrape of d that was skipped for reason.
+func (h *scrapeHistory) recordSkip(d config.Database, reason string) {
+	h.add(scrapeAttempt{Time: time.Now().UTC(), Server: d.Server, Database: d.Name, Outcome: scrapeSkipped, Reason: reason})
+}
+
+func (h *scrapeHistory) add(a scrapeAttempt) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.enc != nil {
+		if err := h.enc.Encode(a); err != nil {
+			log.Errorf("Failed to write scrape record: %s", err)
+		}
+	}
+	if len(h.attempts) == 0 {
+		return
+	}
+	h.attempts[h.next] = a
+	h.next = (h.next + 1) % len(h.attempts)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// matching returns the attempts accepted by match, oldest first, at most the last limit of them if limit is
+// positive.
+func (h *scrapeHistory) matching(match func(scrapeAttempt) bool, limit int) []scrapeAttempt {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	ordered := h.attempts[:h.next]
+	if h.full {
+		ordered = append(append([]scrapeAttempt{}, h.attempts[h.next:]...), h.attempts[:h.next]...)
+	}
+	attempts := []scrapeAttempt{}
+	for _, a := range ordered {
+		if match(a) {
+			attempts = append(attempts, a)
+		}
+	}
+	if limit > 0 && len(attempts) > limit {
+		attempts = attempts[len(attempts)-limit:]
+	}
+	return attempts
+}
+
+// apiScrapes is the data of the response of the scrapes API.
+type apiScrapes struct {
+	Scrapes []scrapeAttempt `json:"scrapes"`
+}
+
+// ScrapesAPIHandler serves the recent scrape attempts as JSON, oldest first, in the envelope of the Prometheus HTTP
+// API. The optional database and server query parameters select the attempts of a database, and limit the number
+// of the most recent attempts returned.
+func (e *Exporter) ScrapesAPIHandler(w http.ResponseWriter, r *http.Request) {
+	database, server := r.URL.Query().Get("database"), r.URL.Query().Get("server")
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", l), http.StatusBadRequest)
+			return
+		}
+	}
+	attempts := e.history.matching(func(a scrapeAttempt) bool {
+		return (database == "" || a.Database == database) && (server == "" || a.Server == server)
+	}, limit)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiResponse{Status: "success", Data: apiScrapes{Scrapes: attempts}})
+}
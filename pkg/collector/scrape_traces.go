@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+// Results of a scrape of a database.
+const (
+	scrapeSuccess = "success"
+	scrapeFailure = "failure"
+)
+
+// scrapeCount counts the scrapes of a database with a result. The trace ID of the last one is exported as
+// exemplar, with the duration of the scrape as value, so a failed or slow scrape can be looked up from a dashboard.
+type scrapeCount struct {
+	count    float64
+	traceID  string
+	duration time.Duration
+	time     time.Time
+}
+
+// newTraceID returns a random trace ID in the W3C trace context format, 32 lower case hex digits.
+func newTraceID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		log.Warnf("Unable to generate trace ID: %s", err)
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// recordScrape counts a scrape of the target's database that took duration and failed with err, if not nil.
+func (t *target) recordScrape(err error, traceID string, duration time.Duration) {
+	result := scrapeSuccess
+	if err != nil {
+		result = scrapeFailure
+	}
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+	if t.scrapeCounts == nil {
+		t.scrapeCounts = map[string]*scrapeCount{}
+	}
+	c, ok := t.scrapeCounts[result]
+	if !ok {
+		c = &scrapeCount{}
+		t.scrapeCounts[result] = c
+	}
+	c.count++
+	c.traceID = traceID
+	c.duration = duration
+	c.time = time.Now()
+}
+
+// collectScrapes sends the scrape counters of the target's database with the exemplar of their last scrape.
+func (e *Exporter) collectScrapes(t *target, ch chan<- prometheus.Metric) {
+	labels := t.LabelValues(e.extraLabels)
+	t.statusMutex.RLock()
+	defer t.statusMutex.RUnlock()
+	for result, c := range t.scrapeCounts {
+		m := prometheus.MustNewConstMetric(e.scrapesDesc, prometheus.CounterValue, c.count, append(labels, result)...)
+		m, err := prometheus.NewMetricWithExemplars(m, prometheus.Exemplar{
+			Value:     c.duration.Seconds(),
+			Labels:    prometheus.Labels{"trace_id": c.traceID},
+			Timestamp: c.time,
+		})
+		if err != nil {
+			log.Errorf("Invalid exemplar for %s: %s", t.Database, err)
+			continue
+		}
+		ch <- m
+	}
+}
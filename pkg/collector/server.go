@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serverResourceStatsQuery returns the latest row of master.sys.resource_stats, which is aggregated over 5
+// minutes, for every database of the server.
+const serverResourceStatsQuery = `SELECT rs.database_name, rs.avg_cpu_percent, rs.avg_data_io_percent, rs.avg_log_write_percent, rs.storage_in_megabytes
+FROM sys.resource_stats rs
+JOIN (SELECT database_name, MAX(end_time) AS end_time FROM sys.resource_stats GROUP BY database_name) latest
+ON latest.database_name = rs.database_name AND latest.end_time = rs.end_time`
+
+// elasticPoolStatsQuery returns the latest row of sys.elastic_pool_resource_stats for every elastic pool of the
+// server.
+const elasticPoolStatsQuery = `SELECT ps.elastic_pool_name, ps.avg_cpu_percent, ps.avg_data_io_percent, ps.avg_log_write_percent, ps.avg_storage_percent
+FROM sys.elastic_pool_resource_stats ps
+JOIN (SELECT elastic_pool_name, MAX(end_time) AS end_time FROM sys.elastic_pool_resource_stats GROUP BY elastic_pool_name) latest
+ON latest.elastic_pool_name = ps.elastic_pool_name AND latest.end_time = ps.end_time`
+
+const databaseStatesQuery = `SELECT name, state_desc FROM sys.databases`
+
+// connectionEventsQuery counts the connection events of the last hour, e.g. connections blocked by the firewall.
+const connectionEventsQuery = `SELECT database_name, event_type, SUM(event_count)
+FROM sys.event_log
+WHERE start_time > DATEADD(hour, -1, GETUTCDATE())
+GROUP BY database_name, event_type`
+
+// serverScraper replaces the resource stats of a database for targets of type server. It queries the master
+// database for the resource usage of every database and elastic pool of the logical server, the states of its
+// databases and its connection events.
+type serverScraper struct {
+	cpuPercent      *prometheus.Desc
+	dataIO          *prometheus.Desc
+	logIO           *prometheus.Desc
+	storage         *prometheus.Desc
+	poolCPUPercent  *prometheus.Desc
+	poolDataIO      *prometheus.Desc
+	poolLogIO       *prometheus.Desc
+	poolStorage     *prometheus.Desc
+	databaseState   *prometheus.Desc
+	connectionEvent *prometheus.Desc
+}
+
+func newServerScraper(labels prometheus.Labels) scraper {
+	return serverScraper{
+		cpuPercent:      newDesc("server_db_cpu_percent", "Average CPU utilization of a database of the server in the last interval of sys.resource_stats.", labels, "db_name"),
+		dataIO:          newDesc("server_db_data_io_percent", "Average data IO utilization of a database of the server in the last interval of sys.resource_stats.", labels, "db_name"),
+		logIO:           newDesc("server_db_log_write_percent", "Average log write utilization of a database of the server in the last interval of sys.resource_stats.", labels, "db_name"),
+		storage:         newDesc("server_db_storage_bytes", "Storage used by a database of the server.", labels, "db_name"),
+		poolCPUPercent:  newDesc("elastic_pool_cpu_percent", "Average CPU utilization of an elastic pool in the last interval of sys.elastic_pool_resource_stats.", labels, "elastic_pool"),
+		poolDataIO:      newDesc("elastic_pool_data_io_percent", "Average data IO utilization of an elastic pool in the last interval of sys.elastic_pool_resource_stats.", labels, "elastic_pool"),
+		poolLogIO:       newDesc("elastic_pool_log_write_percent", "Average log write utilization of an elastic pool in the last interval of sys.elastic_pool_resource_stats.", labels, "elastic_pool"),
+		poolStorage:     newDesc("elastic_pool_storage_percent", "Storage utilization of an elastic pool in percent of its limit.", labels, "elastic_pool"),
+		databaseState:   newDesc("server_database_state", "State of a database of the server from sys.databases. Always 1.", labels, "db_name", "state"),
+		connectionEvent: newDesc("server_connection_events", "Number of connection events of the last hour from sys.event_log by database and event type, e.g. blocked_by_firewall.", labels, "db_name", "event_type"),
+	}
+}
+
+func (s serverScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.cpuPercent
+	ch <- s.dataIO
+	ch <- s.logIO
+	ch <- s.storage
+	ch <- s.poolCPUPercent
+	ch <- s.poolDataIO
+	ch <- s.poolLogIO
+	ch <- s.poolStorage
+	ch <- s.databaseState
+	ch <- s.connectionEvent
+}
+
+// Scrape queries every section the login has the permissions for. Sections it lacks the permissions for, e.g.
+// sys.event_log without access to master, are skipped and reported by the capability metric.
+func (s serverScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	server, name := c.database.Server, c.database.Name
+	err := c.capability("server_resource_stats", func() error {
+		return c.query(func(r resultRow) error {
+			var dbName string
+			var cpu, data, logio, storage float64
+			if err := r.Scan(&dbName, &cpu, &data, &logio, &storage); err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(s.cpuPercent, prometheus.GaugeValue, cpu, server, name, dbName)
+			ch <- prometheus.MustNewConstMetric(s.dataIO, prometheus.GaugeValue, data, server, name, dbName)
+			ch <- prometheus.MustNewConstMetric(s.logIO, prometheus.GaugeValue, logio, server, name, dbName)
+			ch <- prometheus.MustNewConstMetric(s.storage, prometheus.GaugeValue, storage*1024*1024, server, name, dbName)
+			return nil
+		}, serverResourceStatsQuery)
+	}, nil)
+	if err != nil {
+		return err
+	}
+	err = c.capability("elastic_pool_stats", func() error {
+		return c.query(func(r resultRow) error {
+			var pool string
+			var cpu, data, logio, storage float64
+			if err := r.Scan(&pool, &cpu, &data, &logio, &storage); err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(s.poolCPUPercent, prometheus.GaugeValue, cpu, server, name, pool)
+			ch <- prometheus.MustNewConstMetric(s.poolDataIO, prometheus.GaugeValue, data, server, name, pool)
+			ch <- prometheus.MustNewConstMetric(s.poolLogIO, prometheus.GaugeValue, logio, server, name, pool)
+			ch <- prometheus.MustNewConstMetric(s.poolStorage, prometheus.GaugeValue, storage, server, name, pool)
+			return nil
+		}, elasticPoolStatsQuery)
+	}, nil)
+	if err != nil {
+		return err
+	}
+	err = c.query(func(r resultRow) error {
+		var dbName, state string
+		if err := r.Scan(&dbName, &state); err != nil {
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(s.databaseState, prometheus.GaugeValue, 1, server, name, dbName, state)
+		return nil
+	}, databaseStatesQuery)
+	if err != nil {
+		return err
+	}
+	return c.capability("connection_events", func() error {
+		return c.query(func(r resultRow) error {
+			var dbName, eventType string
+			var count float64
+			if err := r.Scan(&dbName, &eventType, &count); err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(s.connectionEvent, prometheus.GaugeValue, count, server, name, dbName, eventType)
+			return nil
+		}, connectionEventsQuery)
+	}, nil)
+}
@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sessionOriginsQuery counts the user sessions of the database by where they come from, except the exporter's own.
+const sessionOriginsQuery = `SELECT ISNULL(login_name, ''), ISNULL(host_name, ''), ISNULL(program_name, ''), COUNT(*)
+FROM sys.dm_exec_sessions
+WHERE is_user_process = 1 AND database_id = DB_ID() AND session_id <> @@SPID
+GROUP BY login_name, host_name, program_name`
+
+// sessionOriginsScraper exports the number of sessions by login, client host and program. The label values are
+// subject to the redaction configured in the config file.
+type sessionOriginsScraper struct {
+	sessions *prometheus.Desc
+}
+
+func init() {
+	registerScraper("session_origins", newSessionOriginsScraper)
+}
+
+func newSessionOriginsScraper(labels prometheus.Labels) scraper {
+	return sessionOriginsScraper{
+		sessions: newDesc("sessions", "Number of user sessions by login, client host and program.", labels, "login_name", "host_name", "program_name"),
+	}
+}
+
+func (s sessionOriginsScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.sessions
+}
+
+func (s sessionOriginsScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	counts := newRedactedCounts("login_name", "host_name", "program_name")
+	err := c.query(func(rows resultRow) error {
+		var login, host, program string
+		var count float64
+		if err := rows.Scan(&login, &host, &program, &count); err != nil {
+			return err
+		}
+		counts.add(count, login, host, program)
+		return nil
+	}, sessionOriginsQuery)
+	if err != nil {
+		return err
+	}
+	counts.each(func(count float64, values []string) {
+		ch <- prometheus.MustNewConstMetric(s.sessions, prometheus.GaugeValue, count, append([]string{c.database.Server, c.database.Name}, values...)...)
+	})
+	return nil
+}
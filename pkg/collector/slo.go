@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultSLOWindow is the window an availability objective applies to if it doesn't set one.
+const defaultSLOWindow = 30 * 24 * time.Hour
+
+// sloBucket counts the scrapes of one minute.
+type sloBucket struct {
+	minute int64
+	total  float64
+	errors float64
+}
+
+// sloTracker counts the available and unavailable scrapes of a database over the window of its objective and
+// exports the error budget the way SLO generators such as Sloth record it. The counts only cover the lifetime of
+// the exporter process.
+type sloTracker struct {
+	slo config.SLO
+
+	objective       *prometheus.Desc
+	events          *prometheus.Desc
+	errorEvents     *prometheus.Desc
+	errorRatio      *prometheus.Desc
+	budgetRemaining *prometheus.Desc
+
+	mutex       sync.Mutex
+	buckets     []sloBucket
+	total       float64
+	errorsTotal float64
+}
+
+func newSLOTracker(slo config.SLO, labels prometheus.Labels) *sloTracker {
+	if slo.Window <= 0 {
+		slo.Window = defaultSLOWindow
+	}
+	return &sloTracker{
+		slo:             slo,
+		objective:       newDesc("slo_objective_ratio", "Availability objective of the database.", labels),
+		events:          newDesc("slo_events_total", "Number of scrapes evaluated for the availability objective.", labels),
+		errorEvents:     newDesc("slo_error_events_total", "Number of scrapes that found the database unavailable.", labels),
+		errorRatio:      newDesc("slo_sli_error_ratio", "Ratio of scrapes that found the database unavailable over the window of the objective.", labels),
+		budgetRemaining: newDesc("slo_period_error_budget_remaining_ratio", "Ratio of the error budget of the window of the objective that is left. Negative once the budget is exhausted.", labels),
+	}
+}
+
+func (s *sloTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.objective
+	ch <- s.events
+	ch <- s.errorEvents
+	ch <- s.errorRatio
+	ch <- s.budgetRemaining
+}
+
+// record adds the outcome of a scrape at now.
+func (s *sloTracker) record(now time.Time, available bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	minute := now.Unix() / 60
+	if n := len(s.buckets); n == 0 || s.buckets[n-1].minute != minute {
+		s.buckets = append(s.buckets, sloBucket{minute: minute})
+	}
+	b := &s.buckets[len(s.buckets)-1]
+	b.total++
+	s.total++
+	if !available {
+		b.errors++
+		s.errorsTotal++
+	}
+	oldest := now.Add(-s.slo.Window).Unix() / 60
+	i := 0
+	for i < len(s.buckets) && s.buckets[i].minute < oldest {
+		i++
+	}
+	s.buckets = s.buckets[i:]
+}
+
+func (s *sloTracker) Collect(d config.Database, ch chan<- prometheus.Metric) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var total, errors float64
+	for _, b := range s.buckets {
+		total += b.total
+		errors += b.errors
+	}
+	ch <- prometheus.MustNewConstMetric(s.objective, prometheus.GaugeValue, s.slo.Objective, d.Server, d.Name)
+	ch <- prometheus.MustNewConstMetric(s.events, prometheus.CounterValue, s.total, d.Server, d.Name)
+	ch <- prometheus.MustNewConstMetric(s.errorEvents, prometheus.CounterValue, s.errorsTotal, d.Server, d.Name)
+	if total == 0 {
+		return
+	}
+	ratio := errors / total
+	ch <- prometheus.MustNewConstMetric(s.errorRatio, prometheus.GaugeValue, ratio, d.Server, d.Name)
+	ch <- prometheus.MustNewConstMetric(s.budgetRemaining, prometheus.GaugeValue, 1-ratio/(1-s.slo.Objective), d.Server, d.Name)
+}
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sloCollector collects the metrics of the SLO tracker of a database.
+type sloCollector struct {
+	slo *sloTracker
+	db  config.Database
+}
+
+func (c sloCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.slo.Describe(ch)
+}
+
+func (c sloCollector) Collect(ch chan<- prometheus.Metric) {
+	c.slo.Collect(c.db, ch)
+}
+
+func TestSLOErrorBudget(t *testing.T) {
+	s := newSLOTracker(config.SLO{Objective: 0.75, Window: time.Hour}, nil)
+	c := sloCollector{s, sales}
+	labels := map[string]string{"server": sales.Server, "database": sales.Name}
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	record := func(at time.Duration, available, unavailable int) {
+		for i := 0; i < available; i++ {
+			s.record(start.Add(at), true)
+		}
+		for i := 0; i < unavailable; i++ {
+			s.record(start.Add(at), false)
+		}
+	}
+	expect := func(events, errors, ratio, remaining float64) {
+		t.Helper()
+		families := gather(t, c)
+		expectValue(t, families, "azure_sql_slo_objective_ratio", labels, 0.75)
+		expectValue(t, families, "azure_sql_slo_events_total", labels, events)
+		expectValue(t, families, "azure_sql_slo_error_events_total", labels, errors)
+		for name, want := range map[string]float64{"azure_sql_slo_sli_error_ratio": ratio, "azure_sql_slo_period_error_budget_remaining_ratio": remaining} {
+			if got, _ := value(families, name, labels); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s = %v, want %v", name, got, want)
+			}
+		}
+	}
+
+	// Without scrapes there is no ratio yet.
+	families := gather(t, c)
+	expectValue(t, families, "azure_sql_slo_events_total", labels, 0)
+	expectAbsent(t, families, "azure_sql_slo_sli_error_ratio", labels)
+
+	// One error in eight scrapes uses half of the budget of a 75% objective.
+	record(0, 7, 1)
+	expect(8, 1, 0.125, 0.5)
+	// Two more errors exhaust it.
+	record(30*time.Minute, 0, 2)
+	expect(10, 3, 0.3, -0.2)
+	// Scrapes older than the window no longer count, but the events still do.
+	record(90*time.Minute, 2, 0)
+	expect(12, 3, 0.5, -1)
+	record(3*time.Hour, 4, 0)
+	expect(16, 3, 0, 1)
+}
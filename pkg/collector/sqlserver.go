@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sqlServerResourceStatsQuery returns the resource stats of an on-premises or IaaS SQL Server, which has no
+// sys.dm_db_resource_stats, under the column names of sys.dm_db_resource_stats, so they are exported as the same
+// gauges: the CPU usage of all resource pools in percent of the CPU of the machine and the memory of the buffer
+// manager in percent of the memory it targets. The counters are sampled continuously by SQL Server, so end_time is
+// the current time.
+const sqlServerResourceStatsQuery = `SELECT
+	100.0 * SUM(CASE WHEN object_name LIKE '%:Resource Pool Stats' AND counter_name = 'CPU usage %' THEN cntr_value END)
+		/ NULLIF(MAX(CASE WHEN object_name LIKE '%:Resource Pool Stats' AND counter_name = 'CPU usage % base' THEN cntr_value END), 0) AS avg_cpu_percent,
+	100.0 * MAX(CASE WHEN object_name LIKE '%:Memory Manager' AND counter_name = 'Total Server Memory (KB)' THEN cntr_value END)
+		/ NULLIF(MAX(CASE WHEN object_name LIKE '%:Memory Manager' AND counter_name = 'Target Server Memory (KB)' THEN cntr_value END), 0) AS avg_memory_usage_percent,
+	SYSUTCDATETIME() AS end_time
+FROM sys.dm_os_performance_counters
+WHERE object_name LIKE '%:Resource Pool Stats' OR object_name LIKE '%:Memory Manager'`
+
+// sqlServerIOQuery returns the cumulative disk IO of all resource pools of a SQL Server instance. Despite their
+// names, the /sec counters are totals since the instance started.
+const sqlServerIOQuery = `SELECT RTRIM(counter_name), SUM(cntr_value)
+FROM sys.dm_os_performance_counters
+WHERE object_name LIKE '%:Resource Pool Stats'
+AND counter_name IN ('Disk Read Bytes/sec', 'Disk Write Bytes/sec', 'Disk Read IO/sec', 'Disk Write IO/sec')
+GROUP BY counter_name`
+
+// sqlServerScraper exports the disk IO of the instance of an on-premises or IaaS SQL Server, for which Azure SQL
+// Database reports avg_data_io_percent in its resource stats.
+type sqlServerScraper struct {
+	descs    map[string]*prometheus.Desc
+	counters *counterResets
+}
+
+func newSQLServerScraper(labels prometheus.Labels) scraper {
+	return sqlServerScraper{
+		descs: map[string]*prometheus.Desc{
+			"disk read bytes/sec":  newDesc("io_read_bytes_total", "Bytes read from disk by the SQL Server instance.", labels),
+			"disk write bytes/sec": newDesc("io_written_bytes_total", "Bytes written to disk by the SQL Server instance.", labels),
+			"disk read io/sec":     newDesc("io_reads_total", "Number of disk reads of the SQL Server instance.", labels),
+			"disk write io/sec":    newDesc("io_writes_total", "Number of disk writes of the SQL Server instance.", labels),
+		},
+		counters: newCounterResets(),
+	}
+}
+
+func (s sqlServerScraper) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range s.descs {
+		ch <- desc
+	}
+}
+
+func (s sqlServerScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	return c.query(func(rows resultRow) error {
+		var name string
+		var value float64
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		if desc, ok := s.descs[strings.ToLower(name)]; ok {
+			ch <- s.counters.metric(desc, value, c.database.Server, c.database.Name)
+		}
+		return nil
+	}, sqlServerIOQuery)
+}
@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// StorageGrowthWindow is the time window over which the storage_growth collector computes the growth rate of the
+	// used storage.
+	StorageGrowthWindow = 24 * time.Hour
+)
+
+const storageUsedQuery = `SELECT SUM(CAST(FILEPROPERTY(name, 'SpaceUsed') AS bigint)) * 8192, CAST(DATABASEPROPERTYEX(DB_NAME(), 'MaxSizeInBytes') AS bigint)
+FROM sys.database_files
+WHERE type_desc = 'ROWS'`
+
+// storageSample is the used storage of a database at a point in time.
+type storageSample struct {
+	time  time.Time
+	bytes float64
+}
+
+// storageGrowthScraper exports the storage used by the data files of the database and its growth rate over
+// --collector.storage_growth.window, computed from the samples of previous scrapes so "days until full" alerts
+// don't need long retention in Prometheus.
+type storageGrowthScraper struct {
+	used   *prometheus.Desc
+	max    *prometheus.Desc
+	growth *prometheus.Desc
+
+	// Scrapers are created per database and only a single scrape of a database runs at a time, so the samples
+	// don't need locking.
+	samples []storageSample
+}
+
+func init() {
+	registerScraper("storage_growth", newStorageGrowthScraper)
+}
+
+func newStorageGrowthScraper(labels prometheus.Labels) scraper {
+	return &storageGrowthScraper{
+		used:   newDesc("storage_used_bytes", "Space used by the data files of the database.", labels),
+		max:    newDesc("storage_max_bytes", "Maximum size of the database.", labels),
+		growth: newDesc("storage_growth_bytes_per_hour", "Growth rate of the space used by the data files of the database over -collector.storage_growth.window.", labels),
+	}
+}
+
+func (s *storageGrowthScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.used
+	ch <- s.max
+	ch <- s.growth
+}
+
+func (s *storageGrowthScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	server, name := c.database.Server, c.database.Name
+	return c.query(func(rows resultRow) error {
+		var used, max float64
+		if err := rows.Scan(&used, &max); err != nil {
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(s.used, prometheus.GaugeValue, used, server, name)
+		ch <- prometheus.MustNewConstMetric(s.max, prometheus.GaugeValue, max, server, name)
+		if rate, ok := s.record(storageSample{time: time.Now(), bytes: used}); ok {
+			ch <- prometheus.MustNewConstMetric(s.growth, prometheus.GaugeValue, rate, server, name)
+		}
+		return nil
+	}, storageUsedQuery)
+}
+
+// record adds sample and drops the samples that fell out of the window. It returns the growth rate in bytes per
+// hour between the oldest sample in the window and sample, or false while there is only a single sample.
+func (s *storageGrowthScraper) record(sample storageSample) (float64, bool) {
+	s.samples = append(s.samples, sample)
+	cutoff := sample.time.Add(-StorageGrowthWindow)
+	i := 0
+	for i < len(s.samples)-1 && s.samples[i].time.Before(cutoff) {
+		i++
+	}
+	s.samples = s.samples[i:]
+	oldest := s.samples[0]
+	elapsed := sample.time.Sub(oldest.time).Hours()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	return (sample.bytes - oldest.bytes) / elapsed, true
+}
@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// synapseServiceObjectiveQuery returns the DWU level of the dedicated SQL pool, e.g. DW100c.
+const synapseServiceObjectiveQuery = `SELECT CAST(DATABASEPROPERTYEX(DB_NAME(), 'ServiceObjective') AS nvarchar(128))`
+
+// synapseRequestsQuery counts the running requests and the requests queued for a concurrency slot, which are
+// suspended until one is free.
+const synapseRequestsQuery = `SELECT status, COUNT(*)
+FROM sys.dm_pdw_exec_requests
+WHERE status IN ('Running', 'Suspended') AND session_id <> SESSION_ID()
+GROUP BY status`
+
+// synapseResourcePoolsQuery returns the resource usage of the resource governor pools summed over all nodes of
+// the pool.
+const synapseResourcePoolsQuery = `SELECT name, SUM(total_cpu_usage_ms), SUM(used_memory_kb), SUM(max_memory_kb), SUM(active_memgrant_count)
+FROM sys.dm_pdw_nodes_resource_governor_resource_pools
+GROUP BY name`
+
+// synapseScraper replaces the resource stats of Azure SQL Database for dedicated SQL pools of Azure Synapse
+// Analytics, formerly SQL Data Warehouse, which don't have sys.dm_db_resource_stats. It exports the DWU level of
+// the pool, its running and queued requests and the usage of its resource pools from the DW specific DMVs.
+type synapseScraper struct {
+	info           *prometheus.Desc
+	requests       *prometheus.Desc
+	poolCPU        *prometheus.Desc
+	poolMemoryUsed *prometheus.Desc
+	poolMemoryMax  *prometheus.Desc
+	poolMemGrants  *prometheus.Desc
+	counters       *counterResets
+}
+
+func newSynapseScraper(labels prometheus.Labels) scraper {
+	return synapseScraper{
+		info:           newDesc("synapse_info", "Service objective, the DWU level, of the dedicated SQL pool. Always 1.", labels, "service_objective"),
+		requests:       newDesc("synapse_requests", "Number of requests of the dedicated SQL pool by status: running, or queued for a concurrency slot.", labels, "status"),
+		poolCPU:        newDesc("synapse_resource_pool_cpu_seconds_total", "CPU time used by the resource pool on all nodes.", labels, "pool"),
+		poolMemoryUsed: newDesc("synapse_resource_pool_memory_used_bytes", "Memory used by the resource pool on all nodes.", labels, "pool"),
+		poolMemoryMax:  newDesc("synapse_resource_pool_memory_max_bytes", "Maximum memory the resource pool can use on all nodes.", labels, "pool"),
+		poolMemGrants:  newDesc("synapse_resource_pool_memory_grants", "Number of active memory grants of the resource pool on all nodes.", labels, "pool"),
+		counters:       newCounterResets(),
+	}
+}
+
+func (s synapseScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.info
+	ch <- s.requests
+	ch <- s.poolCPU
+	ch <- s.poolMemoryUsed
+	ch <- s.poolMemoryMax
+	ch <- s.poolMemGrants
+}
+
+func (s synapseScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	server, name := c.database.Server, c.database.Name
+	err := c.query(func(r resultRow) error {
+		var objective sql.NullString
+		if err := r.Scan(&objective); err != nil {
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(s.info, prometheus.GaugeValue, 1, server, name, objective.String)
+		return nil
+	}, synapseServiceObjectiveQuery)
+	if err != nil {
+		return err
+	}
+	counts := map[string]float64{"running": 0, "queued": 0}
+	err = c.query(func(r resultRow) error {
+		var status string
+		var count float64
+		if err := r.Scan(&status, &count); err != nil {
+			return err
+		}
+		if strings.EqualFold(status, "Suspended") {
+			counts["queued"] += count
+		} else {
+			counts["running"] += count
+		}
+		return nil
+	}, synapseRequestsQuery)
+	if err != nil {
+		return err
+	}
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(s.requests, prometheus.GaugeValue, count, server, name, status)
+	}
+	return c.query(func(r resultRow) error {
+		var pool string
+		var cpuMs, usedKB, maxKB, grants float64
+		if err := r.Scan(&pool, &cpuMs, &usedKB, &maxKB, &grants); err != nil {
+			return err
+		}
+		ch <- s.counters.metric(s.poolCPU, cpuMs/1000, server, name, pool)
+		ch <- prometheus.MustNewConstMetric(s.poolMemoryUsed, prometheus.GaugeValue, usedKB*1024, server, name, pool)
+		ch <- prometheus.MustNewConstMetric(s.poolMemoryMax, prometheus.GaugeValue, maxKB*1024, server, name, pool)
+		ch <- prometheus.MustNewConstMetric(s.poolMemGrants, prometheus.GaugeValue, grants, server, name, pool)
+		return nil
+	}, synapseResourcePoolsQuery)
+}
@@ -0,0 +1,376 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+// target tracks the scrape state of a single configured database.
+type target struct {
+	config.Database
+	// mutex serializes scrapes of the database.
+	mutex      sync.Mutex
+	lastScrape time.Time
+	// metrics holds the metrics produced during the last scrape by the optional collectors and the instance
+	// level collector, by collector name.
+	metrics map[string][]prometheus.Metric
+	// instance collects the instance level metrics of a Managed Instance or the server level metrics of a
+	// logical server in place of the resource stats.
+	instance scraper
+	// resourceQuery is the query of the resource stats of the database, unless the config overrides it.
+	resourceQuery string
+	// host collects the instance level metrics of an on-premises or IaaS SQL Server on top of its resource stats.
+	host scraper
+	// scrapers holds the optional collectors enabled for the database by name.
+	scrapers map[string]scraper
+	// results holds the last result of every optional collector by name.
+	results map[string]scraperResult
+	// refreshes tracks the refreshes of the collectors refreshed in the background by name.
+	refreshes map[string]*backgroundRefresh
+	// states holds the outcome of every optional collector in the last scrape by name.
+	states map[string]string
+	// slo tracks the availability objective of the database, if configured.
+	slo *sloTracker
+	// db is the connection pool of a prewarmed database, kept open between scrapes.
+	db querier
+	// tunnel forwards the connections to the database through an SSH bastion host, if configured.
+	tunnel *tunnel
+	// sourceTime is the end_time of the resource stats of the last scrape, or zero if it failed.
+	sourceTime time.Time
+	// rollup holds the values of the last scrape rolled up by server and elastic pool, or nil if it failed.
+	rollup *rollupSample
+	// caps tracks the queries the exporter lacks the permissions for.
+	caps *capabilities
+	// queries holds the queries the config overrides for the database by the default query they replace.
+	queries map[string]string
+	// removed is closed when the database is removed at runtime, which stops its scrapes and background pings.
+	removed chan struct{}
+	// tierCollectors maps service tiers to the optional collectors to run, if the database doesn't list them.
+	tierCollectors map[string][]string
+	// span is the root span of the scrape in progress, nil if it isn't traced.
+	span *span
+
+	// statusMutex guards the fields below, which are read by the web UI while a scrape may be in progress.
+	statusMutex sync.RWMutex
+	lastError   error
+	failures    int
+	nextRetry   time.Time
+	paused      bool
+	missing     bool
+	lastSuccess time.Time
+	// scrapeCounts counts the scrapes of the database by result.
+	scrapeCounts map[string]*scrapeCount
+	// disabledUntil is the time scraping resumes after being disabled through the API.
+	disabledUntil time.Time
+}
+
+// setStates sets the state of all optional collectors of the target.
+func (t *target) setStates(state string) {
+	t.states = make(map[string]string, len(t.scrapers))
+	for name := range t.scrapers {
+		t.states[name] = state
+	}
+}
+
+// targetStatus is a point in time copy of the health of a target.
+type targetStatus struct {
+	Database  config.Database
+	LastError string
+	Failures  int
+	NextRetry time.Time
+	Paused    bool
+	Missing   bool
+	// LastSuccess is the time of the last successful scrape.
+	LastSuccess time.Time
+	// LastScrape and LastDuration are the time and duration of the last scrape, successful or not.
+	LastScrape   time.Time
+	LastDuration time.Duration
+	// DisabledUntil is the time scraping resumes after being disabled through the API.
+	DisabledUntil time.Time
+	// Maintenance is true while the database is in one of its maintenance windows.
+	Maintenance bool
+}
+
+// Disabled reports whether scraping the target is currently disabled through the API.
+func (s targetStatus) Disabled() bool {
+	return time.Now().Before(s.DisabledUntil)
+}
+
+// BackingOff reports whether scrapes of the target are currently suspended after consecutive failures.
+func (s targetStatus) BackingOff() bool {
+	return time.Now().Before(s.NextRetry)
+}
+
+// Health returns up if the last scrape of the target succeeded, down if it failed and unknown if the target wasn't
+// scraped yet.
+func (s targetStatus) Health() string {
+	switch {
+	case s.LastScrape.IsZero():
+		return "unknown"
+	case s.LastError != "":
+		return "down"
+	}
+	return "up"
+}
+
+// State describes why the target is or isn't scraped.
+func (s targetStatus) State() string {
+	switch {
+	case s.Disabled():
+		return "disabled"
+	case s.Maintenance:
+		return "maintenance"
+	case s.Paused:
+		return "paused"
+	case s.Missing:
+		return "missing"
+	case s.BackingOff():
+		return "backing off"
+	case s.LastError != "":
+		return "failing"
+	}
+	return "ok"
+}
+
+// Stale reports whether the target had no successful scrape within ttl before now. A ttl of 0 never expires.
+func (s targetStatus) Stale(now time.Time, ttl time.Duration) bool {
+	return ttl > 0 && now.Sub(s.LastSuccess) > ttl
+}
+
+// record updates the target's health with the outcome of a scrape. After a failure, scrapes are suspended for
+// initial, doubling with every consecutive failure up to max. An initial backoff of 0 disables backing off.
+// Paused databases are suspended for their paused retry interval instead, if set, and missing databases for
+// MissingRetryInterval.
+func (t *target) record(err error, initial, max time.Duration) {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+	t.paused = isPausedError(err)
+	t.missing = isMissingError(err)
+	if err == nil {
+		t.lastError = nil
+		t.failures = 0
+		t.nextRetry = time.Time{}
+		t.lastSuccess = time.Now()
+		return
+	}
+	t.lastError = err
+	t.failures++
+	if t.paused && t.PausedRetryInterval > 0 {
+		t.nextRetry = time.Now().Add(t.PausedRetryInterval)
+		log.Infof("Not scraping paused database %s until %s", t.Database, t.nextRetry)
+		return
+	}
+	if t.missing && MissingRetryInterval > 0 {
+		t.nextRetry = time.Now().Add(MissingRetryInterval)
+		log.Warnf("Not scraping missing database %s until %s", t.Database, t.nextRetry)
+		return
+	}
+	if initial <= 0 {
+		return
+	}
+	backoff := initial
+	for i := 1; i < t.failures && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	t.nextRetry = time.Now().Add(backoff)
+	log.Warnf("Backing off %s for %s after %d consecutive failures", t.Database, backoff, t.failures)
+}
+
+func (t *target) status() targetStatus {
+	t.statusMutex.RLock()
+	defer t.statusMutex.RUnlock()
+	s := targetStatus{
+		Database:      t.Database,
+		Failures:      t.failures,
+		NextRetry:     t.nextRetry,
+		Paused:        t.paused,
+		Missing:       t.missing,
+		LastSuccess:   t.lastSuccess,
+		DisabledUntil: t.disabledUntil,
+		Maintenance:   t.InMaintenance(time.Now()),
+	}
+	if t.lastError != nil {
+		s.LastError = t.lastError.Error()
+	}
+	for _, c := range t.scrapeCounts {
+		if c.time.After(s.LastScrape) {
+			s.LastScrape = c.time
+			s.LastDuration = c.duration
+		}
+	}
+	return s
+}
+
+// statuses returns the status of every target, in configuration order.
+func (e *Exporter) statuses() []targetStatus {
+	targets := e.allTargets()
+	statuses := make([]targetStatus, len(targets))
+	for i, t := range targets {
+		statuses[i] = t.status()
+	}
+	return statuses
+}
+
+// ScrapeErrors returns the errors of the databases whose last scrape failed.
+func (e *Exporter) ScrapeErrors() []error {
+	var errs []error
+	for _, s := range e.statuses() {
+		if s.LastError != "" {
+			errs = append(errs, fmt.Errorf("database %s on %s: %s", s.Database.Name, s.Database.Server, s.LastError))
+		}
+	}
+	return errs
+}
+
+// backingOff returns the status of the targets that are currently backing off.
+func (e *Exporter) backingOff() []targetStatus {
+	var statuses []targetStatus
+	for _, s := range e.statuses() {
+		if s.BackingOff() {
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses
+}
+
+const backingOffTemplate = `{{define "backingOff"}}
+{{if .}}
+<h2 style="color: #c00">Backing off</h2>
+<p>The exporter stopped scraping these databases after consecutive failures and will retry them at the given time.</p>
+<table border="1" cellpadding="4">
+<tr><th>Server</th><th>Database</th><th>Failures</th><th>Next retry</th><th>Last error</th></tr>
+{{range .}}<tr><td>{{.Database.Server}}</td><td>{{.Database.Name}}</td><td>{{.Failures}}</td><td>{{.NextRetry.Format "2006-01-02 15:04:05 MST"}}</td><td>{{.LastError}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}`
+
+// targetsTableTemplate renders the status of every database, similar to the targets page of Prometheus.
+const targetsTableTemplate = `{{define "targetsTable"}}
+<table border="1" cellpadding="4">
+<tr><th>Server</th><th>Database</th><th>Health</th><th>State</th><th>Last scrape</th><th>Duration</th><th>Failures</th><th>Last error</th></tr>
+{{range .}}<tr><td>{{.Database.Server}}</td><td>{{.Database.Name}}</td><td style="color: {{if eq .Health "up"}}#080{{else if eq .Health "down"}}#c00{{else}}#888{{end}}">{{.Health}}</td><td>{{if .Disabled}}disabled until {{.DisabledUntil.Format "2006-01-02 15:04:05 MST"}}{{else}}{{.State}}{{end}}</td><td>{{if not .LastScrape.IsZero}}{{.LastScrape.Format "2006-01-02 15:04:05 MST"}}{{end}}</td><td>{{if not .LastScrape.IsZero}}{{.LastDuration}}{{end}}</td><td>{{.Failures}}</td><td>{{.LastError}}</td></tr>
+{{end}}</table>
+{{end}}`
+
+var landingPageTemplate = template.Must(template.New("landing").Parse(backingOffTemplate + targetsTableTemplate + `<html>
+<head><title>Azure SQL Exporter</title></head>
+<body>
+<h1>Azure SQL Exporter</h1>
+<p><a href="{{.MetricsPath}}">Metrics</a> - <a href="/api/v1/targets">Targets as JSON</a> - <a href="/api/v1/scrapes">Recent scrapes as JSON</a></p>
+{{template "backingOff" .BackingOff}}
+<h2>Targets</h2>
+{{template "targetsTable" .Targets}}
+</body>
+</html>
+`))
+
+var targetsTemplate = template.Must(template.New("targets").Parse(backingOffTemplate + targetsTableTemplate + `<html>
+<head><title>Azure SQL Exporter - Targets</title></head>
+<body>
+<h1>Targets</h1>
+{{template "backingOff" .BackingOff}}
+<h2>All targets</h2>
+{{template "targetsTable" .Targets}}
+</body>
+</html>
+`))
+
+// LandingPageHandler returns a handler rendering the landing page, linking to the metrics at metricsPath and
+// showing the status of every database.
+func (e *Exporter) LandingPageHandler(metricsPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			MetricsPath string
+			Targets     []targetStatus
+			BackingOff  []targetStatus
+		}{metricsPath, e.statuses(), e.backingOff()}
+		if err := landingPageTemplate.Execute(w, data); err != nil {
+			log.Errorf("Failed to render landing page: %s", err)
+		}
+	}
+}
+
+// TargetsHandler renders the status of every database.
+func (e *Exporter) TargetsHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Targets    []targetStatus
+		BackingOff []targetStatus
+	}{e.statuses(), e.backingOff()}
+	if err := targetsTemplate.Execute(w, data); err != nil {
+		log.Errorf("Failed to render targets page: %s", err)
+	}
+}
+
+// apiTarget is the status of a database as served by the targets API.
+type apiTarget struct {
+	Server             string            `json:"server"`
+	Database           string            `json:"database"`
+	Labels             map[string]string `json:"labels,omitempty"`
+	Health             string            `json:"health"`
+	State              string            `json:"state"`
+	LastScrape         *time.Time        `json:"last_scrape,omitempty"`
+	LastScrapeDuration float64           `json:"last_scrape_duration_seconds"`
+	LastSuccess        *time.Time        `json:"last_success,omitempty"`
+	LastError          string            `json:"last_error"`
+	Failures           int               `json:"failures"`
+	NextRetry          *time.Time        `json:"next_retry,omitempty"`
+	DisabledUntil      *time.Time        `json:"disabled_until,omitempty"`
+}
+
+// apiTargets is the data of the targets API response.
+type apiTargets struct {
+	Targets []apiTarget `json:"targets"`
+}
+
+// apiResponse is the envelope of the responses of the Prometheus HTTP API.
+type apiResponse struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data"`
+}
+
+// optionalTime returns a pointer to t, or nil if t is zero, so it is omitted from the JSON.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// TargetsAPIHandler serves the status of every database as JSON, in the envelope of the Prometheus HTTP API.
+func (e *Exporter) TargetsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := e.statuses()
+	targets := make([]apiTarget, len(statuses))
+	for i, s := range statuses {
+		targets[i] = apiTarget{
+			Server:             s.Database.Server,
+			Database:           s.Database.Name,
+			Labels:             s.Database.Labels,
+			Health:             s.Health(),
+			State:              s.State(),
+			LastScrape:         optionalTime(s.LastScrape),
+			LastScrapeDuration: s.LastDuration.Seconds(),
+			LastSuccess:        optionalTime(s.LastSuccess),
+			LastError:          s.LastError,
+			Failures:           s.Failures,
+		}
+		if s.BackingOff() {
+			targets[i].NextRetry = optionalTime(s.NextRetry)
+		}
+		if s.Disabled() {
+			targets[i].DisabledUntil = optionalTime(s.DisabledUntil)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiResponse{Status: "success", Data: apiTargets{Targets: targets}})
+}
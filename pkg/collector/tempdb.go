@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const tempdbQuery = `SELECT
+	SUM(user_object_reserved_page_count),
+	SUM(internal_object_reserved_page_count),
+	SUM(version_store_reserved_page_count),
+	SUM(unallocated_extent_page_count)
+FROM tempdb.sys.dm_db_file_space_usage`
+
+// tempdbSessionQuery is the fallback of tempdbQuery for logins without access to tempdb. It only covers the objects
+// of the sessions of the database, and neither the version store nor the free space.
+const tempdbSessionQuery = `SELECT
+	ISNULL(SUM(user_objects_alloc_page_count - user_objects_dealloc_page_count), 0),
+	ISNULL(SUM(internal_objects_alloc_page_count - internal_objects_dealloc_page_count), 0)
+FROM sys.dm_db_session_space_usage`
+
+// pageSize is the size of a SQL Server data page in bytes.
+const pageSize = 8192
+
+// tempdbScraper exports the space used in tempdb by object type.
+type tempdbScraper struct {
+	userObjects     *prometheus.Desc
+	internalObjects *prometheus.Desc
+	versionStore    *prometheus.Desc
+	free            *prometheus.Desc
+}
+
+func init() {
+	registerScraper("tempdb", newTempdbScraper)
+}
+
+func newTempdbScraper(labels prometheus.Labels) scraper {
+	return tempdbScraper{
+		userObjects:     newDesc("tempdb_user_objects_bytes", "Space reserved in tempdb for user objects such as temporary tables.", labels),
+		internalObjects: newDesc("tempdb_internal_objects_bytes", "Space reserved in tempdb for internal objects such as sort and hash spills.", labels),
+		versionStore:    newDesc("tempdb_version_store_bytes", "Space reserved in tempdb for the version store.", labels),
+		free:            newDesc("tempdb_free_bytes", "Unallocated space in the tempdb data files.", labels),
+	}
+}
+
+func (t tempdbScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.userObjects
+	ch <- t.internalObjects
+	ch <- t.versionStore
+	ch <- t.free
+}
+
+func (t tempdbScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	return c.capability("tempdb_file_space", func() error {
+		return c.query(func(rows resultRow) error {
+			var user, internal, version, free float64
+			if err := rows.Scan(&user, &internal, &version, &free); err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(t.userObjects, prometheus.GaugeValue, user*pageSize, c.database.Server, c.database.Name)
+			ch <- prometheus.MustNewConstMetric(t.internalObjects, prometheus.GaugeValue, internal*pageSize, c.database.Server, c.database.Name)
+			ch <- prometheus.MustNewConstMetric(t.versionStore, prometheus.GaugeValue, version*pageSize, c.database.Server, c.database.Name)
+			ch <- prometheus.MustNewConstMetric(t.free, prometheus.GaugeValue, free*pageSize, c.database.Server, c.database.Name)
+			return nil
+		}, tempdbQuery)
+	}, func() error {
+		return c.query(func(rows resultRow) error {
+			var user, internal float64
+			if err := rows.Scan(&user, &internal); err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(t.userObjects, prometheus.GaugeValue, user*pageSize, c.database.Server, c.database.Name)
+			ch <- prometheus.MustNewConstMetric(t.internalObjects, prometheus.GaugeValue, internal*pageSize, c.database.Server, c.database.Name)
+			return nil
+		}, tempdbSessionQuery)
+	})
+}
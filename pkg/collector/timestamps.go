@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// timestampedMetric is a metric exposed with an explicit timestamp.
+type timestampedMetric struct {
+	prometheus.Metric
+	time time.Time
+}
+
+func (m timestampedMetric) Write(pb *dto.Metric) error {
+	if err := m.Metric.Write(pb); err != nil {
+		return err
+	}
+	ms := m.time.UnixNano() / int64(time.Millisecond)
+	pb.TimestampMs = &ms
+	return nil
+}
+
+// labelSignature identifies a series by its label pairs, in the name order they are exposed in.
+func labelSignature(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(0)
+		b.WriteString(labels[name])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// sourceTimes returns the end_time of the resource stats of the last scrape of the databases that honor source
+// timestamps, by the label signature of their resource gauges.
+func (e *Exporter) sourceTimes() map[string]time.Time {
+	times := map[string]time.Time{}
+	for _, t := range e.allTargets() {
+		if !t.HonorSourceTimestamps {
+			continue
+		}
+		t.mutex.Lock()
+		sourceTime := t.sourceTime
+		t.mutex.Unlock()
+		if sourceTime.IsZero() {
+			continue
+		}
+		labels := map[string]string{"server": t.Server, "database": t.Name}
+		for _, name := range e.extraLabels {
+			labels[name] = t.Labels[name]
+		}
+		times[labelSignature(labels)] = sourceTime
+	}
+	return times
+}
+
+// collectWithSourceTimes collects vec, attaching the source timestamps in times to the series of databases that
+// honor them.
+func collectWithSourceTimes(vec *prometheus.GaugeVec, times map[string]time.Time, ch chan<- prometheus.Metric) {
+	if len(times) == 0 {
+		vec.Collect(ch)
+		return
+	}
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(metrics)
+		close(metrics)
+	}()
+	for m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			ch <- m
+			continue
+		}
+		labels := map[string]string{}
+		for _, p := range pb.Label {
+			labels[p.GetName()] = p.GetValue()
+		}
+		if sourceTime, ok := times[labelSignature(labels)]; ok {
+			m = timestampedMetric{m, sourceTime}
+		}
+		ch <- m
+	}
+}
@@ -0,0 +1,401 @@
+package collector
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/log"
+)
+
+// Limits of the span batches, the defaults of the OpenTelemetry batch span processor.
+const (
+	traceExportInterval = 5 * time.Second
+	traceBatchSize      = 512
+	traceQueueSize      = 2048
+)
+
+// tracer exports the spans of the scrapes, nil unless tracing is enabled with StartTracing.
+var tracer *otlpTracer
+
+// StartTracing enables tracing of the scrapes if an OTLP endpoint is configured with the standard OpenTelemetry
+// environment variables OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT. Spans are exported in
+// batches with OTLP over HTTP using the JSON encoding.
+func StartTracing() error {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return nil
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return fmt.Errorf("invalid OTLP traces endpoint: %s", err)
+	}
+	protocol := otelEnv("PROTOCOL")
+	if protocol != "" && protocol != "http/json" {
+		return fmt.Errorf("unsupported OTLP protocol %q, only http/json is supported", protocol)
+	}
+	headers, err := parseOTELList(otelEnv("HEADERS"))
+	if err != nil {
+		return fmt.Errorf("invalid OTLP headers: %s", err)
+	}
+	timeout := 10 * time.Second
+	if s := otelEnv("TIMEOUT"); s != "" {
+		ms, err := strconv.Atoi(s)
+		if err != nil || ms <= 0 {
+			return fmt.Errorf("invalid OTLP timeout %q, must be a positive number of milliseconds", s)
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	ratio, err := samplingRatio(os.Getenv("OTEL_TRACES_SAMPLER"), os.Getenv("OTEL_TRACES_SAMPLER_ARG"))
+	if err != nil {
+		return err
+	}
+	resource, err := parseOTELList(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		return fmt.Errorf("invalid OTEL_RESOURCE_ATTRIBUTES: %s", err)
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		resource["service.name"] = name
+	} else if resource["service.name"] == "" {
+		resource["service.name"] = "azure_sql_exporter"
+	}
+	t := &otlpTracer{
+		endpoint: endpoint,
+		headers:  headers,
+		client:   &http.Client{Timeout: timeout},
+		ratio:    ratio,
+		flush:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for k, v := range resource {
+		t.resource = append(t.resource, attr(k, v))
+	}
+	go t.run()
+	tracer = t
+	log.Infof("Exporting traces of the scrapes to %s", endpoint)
+	return nil
+}
+
+// StopTracing exports the spans not exported yet and disables tracing.
+func StopTracing() {
+	if tracer == nil {
+		return
+	}
+	close(tracer.stop)
+	<-tracer.done
+	tracer = nil
+}
+
+// otelEnv returns the OTLP exporter setting OTEL_EXPORTER_OTLP_TRACES_<name>, falling back to
+// OTEL_EXPORTER_OTLP_<name>.
+func otelEnv(name string) string {
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_" + name); v != "" {
+		return v
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + name)
+}
+
+// parseOTELList parses a comma separated list of URL encoded key=value pairs, the format of
+// OTEL_EXPORTER_OTLP_HEADERS and OTEL_RESOURCE_ATTRIBUTES.
+func parseOTELList(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		v, err := url.QueryUnescape(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, err
+		}
+		m[strings.TrimSpace(kv[0])] = v
+	}
+	return m, nil
+}
+
+// samplingRatio returns the ratio of the scrapes to trace for the sampler configured with OTEL_TRACES_SAMPLER
+// and OTEL_TRACES_SAMPLER_ARG. The scrapes are root spans, so the parent based samplers behave like the ones they
+// are based on.
+func samplingRatio(sampler, arg string) (float64, error) {
+	switch strings.TrimPrefix(sampler, "parentbased_") {
+	case "", "always_on":
+		return 1, nil
+	case "always_off":
+		return 0, nil
+	case "traceidratio":
+		if arg == "" {
+			return 1, nil
+		}
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return 0, fmt.Errorf("invalid sampling ratio %q, must be between 0 and 1", arg)
+		}
+		return ratio, nil
+	}
+	return 0, fmt.Errorf("unsupported sampler %q", sampler)
+}
+
+// otlpTracer batches finished spans and exports them to an OTLP endpoint.
+type otlpTracer struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+	resource []otlpAttribute
+	// ratio is the ratio of the scrapes that are traced.
+	ratio float64
+
+	mutex   sync.Mutex
+	spans   []otlpSpan
+	dropped int
+
+	// flush is signalled when a full batch is queued, stop when tracing is stopped. done is closed once the last
+	// spans are exported.
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// sampled decides whether the scrape with the trace ID is traced. Like the traceidratio sampler, the decision is
+// based on the lower 8 bytes of the trace ID.
+func (t *otlpTracer) sampled(traceID string) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	if t.ratio <= 0 || len(traceID) != 32 {
+		return false
+	}
+	n, err := strconv.ParseUint(traceID[16:], 16, 64)
+	if err != nil {
+		return false
+	}
+	return n < uint64(t.ratio*(1<<64))
+}
+
+// enqueue queues a finished span for export. Spans are dropped while the queue is full.
+func (t *otlpTracer) enqueue(s otlpSpan) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.spans) >= traceQueueSize {
+		t.dropped++
+		return
+	}
+	t.spans = append(t.spans, s)
+	if len(t.spans) == traceBatchSize {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *otlpTracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flush:
+		case <-t.stop:
+			t.exportAll()
+			return
+		}
+		t.exportAll()
+	}
+}
+
+// exportAll exports the queued spans in batches.
+func (t *otlpTracer) exportAll() {
+	t.mutex.Lock()
+	spans, dropped := t.spans, t.dropped
+	t.spans, t.dropped = nil, 0
+	t.mutex.Unlock()
+	if dropped > 0 {
+		log.Warnf("Dropped %d spans, the export of the traces can't keep up", dropped)
+	}
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > traceBatchSize {
+			n = traceBatchSize
+		}
+		if err := t.export(spans[:n]); err != nil {
+			log.Errorf("Unable to export %d spans: %s", n, err)
+		}
+		spans = spans[n:]
+	}
+}
+
+// export sends spans to the OTLP endpoint.
+func (t *otlpTracer) export(spans []otlpSpan) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": t.resource},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "github.com/iamseth/azure_sql_exporter"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("OTLP endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Span kinds of OTLP.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+)
+
+// otlpSpan is a finished span in the OTLP JSON encoding.
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue is a string or integer attribute value. OTLP encodes 64 bit integers as strings in JSON.
+type otlpValue struct {
+	String *string `json:"stringValue,omitempty"`
+	Int    *string `json:"intValue,omitempty"`
+}
+
+// otlpStatus is the status of a span, with code 2 for errors.
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func attr(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{String: &value}}
+}
+
+func intAttr(key string, value int) otlpAttribute {
+	s := strconv.Itoa(value)
+	return otlpAttribute{Key: key, Value: otlpValue{Int: &s}}
+}
+
+// span is a span of a traced scrape. A nil span is a scrape that isn't traced, all methods do nothing on it.
+type span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+	attrs    []otlpAttribute
+}
+
+// startTrace starts the root span of the scrape with the trace ID, or returns nil if tracing is disabled or the
+// scrape isn't sampled.
+func startTrace(traceID, name string, attrs ...otlpAttribute) *span {
+	if tracer == nil || !tracer.sampled(traceID) {
+		return nil
+	}
+	return &span{traceID: traceID, spanID: newSpanID(), name: name, kind: spanKindInternal, start: time.Now(), attrs: attrs}
+}
+
+// child starts a span of the work done for s.
+func (s *span) child(name string, attrs ...otlpAttribute) *span {
+	if s == nil {
+		return nil
+	}
+	return &span{traceID: s.traceID, spanID: newSpanID(), parentID: s.spanID, name: name, kind: spanKindInternal, start: time.Now(), attrs: attrs}
+}
+
+// query starts a span of a query sent to the database.
+func (s *span) query(name, query string) *span {
+	c := s.child(name, attr("db.query.text", query))
+	if c != nil {
+		c.kind = spanKindClient
+	}
+	return c
+}
+
+// set adds attributes to s.
+func (s *span) set(attrs ...otlpAttribute) {
+	if s == nil {
+		return
+	}
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// end finishes s, failed with err if not nil, and queues it for export.
+func (s *span) end(err error) {
+	if s == nil || tracer == nil {
+		return
+	}
+	o := otlpSpan{
+		TraceID:      s.traceID,
+		SpanID:       s.spanID,
+		ParentSpanID: s.parentID,
+		Name:         s.name,
+		Kind:         s.kind,
+		Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+		End:          strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:   s.attrs,
+	}
+	if err != nil {
+		o.Status = otlpStatus{Code: 2, Message: err.Error()}
+	}
+	tracer.enqueue(o)
+}
+
+// newSpanID returns a random span ID, 16 lower case hex digits.
+func newSpanID() string {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		log.Warnf("Unable to generate span ID: %s", err)
+	}
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,217 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// tunnel forwards connections from a local port through an SSH bastion host to a database server. Databases
+// on the same server share a tunnel and its SSH connection.
+type tunnel struct {
+	config config.SSHTunnel
+	remote string
+
+	mutex    sync.Mutex
+	listener net.Listener
+	client   *ssh.Client
+	// closed is set by close, after which the tunnel isn't started again.
+	closed bool
+}
+
+// tunnelDialTimeout limits connecting to the bastion host, including the SSH handshake.
+var tunnelDialTimeout = 10 * time.Second
+
+// errTunnelClosed is returned by tunnels used after they were closed.
+var errTunnelClosed = errors.New("tunnel closed")
+
+// tunnelKey identifies the tunnel of a database.
+func tunnelKey(d config.Database) string {
+	return d.SSHTunnel.User + "@" + d.SSHTunnel.Host + "->" + tunnelRemote(d)
+}
+
+// tunnelRemote returns the address of the database server the tunnel forwards to.
+func tunnelRemote(d config.Database) string {
+	address, _ := d.Address()
+	return address
+}
+
+func newTunnel(d config.Database) *tunnel {
+	return &tunnel{config: *d.SSHTunnel, remote: tunnelRemote(d)}
+}
+
+// dsn returns the data source name of d connecting through the tunnel, starting the tunnel if it isn't running
+// yet. The server's certificate is still verified against its real host name.
+func (t *tunnel) dsn(d config.Database) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return "", errTunnelClosed
+	}
+	if t.listener == nil {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", fmt.Errorf("unable to listen for tunnel to %s: %s", t.remote, err)
+		}
+		t.listener = listener
+		go t.serve(listener)
+	}
+	_, port, _ := net.SplitHostPort(t.listener.Addr().String())
+	host := d.HostNameInCertificate
+	if host == "" {
+		host = d.Host()
+	}
+	// Later parameters override earlier ones.
+	return fmt.Sprintf("%s;server=127.0.0.1;port=%s;hostnameincertificate=%s", d.DSN(), port, host), nil
+}
+
+func (t *tunnel) serve(listener net.Listener) {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			t.mutex.Lock()
+			closed := t.closed
+			t.mutex.Unlock()
+			if !closed {
+				log.Errorf("Tunnel to %s stopped accepting connections: %s", t.remote, err)
+			}
+			return
+		}
+		go t.forward(local)
+	}
+}
+
+func (t *tunnel) forward(local net.Conn) {
+	defer local.Close()
+	remote, err := t.dial()
+	if err != nil {
+		log.Errorf("Unable to connect to %s through %s: %s", t.remote, t.config.Host, err)
+		return
+	}
+	defer remote.Close()
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// dial opens a connection to the database server through the bastion host, reconnecting to the bastion host if
+// its connection was lost. Connecting doesn't hold the mutex, so a bastion host that doesn't respond only holds up
+// the connections that need it.
+func (t *tunnel) dial() (net.Conn, error) {
+	t.mutex.Lock()
+	client, closed := t.client, t.closed
+	t.mutex.Unlock()
+	if closed {
+		return nil, errTunnelClosed
+	}
+	if client != nil {
+		conn, err := client.Dial("tcp", t.remote)
+		if err == nil {
+			return conn, nil
+		}
+		log.Warnf("Reconnecting to %s: %s", t.config.Host, err)
+		client.Close()
+		t.mutex.Lock()
+		if t.client == client {
+			t.client = nil
+		}
+		t.mutex.Unlock()
+	}
+	client, err := t.connect()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %s", t.config.Host, err)
+	}
+	t.mutex.Lock()
+	switch {
+	case t.closed:
+		t.mutex.Unlock()
+		client.Close()
+		return nil, errTunnelClosed
+	case t.client != nil:
+		// Another connection reconnected in the meantime.
+		client.Close()
+		client = t.client
+	default:
+		t.client = client
+	}
+	t.mutex.Unlock()
+	return client.Dial("tcp", t.remote)
+}
+
+// connect opens an SSH connection to the bastion host. The Timeout of the client config only limits the TCP
+// connect, so the handshake is limited by a deadline on the connection.
+func (t *tunnel) connect() (*ssh.Client, error) {
+	config, err := t.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", t.config.Host, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(config.Timeout))
+	c, channels, requests, err := ssh.NewClientConn(conn, t.config.Host, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, channels, requests), nil
+}
+
+// close stops the tunnel: it stops accepting connections and closes the SSH connection to the bastion host, which
+// closes the connections forwarded through it.
+func (t *tunnel) close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.closed = true
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	if t.client != nil {
+		t.client.Close()
+		t.client = nil
+	}
+}
+
+func (t *tunnel) clientConfig() (*ssh.ClientConfig, error) {
+	key, err := ioutil.ReadFile(t.config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key file %s: %s", t.config.KeyFile, err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse key file %s: %s", t.config.KeyFile, err)
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(t.config.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse host key of %s: %s", t.config.Host, err)
+	}
+	return &ssh.ClientConfig{
+		User:    t.config.User,
+		Timeout: tunnelDialTimeout,
+		Auth:    []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if !bytes.Equal(key.Marshal(), hostKey.Marshal()) {
+				return fmt.Errorf("host key of %s doesn't match the configured host key", hostname)
+			}
+			return nil
+		},
+	}, nil
+}
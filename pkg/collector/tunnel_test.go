@@ -0,0 +1,259 @@
+package collector
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// bastion is an SSH server forwarding direct-tcpip channels, like a bastion host.
+type bastion struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	mutex sync.Mutex
+	conns []*ssh.ServerConn
+}
+
+func newBastion(t *testing.T, hostKey ssh.Signer) *bastion {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	b := &bastion{listener: listener, config: &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) { return nil, nil },
+	}}
+	b.config.AddHostKey(hostKey)
+	go b.serve()
+	return b
+}
+
+func (b *bastion) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		sconn, channels, requests, err := ssh.NewServerConn(conn, b.config)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		b.mutex.Lock()
+		b.conns = append(b.conns, sconn)
+		b.mutex.Unlock()
+		go ssh.DiscardRequests(requests)
+		go forwardChannels(channels)
+	}
+}
+
+// forwardChannels connects the direct-tcpip channels to their destination.
+func forwardChannels(channels <-chan ssh.NewChannel) {
+	for ch := range channels {
+		var dest struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if ch.ChannelType() != "direct-tcpip" || ssh.Unmarshal(ch.ExtraData(), &dest) != nil {
+			ch.Reject(ssh.UnknownChannelType, "unsupported channel")
+			continue
+		}
+		remote, err := net.Dial("tcp", net.JoinHostPort(dest.Host, strconv.FormatUint(uint64(dest.Port), 10)))
+		if err != nil {
+			ch.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, requests, err := ch.Accept()
+		if err != nil {
+			remote.Close()
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		go func() {
+			defer channel.Close()
+			defer remote.Close()
+			go io.Copy(remote, channel)
+			io.Copy(channel, remote)
+		}()
+	}
+}
+
+// dropConnections closes the SSH connections of the bastion host, as a restart of it would.
+func (b *bastion) dropConnections() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, c := range b.conns {
+		c.Close()
+	}
+}
+
+func (b *bastion) connections() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.conns)
+}
+
+// newTunnelKeys returns the host key of a bastion host and the file of a client key.
+func newTunnelKeys(t *testing.T) (ssh.Signer, string) {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, _ := ssh.NewSignerFromKey(hostKey)
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(clientKey)
+	keyFile := filepath.Join(t.TempDir(), "id_ecdsa")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return hostSigner, keyFile
+}
+
+func TestTunnelReconnect(t *testing.T) {
+	hostSigner, keyFile := newTunnelKeys(t)
+	b := newBastion(t, hostSigner)
+
+	// The database server echoes what it receives.
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	tun := &tunnel{config: config.SSHTunnel{
+		Host:    b.listener.Addr().String(),
+		User:    "exporter",
+		KeyFile: keyFile,
+		HostKey: string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey())),
+	}, remote: server.Addr().String()}
+	echo := func() {
+		t.Helper()
+		conn, err := tun.dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("got %q, %v through the tunnel, want ping", buf, err)
+		}
+	}
+
+	echo()
+	echo()
+	if n := b.connections(); n != 1 {
+		t.Errorf("got %d SSH connections, want the connection shared", n)
+	}
+	// A lost connection to the bastion host is reestablished on the next dial.
+	b.dropConnections()
+	tun.client.Wait()
+	echo()
+	if n := b.connections(); n != 2 {
+		t.Errorf("got %d SSH connections, want a reconnect", n)
+	}
+
+	// Bastion hosts presenting another key are refused.
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherKey)
+	tun.config.HostKey = string(ssh.MarshalAuthorizedKey(otherSigner.PublicKey()))
+	tun.client.Close()
+	tun.client = nil
+	if conn, err := tun.dial(); err == nil {
+		conn.Close()
+		t.Error("connected to a bastion host with another host key")
+	}
+
+	// Closing the tunnel closes its SSH connection and keeps it from being started again.
+	tun.config.HostKey = string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey()))
+	echo()
+	client := tun.client
+	tun.close()
+	client.Wait()
+	if _, err := tun.dial(); err != errTunnelClosed {
+		t.Errorf("got error %v dialing a closed tunnel, want %s", err, errTunnelClosed)
+	}
+	if _, err := tun.dsn(sales); err != errTunnelClosed {
+		t.Errorf("got error %v for the data source name of a closed tunnel, want %s", err, errTunnelClosed)
+	}
+}
+
+func TestTunnelDialTimeout(t *testing.T) {
+	timeout := tunnelDialTimeout
+	tunnelDialTimeout = 200 * time.Millisecond
+	defer func() { tunnelDialTimeout = timeout }()
+	hostSigner, keyFile := newTunnelKeys(t)
+	// The bastion host accepts connections but never answers the handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	tun := &tunnel{config: config.SSHTunnel{
+		Host:    listener.Addr().String(),
+		User:    "exporter",
+		KeyFile: keyFile,
+		HostKey: string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey())),
+	}, remote: "127.0.0.1:1433"}
+	defer tun.close()
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, err := tun.dial()
+		done <- err
+	}()
+	conn := <-accepted
+	defer conn.Close()
+	// Other users of the tunnel don't wait for the handshake.
+	if _, err := tun.dsn(sales); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+		t.Error("the data source name waited for the connection to the bastion host")
+	default:
+	}
+	if err := <-done; err == nil {
+		t.Error("connected to a bastion host that doesn't answer")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("dial took %s, want it to time out", d)
+	}
+}
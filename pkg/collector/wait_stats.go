@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"github.com/iamseth/azure_sql_exporter/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// waitStatsQuery returns the 20 wait types the database spent the most time waiting on. The values are cumulative
+// since the database last started or failed over.
+const waitStatsQuery = `SELECT TOP 20 wait_type, wait_time_ms, waiting_tasks_count
+FROM sys.dm_db_wait_stats
+WHERE wait_time_ms > 0
+ORDER BY wait_time_ms DESC`
+
+// waitStatsScraper exports the wait statistics of the database from sys.dm_db_wait_stats as counters. On SQL Server,
+// which has no sys.dm_db_wait_stats, the waits of the instance are exported instead.
+type waitStatsScraper struct {
+	waitSeconds  *prometheus.Desc
+	waitingTasks *prometheus.Desc
+	counters     *counterResets
+}
+
+func init() {
+	registerScraper("wait_stats", newWaitStatsScraper)
+}
+
+func newWaitStatsScraper(labels prometheus.Labels) scraper {
+	return waitStatsScraper{
+		waitSeconds:  newDesc("wait_seconds_total", "Time spent waiting by wait type, for the top 20 wait types.", labels, "wait_type"),
+		waitingTasks: newDesc("waiting_tasks_total", "Number of waits by wait type, for the top 20 wait types.", labels, "wait_type"),
+		counters:     newCounterResets(),
+	}
+}
+
+func (w waitStatsScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- w.waitSeconds
+	ch <- w.waitingTasks
+}
+
+func (w waitStatsScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	query := waitStatsQuery
+	if c.database.Type == config.TypeSQLServer {
+		query = instanceWaitStatsQuery
+	}
+	return c.query(func(rows resultRow) error {
+		var waitType string
+		var waitMs, tasks float64
+		if err := rows.Scan(&waitType, &waitMs, &tasks); err != nil {
+			return err
+		}
+		ch <- w.counters.metric(w.waitSeconds, waitMs/1000, c.database.Server, c.database.Name, waitType)
+		ch <- w.counters.metric(w.waitingTasks, tasks, c.database.Server, c.database.Name, waitType)
+		return nil
+	}, query)
+}
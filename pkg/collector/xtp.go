@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// xtpQuery returns the In-Memory OLTP storage utilization of the latest row of the resource stats and the memory
+// used by the memory-optimized tables and their indexes. The storage utilization is NULL on service tiers without
+// In-Memory OLTP.
+const xtpQuery = `SELECT (SELECT TOP 1 xtp_storage_percent FROM sys.dm_db_resource_stats ORDER BY end_time DESC),
+	ISNULL(SUM(memory_used_by_table_kb), 0) * 1024,
+	ISNULL(SUM(memory_used_by_indexes_kb), 0) * 1024
+FROM sys.dm_db_xtp_table_memory_stats`
+
+// xtpScraper exports the usage of the In-Memory OLTP storage. Inserts into memory-optimized tables fail once the
+// storage of the service tier is full.
+type xtpScraper struct {
+	storagePercent *prometheus.Desc
+	tableMemory    *prometheus.Desc
+	indexMemory    *prometheus.Desc
+}
+
+func init() {
+	registerScraper("xtp", newXTPScraper)
+}
+
+func newXTPScraper(labels prometheus.Labels) scraper {
+	return xtpScraper{
+		storagePercent: newDesc("xtp_storage_percent", "In-Memory OLTP storage utilization in percentage of the limit of the service tier.", labels),
+		tableMemory:    newDesc("xtp_table_memory_bytes", "Memory used by the rows of memory-optimized tables.", labels),
+		indexMemory:    newDesc("xtp_index_memory_bytes", "Memory used by the indexes of memory-optimized tables.", labels),
+	}
+}
+
+func (x xtpScraper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- x.storagePercent
+	ch <- x.tableMemory
+	ch <- x.indexMemory
+}
+
+func (x xtpScraper) Scrape(c *connection, ch chan<- prometheus.Metric) error {
+	return c.query(func(rows resultRow) error {
+		var storagePercent sql.NullFloat64
+		var tableMemory, indexMemory float64
+		if err := rows.Scan(&storagePercent, &tableMemory, &indexMemory); err != nil {
+			return err
+		}
+		if storagePercent.Valid {
+			ch <- prometheus.MustNewConstMetric(x.storagePercent, prometheus.GaugeValue, storagePercent.Float64, c.database.Server, c.database.Name)
+		}
+		ch <- prometheus.MustNewConstMetric(x.tableMemory, prometheus.GaugeValue, tableMemory, c.database.Server, c.database.Name)
+		ch <- prometheus.MustNewConstMetric(x.indexMemory, prometheus.GaugeValue, indexMemory, c.database.Server, c.database.Name)
+		return nil
+	}, xtpQuery)
+}
@@ -0,0 +1,311 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Files encrypted with age (https://age-encryption.org/v1) start with the version line, or the armor line if they
+// are ASCII armored.
+const (
+	ageVersionLine = "age-encryption.org/v1"
+	ageArmorBegin  = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageArmorEnd    = "-----END AGE ENCRYPTED FILE-----"
+)
+
+// ageChunkSize is the size of the plaintext chunks of the payload of an age file.
+const ageChunkSize = 64 * 1024
+
+// isAgeEncrypted returns whether b is a file encrypted with age.
+func isAgeEncrypted(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(ageVersionLine+"\n")) || bytes.HasPrefix(bytes.TrimSpace(b), []byte(ageArmorBegin))
+}
+
+// parseAgeIdentities returns the X25519 identities of an age key file, one AGE-SECRET-KEY-1 per line. Empty lines
+// and lines starting with # are ignored.
+func parseAgeIdentities(b []byte) ([]*ecdh.PrivateKey, error) {
+	var identities []*ecdh.PrivateKey
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hrp, key, err := bech32Decode(line)
+		if err != nil || hrp != "age-secret-key-" || len(key) != 32 {
+			return nil, fmt.Errorf("line %d is not an age X25519 identity", n)
+		}
+		identity, err := ecdh.X25519().NewPrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		identities = append(identities, identity)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("no age identities found")
+	}
+	return identities, nil
+}
+
+// ageStanza is a recipient stanza of the header of an age file, wrapping the file key for one recipient.
+type ageStanza struct {
+	typ  string
+	args []string
+	body []byte
+}
+
+// ageDecrypt decrypts the age file b with one of the X25519 identities.
+func ageDecrypt(b []byte, identities []*ecdh.PrivateKey) ([]byte, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte(ageArmorBegin)) {
+		var err error
+		if b, err = ageDearmor(b); err != nil {
+			return nil, err
+		}
+	}
+	stanzas, header, mac, payload, err := parseAgeHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	var fileKey []byte
+	for _, s := range stanzas {
+		if s.typ != "X25519" {
+			continue
+		}
+		for _, identity := range identities {
+			if fileKey, err = ageUnwrapX25519(s, identity); err == nil {
+				break
+			}
+		}
+		if fileKey != nil {
+			break
+		}
+	}
+	if fileKey == nil {
+		return nil, errors.New("no age identity matches any recipient of the file")
+	}
+	hmacKey, err := hkdf.Key(sha256.New, fileKey, nil, "header", 32)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, hmacKey)
+	h.Write(header)
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return nil, errors.New("age header MAC mismatch, the file was modified")
+	}
+	return ageDecryptPayload(fileKey, payload)
+}
+
+// ageDearmor returns the binary age file of the ASCII armored file b.
+func ageDearmor(b []byte) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) < 2 || strings.TrimSpace(lines[len(lines)-1]) != ageArmorEnd {
+		return nil, errors.New("age armor has no end line")
+	}
+	var encoded strings.Builder
+	for _, line := range lines[1 : len(lines)-1] {
+		encoded.WriteString(strings.TrimSpace(line))
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid age armor: %s", err)
+	}
+	return decoded, nil
+}
+
+// parseAgeHeader splits the age file b into the recipient stanzas of its header, the header covered by the MAC, the
+// MAC and the payload.
+func parseAgeHeader(b []byte) (stanzas []ageStanza, header, mac, payload []byte, err error) {
+	rest := b
+	nextLine := func() (string, bool) {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			return "", false
+		}
+		line := string(rest[:i])
+		rest = rest[i+1:]
+		return line, true
+	}
+	if line, ok := nextLine(); !ok || line != ageVersionLine {
+		return nil, nil, nil, nil, errors.New("unsupported age version")
+	}
+	for {
+		line, ok := nextLine()
+		if !ok {
+			return nil, nil, nil, nil, errors.New("truncated age header")
+		}
+		if strings.HasPrefix(line, "--- ") {
+			// The MAC covers the header up to and including the dashes of the footer line.
+			header = b[:len(b)-len(rest)-len(line)-1+len("---")]
+			if mac, err = base64.RawStdEncoding.DecodeString(line[len("--- "):]); err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("invalid age header MAC: %s", err)
+			}
+			return stanzas, header, mac, rest, nil
+		}
+		fields := strings.Split(line, " ")
+		if len(fields) < 2 || fields[0] != "->" {
+			return nil, nil, nil, nil, fmt.Errorf("invalid age stanza %q", line)
+		}
+		s := ageStanza{typ: fields[1], args: fields[2:]}
+		// The body is wrapped at 64 columns and ends with the first shorter line, which may be empty.
+		for {
+			line, ok := nextLine()
+			if !ok {
+				return nil, nil, nil, nil, errors.New("truncated age stanza")
+			}
+			chunk, err := base64.RawStdEncoding.DecodeString(line)
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("invalid age stanza body: %s", err)
+			}
+			s.body = append(s.body, chunk...)
+			if len(line) < 64 {
+				break
+			}
+		}
+		stanzas = append(stanzas, s)
+	}
+}
+
+// ageUnwrapX25519 returns the file key wrapped in the X25519 stanza s for identity.
+func ageUnwrapX25519(s ageStanza, identity *ecdh.PrivateKey) ([]byte, error) {
+	if len(s.args) != 1 {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+	share, err := base64.RawStdEncoding.DecodeString(s.args[0])
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(share)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(append([]byte{}, share...), identity.PublicKey().Bytes()...)
+	wrapKey, err := hkdf.Key(sha256.New, shared, salt, "age-encryption.org/v1/X25519", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), s.body, nil)
+}
+
+// ageDecryptPayload decrypts the payload of an age file: a nonce followed by the chunks of the plaintext, each
+// encrypted with a counter and a flag marking the last chunk.
+func ageDecryptPayload(fileKey, payload []byte) ([]byte, error) {
+	if len(payload) < 16 {
+		return nil, errors.New("truncated age payload")
+	}
+	key, err := hkdf.Key(sha256.New, fileKey, payload[:16], "payload", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	var plaintext []byte
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	rest := payload[16:]
+	for counter := uint64(0); ; counter++ {
+		chunk := rest
+		last := len(rest) <= ageChunkSize+aead.Overhead()
+		if !last {
+			chunk = rest[:ageChunkSize+aead.Overhead()]
+		}
+		binary.BigEndian.PutUint64(nonce[3:11], counter)
+		if last {
+			nonce[11] = 1
+		}
+		p, err := aead.Open(nil, nonce, chunk, nil)
+		if err != nil {
+			return nil, errors.New("age payload authentication failed, the file was modified or truncated")
+		}
+		plaintext = append(plaintext, p...)
+		if last {
+			return plaintext, nil
+		}
+		rest = rest[len(chunk):]
+	}
+}
+
+// bech32Charset maps the 5 bit values of bech32 to characters.
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Decode returns the human readable part, in lower case, and the data of the bech32 string s.
+func bech32Decode(s string) (string, []byte, error) {
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errors.New("invalid bech32 separator")
+	}
+	hrp := s[:sep]
+	values := make([]byte, 0, len(s)-sep-1)
+	for _, c := range s[sep+1:] {
+		v := strings.IndexRune(bech32Charset, c)
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid bech32 character %q", c)
+		}
+		values = append(values, byte(v))
+	}
+	// The checksum covers the human readable part and the data.
+	check := make([]byte, 0, 2*len(hrp)+1+len(values))
+	for _, c := range hrp {
+		check = append(check, byte(c)>>5)
+	}
+	check = append(check, 0)
+	for _, c := range hrp {
+		check = append(check, byte(c)&31)
+	}
+	if bech32Polymod(append(check, values...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+	// Convert the 5 bit values without the checksum to bytes.
+	var data []byte
+	acc, bits := 0, 0
+	for _, v := range values[:len(values)-6] {
+		acc = (acc<<5 | int(v)) & 0xfff
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			data = append(data, byte(acc>>bits))
+		}
+	}
+	if bits >= 5 || acc&(1<<bits-1) != 0 {
+		return "", nil, errors.New("invalid bech32 padding")
+	}
+	return hrp, data, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Default endpoints of the Azure public cloud.
+const (
+	defaultAuthorityHost   = "https://login.microsoftonline.com"
+	defaultResourceManager = "https://management.azure.com"
+)
+
+// AzureConfig configures access to the Azure Resource Manager API with a service principal.
+type AzureConfig struct {
+	TenantID string `yaml:"tenant_id"`
+	ClientID string `yaml:"client_id"`
+	// ClientSecret is the secret of the service principal. Defaults to the AZURE_CLIENT_SECRET environment
+	// variable.
+	ClientSecret string `yaml:"client_secret"`
+	// Subscriptions lists the IDs of the subscriptions the servers are in.
+	Subscriptions []string
+	// TagKeys lists the tags of the databases exported as labels of azure_sql_database_tags_info.
+	TagKeys []string `yaml:"tag_keys"`
+	// EgressIPURL returns the public IP address of the exporter as plain text, e.g. https://api.ipify.org. If set,
+	// the firewall rules of the servers are checked for the address.
+	EgressIPURL string `yaml:"egress_ip_url"`
+	// BackupRetention looks up the point-in-time restore retention of every database, one request per database.
+	BackupRetention bool `yaml:"backup_retention"`
+	// MonitorMetrics lists the Azure Monitor metrics of the databases exported as azure_sql_monitor_<name>.
+	MonitorMetrics []MonitorMetric `yaml:"monitor_metrics"`
+	// AuthorityHost and ResourceManager override the endpoints for sovereign clouds.
+	AuthorityHost   string `yaml:"authority_host"`
+	ResourceManager string `yaml:"resource_manager"`
+}
+
+// MonitorMetric is an Azure Monitor metric of the databases, e.g. dtu_consumption_percent.
+type MonitorMetric struct {
+	Name string
+	// Aggregation of the metric over a minute, one of average, minimum, maximum, total and count. Defaults to
+	// average.
+	Aggregation string
+}
+
+// monitorAggregations are the aggregations of Azure Monitor metrics.
+var monitorAggregations = map[string]bool{"average": true, "minimum": true, "maximum": true, "total": true, "count": true}
+
+func (c *AzureConfig) validate() error {
+	if c.ClientSecret == "" {
+		c.ClientSecret = os.Getenv("AZURE_CLIENT_SECRET")
+	}
+	if c.TenantID == "" || c.ClientID == "" || c.ClientSecret == "" {
+		return fmt.Errorf("azure requires tenant_id, client_id and client_secret")
+	}
+	if len(c.Subscriptions) == 0 {
+		return fmt.Errorf("azure requires at least one subscription")
+	}
+	seen := map[string]string{}
+	for _, key := range c.TagKeys {
+		if other, ok := seen[TagLabel(key)]; ok {
+			return fmt.Errorf("azure tag keys %q and %q map to the same label %s", other, key, TagLabel(key))
+		}
+		seen[TagLabel(key)] = key
+	}
+	metrics := map[string]string{}
+	for i, m := range c.MonitorMetrics {
+		if m.Name == "" {
+			return fmt.Errorf("azure monitor metric %d has no name", i+1)
+		}
+		if other, ok := metrics[MonitorMetricName(m.Name)]; ok {
+			return fmt.Errorf("azure monitor metrics %q and %q map to the same metric %s", other, m.Name, MonitorMetricName(m.Name))
+		}
+		metrics[MonitorMetricName(m.Name)] = m.Name
+		if m.Aggregation == "" {
+			c.MonitorMetrics[i].Aggregation = "average"
+		} else if !monitorAggregations[m.Aggregation] {
+			return fmt.Errorf("azure monitor metric %s has unknown aggregation %q", m.Name, m.Aggregation)
+		}
+	}
+	if c.AuthorityHost == "" {
+		c.AuthorityHost = defaultAuthorityHost
+	}
+	if c.ResourceManager == "" {
+		c.ResourceManager = defaultResourceManager
+	}
+	return nil
+}
+
+var invalidLabelCharsRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// TagLabel returns the name of the label of the Azure tag key.
+func TagLabel(key string) string {
+	return "tag_" + invalidLabelCharsRE.ReplaceAllString(key, "_")
+}
+
+// MonitorMetricName returns the suffix of the name the Azure Monitor metric is exported as.
+func MonitorMetricName(name string) string {
+	return invalidLabelCharsRE.ReplaceAllString(strings.ToLower(name), "_")
+}
@@ -0,0 +1,457 @@
+// Package config loads and validates the YAML configuration of the databases to export.
+package config
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Database types.
+const (
+	// TypeDatabase is an Azure SQL Database, the default.
+	TypeDatabase = "database"
+	// TypeManagedInstance is a database on an Azure SQL Managed Instance.
+	TypeManagedInstance = "managed_instance"
+	// TypeServer is a logical server, monitored through its master database.
+	TypeServer = "server"
+	// TypeSQLServer is a database of an on-premises SQL Server or SQL Server on a virtual machine.
+	TypeSQLServer = "sqlserver"
+	// TypeSynapse is a dedicated SQL pool of Azure Synapse Analytics, formerly SQL Data Warehouse.
+	TypeSynapse = "synapse"
+	// TypeSynthetic is a fake database producing random values, as added by --test.synthetic-targets.
+	TypeSynthetic = "synthetic"
+)
+
+// Database represents a MS SQL database connection.
+type Database struct {
+	Name               string
+	Server             string
+	ConnectionSettings `yaml:",inline"`
+	// MinScrapeInterval is the minimum time between two queries against the database. Scrapes
+	// arriving sooner are answered from the values of the previous query.
+	MinScrapeInterval time.Duration `yaml:"min_scrape_interval"`
+	// Collectors lists the optional collectors enabled for the database. If not set, the collectors are chosen
+	// by the service tier of the database.
+	Collectors []string
+	// Metadata holds arbitrary deployment information that info metrics can refer to.
+	Metadata map[string]string
+	// Labels are static labels added to all metrics of the database.
+	Labels map[string]string
+	// Type is the kind of database, either "database" for Azure SQL Database, the default,
+	// "managed_instance" for a database on an Azure SQL Managed Instance, "server" for the master database of
+	// a logical server, "sqlserver" for a database of an on-premises or IaaS SQL Server or "synapse" for a
+	// dedicated SQL pool.
+	Type string
+	// PausedRetryInterval is how long to wait before connecting to the database again after it was found
+	// paused. Connecting to a paused serverless database resumes it. If 0, paused databases are retried like any
+	// other failing database.
+	PausedRetryInterval time.Duration `yaml:"paused_retry_interval"`
+	// ApplicationIntent is the application intent of the connection, either "readwrite", the default, or
+	// "readonly" to connect to a readable secondary replica.
+	ApplicationIntent string `yaml:"application_intent"`
+	// ConnectionString is a go-mssqldb connection string used in place of the connection fields above, e.g. to
+	// set parameters the exporter doesn't know about. Server and Name default to its server and database.
+	ConnectionString string `yaml:"dsn"`
+	// SSHTunnel configures a bastion host the database is reached through.
+	SSHTunnel *SSHTunnel `yaml:"ssh_tunnel"`
+	// Region is the Azure region of the database. Looked up through the Azure Resource Manager API if not set
+	// and the API is configured.
+	Region string
+	// FailoverPartner is the host name of the partner server of the failover group of the database. Looked up
+	// through the Azure Resource Manager API if not set and the API is configured. If set, connections fall back
+	// to it when the server can't be reached.
+	FailoverPartner string `yaml:"failover_partner"`
+	// Prewarm connects to the database at startup and keeps the connections open between scrapes.
+	Prewarm bool
+	// HonorSourceTimestamps exposes the resource gauges with the end_time of their row in sys.dm_db_resource_stats
+	// as timestamp instead of the time of the scrape.
+	HonorSourceTimestamps bool `yaml:"honor_source_timestamps"`
+	// Auth is the authentication method, either "sql", the default, or "windows" for integrated Windows
+	// authentication. With "windows" and no user, the exporter logs in as the account it runs as through SSPI,
+	// which is only available on Windows. Elsewhere, User must be given as DOMAIN\user and is authenticated with
+	// NTLM.
+	Auth string
+	// ServerSPN is the service principal name of the server for Windows authentication. Defaults to
+	// MSSQLSvc/<server>:<port>.
+	ServerSPN string `yaml:"server_spn"`
+	// SLO is the availability objective of the database, if any.
+	SLO *SLO
+	// Queries override the SQL of the built-in collectors' queries by name, in addition to the queries of the
+	// config.
+	Queries map[string]string
+	// Group is the name of the group the database is in. The databases of a group are exposed at their own path
+	// with a registry of their own.
+	Group string
+	// MaintenanceWindows are the recurring windows in which the database isn't scraped.
+	MaintenanceWindows []MaintenanceWindow `yaml:"maintenance_windows"`
+}
+
+// LabelValues returns the values of the server and database labels followed by the values of the given static
+// labels.
+func (d Database) LabelValues(extraLabels []string) []string {
+	values := []string{d.Server, d.Name}
+	for _, name := range extraLabels {
+		values = append(values, d.Labels[name])
+	}
+	return values
+}
+
+// ConstLabels returns the given static labels of the database. Labels the database doesn't set are empty.
+func (d Database) ConstLabels(extraLabels []string) map[string]string {
+	labels := map[string]string{}
+	for _, name := range extraLabels {
+		labels[name] = d.Labels[name]
+	}
+	return labels
+}
+
+// LabelNames returns the names of the static labels set for any of the databases in alphabetical order.
+func LabelNames(dbs []Database) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, db := range dbs {
+		for name := range db.Labels {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DSN returns the data source name as a string for the DB connection.
+func (d Database) DSN() string {
+	if d.ConnectionString != "" {
+		return d.ConnectionString + d.connectionStringAppName() + d.intentParam() + d.failoverParam()
+	}
+	return d.dsn(d.Password)
+}
+
+// String returns the data source name like DSN, with the password hidden for safe log output.
+func (d Database) String() string {
+	if d.ConnectionString != "" {
+		params := strings.Split(d.ConnectionString, ";")
+		for i, param := range params {
+			key := strings.SplitN(param, "=", 2)[0]
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "password", "pwd":
+				params[i] = key + "=******"
+			}
+		}
+		return strings.Join(params, ";") + d.connectionStringAppName() + d.intentParam() + d.failoverParam()
+	}
+	return d.dsn("******")
+}
+
+func (d Database) dsn(password string) string {
+	if d.Auth != AuthWindows {
+		return fmt.Sprintf("%s;user id=%s;password=%s;database=%s", d.serverParams(), d.User, password, d.Name) + d.params() + d.intentParam() + d.failoverParam()
+	}
+	dsn := fmt.Sprintf("%s;database=%s", d.serverParams(), d.Name)
+	// Without a user, the driver uses the credentials of the process.
+	if d.User != "" {
+		dsn += fmt.Sprintf(";user id=%s;password=%s", d.User, password)
+	}
+	// The SPN is set explicitly, as the driver derives it from the host it connects to, which is the local end of
+	// an SSH tunnel if one is configured.
+	spn := d.ServerSPN
+	if spn == "" {
+		spn = d.spn()
+	}
+	return dsn + ";ServerSPN=" + spn + d.params() + d.intentParam() + d.failoverParam()
+}
+
+// connectionStringAppName returns the application name parameter to add to the connection string, none if it
+// sets one itself.
+func (d Database) connectionStringAppName() string {
+	if _, ok := connectionStringParams(d.ConnectionString)["app name"]; ok {
+		return ""
+	}
+	return appNameParam(d.AppName)
+}
+
+func (d Database) intentParam() string {
+	if d.ApplicationIntent == IntentReadOnly {
+		return ";applicationintent=ReadOnly"
+	}
+	return ""
+}
+
+// Application intents.
+const (
+	IntentReadWrite = "readwrite"
+	IntentReadOnly  = "readonly"
+)
+
+// Authentication methods.
+const (
+	AuthSQL     = "sql"
+	AuthWindows = "windows"
+)
+
+// ReplicaLabel is the label distinguishing the metrics of the primary from those of the readable secondary
+// replica. It is added to all databases as soon as one of them sets an application intent.
+const ReplicaLabel = "replica"
+
+// Replica returns the value of the replica label of the database.
+func (d Database) Replica() string {
+	if d.ApplicationIntent == IntentReadOnly {
+		return "readonly"
+	}
+	return "primary"
+}
+
+// Key identifies the database among the configured ones. Database names are case insensitive, the same database
+// is only allowed once per replica.
+func (d Database) Key() string {
+	return strings.ToLower(d.Server + "/" + d.Name + "/" + d.Replica())
+}
+
+// Config contains all the required information for connecting to the databases.
+type Config struct {
+	Databases []Database
+	// Defaults are the connection settings of all databases that don't set them.
+	Defaults ConnectionSettings
+	// Servers group the databases of a server, which inherit the server and its connection settings.
+	Servers     []ServerGroup
+	InfoMetrics []InfoMetric `yaml:"info_metrics"`
+	// TierCollectors maps service tiers, as returned by DATABASEPROPERTYEX(..., 'Edition'), to the optional
+	// collectors enabled for databases of that tier that don't list their collectors.
+	TierCollectors map[string][]string `yaml:"tier_collectors"`
+	// Tenants are subsets of the metrics exposed at their own paths.
+	Tenants []Tenant
+	// Redaction configures how sensitive label values such as login names are exposed.
+	Redaction Redaction
+	// Azure enables looking up the databases through the Azure Resource Manager API.
+	Azure *AzureConfig
+	// Queries override the SQL of the built-in collectors' queries by name for all databases that don't override
+	// them themselves.
+	Queries map[string]string
+}
+
+// Load reads the config from a local YAML file. The collectors the databases and tiers enable must be among the
+// given names of the available collectors.
+func Load(path string, collectors []string) (Config, error) {
+	config, err := readFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return config.validate(collectors)
+}
+
+// ValidateDatabase checks a database added at runtime, e.g. through the API, and completes it like Load completes
+// the databases of the config: it inherits the defaults and queries of c.
+func (c Config) ValidateDatabase(db Database, collectors []string) (Database, error) {
+	db.ConnectionSettings.inherit(c.Defaults)
+	single, err := Config{Databases: []Database{db}, Queries: c.Queries, Tenants: c.Tenants}.validate(collectors)
+	if err != nil {
+		return Database{}, err
+	}
+	return single.Databases[0], nil
+}
+
+// readFile reads the config file at path, decrypting it if needed, and expands its server groups and defaults into its databases.
+func readFile(path string) (Config, error) {
+	fh, err := readConfigFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var config Config
+	err = yaml.Unmarshal(fh, &config)
+	if err != nil {
+		return Config{}, fmt.Errorf("unable to unmarshal file %s: %s", path, err)
+	}
+	if config.Databases, err = config.expandServers(); err != nil {
+		return Config{}, err
+	}
+	config.Servers = nil
+	return config, nil
+}
+
+// validate checks the config and completes the databases from their connection strings.
+func (config Config) validate(collectors []string) (Config, error) {
+	tierCollectors := map[string][]string{}
+	for tier, names := range config.TierCollectors {
+		for _, name := range names {
+			if !contains(collectors, name) {
+				return Config{}, fmt.Errorf("unknown collector %q for tier %s, available collectors: %s", name, tier, strings.Join(collectors, ", "))
+			}
+		}
+		tierCollectors[strings.ToLower(tier)] = names
+	}
+	config.TierCollectors = tierCollectors
+	if err := validateTenants(config.Tenants); err != nil {
+		return Config{}, err
+	}
+	if err := validateGroups(config.Databases, config.Tenants); err != nil {
+		return Config{}, err
+	}
+	if err := config.Redaction.validate(); err != nil {
+		return Config{}, err
+	}
+	if config.Azure != nil {
+		if err := config.Azure.validate(); err != nil {
+			return Config{}, err
+		}
+	}
+	intents := false
+	for i, db := range config.Databases {
+		switch db.ApplicationIntent {
+		case "", IntentReadWrite, IntentReadOnly:
+		default:
+			return Config{}, fmt.Errorf("unknown application intent %q for database %s, must be %s or %s", db.ApplicationIntent, db.Name, IntentReadWrite, IntentReadOnly)
+		}
+		if db.ApplicationIntent != "" {
+			intents = true
+		}
+		if db.ConnectionString != "" {
+			params := connectionStringParams(db.ConnectionString)
+			if db.Server == "" {
+				db.Server = params["server"]
+				config.Databases[i].Server = db.Server
+			}
+			if db.Name == "" {
+				db.Name = params["database"]
+				config.Databases[i].Name = db.Name
+			}
+			if db.Server == "" {
+				return Config{}, fmt.Errorf("dsn of database %s has no server, set server", db.Name)
+			}
+		}
+		switch db.Auth {
+		case "", AuthSQL:
+		case AuthWindows:
+			if db.ConnectionString != "" {
+				return Config{}, fmt.Errorf("auth of database %s can't be combined with dsn", db.Name)
+			}
+			if db.User != "" && !strings.Contains(db.User, `\`) {
+				return Config{}, fmt.Errorf("user of database %s must be given as DOMAIN\\user for windows auth", db.Name)
+			}
+			if db.User == "" && runtime.GOOS != "windows" {
+				return Config{}, fmt.Errorf("windows auth of database %s without a user is only supported on Windows, set user and password", db.Name)
+			}
+		default:
+			return Config{}, fmt.Errorf("unknown auth %q for database %s, must be %s or %s", db.Auth, db.Name, AuthSQL, AuthWindows)
+		}
+		if err := db.ConnectionSettings.validate(); err != nil {
+			return Config{}, fmt.Errorf("invalid connection settings of database %s: %s", db.Name, err)
+		}
+		if db.IsContainedUser() && (db.Type == TypeServer || db.Type == TypeManagedInstance || db.Type == TypeSQLServer) {
+			return Config{}, fmt.Errorf("database %s of type %s requires a server login, contained users have no access to master", db.Name, db.Type)
+		}
+		for j := range db.MaintenanceWindows {
+			if err := db.MaintenanceWindows[j].validate(); err != nil {
+				return Config{}, fmt.Errorf("maintenance window %d of database %s: %s", j+1, db.Name, err)
+			}
+		}
+		if db.SLO != nil && (db.SLO.Objective <= 0 || db.SLO.Objective >= 1) {
+			return Config{}, fmt.Errorf("slo objective of database %s must be between 0 and 1", db.Name)
+		}
+		if tun := db.SSHTunnel; tun != nil && (tun.Host == "" || tun.User == "" || tun.KeyFile == "" || tun.HostKey == "") {
+			return Config{}, fmt.Errorf("ssh_tunnel of database %s requires host, user, key_file and host_key", db.Name)
+		}
+		switch db.Type {
+		case "", TypeDatabase, TypeManagedInstance, TypeSynapse:
+		case TypeServer, TypeSQLServer:
+			if db.Name == "" {
+				config.Databases[i].Name = "master"
+			}
+		default:
+			return Config{}, fmt.Errorf("unknown type %q for database %s, must be %s, %s, %s, %s or %s", db.Type, db.Name, TypeDatabase, TypeManagedInstance, TypeServer, TypeSQLServer, TypeSynapse)
+		}
+		if db.Server == "" {
+			return Config{}, fmt.Errorf("database %s has no server, set server or dsn", db.Name)
+		}
+		if db.ConnectionString == "" {
+			if err := validateServer(db.Server); err != nil {
+				return Config{}, fmt.Errorf("invalid server of database %s: %s", db.Name, err)
+			}
+			if db.FailoverPartner != "" {
+				if err := validateServer(db.FailoverPartner); err != nil {
+					return Config{}, fmt.Errorf("invalid failover_partner of database %s: %s", db.Name, err)
+				}
+			}
+			if db.SSHTunnel != nil && db.Instance() != "" && db.Port == 0 {
+				return Config{}, fmt.Errorf("ssh_tunnel of database %s on named instance %s requires port, the instance can't be looked up through the tunnel", db.Name, db.Server)
+			}
+		}
+		if config.Databases[i].Name == "" {
+			return Config{}, fmt.Errorf("database on server %s has no name, set name or dsn", db.Server)
+		}
+		for _, name := range db.Collectors {
+			if !contains(collectors, name) {
+				return Config{}, fmt.Errorf("unknown collector %q for database %s, available collectors: %s", name, db.Name, strings.Join(collectors, ", "))
+			}
+		}
+		for name := range db.Labels {
+			if name == "server" || name == "database" {
+				return Config{}, fmt.Errorf("label %q of database %s is reserved", name, db.Name)
+			}
+		}
+		queries := map[string]string{}
+		for name, query := range config.Queries {
+			queries[name] = query
+		}
+		for name, query := range db.Queries {
+			queries[name] = query
+		}
+		for name, query := range queries {
+			if strings.TrimSpace(query) == "" {
+				return Config{}, fmt.Errorf("query %s of database %s is empty", name, db.Name)
+			}
+		}
+		if len(queries) > 0 {
+			config.Databases[i].Queries = queries
+		}
+	}
+	seen := map[string]bool{}
+	for _, db := range config.Databases {
+		key := db.Key()
+		if seen[key] {
+			return Config{}, fmt.Errorf("database %s on server %s is configured more than once", db.Name, db.Server)
+		}
+		seen[key] = true
+	}
+	if intents {
+		// The same database may be configured once per replica, so the metrics need a label telling them apart.
+		for i, db := range config.Databases {
+			if _, ok := db.Labels[ReplicaLabel]; ok {
+				return Config{}, fmt.Errorf("label %q of database %s is reserved when application intents are used", ReplicaLabel, db.Name)
+			}
+			labels := map[string]string{ReplicaLabel: db.Replica()}
+			for name, value := range db.Labels {
+				labels[name] = value
+			}
+			config.Databases[i].Labels = labels
+		}
+	}
+	return config, nil
+}
+
+// connectionStringParams returns the parameters of a go-mssqldb connection string by lower case name.
+func connectionStringParams(dsn string) map[string]string {
+	params := map[string]string{}
+	for _, param := range strings.Split(dsn, ";") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+		}
+	}
+	return params
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ConnectionSettings are the settings of the connection to a database that databases inherit from the defaults
+// and their server group in the config file.
+type ConnectionSettings struct {
+	User     string
+	Password string
+	Port     uint
+	// ConnectionTimeout is how long to wait for the login to the database, 30s by default. Rounded up to whole
+	// seconds.
+	ConnectionTimeout time.Duration `yaml:"connection_timeout"`
+	// DialTimeout is how long to wait for the TCP connection to the server, 5s by default. Rounded up to whole
+	// seconds.
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+	// Encrypt is "true" to encrypt all traffic, "false" to only encrypt the login and "disable" to not encrypt at
+	// all. If not set, the server certificate isn't verified unless TrustServerCertificate is false.
+	Encrypt string
+	// TrustServerCertificate skips the verification of the server certificate.
+	TrustServerCertificate *bool `yaml:"trust_server_certificate"`
+	// Certificate is the path of a file with the CA certificates the server certificate is verified against,
+	// instead of the system's.
+	Certificate string
+	// HostNameInCertificate is the host name the server certificate is verified for. Defaults to the server.
+	HostNameInCertificate string `yaml:"hostname_in_certificate"`
+	// ContainedUser is true if User is a contained database user rather than a server login. Contained users
+	// only have access to their own database, not to master or tempdb.
+	ContainedUser *bool `yaml:"contained_user"`
+	// AppName is the application name the exporter connects with, as returned by APP_NAME(). Defaults to
+	// DefaultAppName.
+	AppName string `yaml:"app_name"`
+	// ConnectionMaxLifetime is how long connections kept open between scrapes are reused before they are closed
+	// and the server name is resolved again, e.g. to follow the listener of a failover group to the new primary.
+	// Connections are reused indefinitely if it isn't set.
+	ConnectionMaxLifetime time.Duration `yaml:"connection_max_lifetime"`
+}
+
+// DefaultAppName is the application name of connections to databases that don't set one, so their sessions,
+// queries and audit records can be attributed to the exporter.
+var DefaultAppName = "azure_sql_exporter"
+
+// ServerGroup lists databases on the same server, which inherit the server and its connection settings.
+type ServerGroup struct {
+	Server             string
+	ConnectionSettings `yaml:",inline"`
+	// Group is the group of the databases of the server that don't set one.
+	Group     string
+	Databases []Database
+}
+
+// inherit sets the settings that s doesn't set to the ones of parent.
+func (s *ConnectionSettings) inherit(parent ConnectionSettings) {
+	if s.User == "" {
+		s.User = parent.User
+	}
+	if s.Password == "" {
+		s.Password = parent.Password
+	}
+	if s.Port == 0 {
+		s.Port = parent.Port
+	}
+	if s.ConnectionTimeout == 0 {
+		s.ConnectionTimeout = parent.ConnectionTimeout
+	}
+	if s.DialTimeout == 0 {
+		s.DialTimeout = parent.DialTimeout
+	}
+	if s.Encrypt == "" {
+		s.Encrypt = parent.Encrypt
+	}
+	if s.TrustServerCertificate == nil {
+		s.TrustServerCertificate = parent.TrustServerCertificate
+	}
+	if s.Certificate == "" {
+		s.Certificate = parent.Certificate
+	}
+	if s.HostNameInCertificate == "" {
+		s.HostNameInCertificate = parent.HostNameInCertificate
+	}
+	if s.ContainedUser == nil {
+		s.ContainedUser = parent.ContainedUser
+	}
+	if s.AppName == "" {
+		s.AppName = parent.AppName
+	}
+	if s.ConnectionMaxLifetime == 0 {
+		s.ConnectionMaxLifetime = parent.ConnectionMaxLifetime
+	}
+}
+
+// IsContainedUser reports whether the user is a contained database user.
+func (s ConnectionSettings) IsContainedUser() bool {
+	return s.ContainedUser != nil && *s.ContainedUser
+}
+
+func (s ConnectionSettings) validate() error {
+	switch strings.ToLower(s.Encrypt) {
+	case "", "true", "false", "disable":
+	default:
+		return fmt.Errorf("unknown encrypt %q, must be true, false or disable", s.Encrypt)
+	}
+	if s.ConnectionTimeout < 0 || s.DialTimeout < 0 || s.ConnectionMaxLifetime < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+	if s.Port > 65535 {
+		return fmt.Errorf("port %d is out of range, must be between 1 and 65535", s.Port)
+	}
+	if strings.ContainsAny(s.AppName, ";=") || len(s.AppName) > 128 {
+		return fmt.Errorf("app_name must not contain ; or = and is limited to 128 characters")
+	}
+	return nil
+}
+
+// params returns the connection string parameters of the settings other than the user, password and port.
+func (s ConnectionSettings) params() string {
+	var params string
+	if s.ConnectionTimeout > 0 {
+		params += fmt.Sprintf(";connection timeout=%d", seconds(s.ConnectionTimeout))
+	}
+	if s.DialTimeout > 0 {
+		params += fmt.Sprintf(";dial timeout=%d", seconds(s.DialTimeout))
+	}
+	if s.Encrypt != "" {
+		params += ";encrypt=" + s.Encrypt
+	}
+	if s.TrustServerCertificate != nil {
+		params += fmt.Sprintf(";TrustServerCertificate=%t", *s.TrustServerCertificate)
+	}
+	if s.Certificate != "" {
+		params += ";certificate=" + s.Certificate
+	}
+	if s.HostNameInCertificate != "" {
+		params += ";hostNameInCertificate=" + s.HostNameInCertificate
+	}
+	return params + appNameParam(s.AppName)
+}
+
+// appNameParam returns the connection string parameter of the application name, DefaultAppName if name is empty.
+func appNameParam(name string) string {
+	if name == "" {
+		name = DefaultAppName
+	}
+	return ";app name=" + name
+}
+
+// seconds returns d in whole seconds, rounded up.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// expandServers returns the databases of the config, followed by the databases of the server groups, with the
+// settings they don't set inherited from their server group and the defaults.
+func (c Config) expandServers() ([]Database, error) {
+	dbs := append([]Database{}, c.Databases...)
+	for _, g := range c.Servers {
+		if g.Server == "" {
+			return nil, fmt.Errorf("server group without server")
+		}
+		for _, db := range g.Databases {
+			if db.Server != "" && db.Server != g.Server {
+				return nil, fmt.Errorf("database %s of server group %s sets a different server %s", db.Name, g.Server, db.Server)
+			}
+			db.Server = g.Server
+			if db.Group == "" {
+				db.Group = g.Group
+			}
+			db.ConnectionSettings.inherit(g.ConnectionSettings)
+			dbs = append(dbs, db)
+		}
+	}
+	for i := range dbs {
+		dbs[i].ConnectionSettings.inherit(c.Defaults)
+	}
+	return dbs, nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Files returns the paths of the YAML files, ending in .yaml or .yml, in dir in alphabetical order.
+func Files(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read directory %s: %s", dir, err)
+	}
+	var paths []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// LoadDir reads all YAML files in dir in parallel and merges them into one config. The defaults and server groups of
+// a file only apply to the databases of that file. Databases, info metrics and tenants are concatenated in the
+// order of the file names; the service tiers of tier_collectors, the queries, and the azure and redaction sections,
+// may only be set in one file each. A database configured in more than one file is an error.
+func LoadDir(dir string, collectors []string) (Config, error) {
+	paths, err := Files(dir)
+	if err != nil {
+		return Config{}, err
+	}
+	if len(paths) == 0 {
+		return Config{}, fmt.Errorf("no .yaml or .yml files in directory %s", dir)
+	}
+	configs := make([]Config, len(paths))
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			configs[i], errs[i] = readFile(path)
+		}(i, path)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %s", paths[i], err)
+		}
+	}
+	config, err := merge(paths, configs)
+	if err != nil {
+		return Config{}, err
+	}
+	return config.validate(collectors)
+}
+
+// merge merges the configs read from the files at paths.
+func merge(paths []string, configs []Config) (Config, error) {
+	merged := Config{TierCollectors: map[string][]string{}, Queries: map[string]string{}}
+	// Files that set a database, tier, query or section, by key.
+	databases, tiers, queries := map[string]string{}, map[string]string{}, map[string]string{}
+	azure, redaction := "", ""
+	for i, c := range configs {
+		path := paths[i]
+		for _, db := range c.Databases {
+			// Databases configured with only a dsn get their server and name later and are checked for
+			// duplicates by validate.
+			if db.Server != "" && db.Name != "" {
+				key := db.Key()
+				if other, ok := databases[key]; ok {
+					return Config{}, fmt.Errorf("database %s on server %s is configured in both %s and %s", db.Name, db.Server, other, path)
+				}
+				databases[key] = path
+			}
+			merged.Databases = append(merged.Databases, db)
+		}
+		merged.InfoMetrics = append(merged.InfoMetrics, c.InfoMetrics...)
+		merged.Tenants = append(merged.Tenants, c.Tenants...)
+		for tier, names := range c.TierCollectors {
+			key := strings.ToLower(tier)
+			if other, ok := tiers[key]; ok {
+				return Config{}, fmt.Errorf("collectors of tier %s are configured in both %s and %s", tier, other, path)
+			}
+			tiers[key] = path
+			merged.TierCollectors[tier] = names
+		}
+		for name, query := range c.Queries {
+			if other, ok := queries[name]; ok {
+				return Config{}, fmt.Errorf("query %s is configured in both %s and %s", name, other, path)
+			}
+			queries[name] = path
+			merged.Queries[name] = query
+		}
+		if c.Azure != nil {
+			if azure != "" {
+				return Config{}, fmt.Errorf("azure is configured in both %s and %s", azure, path)
+			}
+			azure = path
+			merged.Azure = c.Azure
+		}
+		if len(c.Redaction.Labels) > 0 || c.Redaction.Salt != "" {
+			if redaction != "" {
+				return Config{}, fmt.Errorf("redaction is configured in both %s and %s", redaction, path)
+			}
+			redaction = path
+			merged.Redaction = c.Redaction
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDir(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		files     map[string]string
+		databases []string
+		err       string
+	}{
+		{"merged", map[string]string{
+			"sales.yaml": `
+defaults:
+  user: sales
+servers:
+  - server: sales.database.windows.net
+    databases:
+      - name: Sales
+      - name: Returns
+`,
+			"inventory.yml": `
+databases:
+  - name: Inventory
+    server: inventory.database.windows.net
+`,
+			"README.md": "not a config file",
+		}, []string{"Inventory@", "Sales@sales", "Returns@sales"}, ""},
+		{"duplicate", map[string]string{
+			"a.yaml": `
+databases:
+  - name: Sales
+    server: sales.database.windows.net
+`,
+			"b.yaml": `
+databases:
+  - name: sales
+    server: sales.database.windows.net
+`,
+		}, nil, "database sales on server sales.database.windows.net is configured in both"},
+		{"invalid file", map[string]string{
+			"a.yaml": "databases: [",
+		}, nil, "a.yaml: unable to unmarshal file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg, err := LoadDir(dir, nil)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want it to contain %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var databases []string
+			for _, db := range cfg.Databases {
+				databases = append(databases, db.Name+"@"+db.User)
+			}
+			if strings.Join(databases, ",") != strings.Join(tc.databases, ",") {
+				t.Errorf("got databases %v, want %v", databases, tc.databases)
+			}
+		})
+	}
+}
@@ -0,0 +1,396 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AgeKeyFile is the path of the age key file holding the identities that decrypt config files encrypted with age
+// or SOPS. Defaults to the SOPS_AGE_KEY_FILE environment variable; the identities can also be given in the
+// SOPS_AGE_KEY environment variable.
+var AgeKeyFile string
+
+// readConfigFile reads the config file at path. Files encrypted with age or SOPS are decrypted in memory, so the
+// plaintext never touches the disk.
+func readConfigFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %s: %s", path, err)
+	}
+	if isAgeEncrypted(b) {
+		identities, err := ageIdentities()
+		if err == nil {
+			b, err = ageDecrypt(b, identities)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt file %s: %s", path, err)
+		}
+		return b, nil
+	}
+	if metadata := sopsMetadataOf(b); metadata != nil {
+		if b, err = sopsDecrypt(b, metadata); err != nil {
+			return nil, fmt.Errorf("unable to decrypt SOPS file %s: %s", path, err)
+		}
+	}
+	return b, nil
+}
+
+// ageIdentities returns the identities of AgeKeyFile, SOPS_AGE_KEY_FILE or SOPS_AGE_KEY.
+func ageIdentities() ([]*ecdh.PrivateKey, error) {
+	path := AgeKeyFile
+	if path == "" {
+		path = os.Getenv("SOPS_AGE_KEY_FILE")
+	}
+	if path == "" {
+		if key := os.Getenv("SOPS_AGE_KEY"); key != "" {
+			return parseAgeIdentities([]byte(key))
+		}
+		return nil, errors.New("no age key file given, set --config.age-key-file")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read age key file: %s", err)
+	}
+	identities, err := parseAgeIdentities(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age key file %s: %s", path, err)
+	}
+	return identities, nil
+}
+
+// sopsMetadata is the sops section of a file encrypted with SOPS, holding the data key encrypted for every
+// recipient and the MAC of the values.
+type sopsMetadata struct {
+	Age []struct {
+		Recipient string
+		Enc       string
+	}
+	AzureKV []struct {
+		VaultURL string `yaml:"vault_url"`
+		Name     string
+		Version  string
+		Enc      string
+	} `yaml:"azure_kv"`
+	// Recipients of the other key management services of SOPS, which aren't supported.
+	AWSKMS           []interface{} `yaml:"kms"`
+	GCPKMS           []interface{} `yaml:"gcp_kms"`
+	HCVault          []interface{} `yaml:"hc_vault"`
+	PGP              []interface{} `yaml:"pgp"`
+	KeyGroups        []interface{} `yaml:"key_groups"`
+	LastModified     string        `yaml:"lastmodified"`
+	MAC              string        `yaml:"mac"`
+	MACOnlyEncrypted bool          `yaml:"mac_only_encrypted"`
+}
+
+// sopsMetadataOf returns the sops section of b, or nil if b isn't a YAML file encrypted with SOPS.
+func sopsMetadataOf(b []byte) *sopsMetadata {
+	var file struct {
+		Sops *sopsMetadata
+	}
+	if yaml.Unmarshal(b, &file) != nil || file.Sops == nil || file.Sops.MAC == "" {
+		return nil
+	}
+	return file.Sops
+}
+
+// sopsDecrypt decrypts the values of the YAML file b encrypted with SOPS and returns it without its sops section.
+// The data key is decrypted with an age identity or an Azure Key Vault key; Azure Key Vault is the only supported key
+// management service. Files with key groups are refused, since their data key is split into shares.
+func sopsDecrypt(b []byte, metadata *sopsMetadata) ([]byte, error) {
+	if len(metadata.KeyGroups) > 0 {
+		return nil, errors.New("key groups aren't supported, encrypt the file for age or Azure Key Vault recipients without key groups")
+	}
+	key, err := sopsDataKey(metadata)
+	if err != nil {
+		return nil, err
+	}
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal(b, &tree); err != nil {
+		return nil, err
+	}
+	for i, item := range tree {
+		if item.Key == "sops" {
+			tree = append(tree[:i], tree[i+1:]...)
+			break
+		}
+	}
+	d := sopsDecrypter{key: key, macOnlyEncrypted: metadata.MACOnlyEncrypted, hash: sha512.New()}
+	if _, err := d.walk(tree, nil); err != nil {
+		return nil, err
+	}
+	// The MAC over all values is encrypted with the time of the last modification as additional data.
+	lastModified, err := time.Parse(time.RFC3339, metadata.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lastmodified: %s", err)
+	}
+	mac, err := d.decrypt(metadata.MAC, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the MAC: %s", err)
+	}
+	if mac != fmt.Sprintf("%X", d.hash.Sum(nil)) {
+		if bytes.Contains(b, []byte("#ENC[")) {
+			return nil, errors.New("MAC mismatch, files with encrypted comments aren't supported, remove the comments before encrypting")
+		}
+		return nil, errors.New("MAC mismatch, the file was modified")
+	}
+	return yaml.Marshal(tree)
+}
+
+// sopsDataKey returns the data key of a SOPS file, decrypted with the first recipient that succeeds.
+func sopsDataKey(metadata *sopsMetadata) ([]byte, error) {
+	var errs []string
+	if len(metadata.Age) > 0 {
+		identities, err := ageIdentities()
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		for _, recipient := range metadata.Age {
+			if identities == nil {
+				break
+			}
+			key, err := ageDecrypt([]byte(recipient.Enc), identities)
+			if err == nil {
+				return key, nil
+			}
+			errs = append(errs, fmt.Sprintf("age recipient %s: %s", recipient.Recipient, err))
+		}
+	}
+	for _, kv := range metadata.AzureKV {
+		key, err := keyVaultDecrypt(kv.VaultURL, kv.Name, kv.Version, kv.Enc)
+		if err == nil {
+			return key, nil
+		}
+		errs = append(errs, fmt.Sprintf("Azure Key Vault key %s/keys/%s: %s", strings.TrimSuffix(kv.VaultURL, "/"), kv.Name, err))
+	}
+	if len(errs) == 0 {
+		var unsupported []string
+		for _, kms := range []struct {
+			name       string
+			recipients []interface{}
+		}{{"AWS KMS", metadata.AWSKMS}, {"GCP KMS", metadata.GCPKMS}, {"HashiCorp Vault", metadata.HCVault}, {"PGP", metadata.PGP}} {
+			if len(kms.recipients) > 0 {
+				unsupported = append(unsupported, kms.name)
+			}
+		}
+		if len(unsupported) > 0 {
+			return nil, fmt.Errorf("no age or Azure Key Vault recipient, %s isn't supported", strings.Join(unsupported, " and "))
+		}
+		return nil, errors.New("no age or Azure Key Vault recipient")
+	}
+	return nil, fmt.Errorf("unable to decrypt the data key: %s", strings.Join(errs, "; "))
+}
+
+// sopsDecrypter decrypts the values of a SOPS file and hashes them for the MAC, in the order of the file.
+type sopsDecrypter struct {
+	key              []byte
+	macOnlyEncrypted bool
+	hash             hash.Hash
+}
+
+var sopsValueRE = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// walk decrypts the values in v, whose keys are path, in place.
+func (d *sopsDecrypter) walk(v interface{}, path []string) (interface{}, error) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for i, item := range v {
+			value, err := d.walk(item.Value, append(path[:len(path):len(path)], fmt.Sprint(item.Key)))
+			if err != nil {
+				return nil, err
+			}
+			v[i].Value = value
+		}
+		return v, nil
+	case []interface{}:
+		// Items of lists are encrypted with the path of the list.
+		for i, item := range v {
+			value, err := d.walk(item, path)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = value
+		}
+		return v, nil
+	}
+	encrypted := false
+	if s, ok := v.(string); ok && sopsValueRE.MatchString(s) {
+		var err error
+		if v, err = d.decrypt(s, strings.Join(path, ":")+":"); err != nil {
+			return nil, fmt.Errorf("unable to decrypt %s: %s", strings.Join(path, "."), err)
+		}
+		encrypted = true
+	}
+	if encrypted || !d.macOnlyEncrypted {
+		d.hash.Write(sopsMACBytes(v))
+	}
+	return v, nil
+}
+
+// decrypt returns the value of the encrypted SOPS value s, converted to its type.
+func (d *sopsDecrypter) decrypt(s, additionalData string) (interface{}, error) {
+	m := sopsValueRE.FindStringSubmatch(s)
+	if m == nil {
+		return nil, errors.New("not an encrypted value")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		var err error
+		if parts[i], err = base64.StdEncoding.DecodeString(m[i+1]); err != nil {
+			return nil, err
+		}
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	block, err := aes.NewCipher(d.key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, errors.New("authentication failed, the value was modified or moved")
+	}
+	switch m[4] {
+	case "str":
+		return string(plaintext), nil
+	case "int":
+		v, err := strconv.Atoi(string(plaintext))
+		return v, err
+	case "float":
+		v, err := strconv.ParseFloat(string(plaintext), 64)
+		return v, err
+	case "bool":
+		v, err := strconv.ParseBool(string(plaintext))
+		return v, err
+	case "bytes":
+		return string(plaintext), nil
+	}
+	return nil, fmt.Errorf("unknown type %s", m[4])
+}
+
+// sopsMACBytes returns the bytes of the value v hashed for the MAC, as SOPS formats them.
+func sopsMACBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(v)
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		if v {
+			return []byte("True")
+		}
+		return []byte("False")
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+// keyVaultDecrypt decrypts the SOPS data key enc with the RSA key name of the Azure Key Vault at vaultURL. The access
+// token is requested for the service principal of the AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET
+// environment variables if set, and for the managed identity of the host otherwise.
+func keyVaultDecrypt(vaultURL, name, version, enc string) ([]byte, error) {
+	u, err := url.Parse(vaultURL)
+	if err != nil {
+		return nil, err
+	}
+	// The resource of the access token is the vault's domain, e.g. https://vault.azure.net.
+	resource := u.Scheme + "://" + u.Host[strings.Index(u.Host, ".")+1:]
+	token, err := azureAccessToken(resource)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]string{"alg": "RSA-OAEP-256", "value": enc})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/keys/%s/%s/decrypt?api-version=7.4", strings.TrimSuffix(vaultURL, "/"), name, version), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	var result struct {
+		Value string `json:"value"`
+	}
+	if err := doAzureRequest(req, &result); err != nil {
+		return nil, err
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(result.Value, "="))
+}
+
+// azureAccessToken returns an access token for the resource, e.g. https://vault.azure.net, for the service
+// principal of the environment variables or the managed identity of the host.
+func azureAccessToken(resource string) (string, error) {
+	var req *http.Request
+	var err error
+	if tenant, secret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_SECRET"); tenant != "" && secret != "" {
+		authority := os.Getenv("AZURE_AUTHORITY_HOST")
+		if authority == "" {
+			authority = defaultAuthorityHost
+		}
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {os.Getenv("AZURE_CLIENT_ID")},
+			"client_secret": {secret},
+			"scope":         {resource + "/.default"},
+		}
+		req, err = http.NewRequest("POST", fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authority, "/"), tenant), strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := url.Values{"api-version": {"2018-02-01"}, "resource": {resource}}
+		if id := os.Getenv("AZURE_CLIENT_ID"); id != "" {
+			query.Set("client_id", id)
+		}
+		req, err = http.NewRequest("GET", "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata", "true")
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := doAzureRequest(req, &token); err != nil {
+		return "", fmt.Errorf("unable to get Azure access token: %s", err)
+	}
+	return token.AccessToken, nil
+}
+
+// doAzureRequest sends req and decodes the JSON response into out.
+func doAzureRequest(req *http.Request, out interface{}) error {
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
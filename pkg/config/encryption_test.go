@@ -0,0 +1,252 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestEncryptedConfig(t *testing.T) {
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	AgeKeyFile = filepath.Join(dir, "keys.txt")
+	defer func() { AgeKeyFile = "" }()
+	write(t, AgeKeyFile, "# created: 2026-10-15T00:00:00Z\n"+bech32Encode("age-secret-key-", identity.Bytes())+"\n")
+
+	config := `
+databases:
+  - name: Sales
+    server: sales.database.windows.net
+    user: exporter
+    password: s3cr3t
+`
+	age := filepath.Join(dir, "age.yaml")
+	write(t, age, ageEncrypt(t, []byte(config), identity.PublicKey()))
+	key := make([]byte, 32)
+	rand.Read(key)
+	dataKey := ageEncrypt(t, key, identity.PublicKey())
+	sopsPath := filepath.Join(dir, "sops.yaml")
+	write(t, sopsPath, sopsEncrypt(t, key, dataKey, "Sales", "s3cr3t"))
+	for _, path := range []string{age, sopsPath} {
+		cfg, err := Load(path, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if db := cfg.Databases[0]; db.Name != "Sales" || db.Password != "s3cr3t" {
+			t.Errorf("%s: got database %s with password %q, want Sales with s3cr3t", path, db.Name, db.Password)
+		}
+	}
+
+	// Values can't be moved to another key.
+	swapped := filepath.Join(dir, "swapped.yaml")
+	lines := strings.Split(sopsEncrypt(t, key, dataKey, "Sales", "s3cr3t"), "\n")
+	name, password := strings.TrimPrefix(lines[1], "  - name: "), strings.TrimPrefix(lines[3], "    password: ")
+	lines[1], lines[3] = "  - name: "+password, "    password: "+name
+	write(t, swapped, strings.Join(lines, "\n"))
+	if _, err := Load(swapped, nil); err == nil || !strings.Contains(err.Error(), "unable to decrypt databases.name") {
+		t.Errorf("got error %v for moved values, want authentication to fail", err)
+	}
+
+	// Only age and Azure Key Vault recipients are supported, without key groups.
+	for recipients, want := range map[string]string{
+		"  kms:\n    - arn: arn:aws:kms:us-east-1:123456789012:key/test\n      enc: test\n": "AWS KMS isn't supported",
+		"  key_groups:\n    - age:\n        - recipient: age1test\n          enc: test\n":   "key groups aren't supported",
+	} {
+		unsupported := filepath.Join(dir, "unsupported.yaml")
+		write(t, unsupported, strings.Replace(sopsEncrypt(t, key, dataKey, "Sales", "s3cr3t"), "sops:\n  age:", "sops:\n"+recipients+"  unused:", 1))
+		if _, err := Load(unsupported, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	}
+
+	other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	write(t, age, ageEncrypt(t, []byte(config), other.PublicKey()))
+	if _, err := Load(age, nil); err == nil || !strings.Contains(err.Error(), "no age identity matches") {
+		t.Errorf("got error %v for another recipient, want no matching identity", err)
+	}
+}
+
+func TestAgeChunks(t *testing.T) {
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities := []*ecdh.PrivateKey{identity}
+	for _, size := range []int{0, 1, ageChunkSize - 1, ageChunkSize, ageChunkSize + 1, 2 * ageChunkSize, 2*ageChunkSize + 100} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		file := ageEncryptBinary(t, plaintext, identity.PublicKey())
+		for name, b := range map[string][]byte{"binary": file, "armored": []byte(ageArmor(file))} {
+			if !isAgeEncrypted(b) {
+				t.Errorf("%d bytes %s: not recognized as encrypted with age", size, name)
+				continue
+			}
+			decrypted, err := ageDecrypt(b, identities)
+			if err != nil {
+				t.Errorf("%d bytes %s: %s", size, name, err)
+			} else if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("%d bytes %s: got %d bytes of plaintext that differ", size, name, len(decrypted))
+			}
+		}
+	}
+
+	// Dropping the last chunk leaves a file whose new last chunk isn't flagged as such.
+	plaintext := make([]byte, ageChunkSize+1)
+	file := ageEncryptBinary(t, plaintext, identity.PublicKey())
+	truncated := file[:len(file)-(1+chacha20poly1305.Overhead)]
+	if _, err := ageDecrypt(truncated, identities); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("got error %v for a truncated file, want authentication to fail", err)
+	}
+}
+
+func write(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ageEncrypt returns the plaintext encrypted with age for recipient, ASCII armored.
+func ageEncrypt(t *testing.T, plaintext []byte, recipient *ecdh.PublicKey) string {
+	return ageArmor(ageEncryptBinary(t, plaintext, recipient))
+}
+
+// ageEncryptBinary returns the plaintext encrypted with age for recipient, in chunks of 64 KiB of which the last
+// one is flagged. Like age, it only writes an empty chunk for an empty plaintext.
+func ageEncryptBinary(t *testing.T, plaintext []byte, recipient *ecdh.PublicKey) []byte {
+	fileKey := make([]byte, 16)
+	rand.Read(fileKey)
+	ephemeral, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	share := ephemeral.PublicKey().Bytes()
+	wrapKey, _ := hkdf.Key(sha256.New, shared, append(append([]byte{}, share...), recipient.Bytes()...), "age-encryption.org/v1/X25519", 32)
+	aead, _ := chacha20poly1305.New(wrapKey)
+	body := aead.Seal(nil, make([]byte, 12), fileKey, nil)
+	header := fmt.Sprintf("age-encryption.org/v1\n-> X25519 %s\n%s\n---", base64.RawStdEncoding.EncodeToString(share), base64.RawStdEncoding.EncodeToString(body))
+	hmacKey, _ := hkdf.Key(sha256.New, fileKey, nil, "header", 32)
+	h := hmac.New(sha256.New, hmacKey)
+	h.Write([]byte(header))
+	var file bytes.Buffer
+	file.WriteString(header + " " + base64.RawStdEncoding.EncodeToString(h.Sum(nil)) + "\n")
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	file.Write(nonce)
+	streamKey, _ := hkdf.Key(sha256.New, fileKey, nonce, "payload", 32)
+	aead, _ = chacha20poly1305.New(streamKey)
+	chunkNonce := make([]byte, 12)
+	for counter := uint64(0); ; counter++ {
+		last := len(plaintext) <= ageChunkSize
+		n := len(plaintext)
+		if !last {
+			n = ageChunkSize
+		}
+		binary.BigEndian.PutUint64(chunkNonce[3:11], counter)
+		if last {
+			chunkNonce[11] = 1
+		}
+		file.Write(aead.Seal(nil, chunkNonce, plaintext[:n], nil))
+		plaintext = plaintext[n:]
+		if last {
+			return file.Bytes()
+		}
+	}
+}
+
+// ageArmor returns the age file b ASCII armored, with the base64 wrapped at 64 columns.
+func ageArmor(b []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(b)
+	armored := "-----BEGIN AGE ENCRYPTED FILE-----\n"
+	for len(encoded) > 64 {
+		armored += encoded[:64] + "\n"
+		encoded = encoded[64:]
+	}
+	return armored + encoded + "\n-----END AGE ENCRYPTED FILE-----\n"
+}
+
+// sopsEncrypt returns a SOPS file with one database of the name and password, encrypted with key. Its server isn't
+// encrypted, as with an encrypted_regex.
+func sopsEncrypt(t *testing.T, key []byte, dataKey, name, password string) string {
+	encrypt := func(value, additionalData string) string {
+		block, _ := aes.NewCipher(key)
+		gcm, _ := cipher.NewGCMWithNonceSize(block, 32)
+		iv := make([]byte, 32)
+		rand.Read(iv)
+		sealed := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+		data, tag := sealed[:len(sealed)-16], sealed[len(sealed)-16:]
+		return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]", base64.StdEncoding.EncodeToString(data), base64.StdEncoding.EncodeToString(iv), base64.StdEncoding.EncodeToString(tag))
+	}
+	hash := sha512.New()
+	for _, v := range []string{name, "sales.database.windows.net", password} {
+		hash.Write([]byte(v))
+	}
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	return fmt.Sprintf(`databases:
+  - name: %s
+    server: sales.database.windows.net
+    password: %s
+sops:
+  age:
+    - recipient: age1test
+      enc: |
+%s
+  lastmodified: "%s"
+  mac: %s
+  version: 3.9.0
+`, encrypt(name, "databases:name:"), encrypt(password, "databases:password:"), "        "+strings.Replace(strings.TrimSpace(dataKey), "\n", "\n        ", -1), lastModified, encrypt(fmt.Sprintf("%X", hash.Sum(nil)), lastModified))
+}
+
+// bech32Encode returns the bech32 encoding of data with the human readable part hrp, in upper case as age writes
+// identities.
+func bech32Encode(hrp string, data []byte) string {
+	var values []byte
+	acc, bits := 0, 0
+	for _, b := range data {
+		acc = (acc<<8 | int(b)) & 0xfff
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits&31))
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits)&31))
+	}
+	var check []byte
+	for _, c := range hrp {
+		check = append(check, byte(c)>>5)
+	}
+	check = append(check, 0)
+	for _, c := range hrp {
+		check = append(check, byte(c)&31)
+	}
+	polymod := bech32Polymod(append(append(check, values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>uint(5*(5-i))&31))
+	}
+	s := hrp + "1"
+	for _, v := range values {
+		s += string(bech32Charset[v])
+	}
+	return strings.ToUpper(s)
+}
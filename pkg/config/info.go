@@ -0,0 +1,9 @@
+package config
+
+// InfoMetric defines a static metric with the value 1 that is exported for every database. Its label values
+// are templates rendered with the database's configuration, e.g. "{{.Metadata.owner}}".
+type InfoMetric struct {
+	Name   string
+	Help   string
+	Labels map[string]string
+}
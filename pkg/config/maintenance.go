@@ -0,0 +1,190 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMaintenanceDuration is the longest maintenance window, which bounds the search for the start of an active
+// window.
+const maxMaintenanceDuration = 7 * 24 * time.Hour
+
+// MaintenanceWindow is a recurring time window in which a database isn't scraped, e.g. for planned changes of its
+// service tier or failovers.
+type MaintenanceWindow struct {
+	// Schedule is a cron expression of the starts of the window with the fields minute, hour, day of month, month
+	// and day of week, e.g. "0 2 * * 6" for 02:00 on Saturdays.
+	Schedule string
+	// Duration is how long the window lasts from every start.
+	Duration time.Duration
+	// TimeZone is the IANA name of the time zone of the schedule. Defaults to UTC.
+	TimeZone string `yaml:"time_zone"`
+
+	cron *cronSchedule
+}
+
+// Active reports whether t is within the window, at or after one of its starts and before the end of the window.
+func (w MaintenanceWindow) Active(t time.Time) bool {
+	cron := w.cron
+	if cron == nil {
+		var err error
+		if cron, err = parseCron(w.Schedule); err != nil {
+			return false
+		}
+	}
+	location, err := loadLocation(w.TimeZone)
+	if err != nil {
+		return false
+	}
+	t = t.In(location)
+	for start := t.Truncate(time.Minute); t.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+		if cron.matches(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the window and parses its schedule.
+func (w *MaintenanceWindow) validate() error {
+	if w.Duration <= 0 || w.Duration > maxMaintenanceDuration {
+		return fmt.Errorf("duration must be positive and at most %s", maxMaintenanceDuration)
+	}
+	cron, err := parseCron(w.Schedule)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q: %s", w.Schedule, err)
+	}
+	if _, err := loadLocation(w.TimeZone); err != nil {
+		return fmt.Errorf("invalid time zone %q: %s", w.TimeZone, err)
+	}
+	w.cron = cron
+	return nil
+}
+
+// locations caches the time zones of the maintenance windows by name, as loading them reads the time zone
+// database.
+var locations sync.Map
+
+// loadLocation returns the time zone of the name, UTC if it is empty.
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if location, ok := locations.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, location)
+	return location, nil
+}
+
+// cronSchedule holds the values every field of a cron expression matches.
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays map[int]bool
+	// anyDay and anyWeekday are true if the day of month or day of week field is *. If both fields are restricted,
+	// a time matches if either does, as in cron.
+	anyDay, anyWeekday bool
+}
+
+func (c *cronSchedule) matches(t time.Time) bool {
+	if !c.minutes[t.Minute()] || !c.hours[t.Hour()] || !c.months[int(t.Month())] {
+		return false
+	}
+	day, weekday := c.days[t.Day()], c.weekdays[int(t.Weekday())]
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// parseCron parses a cron expression of five fields. Fields are *, a value, a range a-b, either with a step /n,
+// or a comma separated list of these. Days of week are 0 to 7, both of which are Sunday.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("expected 5 fields: minute, hour, day of month, month and day of week")
+	}
+	var c cronSchedule
+	var err error
+	bounds := []struct {
+		name     string
+		min, max int
+		values   *map[int]bool
+	}{
+		{"minute", 0, 59, &c.minutes},
+		{"hour", 0, 23, &c.hours},
+		{"day of month", 1, 31, &c.days},
+		{"month", 1, 12, &c.months},
+		{"day of week", 0, 7, &c.weekdays},
+	}
+	for i, b := range bounds {
+		if *b.values, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return nil, fmt.Errorf("%s: %s", b.name, err)
+		}
+	}
+	if c.weekdays[7] {
+		c.weekdays[0] = true
+	}
+	c.anyDay, c.anyWeekday = fields[2] == "*", fields[4] == "*"
+	return &c, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		first, last := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if first, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				// A single value with a step runs to the end of the range, e.g. 5/15 for 5, 20, 35 and 50.
+				last = max
+			}
+		}
+		if first < min || last > max || first > last {
+			return nil, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for v := first; v <= last; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// InMaintenance reports whether t is within one of the maintenance windows of the database.
+func (d Database) InMaintenance(t time.Time) bool {
+	for _, w := range d.MaintenanceWindows {
+		if w.Active(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	// 2024-03-09 is a Saturday.
+	saturday := time.Date(2024, 3, 9, 2, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		window MaintenanceWindow
+		time   time.Time
+		active bool
+		err    string
+	}{
+		{"at the start", MaintenanceWindow{Schedule: "0 2 * * 6", Duration: time.Hour}, saturday, true, ""},
+		{"within", MaintenanceWindow{Schedule: "0 2 * * 6", Duration: time.Hour}, saturday.Add(59 * time.Minute), true, ""},
+		{"at the end", MaintenanceWindow{Schedule: "0 2 * * 6", Duration: time.Hour}, saturday.Add(time.Hour), false, ""},
+		{"before", MaintenanceWindow{Schedule: "0 2 * * 6", Duration: time.Hour}, saturday.Add(-time.Second), false, ""},
+		{"other day", MaintenanceWindow{Schedule: "0 2 * * 6", Duration: time.Hour}, saturday.AddDate(0, 0, 1), false, ""},
+		{"across midnight", MaintenanceWindow{Schedule: "30 23 * * 5", Duration: 4 * time.Hour}, saturday, true, ""},
+		{"sunday as 7", MaintenanceWindow{Schedule: "0 2 * * 7", Duration: time.Hour}, saturday.AddDate(0, 0, 1), true, ""},
+		{"day of month or week", MaintenanceWindow{Schedule: "0 2 1 * 6", Duration: time.Hour}, saturday, true, ""},
+		{"steps and lists", MaintenanceWindow{Schedule: "*/20 0-4/2,12 * * *", Duration: time.Minute}, saturday.Add(40 * time.Minute), true, ""},
+		{"time zone", MaintenanceWindow{Schedule: "0 3 * * 6", Duration: time.Hour, TimeZone: "Europe/Berlin"}, saturday, true, ""},
+		{"no duration", MaintenanceWindow{Schedule: "0 2 * * 6"}, saturday, false, "duration must be positive"},
+		{"too few fields", MaintenanceWindow{Schedule: "0 2 * *", Duration: time.Hour}, saturday, false, "expected 5 fields"},
+		{"out of range", MaintenanceWindow{Schedule: "0 24 * * *", Duration: time.Hour}, saturday, false, `hour: "24" is out of the range 0-23`},
+		{"invalid step", MaintenanceWindow{Schedule: "*/0 2 * * *", Duration: time.Hour}, saturday, false, `minute: invalid step "0"`},
+		{"unknown time zone", MaintenanceWindow{Schedule: "0 2 * * 6", Duration: time.Hour, TimeZone: "Mars/Olympus"}, saturday, false, "invalid time zone"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.window.validate()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want it to contain %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if active := tc.window.Active(tc.time); active != tc.active {
+				t.Errorf("got active %t at %s, want %t", active, tc.time, tc.active)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Redaction actions.
+const (
+	redactKeep = "keep"
+	redactHash = "hash"
+	redactDrop = "drop"
+)
+
+// sensitiveLabels are the labels whose values may identify people or machines, e.g. the session breakdowns of the
+// session_origins collector.
+var sensitiveLabels = []string{"host_name", "login_name", "program_name"}
+
+// Redaction configures how sensitive label values are exposed.
+type Redaction struct {
+	// Labels maps sensitive labels to their redaction action: keep, the default, hash or drop.
+	Labels map[string]string
+	// Salt is prepended to values before hashing, so hashes of short values such as login names can't be
+	// reversed by hashing candidates.
+	Salt string
+}
+
+func (r Redaction) validate() error {
+	for label, action := range r.Labels {
+		known := false
+		for _, name := range sensitiveLabels {
+			known = known || name == label
+		}
+		if !known {
+			return fmt.Errorf("unknown redacted label %q, available labels: %s", label, strings.Join(sensitiveLabels, ", "))
+		}
+		switch action {
+		case redactKeep, redactHash, redactDrop:
+		default:
+			return fmt.Errorf("unknown redaction %q for label %s, must be %s, %s or %s", action, label, redactKeep, redactHash, redactDrop)
+		}
+	}
+	return nil
+}
+
+// Redact returns the value of label as it may be exposed. Hashed values are the first 16 hex digits of the salted
+// SHA-256 hash, dropped values are empty.
+func (r Redaction) Redact(label, value string) string {
+	switch r.Labels[label] {
+	case redactHash:
+		sum := sha256.Sum256([]byte(r.Salt + value))
+		return hex.EncodeToString(sum[:8])
+	case redactDrop:
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort is the port SQL Server listens on, used for databases that don't set one.
+const DefaultPort = 1433
+
+// splitServer splits a server given as host or host\instance into the host and instance name. IPv6 addresses may
+// be given in brackets, which are removed from the host.
+func splitServer(server string) (host, instance string) {
+	host = server
+	if i := strings.Index(server, `\`); i >= 0 {
+		host, instance = server[:i], server[i+1:]
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return host, instance
+}
+
+// validateServer checks that server is a host name, IPv4 address or IPv6 address, optionally in brackets, followed
+// by an optional instance name.
+func validateServer(server string) error {
+	host, instance := splitServer(server)
+	switch {
+	case host == "":
+		return fmt.Errorf("server %q has no host", server)
+	case strings.Contains(server, `\`) && (instance == "" || strings.ContainsAny(instance, `\ `)):
+		return fmt.Errorf("server %q has an invalid instance name, must be given as host\\instance", server)
+	case strings.ContainsAny(host, "[]"):
+		return fmt.Errorf("server %q has unbalanced brackets, IPv6 addresses must be given as [address]", server)
+	case strings.HasPrefix(server, "[") && (net.ParseIP(host) == nil || !strings.Contains(host, ":")):
+		return fmt.Errorf("server %q is not a valid IPv6 address in brackets", server)
+	case strings.Contains(host, ":") && net.ParseIP(host) == nil:
+		return fmt.Errorf("server %q must not contain a port, set port instead", server)
+	}
+	return nil
+}
+
+// Host returns the host name or IP address of the database's server, without the brackets of an IPv6 address and
+// the instance name.
+func (d Database) Host() string {
+	host, _ := splitServer(d.Server)
+	return host
+}
+
+// Instance returns the instance name of a server given as host\instance, or an empty string.
+func (d Database) Instance() string {
+	_, instance := splitServer(d.Server)
+	return instance
+}
+
+// Address returns the host and port of the database's server. The port of a named instance without a port is only
+// known to the SQL Server Browser service of the host, so ok is false for them.
+func (d Database) Address() (address string, ok bool) {
+	if d.Instance() != "" && d.Port == 0 {
+		return "", false
+	}
+	return net.JoinHostPort(d.Host(), strconv.Itoa(int(d.port()))), true
+}
+
+// port returns the port of the database's server, DefaultPort if it isn't set.
+func (d Database) port() uint {
+	if d.Port == 0 {
+		return DefaultPort
+	}
+	return d.Port
+}
+
+// serverParams returns the server and port parameters of the data source name. Named instances without a port
+// are looked up through the SQL Server Browser service by the driver, which ignores the port for them, so the
+// instance name is only passed on if the port isn't set.
+func (d Database) serverParams() string {
+	if instance := d.Instance(); instance != "" && d.Port == 0 {
+		return fmt.Sprintf(`server=%s\%s`, d.Host(), instance)
+	}
+	return fmt.Sprintf("server=%s;port=%d", d.Host(), d.port())
+}
+
+// spn returns the service principal name of the database's server for Windows authentication, by instance name
+// for named instances without a port.
+func (d Database) spn() string {
+	if instance := d.Instance(); instance != "" && d.Port == 0 {
+		return fmt.Sprintf("MSSQLSvc/%s:%s", d.Host(), instance)
+	}
+	return fmt.Sprintf("MSSQLSvc/%s:%d", d.Host(), d.port())
+}
+
+// failoverParam returns the parameter of the partner server the driver connects to if the server can't be reached,
+// none if the database has no failover partner, its connection string sets one itself or it is reached through an
+// SSH tunnel, which the partner would bypass.
+func (d Database) failoverParam() string {
+	if d.FailoverPartner == "" || d.SSHTunnel != nil {
+		return ""
+	}
+	if _, ok := connectionStringParams(d.ConnectionString)["failoverpartner"]; ok {
+		return ""
+	}
+	host, instance := splitServer(d.FailoverPartner)
+	if instance != "" {
+		return fmt.Sprintf(`;failoverpartner=%s\%s`, host, instance)
+	}
+	return ";failoverpartner=" + host
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	for _, tc := range []struct {
+		server string
+		port   uint
+		dsn    string
+		err    string
+	}{
+		{"sales.database.windows.net", 0, "server=sales.database.windows.net;port=1433;", ""},
+		{"sales.database.windows.net", 11000, "server=sales.database.windows.net;port=11000;", ""},
+		{"[2001:db8::1]", 0, "server=2001:db8::1;port=1433;", ""},
+		{"2001:db8::1", 0, "server=2001:db8::1;port=1433;", ""},
+		{`sqlvm\REPORTING`, 0, `server=sqlvm\REPORTING;`, ""},
+		{`sqlvm\REPORTING`, 1500, "server=sqlvm;port=1500;", ""},
+		{`[2001:db8::1]\REPORTING`, 0, `server=2001:db8::1\REPORTING;`, ""},
+		{"sales.database.windows.net:1433", 0, "", "must not contain a port"},
+		{"[sales.database.windows.net]", 0, "", "not a valid IPv6 address"},
+		{"[2001:db8::1", 0, "", "unbalanced brackets"},
+		{`sqlvm\`, 0, "", "invalid instance name"},
+	} {
+		t.Run(tc.server, func(t *testing.T) {
+			err := validateServer(tc.server)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want it to contain %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := Database{Name: "Sales", Server: tc.server, ConnectionSettings: ConnectionSettings{User: "prometheus", Port: tc.port}}
+			if dsn := d.DSN(); !strings.HasPrefix(dsn, tc.dsn) {
+				t.Errorf("got dsn %s, want it to start with %s", dsn, tc.dsn)
+			}
+		})
+	}
+}
+
+func TestFailoverPartner(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		db   Database
+		want string
+	}{
+		{"partner", Database{Server: "sales.database.windows.net", FailoverPartner: "sales-dr.database.windows.net"}, ";failoverpartner=sales-dr.database.windows.net"},
+		{"instance", Database{Server: `sqlvm\REPORTING`, FailoverPartner: `[2001:db8::2]\REPORTING`}, `;failoverpartner=2001:db8::2\REPORTING`},
+		{"none", Database{Server: "sales.database.windows.net"}, ""},
+		{"tunnel", Database{Server: "sales.database.windows.net", FailoverPartner: "sales-dr.database.windows.net", SSHTunnel: &SSHTunnel{}}, ""},
+		{"connection string", Database{ConnectionString: "server=sales;failoverpartner=sales-dr", FailoverPartner: "sales-dr.database.windows.net"}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.db.failoverParam(); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		db   Database
+	}{
+		{"password", Database{Name: "Sales", Server: "sales.database.windows.net", User: "exporter", Password: "s3cr3t", FailoverPartner: "sales-dr.database.windows.net"}},
+		{"windows", Database{Name: "Sales", Server: `sqlvm\REPORTING`, Auth: AuthWindows, User: `CORP\exporter`, Password: "s3cr3t", FailoverPartner: `sqlvm-dr\REPORTING`}},
+		{"connection string", Database{ConnectionString: "server=sales.database.windows.net;user id=exporter;Password=s3cr3t;database=Sales", FailoverPartner: "sales-dr.database.windows.net", ApplicationIntent: IntentReadOnly}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			want := strings.Replace(tc.db.DSN(), "s3cr3t", "******", -1)
+			if got := tc.db.String(); got != want {
+				t.Errorf("got %s, want the DSN with the password hidden, %s", got, want)
+			}
+		})
+	}
+}
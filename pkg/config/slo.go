@@ -0,0 +1,13 @@
+package config
+
+import (
+	"time"
+)
+
+// SLO is an availability objective of a database.
+type SLO struct {
+	// Objective is the ratio of scrapes that must find the database available, e.g. 0.999.
+	Objective float64
+	// Window is the rolling time window the objective applies to. Defaults to 30 days.
+	Window time.Duration
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Tenant is a subset of the metrics exposed at its own path, so a shared exporter can serve several teams without
+// exposing the databases of the others.
+type Tenant struct {
+	// Name is the last element of the path the tenant's metrics are exposed at, below --web.telemetry-path.
+	Name string
+	// Labels selects the series exposed to the tenant. A series is exposed if it has all labels with the given
+	// values, e.g. a static label of the tenant's databases.
+	Labels map[string]string
+}
+
+var tenantNameRE = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Groups returns the names of the groups of the databases in alphabetical order.
+func Groups(dbs []Database) []string {
+	seen := map[string]bool{}
+	var groups []string
+	for _, db := range dbs {
+		if db.Group != "" && !seen[db.Group] {
+			seen[db.Group] = true
+			groups = append(groups, db.Group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// validateGroups checks that the group names of the databases are usable in a path and don't clash with the path
+// of a tenant.
+func validateGroups(dbs []Database, tenants []Tenant) error {
+	for _, name := range Groups(dbs) {
+		if !tenantNameRE.MatchString(name) {
+			return fmt.Errorf("group name %q must only contain letters, digits, _ and -", name)
+		}
+		for _, t := range tenants {
+			if t.Name == name {
+				return fmt.Errorf("group %s has the same path as the tenant of that name", name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTenants checks that every tenant has a unique name usable in a path and at least one label.
+func validateTenants(tenants []Tenant) error {
+	seen := map[string]bool{}
+	for _, t := range tenants {
+		if !tenantNameRE.MatchString(t.Name) {
+			return fmt.Errorf("tenant name %q must only contain letters, digits, _ and -", t.Name)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("tenant %s is configured more than once", t.Name)
+		}
+		seen[t.Name] = true
+		if len(t.Labels) == 0 {
+			return fmt.Errorf("tenant %s has no labels", t.Name)
+		}
+	}
+	return nil
+}
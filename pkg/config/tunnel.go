@@ -0,0 +1,13 @@
+package config
+
+// SSHTunnel configures an SSH bastion host through which a database is reached.
+type SSHTunnel struct {
+	// Host is the address of the bastion host as host:port.
+	Host string
+	User string
+	// KeyFile is the path of the unencrypted private key in PEM format to authenticate with.
+	KeyFile string `yaml:"key_file"`
+	// HostKey is the public key of the bastion host in authorized_keys format. Connections to hosts presenting
+	// any other key are refused.
+	HostKey string `yaml:"host_key"`
+}
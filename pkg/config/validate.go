@@ -0,0 +1,59 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Validate checks the config file at path more strictly than Load. Unknown fields, e.g. misspelled ones that Load
+// silently ignores, and files referred to by the config that can't be read are reported as well. It returns all
+// errors found, or none if the config is valid.
+func Validate(path string, collectors []string) []error {
+	fh, err := readConfigFile(path)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	if err := yaml.UnmarshalStrict(fh, &Config{}); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return []error{fmt.Errorf("unable to parse file %s: %s", path, err)}
+		}
+		for _, msg := range typeErr.Errors {
+			errs = append(errs, errors.New(msg))
+		}
+		// Values of the wrong type are reported by both parsers, unknown fields only by the strict one.
+		if yaml.Unmarshal(fh, &Config{}) != nil {
+			return errs
+		}
+	}
+	config, err := Load(path, collectors)
+	if err != nil {
+		return append(errs, err)
+	}
+	for _, db := range config.Databases {
+		if db.Certificate != "" {
+			if err := readable(db.Certificate); err != nil {
+				errs = append(errs, fmt.Errorf("certificate of database %s: %s", db.Name, err))
+			}
+		}
+		if db.SSHTunnel != nil {
+			if err := readable(db.SSHTunnel.KeyFile); err != nil {
+				errs = append(errs, fmt.Errorf("ssh_tunnel key_file of database %s: %s", db.Name, err))
+			}
+		}
+	}
+	return errs
+}
+
+// readable returns an error if the file at path can't be opened for reading.
+func readable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		errors []string
+	}{
+		{"valid", `
+databases:
+  - name: Sales
+    server: sales.database.windows.net
+    port: 1433
+`, nil},
+		{"unknown fields", `
+databases:
+  - name: Sales
+    server: sales.database.windows.net
+    passwrod: secret
+    colectors: [query_store]
+`, []string{"line 5: field passwrod not found", "line 6: field colectors not found"}},
+		{"wrong type", `
+databases:
+  - name: Sales
+    server: sales.database.windows.net
+    port: default
+`, []string{"line 5: cannot unmarshal !!str `default` into uint"}},
+		{"missing server", `
+databases:
+  - name: Sales
+`, []string{"database Sales has no server"}},
+		{"port out of range", `
+databases:
+  - name: Sales
+    server: sales.database.windows.net
+    port: 143300
+`, []string{"port 143300 is out of range"}},
+		{"duplicate", `
+databases:
+  - name: Sales
+    server: sales.database.windows.net
+  - name: sales
+    server: sales.database.windows.net
+`, []string{"database sales on server sales.database.windows.net is configured more than once"}},
+		{"unknown collector", `
+databases:
+  - name: Sales
+    server: sales.database.windows.net
+    collectors: [query_stor]
+`, []string{`unknown collector "query_stor"`}},
+		{"missing key file", `
+databases:
+  - name: Sales
+    server: sales.database.windows.net
+    ssh_tunnel:
+      host: bastion:22
+      user: tunnel
+      key_file: /nonexistent/id_rsa
+      host_key: ssh-ed25519 AAAA
+`, []string{"ssh_tunnel key_file of database Sales: open /nonexistent/id_rsa"}},
+		{"unknown monitor aggregation", `
+azure:
+  tenant_id: tenant
+  client_id: client
+  client_secret: secret
+  subscriptions: [subscription]
+  monitor_metrics:
+    - name: connection_failed
+      aggregation: sum
+databases:
+  - name: Sales
+    server: sales.database.windows.net
+`, []string{`azure monitor metric connection_failed has unknown aggregation "sum"`}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := ioutil.WriteFile(path, []byte(tc.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			errs := Validate(path, []string{"query_store"})
+			if len(errs) != len(tc.errors) {
+				t.Fatalf("got errors %v, want %d errors", errs, len(tc.errors))
+			}
+			for i, err := range errs {
+				if !strings.Contains(err.Error(), tc.errors[i]) {
+					t.Errorf("got error %q, want it to contain %q", err, tc.errors[i])
+				}
+			}
+		})
+	}
+}